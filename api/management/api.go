@@ -1,19 +1,166 @@
 package management
 
 // Management API for WAF administration
-// This is a placeholder for future management API endpoints
-// Potential features:
+//
+// Endpoints (all under /admin/api/v1):
+// - GET    /bans       - List active IP bans
+// - POST   /bans       - Ban an IP ({"ip": "...", "reason": "...", "duration_seconds": 600})
+// - DELETE /bans/{ip}  - Lift a ban
+// - GET    /cluster    - Show gossip cluster membership
+//
+// Potential future features:
 // - Rule management (add/remove/update rules at runtime)
 // - Configuration updates
-// - Metrics and statistics
-// - Health checks
 // - Rule testing
 
-// Example future endpoints:
-// - GET /api/v1/rules - List all rules
-// - POST /api/v1/rules - Add a new rule
-// - PUT /api/v1/rules/{id} - Update a rule
-// - DELETE /api/v1/rules/{id} - Delete a rule
-// - GET /api/v1/metrics - Get WAF metrics
-// - GET /api/v1/health - Health check
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
 
+	"github.com/waf-draft/waf/internal/cluster"
+	"github.com/waf-draft/waf/internal/ipfilter"
+)
+
+// Prefix is the path prefix served by the management API
+const Prefix = "/admin/api/v1"
+
+// Handler serves the management API
+type Handler struct {
+	token   string
+	bans    *ipfilter.BanManager
+	cluster *cluster.Node
+}
+
+// NewHandler creates a management API handler. Requests must carry token as a
+// Bearer token; with an empty token every request is refused. bans may be nil
+// when automatic bans are disabled and node nil when the instance is not part
+// of a gossip cluster.
+func NewHandler(token string, bans *ipfilter.BanManager, node *cluster.Node) *Handler {
+	return &Handler{token: token, bans: bans, cluster: node}
+}
+
+// banRequest is the body accepted by POST /bans
+type banRequest struct {
+	IP              string `json:"ip"`
+	Reason          string `json:"reason"`
+	DurationSeconds int    `json:"duration_seconds"`
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+	switch {
+	case path == "/bans":
+		h.handleBans(w, r)
+	case strings.HasPrefix(path, "/bans/"):
+		h.handleBan(w, r, strings.TrimPrefix(path, "/bans/"))
+	case path == "/cluster":
+		h.handleCluster(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// handleBans lists bans or creates a manual ban
+func (h *Handler) handleBans(w http.ResponseWriter, r *http.Request) {
+	if h.bans == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "automatic bans are disabled"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bans := h.bans.List()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"bans":  bans,
+			"count": len(bans),
+		})
+	case http.MethodPost:
+		var req banRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+			return
+		}
+		addr, err := netip.ParseAddr(req.IP)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ip"})
+			return
+		}
+		if req.Reason == "" {
+			req.Reason = "manual ban"
+		}
+		ban := h.bans.Ban(addr.String(), req.Reason, time.Duration(req.DurationSeconds)*time.Second)
+		writeJSON(w, http.StatusCreated, ban)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// handleBan lifts a single ban
+func (h *Handler) handleBan(w http.ResponseWriter, r *http.Request, ip string) {
+	if h.bans == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "automatic bans are disabled"})
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	// Bans are keyed by the canonical address, as created by POST /bans
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ip"})
+		return
+	}
+	ip = addr.String()
+	if !h.bans.Unban(ip) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "ip is not banned"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unbanned", "ip": ip})
+}
+
+// handleCluster lists the cluster members known to this instance
+func (h *Handler) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if h.cluster == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "clustering is disabled"})
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"node_id": h.cluster.ID(),
+		"members": h.cluster.Members(),
+		"stats":   h.cluster.GetStats(),
+	})
+}
+
+// authorized checks the Bearer token
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -238,7 +238,7 @@ const dashboardHTML = `
                             const status = log.decision.action === 'block' ? '🚫 BLOCKED' : '✅ ALLOWED';
                             
                             div.innerHTML = '<span class="log-time">' + time + '</span>' +
-                                '<span class="log-ip">' + log.source_ip.split(':')[0] + '</span>' +
+                                '<span class="log-ip">' + log.source_ip + '</span>' +
                                 '<span class="log-type ' + severity.toLowerCase() + '">' + attackType + '</span>' +
                                 '<span class="log-path">' + log.path + '</span>' +
                                 '<span class="log-score">' + score + '</span>' +
@@ -0,0 +1,820 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waf-draft/waf/internal/state"
+)
+
+// Member states
+const (
+	StateAlive   = "alive"
+	StateSuspect = "suspect"
+	StateDead    = "dead"
+)
+
+// GossipPath is the HTTP path peers exchange state on
+const GossipPath = "/cluster/gossip"
+
+// signatureHeader carries the HMAC of a gossip message
+const signatureHeader = "X-Cluster-Signature"
+
+// maxMessageSize bounds the size of a gossip message
+const maxMessageSize = 8 << 20
+
+// deltaBudget bounds the state carried by one gossip message, leaving room for
+// the membership list. Registers, which hold bans, are filled first; counters
+// get what is left and wait for later rounds.
+const deltaBudget = maxMessageSize / 2
+
+// maxEntrySize bounds a stored key and value so that any entry fits a message
+const maxEntrySize = 64 << 10
+
+// maxClockSkew is how far in the future a peer's write may be stamped. Later
+// writes would win every conflict until the clock caught up, so they are dropped.
+const maxClockSkew = time.Minute
+
+// Config configures a cluster node
+type Config struct {
+	// NodeID identifies the node; defaults to hostname and bind address
+	NodeID string
+	// BindAddress is the address the gossip listener binds to
+	BindAddress string
+	// AdvertiseAddress is the address peers use to reach this node (defaults to the bound address)
+	AdvertiseAddress string
+	// Peers are seed addresses of other nodes
+	Peers []string
+	// Interval is the time between gossip rounds
+	Interval time.Duration
+	// Fanout is the number of peers contacted per round
+	Fanout int
+	// SuspectAfter and DeadAfter mark members whose heartbeat has not advanced
+	SuspectAfter time.Duration
+	DeadAfter    time.Duration
+	// Secret authenticates gossip messages with HMAC-SHA256 and is required
+	Secret string
+	// MaxTTL bounds the expiry of state received from peers (defaults to 24h)
+	MaxTTL time.Duration
+	// MaxMembers bounds the number of peers tracked (defaults to 256)
+	MaxMembers int
+}
+
+// Member is a node of the cluster as seen by this node
+type Member struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Heartbeat uint64    `json:"heartbeat"`
+	State     string    `json:"state"`
+	LastSeen  time.Time `json:"last_seen"`
+	Self      bool      `json:"self,omitempty"`
+}
+
+// register is a last-writer-wins value. Deletions are kept as tombstones until
+// they expire so that they win over stale copies still circulating.
+type register struct {
+	Value   string `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+	// Updated (unix nanos) orders writes; ties are broken by Node
+	Updated int64  `json:"updated"`
+	Node    string `json:"node"`
+	Expires int64  `json:"expires"`
+	// seq orders local changes for delta gossip
+	seq uint64
+}
+
+// newer reports whether r wins over other
+func (r register) newer(other register) bool {
+	if r.Updated != other.Updated {
+		return r.Updated > other.Updated
+	}
+	return r.Node > other.Node
+}
+
+// counter is a grow-only counter holding each node's contribution. Merging takes
+// the per-node maximum, so repeated or reordered exchanges are harmless.
+type counter struct {
+	Counts  map[string]int64 `json:"counts"`
+	Expires int64            `json:"expires"`
+	// seq orders local changes for delta gossip
+	seq uint64
+}
+
+// total sums the contributions of all nodes
+func (c *counter) total() int64 {
+	var n int64
+	for _, v := range c.Counts {
+		n += v
+	}
+	return n
+}
+
+// watermark is a position in a node's register and counter change sequences
+type watermark struct {
+	Registers uint64 `json:"registers"`
+	Counters  uint64 `json:"counters"`
+}
+
+// message is exchanged by peers in both directions of a gossip round. Only the
+// changes the receiver has not acknowledged are sent: those after Base, up to
+// and including Seq.
+type message struct {
+	From      string              `json:"from"`
+	Address   string              `json:"address"`
+	Heartbeat uint64              `json:"heartbeat"`
+	Members   []Member            `json:"members"`
+	Registers map[string]register `json:"registers"`
+	Counters  map[string]*counter `json:"counters"`
+	// Incarnation changes when the sender restarts and its sequences start over
+	Incarnation int64     `json:"incarnation"`
+	Base        watermark `json:"base"`
+	Seq         watermark `json:"seq"`
+	// Have is how far the sender has merged the receiver's changes, as of the
+	// receiver's HaveIncarnation
+	HaveIncarnation int64     `json:"have_incarnation"`
+	Have            watermark `json:"have"`
+}
+
+// peerSync tracks delta gossip with one peer: have is how far this node has
+// merged the peer's changes and acked how far the peer has merged this node's
+type peerSync struct {
+	incarnation int64
+	have        watermark
+	acked       watermark
+}
+
+// Node is a cluster member that replicates bans and rate limit counters to its
+// peers by periodic push-pull gossip. It implements state.Backend, so anything
+// stored through it is shared with the cluster.
+type Node struct {
+	cfg       Config
+	id        string
+	address   string
+	heartbeat uint64
+	members   map[string]*Member
+	registers map[string]register
+	counters  map[string]*counter
+	mu        sync.Mutex
+
+	// incarnation identifies this run of the node; seq numbers local changes
+	incarnation int64
+	seq         watermark
+	peers       map[string]*peerSync
+
+	listener net.Listener
+	server   *http.Server
+	client   *http.Client
+	ticker   *time.Ticker
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewNode starts a node listening for gossip on cfg.BindAddress
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("cluster secret is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Fanout <= 0 {
+		cfg.Fanout = 3
+	}
+	if cfg.SuspectAfter <= 0 {
+		cfg.SuspectAfter = 5 * cfg.Interval
+	}
+	if cfg.DeadAfter <= cfg.SuspectAfter {
+		cfg.DeadAfter = 4 * cfg.SuspectAfter
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = 24 * time.Hour
+	}
+	if cfg.MaxMembers <= 0 {
+		cfg.MaxMembers = 256
+	}
+
+	ln, err := net.Listen("tcp", cfg.BindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start cluster listener: %w", err)
+	}
+
+	n := &Node{
+		cfg:       cfg,
+		id:        cfg.NodeID,
+		address:   cfg.AdvertiseAddress,
+		members:   make(map[string]*Member),
+		registers: make(map[string]register),
+		counters:  make(map[string]*counter),
+		peers:     make(map[string]*peerSync),
+		listener:  ln,
+		client:    &http.Client{Timeout: cfg.Interval},
+		ticker:    time.NewTicker(cfg.Interval),
+		done:      make(chan struct{}),
+	}
+	n.incarnation = time.Now().UnixNano()
+	if n.address == "" {
+		n.address = ln.Addr().String()
+	}
+	if n.id == "" {
+		host, _ := os.Hostname()
+		n.id = host + "/" + n.address
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(GossipPath, n.handleGossip)
+	n.server = &http.Server{Handler: mux, ReadHeaderTimeout: cfg.Interval}
+	go n.server.Serve(ln)
+	go n.run()
+
+	return n, nil
+}
+
+// ID returns the node's ID
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Address returns the address peers reach this node on
+func (n *Node) Address() string {
+	return n.address
+}
+
+// Members returns this node and the peers it knows about, ordered by ID
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	members := []Member{{
+		ID:        n.id,
+		Address:   n.address,
+		Heartbeat: n.heartbeat,
+		State:     StateAlive,
+		LastSeen:  time.Now(),
+		Self:      true,
+	}}
+	for _, m := range n.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// run gossips with random peers every interval
+func (n *Node) run() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-n.ticker.C:
+			n.mu.Lock()
+			n.heartbeat++
+			n.expireLocked(time.Now())
+			peers := n.pickPeersLocked()
+			n.mu.Unlock()
+
+			for _, addr := range peers {
+				if err := n.exchange(addr); err != nil {
+					log.Printf("Cluster gossip with %s failed: %v", addr, err)
+				}
+			}
+		}
+	}
+}
+
+// pickPeersLocked chooses up to Fanout addresses among live members and seeds.
+// Caller must hold n.mu.
+func (n *Node) pickPeersLocked() []string {
+	seen := map[string]bool{n.address: true}
+	var candidates []string
+	for _, m := range n.members {
+		if m.State != StateDead && !seen[m.Address] {
+			seen[m.Address] = true
+			candidates = append(candidates, m.Address)
+		}
+	}
+	// Seeds are contacted until they show up as members
+	for _, addr := range n.cfg.Peers {
+		if !seen[addr] {
+			seen[addr] = true
+			candidates = append(candidates, addr)
+		}
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n.cfg.Fanout {
+		candidates = candidates[:n.cfg.Fanout]
+	}
+	return candidates
+}
+
+// exchange pushes this node's changes to a peer and merges the changes it returns
+func (n *Node) exchange(addr string) error {
+	body, err := n.encode(addr)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+GossipPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, n.sign(body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+
+	reply, err := n.readMessage(resp.Body, resp.Header.Get(signatureHeader))
+	if err != nil {
+		return err
+	}
+	n.merge(reply)
+	return nil
+}
+
+// handleGossip merges a peer's state and answers with this node's state
+func (n *Node) handleGossip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	msg, err := n.readMessage(r.Body, r.Header.Get(signatureHeader))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n.merge(msg)
+
+	body, err := n.encode(msg.Address)
+	if err != nil {
+		log.Printf("Cluster gossip reply to %s failed: %v", msg.Address, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(signatureHeader, n.sign(body))
+	w.Write(body)
+}
+
+// readMessage reads and authenticates a gossip message
+func (n *Node) readMessage(r io.Reader, signature string) (*message, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("gossip message too large")
+	}
+	if !hmac.Equal([]byte(signature), []byte(n.sign(body))) {
+		return nil, fmt.Errorf("invalid gossip signature")
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid gossip message: %w", err)
+	}
+	if msg.From == "" || msg.From == n.id {
+		return nil, fmt.Errorf("invalid gossip sender %q", msg.From)
+	}
+	return &msg, nil
+}
+
+// sign returns the hex HMAC-SHA256 of body
+func (n *Node) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(n.cfg.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encode builds the message for a peer, warning when changes had to be left
+// for later rounds. A message over the size limit is refused before it is sent.
+func (n *Node) encode(addr string) ([]byte, error) {
+	msg, deferred := n.delta(addr)
+	if deferred > 0 {
+		log.Printf("Warning: cluster gossip to %s is over budget, %d changes deferred to later rounds", addr, deferred)
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("gossip message of %d bytes exceeds the %d byte limit", len(body), maxMessageSize)
+	}
+	return body, nil
+}
+
+// delta copies the changes a peer has not acknowledged into a message, oldest
+// first and within deltaBudget, and returns the number of changes left out
+func (n *Node) delta(addr string) (*message, int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	msg := &message{
+		From:        n.id,
+		Address:     n.address,
+		Heartbeat:   n.heartbeat,
+		Registers:   make(map[string]register),
+		Counters:    make(map[string]*counter),
+		Incarnation: n.incarnation,
+		Seq:         n.seq,
+	}
+	for _, m := range n.members {
+		if m.State != StateDead {
+			msg.Members = append(msg.Members, Member{ID: m.ID, Address: m.Address, Heartbeat: m.Heartbeat})
+		}
+	}
+	if p, ok := n.peers[addr]; ok {
+		msg.Base = p.acked
+		msg.HaveIncarnation = p.incarnation
+		msg.Have = p.have
+	}
+
+	budget := deltaBudget
+	deferred := 0
+
+	var registers []string
+	for key, r := range n.registers {
+		if r.seq > msg.Base.Registers {
+			registers = append(registers, key)
+		}
+	}
+	sort.Slice(registers, func(i, j int) bool { return n.registers[registers[i]].seq < n.registers[registers[j]].seq })
+	for i, key := range registers {
+		r := n.registers[key]
+		size := entrySize(key, r)
+		if size > budget {
+			msg.Seq.Registers = r.seq - 1
+			deferred += len(registers) - i
+			break
+		}
+		budget -= size
+		msg.Registers[key] = r
+	}
+
+	var counters []string
+	for key, c := range n.counters {
+		if c.seq > msg.Base.Counters {
+			counters = append(counters, key)
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool { return n.counters[counters[i]].seq < n.counters[counters[j]].seq })
+	for i, key := range counters {
+		c := n.counters[key]
+		counts := make(map[string]int64, len(c.Counts))
+		for node, v := range c.Counts {
+			counts[node] = v
+		}
+		copied := &counter{Counts: counts, Expires: c.Expires}
+		size := entrySize(key, copied)
+		if size > budget {
+			msg.Seq.Counters = c.seq - 1
+			deferred += len(counters) - i
+			break
+		}
+		budget -= size
+		msg.Counters[key] = copied
+	}
+	return msg, deferred
+}
+
+// entrySize estimates the encoded size of a map entry in a message
+func entrySize(key string, value interface{}) int {
+	data, _ := json.Marshal(value)
+	return len(key) + len(data) + 8
+}
+
+// merge applies a peer's state: newer heartbeats refresh members, registers
+// resolve by last writer and counters by per-node maximum. Expiries are clamped
+// to MaxTTL and writes stamped too far in the future are dropped. Accepted
+// changes are numbered as local changes so they spread to other peers.
+func (n *Node) merge(msg *message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	defer n.syncLocked(msg)
+
+	now := time.Now()
+	n.observeLocked(Member{ID: msg.From, Address: msg.Address, Heartbeat: msg.Heartbeat}, now)
+	for _, m := range msg.Members {
+		if m.ID != n.id {
+			n.observeLocked(m, now)
+		}
+	}
+
+	nowNanos := now.UnixNano()
+	maxExpires := now.Add(n.cfg.MaxTTL).UnixNano()
+	maxUpdated := now.Add(maxClockSkew).UnixNano()
+	for key, incoming := range msg.Registers {
+		if incoming.Expires <= nowNanos || incoming.Updated > maxUpdated {
+			continue
+		}
+		if incoming.Expires > maxExpires {
+			incoming.Expires = maxExpires
+		}
+		if current, ok := n.registers[key]; !ok || incoming.newer(current) {
+			n.seq.Registers++
+			incoming.seq = n.seq.Registers
+			n.registers[key] = incoming
+		}
+	}
+	for key, incoming := range msg.Counters {
+		if incoming == nil || incoming.Expires <= nowNanos {
+			continue
+		}
+		current, ok := n.counters[key]
+		if !ok {
+			current = &counter{Counts: make(map[string]int64)}
+			n.counters[key] = current
+		}
+		changed := false
+		for node, v := range incoming.Counts {
+			// This node's own contribution is authoritative locally
+			if node != n.id && v > current.Counts[node] {
+				current.Counts[node] = v
+				changed = true
+			}
+		}
+		if expires := min(incoming.Expires, maxExpires); expires > current.Expires {
+			current.Expires = expires
+			changed = true
+		}
+		if changed {
+			n.seq.Counters++
+			current.seq = n.seq.Counters
+		}
+	}
+}
+
+// syncLocked advances the delta gossip positions for the sender of a message.
+// A message only moves this node's position in the sender's changes if it
+// continues from there; one that skipped changes leaves it so that the sender
+// is asked for them again. Caller must hold n.mu.
+func (n *Node) syncLocked(msg *message) {
+	p, ok := n.peers[msg.Address]
+	if !ok {
+		if len(n.peers) >= n.cfg.MaxMembers+len(n.cfg.Peers) {
+			return
+		}
+		p = &peerSync{}
+		n.peers[msg.Address] = p
+	}
+	if p.incarnation != msg.Incarnation {
+		*p = peerSync{incarnation: msg.Incarnation}
+	}
+	if msg.Base.Registers <= p.have.Registers && msg.Seq.Registers > p.have.Registers {
+		p.have.Registers = msg.Seq.Registers
+	}
+	if msg.Base.Counters <= p.have.Counters && msg.Seq.Counters > p.have.Counters {
+		p.have.Counters = msg.Seq.Counters
+	}
+	// Acknowledgements from before this node restarted refer to lost state
+	if msg.HaveIncarnation == n.incarnation {
+		p.acked = msg.Have
+	} else {
+		p.acked = watermark{}
+	}
+}
+
+// observeLocked records a member, refreshing it when its heartbeat advanced.
+// New members are ignored once MaxMembers are known and none is dead.
+// Caller must hold n.mu.
+func (n *Node) observeLocked(m Member, now time.Time) {
+	known, ok := n.members[m.ID]
+	if !ok {
+		if len(n.members) >= n.cfg.MaxMembers && !n.evictDeadLocked() {
+			return
+		}
+		n.members[m.ID] = &Member{ID: m.ID, Address: m.Address, Heartbeat: m.Heartbeat, State: StateAlive, LastSeen: now}
+		return
+	}
+	if m.Heartbeat > known.Heartbeat {
+		known.Heartbeat = m.Heartbeat
+		known.Address = m.Address
+		known.LastSeen = now
+		known.State = StateAlive
+	}
+}
+
+// evictDeadLocked removes a dead member to make room for a new one and reports
+// whether it found one. Caller must hold n.mu.
+func (n *Node) evictDeadLocked() bool {
+	for id, m := range n.members {
+		if m.State == StateDead {
+			delete(n.members, id)
+			return true
+		}
+	}
+	return false
+}
+
+// expireLocked updates member states and drops expired state. Caller must hold n.mu.
+func (n *Node) expireLocked(now time.Time) {
+	for id, m := range n.members {
+		silent := now.Sub(m.LastSeen)
+		switch {
+		case silent > 2*n.cfg.DeadAfter:
+			delete(n.members, id)
+		case silent > n.cfg.DeadAfter:
+			m.State = StateDead
+		case silent > n.cfg.SuspectAfter:
+			m.State = StateSuspect
+		}
+	}
+
+	// Forget the gossip positions of peers that left
+	addresses := make(map[string]bool, len(n.members)+len(n.cfg.Peers))
+	for _, m := range n.members {
+		addresses[m.Address] = true
+	}
+	for _, addr := range n.cfg.Peers {
+		addresses[addr] = true
+	}
+	for addr := range n.peers {
+		if !addresses[addr] {
+			delete(n.peers, addr)
+		}
+	}
+
+	nowNanos := now.UnixNano()
+	for key, r := range n.registers {
+		if r.Expires <= nowNanos {
+			delete(n.registers, key)
+		}
+	}
+	for key, c := range n.counters {
+		if c.Expires <= nowNanos {
+			delete(n.counters, key)
+		}
+	}
+}
+
+// IncrBy adds delta to this node's contribution to a counter and returns the cluster-wide total
+func (n *Node) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	if len(key) > maxEntrySize {
+		return 0, fmt.Errorf("cluster key exceeds %d bytes", maxEntrySize)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return 0, state.ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	c, ok := n.counters[key]
+	if !ok || c.Expires <= now {
+		c = &counter{Counts: make(map[string]int64), Expires: now + int64(ttl)}
+		n.counters[key] = c
+	}
+	c.Counts[n.id] += delta
+	n.seq.Counters++
+	c.seq = n.seq.Counters
+	return c.total(), nil
+}
+
+// Get returns a value, or a counter's cluster-wide total
+func (n *Node) Get(key string) (string, bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return "", false, state.ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	if r, ok := n.registers[key]; ok && r.Expires > now {
+		if r.Deleted {
+			return "", false, nil
+		}
+		return r.Value, true, nil
+	}
+	if c, ok := n.counters[key]; ok && c.Expires > now {
+		return strconv.FormatInt(c.total(), 10), true, nil
+	}
+	return "", false, nil
+}
+
+// Set stores a value with a TTL. Values too large to gossip are refused.
+func (n *Node) Set(key, value string, ttl time.Duration) error {
+	if len(key)+len(value) > maxEntrySize {
+		return fmt.Errorf("cluster value for %q exceeds %d bytes", key, maxEntrySize)
+	}
+	return n.write(key, register{Value: value}, ttl)
+}
+
+// Delete removes a key by writing a tombstone that outlives stale copies
+func (n *Node) Delete(key string) error {
+	ttl := n.cfg.DeadAfter
+	n.mu.Lock()
+	if r, ok := n.registers[key]; ok {
+		if remaining := time.Until(time.Unix(0, r.Expires)); remaining > ttl {
+			ttl = remaining
+		}
+	}
+	n.mu.Unlock()
+	return n.write(key, register{Deleted: true}, ttl)
+}
+
+// write stores a register stamped after any previous write to the key
+func (n *Node) write(key string, r register, ttl time.Duration) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return state.ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	r.Updated = now
+	if current, ok := n.registers[key]; ok && current.Updated >= now {
+		r.Updated = current.Updated + 1
+	}
+	r.Node = n.id
+	r.Expires = now + int64(ttl)
+	n.seq.Registers++
+	r.seq = n.seq.Registers
+	n.registers[key] = r
+	return nil
+}
+
+// Keys returns the live keys starting with prefix
+func (n *Node) Keys(prefix string) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return nil, state.ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	var keys []string
+	for key, r := range n.registers {
+		if !r.Deleted && r.Expires > now && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key, c := range n.counters {
+		if c.Expires > now && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// GetStats returns cluster statistics
+func (n *Node) GetStats() map[string]interface{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	alive := 1
+	for _, m := range n.members {
+		if m.State == StateAlive {
+			alive++
+		}
+	}
+	return map[string]interface{}{
+		"node_id":       n.id,
+		"members":       len(n.members) + 1,
+		"alive_members": alive,
+		"registers":     len(n.registers),
+		"counters":      len(n.counters),
+	}
+}
+
+// Close stops gossiping and shuts down the listener
+func (n *Node) Close() error {
+	var err error
+	n.once.Do(func() {
+		n.mu.Lock()
+		close(n.done)
+		n.mu.Unlock()
+		n.ticker.Stop()
+		err = n.server.Close()
+	})
+	return err
+}
+
+// closed reports whether Close was called. Caller must hold n.mu.
+func (n *Node) closed() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
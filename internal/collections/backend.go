@@ -0,0 +1,65 @@
+package collections
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Backend persists collection entries between restarts
+type Backend interface {
+	Load() (map[string]*Entry, error)
+	Save(entries map[string]*Entry) error
+}
+
+// FileBackend stores collections as a JSON document on disk
+type FileBackend struct {
+	path string
+}
+
+// NewFileBackend creates a backend that reads and writes the given file
+func NewFileBackend(path string) *FileBackend {
+	return &FileBackend{path: path}
+}
+
+// Load reads entries from the file. A missing file yields an empty set.
+func (b *FileBackend) Load() (map[string]*Entry, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collections file: %w", err)
+	}
+
+	entries := make(map[string]*Entry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse collections file: %w", err)
+	}
+	return entries, nil
+}
+
+// Save writes entries to the file atomically
+func (b *FileBackend) Save(entries map[string]*Entry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode collections: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), ".collections-*")
+	if err != nil {
+		return fmt.Errorf("failed to write collections file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write collections file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write collections file: %w", err)
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
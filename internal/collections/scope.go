@@ -0,0 +1,87 @@
+package collections
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Collection names
+const (
+	CollectionIP      = "ip"
+	CollectionSession = "session"
+	CollectionAPIKey  = "apikey"
+)
+
+// KeyConfig names where the session and API key collection keys are read from
+type KeyConfig struct {
+	SessionCookie string
+	APIKeyHeader  string
+}
+
+// Scope binds a store to the collection keys of a single request
+type Scope struct {
+	store *Store
+	keys  map[string]string
+}
+
+// NewScope resolves the collection keys for a request. Collections whose key is
+// absent from the request (e.g. no session cookie) are not available to rules.
+func NewScope(store *Store, r *http.Request, clientIP string, cfg KeyConfig) *Scope {
+	keys := make(map[string]string)
+	if clientIP != "" {
+		keys[CollectionIP] = clientIP
+	}
+	if cfg.SessionCookie != "" {
+		if c, err := r.Cookie(cfg.SessionCookie); err == nil && c.Value != "" {
+			keys[CollectionSession] = c.Value
+		}
+	}
+	if cfg.APIKeyHeader != "" {
+		if v := r.Header.Get(cfg.APIKeyHeader); v != "" {
+			keys[CollectionAPIKey] = v
+		}
+	}
+	return &Scope{store: store, keys: keys}
+}
+
+// Split separates a qualified variable name such as "ip.score" into collection and
+// variable. ok is false when the prefix is not a known collection.
+func Split(qualified string) (collection, name string, ok bool) {
+	collection, name, found := strings.Cut(qualified, ".")
+	if !found || name == "" {
+		return "", "", false
+	}
+	switch strings.ToLower(collection) {
+	case CollectionIP, CollectionSession, CollectionAPIKey:
+		return strings.ToLower(collection), name, true
+	}
+	return "", "", false
+}
+
+// Get reads a qualified variable such as "ip.score"
+func (s *Scope) Get(qualified string) (string, bool) {
+	collection, name, ok := Split(qualified)
+	if !ok {
+		return "", false
+	}
+	key, ok := s.keys[collection]
+	if !ok {
+		return "", false
+	}
+	return s.store.Get(collection, key, name)
+}
+
+// Set writes a qualified variable such as "ip.score". It returns false when the
+// collection is not available for this request.
+func (s *Scope) Set(qualified, value string, opts Options) bool {
+	collection, name, ok := Split(qualified)
+	if !ok {
+		return false
+	}
+	key, ok := s.keys[collection]
+	if !ok {
+		return false
+	}
+	s.store.Set(collection, key, name, value, opts)
+	return true
+}
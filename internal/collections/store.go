@@ -0,0 +1,308 @@
+package collections
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single variable stored in a persistent collection
+type Entry struct {
+	Value string `json:"value"`
+	// Expires is the time after which the entry is discarded. When zero, the
+	// entry is discarded once it has not been written for the store's default TTL.
+	Expires time.Time `json:"expires,omitempty"`
+	// DecayAmount is subtracted from numeric values every DecayInterval
+	DecayAmount   int64         `json:"decay_amount,omitempty"`
+	DecayInterval time.Duration `json:"decay_interval,omitempty"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// Options controls expiry and decay when writing a variable
+type Options struct {
+	TTL           time.Duration
+	DecayAmount   int64
+	DecayInterval time.Duration
+}
+
+// Default store limits
+const (
+	DefaultMaxEntries = 100000
+	DefaultTTL        = 24 * time.Hour
+)
+
+// Limits bounds the memory used by a store. Collection keys come from client
+// controlled values such as IPs and cookies, so the store must not grow unbounded.
+type Limits struct {
+	// MaxEntries bounds the number of stored variables; the least recently used
+	// variable is evicted (default DefaultMaxEntries)
+	MaxEntries int
+	// DefaultTTL expires variables written without a TTL once they have not been
+	// written for this long (default DefaultTTL)
+	DefaultTTL time.Duration
+}
+
+// Store holds persistent per-client collections (e.g. ip, session, apikey) in memory.
+// Entries are addressed by collection, collection key and variable name.
+type Store struct {
+	entries map[string]*list.Element
+	lru     *list.List
+	limits  Limits
+	evicted int64
+	mu      sync.Mutex
+	cleanup *time.Ticker
+	done    chan struct{}
+	closed  sync.Once
+	backend Backend
+}
+
+// storeEntry is an LRU list element
+type storeEntry struct {
+	key   string
+	entry *Entry
+}
+
+// NewStore creates a new in-memory collection store. If backend is non-nil, the
+// store is restored from it and written back on Persist and Close.
+func NewStore(backend Backend, limits Limits) (*Store, error) {
+	if limits.MaxEntries <= 0 {
+		limits.MaxEntries = DefaultMaxEntries
+	}
+	if limits.DefaultTTL <= 0 {
+		limits.DefaultTTL = DefaultTTL
+	}
+	s := &Store{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		limits:  limits,
+		cleanup: time.NewTicker(1 * time.Minute),
+		done:    make(chan struct{}),
+		backend: backend,
+	}
+
+	if backend != nil {
+		entries, err := backend.Load()
+		if err != nil {
+			return nil, err
+		}
+		// Restore the most recently updated entries last so that they are kept
+		keys := make([]string, 0, len(entries))
+		for k, e := range entries {
+			if e != nil {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return entries[keys[i]].UpdatedAt.Before(entries[keys[j]].UpdatedAt) })
+		now := time.Now()
+		for _, k := range keys {
+			if e := entries[k]; !s.expired(e, now) {
+				s.insert(k, e)
+			}
+		}
+	}
+
+	go s.cleanupExpired()
+
+	return s, nil
+}
+
+// Get returns the current value of a variable, applying decay
+func (s *Store) Get(collection, key, name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.lookup(entryKey(collection, key, name), time.Now())
+	if e == nil {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// Set assigns a variable. Values prefixed with "+" or "-" adjust the existing numeric
+// value (after decay) instead of replacing it.
+func (s *Store) Set(collection, key, name, value string, opts Options) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	k := entryKey(collection, key, name)
+	e := s.lookup(k, now)
+	if e == nil {
+		e = &Entry{}
+		s.insert(k, e)
+	}
+
+	if len(value) > 1 && (value[0] == '+' || value[0] == '-') {
+		if delta, err := strconv.ParseInt(value[1:], 10, 64); err == nil {
+			current, _ := strconv.ParseInt(e.Value, 10, 64)
+			if value[0] == '-' {
+				delta = -delta
+			}
+			value = strconv.FormatInt(current+delta, 10)
+		}
+	}
+
+	e.Value = value
+	e.UpdatedAt = now
+	if opts.TTL > 0 {
+		e.Expires = now.Add(opts.TTL)
+	}
+	if opts.DecayAmount > 0 && opts.DecayInterval > 0 {
+		e.DecayAmount = opts.DecayAmount
+		e.DecayInterval = opts.DecayInterval
+	}
+	return e.Value
+}
+
+// Delete removes a variable
+func (s *Store) Delete(collection, key, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[entryKey(collection, key, name)]; ok {
+		s.remove(el)
+	}
+}
+
+// lookup returns a live entry with decay applied, removing it if expired.
+// Caller must hold s.mu.
+func (s *Store) lookup(k string, now time.Time) *Entry {
+	el, ok := s.entries[k]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*storeEntry).entry
+	if s.expired(e, now) {
+		s.remove(el)
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	e.decay(now)
+	return e
+}
+
+// insert adds an entry, evicting the least recently used one when the store is
+// full. Caller must hold s.mu.
+func (s *Store) insert(k string, e *Entry) {
+	if s.lru.Len() >= s.limits.MaxEntries {
+		s.remove(s.lru.Back())
+		s.evicted++
+	}
+	s.entries[k] = s.lru.PushFront(&storeEntry{key: k, entry: e})
+}
+
+// remove deletes an entry. Caller must hold s.mu.
+func (s *Store) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*storeEntry).key)
+}
+
+// expired reports whether the entry's TTL, or the default TTL since its last
+// write, has passed
+func (s *Store) expired(e *Entry, now time.Time) bool {
+	if e.Expires.IsZero() {
+		return now.Sub(e.UpdatedAt) > s.limits.DefaultTTL
+	}
+	return now.After(e.Expires)
+}
+
+// decay lowers a numeric value by DecayAmount for every full DecayInterval elapsed
+// since the last update, never going below zero
+func (e *Entry) decay(now time.Time) {
+	if e.DecayAmount <= 0 || e.DecayInterval <= 0 {
+		return
+	}
+	steps := int64(now.Sub(e.UpdatedAt) / e.DecayInterval)
+	if steps <= 0 {
+		return
+	}
+	current, err := strconv.ParseInt(e.Value, 10, 64)
+	if err != nil {
+		return
+	}
+	current -= steps * e.DecayAmount
+	if current < 0 {
+		current = 0
+	}
+	e.Value = strconv.FormatInt(current, 10)
+	e.UpdatedAt = e.UpdatedAt.Add(time.Duration(steps) * e.DecayInterval)
+}
+
+// Snapshot returns a copy of all live entries
+func (s *Store) Snapshot() map[string]*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	snapshot := make(map[string]*Entry, len(s.entries))
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		item := el.Value.(*storeEntry)
+		if s.expired(item.entry, now) {
+			s.remove(el)
+		} else {
+			item.entry.decay(now)
+			copied := *item.entry
+			snapshot[item.key] = &copied
+		}
+		el = next
+	}
+	return snapshot
+}
+
+// Persist writes the current entries to the backend
+func (s *Store) Persist() error {
+	if s.backend == nil {
+		return nil
+	}
+	return s.backend.Save(s.Snapshot())
+}
+
+// cleanupExpired removes expired entries to prevent memory leaks and periodically
+// persists the store so that a crash loses at most a minute of state
+func (s *Store) cleanupExpired() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.cleanup.C:
+			s.mu.Lock()
+			now := time.Now()
+			for _, el := range s.entries {
+				if s.expired(el.Value.(*storeEntry).entry, now) {
+					s.remove(el)
+				}
+			}
+			s.mu.Unlock()
+			s.Persist()
+		}
+	}
+}
+
+// Close stops the cleanup goroutine and persists the store
+func (s *Store) Close() error {
+	s.closed.Do(func() {
+		s.cleanup.Stop()
+		close(s.done)
+	})
+	return s.Persist()
+}
+
+// GetStats returns store statistics
+func (s *Store) GetStats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]interface{}{
+		"entries":     len(s.entries),
+		"max_entries": s.limits.MaxEntries,
+		"evicted":     s.evicted,
+	}
+}
+
+// entryKey builds the storage key for a variable
+func entryKey(collection, key, name string) string {
+	return strings.ToLower(collection) + "\x00" + key + "\x00" + strings.ToLower(name)
+}
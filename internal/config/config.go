@@ -8,36 +8,367 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultMatchedDataLength is the number of bytes of matched data kept per rule match
+const DefaultMatchedDataLength = 64
+
 // Config represents the main WAF configuration
 type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Security SecurityConfig `yaml:"security"`
 	Logging  LoggingConfig  `yaml:"logging"`
 	Rules    RulesConfig    `yaml:"rules"`
+	Admin    AdminConfig    `yaml:"admin"`
+	State    StateConfig    `yaml:"state"`
+}
+
+// StateConfig selects where rate limit counters and bans are shared between replicas
+type StateConfig struct {
+	// Backend is empty for per-instance state, "memory", "redis" or "gossip"
+	Backend string        `yaml:"backend"`
+	Redis   RedisConfig   `yaml:"redis"`
+	Cluster ClusterConfig `yaml:"cluster"`
+	// SyncIntervalMs is how often local rate limit counts are flushed to the backend
+	SyncIntervalMs int `yaml:"sync_interval_ms"`
+	// CacheTTLMs is how long ban lookups are cached locally
+	CacheTTLMs int `yaml:"cache_ttl_ms"`
+	// FailMode is "open" (decide from local state) or "closed" (reject) when the backend is unreachable
+	FailMode string `yaml:"fail_mode"`
+}
+
+// ClusterConfig contains gossip membership settings for the "gossip" state backend
+type ClusterConfig struct {
+	// NodeID defaults to the hostname and advertise address
+	NodeID           string `yaml:"node_id"`
+	BindAddress      string `yaml:"bind_address"`
+	AdvertiseAddress string `yaml:"advertise_address"`
+	// Peers are seed addresses (host:port) of other instances
+	Peers            []string `yaml:"peers"`
+	GossipIntervalMs int      `yaml:"gossip_interval_ms"`
+	Fanout           int      `yaml:"fanout"`
+	SuspectAfterMs   int      `yaml:"suspect_after_ms"`
+	DeadAfterMs      int      `yaml:"dead_after_ms"`
+	// Secret authenticates gossip messages; all instances must share it and
+	// the gossip backend is not started without one
+	Secret string `yaml:"secret"`
+	// MaxTTLSeconds bounds how long state received from peers lives (0 = 24h)
+	MaxTTLSeconds int `yaml:"max_ttl_seconds"`
+	// MaxMembers bounds the number of peers tracked (0 = 256)
+	MaxMembers int `yaml:"max_members"`
+}
+
+// RedisConfig contains Redis connection settings
+type RedisConfig struct {
+	Address   string `yaml:"address"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+	TimeoutMs int    `yaml:"timeout_ms"`
+	PoolSize  int    `yaml:"pool_size"`
+}
+
+// AdminConfig contains management API settings
+type AdminConfig struct {
+	// Token must be sent as a Bearer token to use the management API, which is
+	// disabled while no token is set
+	Token string `yaml:"token"`
 }
 
 // ServerConfig contains HTTP server settings
 type ServerConfig struct {
-	ListenAddress      string `yaml:"listen_address"`
-	UpstreamURL        string `yaml:"upstream_url"`
-	ReadTimeoutSeconds int    `yaml:"read_timeout_seconds"`
-	WriteTimeoutSeconds int   `yaml:"write_timeout_seconds"`
-	IdleTimeoutSeconds int    `yaml:"idle_timeout_seconds"`
+	ListenAddress       string `yaml:"listen_address"`
+	UpstreamURL         string `yaml:"upstream_url"`
+	ReadTimeoutSeconds  int    `yaml:"read_timeout_seconds"`
+	WriteTimeoutSeconds int    `yaml:"write_timeout_seconds"`
+	IdleTimeoutSeconds  int    `yaml:"idle_timeout_seconds"`
+	// ReadHeaderTimeoutSeconds bounds the time to receive the request headers
+	ReadHeaderTimeoutSeconds int `yaml:"read_header_timeout_seconds"`
+	// MaxConnections caps open client connections (0 for no limit)
+	MaxConnections int `yaml:"max_connections"`
+	// MaxConnectionsPerIP caps concurrent connections from one client IP (0 for no limit)
+	MaxConnectionsPerIP int `yaml:"max_connections_per_ip"`
+	// MinBodyRateBytes is the minimum average request body rate in bytes per
+	// second after BodyRateGraceSeconds (0 disables the check)
+	MinBodyRateBytes     int `yaml:"min_body_rate_bytes"`
+	BodyRateGraceSeconds int `yaml:"body_rate_grace_seconds"`
+	// TrustedProxies lists the CIDRs of proxies in front of the WAF. Their
+	// X-Forwarded-For and X-Real-IP headers identify the client; without them
+	// the socket peer is the client.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 // SecurityConfig contains security-related settings
 type SecurityConfig struct {
-	AnomalyThreshold int  `yaml:"anomaly_threshold"`
-	LogRequestBody   bool `yaml:"log_request_bodies"`
-	RateLimit        RateLimitConfig `yaml:"rate_limit"`
-	IPFilter         IPFilterConfig  `yaml:"ip_filter"`
+	AnomalyThreshold int                   `yaml:"anomaly_threshold"`
+	LogRequestBody   bool                  `yaml:"log_request_bodies"`
+	RateLimit        RateLimitConfig       `yaml:"rate_limit"`
+	IPFilter         IPFilterConfig        `yaml:"ip_filter"`
+	Collections      CollectionsConfig     `yaml:"collections"`
+	Bans             BanConfig             `yaml:"bans"`
+	GeoIP            GeoIPConfig           `yaml:"geoip"`
+	Limits           LimitsConfig          `yaml:"limits"`
+	Unicode          UnicodeConfig         `yaml:"unicode"`
+	Response         ResponseConfig        `yaml:"response"`
+	PIIMasking       PIIMaskingConfig      `yaml:"pii_masking"`
+	Headers          SecurityHeadersConfig `yaml:"headers"`
+	ErrorPages       ErrorPagesConfig      `yaml:"error_pages"`
+	BlockPage        BlockPageConfig       `yaml:"block_page"`
+	Challenge        ChallengeConfig       `yaml:"challenge"`
+}
+
+// ChallengeConfig controls the proof-of-work challenge served in place of a
+// block by challenge actions and rate limit policies
+type ChallengeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ScoreThreshold challenges requests scoring at least this much but below the
+	// anomaly threshold; 0 challenges only on challenge actions
+	ScoreThreshold int `yaml:"score_threshold"`
+	// Difficulty is the number of leading zero bits the proof-of-work hash needs (default 16)
+	Difficulty int `yaml:"difficulty"`
+	// Secret signs challenges and clearance cookies. When empty a random secret
+	// is generated, so clearances do not survive restarts or span instances.
+	Secret string `yaml:"secret"`
+	// CookieName is the clearance cookie (default "waf_clearance")
+	CookieName string `yaml:"cookie_name"`
+	// ClearanceSeconds is how long a solved challenge is valid (default 3600)
+	ClearanceSeconds int `yaml:"clearance_seconds"`
+	// ChallengeSeconds is how long a challenge can be solved (default 300)
+	ChallengeSeconds int `yaml:"challenge_seconds"`
+	// Path is where solutions are submitted (default "/.waf/challenge")
+	Path string `yaml:"path"`
+	// Fallback lets browsers without JavaScript through after a delay instead of
+	// the proof-of-work. It grants clearances without any work, so it is off by default.
+	Fallback bool `yaml:"fallback"`
+	// FallbackDelaySeconds is the wait imposed by the fallback (default 5)
+	FallbackDelaySeconds int `yaml:"fallback_delay_seconds"`
+}
+
+// BlockPageConfig controls the responses sent to blocked requests
+type BlockPageConfig struct {
+	// Status replaces 403 for blocks that do not set their own status
+	Status int `yaml:"status"`
+	// HideReason leaves the block reason, which can name rules and scores, out
+	// of the response; it is still logged
+	HideReason bool `yaml:"hide_reason"`
+	// Support is shown with the request ID, e.g. "Contact support@example.com"
+	Support string `yaml:"support"`
+	// Templates are chosen by the request's Accept header
+	Templates BlockTemplatesConfig `yaml:"templates"`
+	// Routes override the status and templates for matching requests; the first match wins
+	Routes []BlockPageRouteConfig `yaml:"routes"`
+	// ReloadSeconds is how often template files are checked for changes; 0 disables reloading
+	ReloadSeconds int `yaml:"reload_seconds"`
+}
+
+// BlockTemplatesConfig lists template files per response format. Empty entries
+// use the built-in responses.
+type BlockTemplatesConfig struct {
+	HTML string `yaml:"html"`
+	JSON string `yaml:"json"`
+	Text string `yaml:"text"`
+}
+
+// BlockPageRouteConfig overrides the block response for matching requests
+type BlockPageRouteConfig struct {
+	Match  RouteMatchConfig `yaml:"match"`
+	Status int              `yaml:"status"`
+	// Templates replace the global templates per format
+	Templates BlockTemplatesConfig `yaml:"templates"`
+}
+
+// ErrorPagesConfig controls replacement of upstream error responses with WAF pages
+type ErrorPagesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Intercept4xx also replaces 4xx responses; 5xx responses are always replaced
+	Intercept4xx bool `yaml:"intercept_4xx"`
+	// ExcludeStatuses are passed through unchanged, e.g. 404 or 503
+	ExcludeStatuses []int `yaml:"exclude_statuses"`
+	// Template is an html/template file rendered with .Status, .StatusText and
+	// .RequestID; empty uses the built-in page
+	Template string `yaml:"template"`
+	// SnippetBytes is the amount of the original body logged (default 1 KiB);
+	// negative logs none
+	SnippetBytes int `yaml:"snippet_bytes"`
+}
+
+// SecurityHeadersConfig controls the security headers added to responses and the
+// fingerprinting headers removed from them
+type SecurityHeadersConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Set adds headers, overriding upstream values; an empty value removes the header
+	Set map[string]string `yaml:"set"`
+	// Defaults adds headers only when the upstream did not send them
+	Defaults map[string]string `yaml:"defaults"`
+	// Strip lists the headers removed from responses; when omitted Server,
+	// X-Powered-By, X-AspNet-Version, X-AspNetMvc-Version and X-Generator
+	Strip []string `yaml:"strip"`
+	// Routes override the policy for matching requests; the first match wins
+	Routes []SecurityHeadersRouteConfig `yaml:"routes"`
+}
+
+// SecurityHeadersRouteConfig is merged over the global header policy for
+// matching requests
+type SecurityHeadersRouteConfig struct {
+	Match RouteMatchConfig `yaml:"match"`
+	// Disabled leaves the route's responses untouched
+	Disabled bool              `yaml:"disabled"`
+	Set      map[string]string `yaml:"set"`
+	Defaults map[string]string `yaml:"defaults"`
+	// Strip adds to the globally stripped headers
+	Strip []string `yaml:"strip"`
+}
+
+// ResponseConfig controls inspection of upstream responses by response-phase rules
+type ResponseConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxBodyBytes is the amount of decompressed body inspected (default 512 KiB)
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// ContentTypes lists the inspected media types; entries ending in "/" match
+	// a whole type. Empty means text, JSON, XML and JavaScript.
+	ContentTypes []string `yaml:"content_types"`
+	// Detectors lists the built-in leak detectors to run; empty means all of them
+	Detectors []string `yaml:"detectors"`
+	// AnomalyThreshold is the response-phase score that blocks a response;
+	// 0 uses the request threshold
+	AnomalyThreshold int `yaml:"anomaly_threshold"`
+}
+
+// UnicodeConfig controls the Unicode normalization pre-pass applied before rules
+type UnicodeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Targets are path, query, body and header; empty means all of them
+	Targets []string `yaml:"targets"`
+	// Transforms are compat, confusables and case_fold, applied in order;
+	// empty means all three
+	Transforms []string `yaml:"transforms"`
+}
+
+// PIIMaskingConfig controls masking of personal data and secrets in response bodies
+type PIIMaskingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Detectors are credit_card, national_id, email and api_key; empty means all
+	Detectors []string `yaml:"detectors"`
+	// ContentTypes lists the masked media types; empty means text, JSON, XML and JavaScript
+	ContentTypes []string `yaml:"content_types"`
+	// MaxBufferBytes bounds the decompressed body buffered to recompute
+	// Content-Length (default 1 MiB); longer bodies are streamed without one
+	MaxBufferBytes int64 `yaml:"max_buffer_bytes"`
+	// Routes select where masking applies; the first matching route wins.
+	// Without routes every response is masked.
+	Routes []PIIRouteConfig `yaml:"routes"`
+}
+
+// PIIRouteConfig turns masking off or selects the detectors for matching requests
+type PIIRouteConfig struct {
+	Match RouteMatchConfig `yaml:"match"`
+	// Disabled turns masking off for the route
+	Disabled bool `yaml:"disabled"`
+	// Detectors overrides the detectors for the route
+	Detectors []string `yaml:"detectors"`
+}
+
+// RouteMatchConfig selects requests by path and host
+type RouteMatchConfig struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	PathRegex  string   `yaml:"path_regex"`
+	Hosts      []string `yaml:"hosts"`
+}
+
+// LimitsConfig bounds the size and shape of requests. Zero disables a limit.
+type LimitsConfig struct {
+	MaxURLLength      int `yaml:"max_url_length"`
+	MaxHeaderCount    int `yaml:"max_header_count"`
+	MaxHeaderSize     int `yaml:"max_header_size"`
+	MaxArgCount       int `yaml:"max_arg_count"`
+	MaxArgNameLength  int `yaml:"max_arg_name_length"`
+	MaxArgValueLength int `yaml:"max_arg_value_length"`
+	// MaxBodySize applies to non-multipart bodies; multipart bodies and the
+	// files they carry have their own limits
+	MaxBodySize          int64 `yaml:"max_body_size"`
+	MaxMultipartBodySize int64 `yaml:"max_multipart_body_size"`
+	MaxFileSize          int64 `yaml:"max_file_size"`
+	// Action is "reject" (413/414/431) or "score" to add Score per exceeded limit
+	Action string `yaml:"action"`
+	Score  int    `yaml:"score"`
+}
+
+// BanConfig contains automatic temporary IP ban settings
+type BanConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// BlockThreshold bans a client after this many blocked requests within the window
+	BlockThreshold int `yaml:"block_threshold"`
+	// RateLimitThreshold bans a client after this many rate-limit violations within the window
+	RateLimitThreshold int `yaml:"rate_limit_threshold"`
+	WindowSeconds      int `yaml:"window_seconds"`
+	// BanRules bans a client immediately when any of these rule IDs fires
+	BanRules           []string `yaml:"ban_rules"`
+	BanSeconds         int      `yaml:"ban_seconds"`
+	MaxBanSeconds      int      `yaml:"max_ban_seconds"`
+	EscalationFactor   float64  `yaml:"escalation_factor"`
+	OffenseMemoryHours int      `yaml:"offense_memory_hours"`
+	// PersistFile is where bans are saved across restarts (empty for memory only)
+	PersistFile string `yaml:"persist_file"`
+}
+
+// CollectionsConfig contains persistent per-client collection settings
+type CollectionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SessionCookie names the cookie that keys the session collection
+	SessionCookie string `yaml:"session_cookie"`
+	// APIKeyHeader names the header that keys the apikey collection
+	APIKeyHeader string `yaml:"api_key_header"`
+	// PersistFile is where collections are saved across restarts (empty for memory only)
+	PersistFile string `yaml:"persist_file"`
+	// MaxEntries bounds the stored variables; the least recently used are evicted (0 = 100000)
+	MaxEntries int `yaml:"max_entries"`
+	// DefaultTTLSeconds expires variables set without a ttl after this long
+	// without a write (0 = 24h)
+	DefaultTTLSeconds int `yaml:"default_ttl_seconds"`
 }
 
 // RateLimitConfig contains rate limiting settings
 type RateLimitConfig struct {
-	Enabled      bool `yaml:"enabled"`
-	MaxRequests  int  `yaml:"max_requests"`
-	WindowSeconds int `yaml:"window_seconds"`
+	Enabled       bool `yaml:"enabled"`
+	MaxRequests   int  `yaml:"max_requests"`
+	WindowSeconds int  `yaml:"window_seconds"`
+	// Algorithm is one of sliding_window (default), sliding_log, token_bucket or gcra
+	Algorithm string `yaml:"algorithm"`
+	// Burst is the bucket size for token_bucket and gcra (defaults to max_requests)
+	Burst int `yaml:"burst"`
+	// Shards and MaxKeys size the limiter's key table (LRU eviction beyond MaxKeys)
+	Shards  int `yaml:"shards"`
+	MaxKeys int `yaml:"max_keys"`
+	// DisableHeaders omits the RateLimit-* response headers
+	DisableHeaders bool `yaml:"disable_headers"`
+	// LegacyHeaders also sends X-RateLimit-Limit/Remaining/Reset
+	LegacyHeaders bool `yaml:"legacy_headers"`
+	// Policies replace the single per-IP limit above when set. Unset policy
+	// limits, windows and algorithms default to the values above.
+	Policies []RateLimitPolicyConfig `yaml:"policies"`
+}
+
+// RateLimitPolicyConfig is a rate limit for the requests matching a path, method and host
+type RateLimitPolicyConfig struct {
+	Name  string               `yaml:"name"`
+	Match RateLimitMatchConfig `yaml:"match"`
+	// Key is a "+" separated key expression: ip, ipv6_64, header:<name>,
+	// cookie:<name> or jwt:<claim> (default ip)
+	Key           string `yaml:"key"`
+	MaxRequests   int    `yaml:"max_requests"`
+	WindowSeconds int    `yaml:"window_seconds"`
+	Burst         int    `yaml:"burst"`
+	Algorithm     string `yaml:"algorithm"`
+	// Action is block (default), challenge, score or log
+	Action string `yaml:"action"`
+	// Score is added to the anomaly score by the score action
+	Score int `yaml:"score"`
+}
+
+// RateLimitMatchConfig selects the requests a rate limit policy applies to
+type RateLimitMatchConfig struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	PathRegex  string   `yaml:"path_regex"`
+	Methods    []string `yaml:"methods"`
+	Hosts      []string `yaml:"hosts"`
 }
 
 // IPFilterConfig contains IP filtering settings
@@ -45,12 +376,51 @@ type IPFilterConfig struct {
 	Enabled   bool     `yaml:"enabled"`
 	Whitelist []string `yaml:"whitelist"`
 	Blacklist []string `yaml:"blacklist"`
+	// Lists are reputation feeds loaded from files or directories
+	Lists []ReputationListConfig `yaml:"lists"`
+	// RefreshSeconds is how often list files are checked for changes
+	RefreshSeconds int `yaml:"refresh_seconds"`
+	// Country (ISO 3166-1 alpha-2) and ASN access policies; requires security.geoip.
+	// When an allow list is set, clients outside it are denied; clients whose
+	// country or ASN is unknown are not affected by allow lists.
+	AllowCountries []string `yaml:"allow_countries"`
+	DenyCountries  []string `yaml:"deny_countries"`
+	AllowASNs      []uint   `yaml:"allow_asns"`
+	DenyASNs       []uint   `yaml:"deny_asns"`
+}
+
+// GeoIPConfig contains GeoIP/ASN database settings (MaxMind .mmdb format)
+type GeoIPConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	CountryDatabase string `yaml:"country_database"`
+	ASNDatabase     string `yaml:"asn_database"`
+	// RefreshSeconds is how often the database files are checked for changes
+	RefreshSeconds int `yaml:"refresh_seconds"`
+}
+
+// ReputationListConfig describes a named IP reputation list
+type ReputationListConfig struct {
+	Name   string `yaml:"name"`
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+	// Action is "block" to reject listed clients or "score" to add Score to the
+	// anomaly score; rules can also match list membership via the ip_reputation target
+	Action string `yaml:"action"`
+	Score  int    `yaml:"score"`
 }
 
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
-	Level  string `yaml:"level"`
-	Output string `yaml:"output"`
+	Level       string            `yaml:"level"`
+	Output      string            `yaml:"output"`
+	MatchedData MatchedDataConfig `yaml:"matched_data"`
+}
+
+// MatchedDataConfig controls how the data that triggered a rule is captured
+type MatchedDataConfig struct {
+	MaxLength       int      `yaml:"max_length"`
+	Redact          bool     `yaml:"redact"`
+	RedactVariables []string `yaml:"redact_variables"`
 }
 
 // RulesConfig contains rule file paths
@@ -83,9 +453,42 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Server.IdleTimeoutSeconds == 0 {
 		cfg.Server.IdleTimeoutSeconds = 60
 	}
+	if cfg.Server.ReadHeaderTimeoutSeconds == 0 {
+		cfg.Server.ReadHeaderTimeoutSeconds = 5
+	}
+	if cfg.Server.BodyRateGraceSeconds == 0 {
+		cfg.Server.BodyRateGraceSeconds = 5
+	}
 	if cfg.Security.AnomalyThreshold == 0 {
 		cfg.Security.AnomalyThreshold = 10
 	}
+	if cfg.Security.Response.MaxBodyBytes == 0 {
+		cfg.Security.Response.MaxBodyBytes = 512 * 1024
+	}
+	if cfg.Security.PIIMasking.MaxBufferBytes == 0 {
+		cfg.Security.PIIMasking.MaxBufferBytes = 1024 * 1024
+	}
+	if cfg.Security.ErrorPages.SnippetBytes == 0 {
+		cfg.Security.ErrorPages.SnippetBytes = 1024
+	}
+	if cfg.Security.Challenge.Difficulty == 0 {
+		cfg.Security.Challenge.Difficulty = 16
+	}
+	if cfg.Security.Challenge.CookieName == "" {
+		cfg.Security.Challenge.CookieName = "waf_clearance"
+	}
+	if cfg.Security.Challenge.ClearanceSeconds == 0 {
+		cfg.Security.Challenge.ClearanceSeconds = 3600
+	}
+	if cfg.Security.Challenge.ChallengeSeconds == 0 {
+		cfg.Security.Challenge.ChallengeSeconds = 300
+	}
+	if cfg.Security.Challenge.Path == "" {
+		cfg.Security.Challenge.Path = "/.waf/challenge"
+	}
+	if cfg.Security.Challenge.FallbackDelaySeconds == 0 {
+		cfg.Security.Challenge.FallbackDelaySeconds = 5
+	}
 	// Rate limit defaults
 	if cfg.Security.RateLimit.MaxRequests == 0 {
 		cfg.Security.RateLimit.MaxRequests = 100
@@ -93,12 +496,65 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Security.RateLimit.WindowSeconds == 0 {
 		cfg.Security.RateLimit.WindowSeconds = 60
 	}
+	if cfg.Security.RateLimit.Algorithm == "" {
+		cfg.Security.RateLimit.Algorithm = "sliding_window"
+	}
+	if cfg.Security.Limits.Action == "" {
+		cfg.Security.Limits.Action = "reject"
+	}
+	if cfg.Security.Limits.Score == 0 {
+		cfg.Security.Limits.Score = 5
+	}
+	if cfg.Security.IPFilter.RefreshSeconds == 0 {
+		cfg.Security.IPFilter.RefreshSeconds = 60
+	}
+	if cfg.Security.GeoIP.RefreshSeconds == 0 {
+		cfg.Security.GeoIP.RefreshSeconds = 300
+	}
+	// Ban defaults
+	if cfg.Security.Bans.WindowSeconds == 0 {
+		cfg.Security.Bans.WindowSeconds = 60
+	}
+	if cfg.Security.Bans.BanSeconds == 0 {
+		cfg.Security.Bans.BanSeconds = 300
+	}
+	if cfg.Security.Bans.MaxBanSeconds == 0 {
+		cfg.Security.Bans.MaxBanSeconds = 86400
+	}
+	if cfg.Security.Bans.EscalationFactor == 0 {
+		cfg.Security.Bans.EscalationFactor = 2
+	}
+	if cfg.Security.Bans.OffenseMemoryHours == 0 {
+		cfg.Security.Bans.OffenseMemoryHours = 24
+	}
+	// Shared state defaults
+	if cfg.State.SyncIntervalMs == 0 {
+		cfg.State.SyncIntervalMs = 100
+	}
+	if cfg.State.CacheTTLMs == 0 {
+		cfg.State.CacheTTLMs = 1000
+	}
+	if cfg.State.FailMode == "" {
+		cfg.State.FailMode = "open"
+	}
+	if cfg.State.Redis.KeyPrefix == "" {
+		cfg.State.Redis.KeyPrefix = "waf:"
+	}
+	if cfg.State.Cluster.BindAddress == "" {
+		cfg.State.Cluster.BindAddress = ":7946"
+	}
+	if cfg.State.Cluster.GossipIntervalMs == 0 {
+		cfg.State.Cluster.GossipIntervalMs = 1000
+	}
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info"
 	}
 	if cfg.Logging.Output == "" {
 		cfg.Logging.Output = "stdout"
 	}
+	if cfg.Logging.MatchedData.MaxLength == 0 {
+		cfg.Logging.MatchedData.MaxLength = DefaultMatchedDataLength
+	}
 
 	return &cfg, nil
 }
@@ -108,6 +564,11 @@ func (s *ServerConfig) ReadTimeout() time.Duration {
 	return time.Duration(s.ReadTimeoutSeconds) * time.Second
 }
 
+// ReadHeaderTimeout returns the header read timeout as a time.Duration
+func (s *ServerConfig) ReadHeaderTimeout() time.Duration {
+	return time.Duration(s.ReadHeaderTimeoutSeconds) * time.Second
+}
+
 // WriteTimeout returns the write timeout as a time.Duration
 func (s *ServerConfig) WriteTimeout() time.Duration {
 	return time.Duration(s.WriteTimeoutSeconds) * time.Second
@@ -117,4 +578,3 @@ func (s *ServerConfig) WriteTimeout() time.Duration {
 func (s *ServerConfig) IdleTimeout() time.Duration {
 	return time.Duration(s.IdleTimeoutSeconds) * time.Second
 }
-
@@ -2,36 +2,86 @@ package decision
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/waf-draft/waf/internal/config"
 	"github.com/waf-draft/waf/internal/detection"
-	"github.com/waf-draft/waf/internal/detection/rules"
 )
 
 // Decision represents the WAF decision for a request
 type Decision struct {
-	Action       string   `json:"action"`
-	Reason       string   `json:"reason"`
-	Score        int      `json:"score"`
-	MatchedRules []string `json:"matched_rules"`
+	Action       string            `json:"action"`
+	Reason       string            `json:"reason"`
+	Score        int               `json:"score"`
+	MatchedRules []string          `json:"matched_rules"`
+	Matches      []detection.Match `json:"matches,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	// ReputationLists names the IP reputation lists the client appears on
+	ReputationLists []string `json:"reputation_lists,omitempty"`
+	// Status is the response status for block and redirect decisions
+	Status int `json:"status,omitempty"`
+	// Location is the redirect target for redirect decisions
+	Location string `json:"location,omitempty"`
+	// RetryAfter is the number of seconds a rate limited client should wait
+	RetryAfter int `json:"retry_after,omitempty"`
 }
 
-// Decide makes a decision based on anomaly score and configuration
-func Decide(score *detection.AnomalyScore, matchedRules []rules.Rule, cfg *config.Config) Decision {
+// redactedValue replaces matched data that must not be logged
+const redactedValue = "[REDACTED]"
+
+// Decide makes a decision based on the rule actions, anomaly score and configuration.
+// A disruptive rule action takes precedence over the anomaly threshold.
+func Decide(tx *detection.Transaction, cfg *config.Config) Decision {
+	score := tx.Score
 	decision := Decision{
-		Score:        score.Total,
-		MatchedRules: make([]string, 0, len(matchedRules)),
+		Score:           score.Total,
+		MatchedRules:    make([]string, 0, len(tx.MatchedRules)),
+		Tags:            tx.Tags,
+		ReputationLists: tx.IPLists,
 	}
 
 	// Collect matched rule IDs
-	for _, rule := range matchedRules {
+	for _, rule := range tx.MatchedRules {
 		decision.MatchedRules = append(decision.MatchedRules, rule.ID)
 	}
 
+	// Capture matched data, truncated and redacted per logging policy
+	for _, match := range score.Matches {
+		match.Value = captureValue(match.Variable, match.Value, cfg.Logging.MatchedData)
+		decision.Matches = append(decision.Matches, match)
+	}
+
+	if d := tx.Disruption; d != nil {
+		switch d.Action {
+		case detection.DisruptDeny:
+			decision.Action = "block"
+			decision.Status = d.Status
+			decision.Reason = fmt.Sprintf("Denied by rule %s", d.RuleID)
+		case detection.DisruptAllow:
+			decision.Action = "allow"
+			decision.Reason = fmt.Sprintf("Allowed by rule %s", d.RuleID)
+		case detection.DisruptRedirect:
+			decision.Action = "redirect"
+			decision.Status = d.Status
+			decision.Location = d.Location
+			decision.Reason = fmt.Sprintf("Redirected by rule %s", d.RuleID)
+		case detection.DisruptDrop:
+			decision.Action = "drop"
+			decision.Reason = fmt.Sprintf("Connection dropped by rule %s", d.RuleID)
+		case detection.DisruptChallenge:
+			decision.Action = "challenge"
+			decision.Reason = fmt.Sprintf("Challenged by rule %s", d.RuleID)
+		}
+		return decision
+	}
+
 	// Make decision based on threshold
 	if score.Total >= cfg.Security.AnomalyThreshold {
 		decision.Action = "block"
 		decision.Reason = fmt.Sprintf("Anomaly score %d exceeds threshold %d", score.Total, cfg.Security.AnomalyThreshold)
+	} else if challenge := cfg.Security.Challenge; challenge.Enabled && challenge.ScoreThreshold > 0 && score.Total >= challenge.ScoreThreshold {
+		decision.Action = "challenge"
+		decision.Reason = fmt.Sprintf("Anomaly score %d reaches challenge threshold %d", score.Total, challenge.ScoreThreshold)
 	} else {
 		decision.Action = "allow"
 		decision.Reason = "Request passed WAF checks"
@@ -40,3 +90,79 @@ func Decide(score *detection.AnomalyScore, matchedRules []rules.Rule, cfg *confi
 	return decision
 }
 
+// captureValue truncates and redacts a matched fragment before it leaves the detection engine
+func captureValue(variable, value string, policy config.MatchedDataConfig) string {
+	if policy.Redact || shouldRedact(variable, policy.RedactVariables) {
+		return redactedValue
+	}
+
+	maxLen := policy.MaxLength
+	if maxLen <= 0 {
+		maxLen = config.DefaultMatchedDataLength
+	}
+	if len(value) > maxLen {
+		return value[:maxLen] + "..."
+	}
+	return value
+}
+
+// shouldRedact reports whether a variable is listed for redaction. Entries ending in
+// "*" match by prefix, so "ARGS:pass*" covers ARGS:password and ARGS:passwd.
+func shouldRedact(variable string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(strings.ToLower(variable), strings.ToLower(prefix)) {
+				return true
+			}
+		} else if strings.EqualFold(variable, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Block creates a block decision for checks that run before rule evaluation,
+// such as IP bans and rate limits
+func Block(reason string, status int) Decision {
+	return Decision{
+		Action:       "block",
+		Reason:       reason,
+		Status:       status,
+		MatchedRules: []string{},
+	}
+}
+
+// Challenge creates a challenge decision for checks that run before rule
+// evaluation, such as rate limits
+func Challenge(reason string, status int) Decision {
+	dec := Block(reason, status)
+	dec.Action = "challenge"
+	return dec
+}
+
+// DecideResponse makes the response-phase decision. Only the score added by
+// response-phase rules counts towards the response threshold, which defaults to
+// the request threshold.
+func DecideResponse(tx *detection.Transaction, cfg *config.Config, requestScore int) Decision {
+	dec := Decide(tx, cfg)
+	if tx.Disruption != nil {
+		if dec.Action == "challenge" {
+			// A response cannot be challenged once the upstream has answered
+			dec.Action = "block"
+		}
+		return dec
+	}
+
+	threshold := cfg.Security.Response.AnomalyThreshold
+	if threshold <= 0 {
+		threshold = cfg.Security.AnomalyThreshold
+	}
+	if score := tx.Score.Total - requestScore; score >= threshold {
+		dec.Action = "block"
+		dec.Reason = fmt.Sprintf("Response anomaly score %d exceeds threshold %d", score, threshold)
+	} else {
+		dec.Action = "allow"
+		dec.Reason = "Response passed WAF checks"
+	}
+	return dec
+}
@@ -0,0 +1,255 @@
+package detection
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/waf-draft/waf/internal/collections"
+	"github.com/waf-draft/waf/internal/detection/rules"
+)
+
+// executeActions runs the actions of a matched rule in order. It returns true when a
+// disruptive action was taken and rule evaluation must stop.
+//
+// Precedence: actions run in the order they are listed and rules run in file order.
+// The first disruptive action (deny, allow, redirect, drop, challenge) wins; any actions listed
+// after it on the same rule are still applied so that a rule can, for example, tag
+// and set a header before denying.
+func executeActions(tx *Transaction, rule rules.Rule, match *Match) (bool, error) {
+	stop := false
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case "add_score":
+			scoreValue, ok := paramInt(action.Param)
+			if !ok {
+				// Use rule severity as fallback
+				scoreValue = rule.Severity
+			}
+			tx.Score.Add(scoreValue, rule.Tags)
+			match.Score += scoreValue
+		case "deny":
+			status, ok := paramInt(action.Param)
+			if !ok || status < 400 || status > 599 {
+				status = http.StatusForbidden
+			}
+			tx.disrupt(Disruption{Action: DisruptDeny, Status: status, RuleID: rule.ID})
+			stop = true
+		case "allow":
+			tx.disrupt(Disruption{Action: DisruptAllow, RuleID: rule.ID})
+			stop = true
+		case "redirect":
+			location, status := redirectParam(action.Param)
+			if location == "" {
+				return stop, fmt.Errorf("rule %s: redirect requires a location", rule.ID)
+			}
+			tx.disrupt(Disruption{Action: DisruptRedirect, Status: status, Location: location, RuleID: rule.ID})
+			stop = true
+		case "drop":
+			tx.disrupt(Disruption{Action: DisruptDrop, RuleID: rule.ID})
+			stop = true
+		case "challenge":
+			tx.disrupt(Disruption{Action: DisruptChallenge, RuleID: rule.ID})
+			stop = true
+		case "pass":
+			// Explicitly non-disruptive; evaluation continues
+		case "log":
+			tx.NoLog = false
+		case "nolog":
+			tx.NoLog = true
+		case "set_header":
+			params := paramMap(action.Param)
+			name := params["name"]
+			if name == "" {
+				return stop, fmt.Errorf("rule %s: set_header requires a name", rule.ID)
+			}
+			if params["target"] == "response" {
+				tx.ResponseHeaders.Set(name, params["value"])
+			} else {
+				tx.RequestHeaders.Set(name, params["value"])
+			}
+		case "tag":
+			tx.Tags = append(tx.Tags, paramList(action.Param)...)
+		case "set_var":
+			params := paramMap(action.Param)
+			if params["name"] == "" {
+				return stop, fmt.Errorf("rule %s: set_var requires a name", rule.ID)
+			}
+			tx.setVar(params["name"], tx.expand(params["value"]), collectionOptions(params))
+		case "mask":
+			if err := tx.mask(rule); err != nil {
+				return stop, fmt.Errorf("rule %s: %w", rule.ID, err)
+			}
+		case "skip_rules":
+			params := paramLists(action.Param)
+			for _, id := range params["ids"] {
+				tx.skipIDs[id] = true
+			}
+			for _, tag := range params["tags"] {
+				tx.skipTags[tag] = true
+			}
+		default:
+			return stop, fmt.Errorf("rule %s: unknown action type: %s", rule.ID, action.Type)
+		}
+	}
+
+	return stop, nil
+}
+
+// disrupt records a disruptive action unless an earlier one was already taken
+func (tx *Transaction) disrupt(d Disruption) {
+	if tx.Disruption == nil {
+		tx.Disruption = &d
+	}
+}
+
+// setVar assigns a variable. Names qualified with a collection ("ip.score") are written
+// to the persistent collection; other names, optionally prefixed with "tx.", are
+// per-request. Values prefixed with "+" or "-" adjust an existing numeric value
+// instead of replacing it (e.g. "+5").
+func (tx *Transaction) setVar(name, value string, opts collections.Options) {
+	if _, _, ok := collections.Split(name); ok {
+		if tx.Collections != nil {
+			tx.Collections.Set(name, value, opts)
+		}
+		return
+	}
+	name = strings.TrimPrefix(name, "tx.")
+
+	if len(value) > 1 && (value[0] == '+' || value[0] == '-') {
+		if delta, err := strconv.Atoi(value[1:]); err == nil {
+			current, _ := strconv.Atoi(tx.Vars[name])
+			if value[0] == '-' {
+				delta = -delta
+			}
+			tx.Vars[name] = strconv.Itoa(current + delta)
+			return
+		}
+	}
+	tx.Vars[name] = value
+}
+
+// getVar reads a per-request variable, including the built-in anomaly_score
+func (tx *Transaction) getVar(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "tx.")
+	if name == "anomaly_score" {
+		return strconv.Itoa(tx.Score.Total), true
+	}
+	value, ok := tx.Vars[name]
+	return value, ok
+}
+
+// expand replaces %{name} macros in an action value with variable values, e.g.
+// "+%{tx.anomaly_score}" or "%{ip.score}". Unknown variables expand to "".
+func (tx *Transaction) expand(value string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(value, "%{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(value[start:], "}")
+		if end < 0 {
+			break
+		}
+		name := value[start+2 : start+end]
+
+		var replacement string
+		if _, _, ok := collections.Split(name); ok {
+			if tx.Collections != nil {
+				replacement, _ = tx.Collections.Get(name)
+			}
+		} else {
+			replacement, _ = tx.getVar(name)
+		}
+		b.WriteString(value[:start])
+		b.WriteString(replacement)
+		value = value[start+end+1:]
+	}
+	b.WriteString(value)
+	return b.String()
+}
+
+// collectionOptions reads ttl and decay settings (in seconds) from set_var parameters
+func collectionOptions(params map[string]string) collections.Options {
+	var opts collections.Options
+	if ttl, err := strconv.Atoi(params["ttl"]); err == nil && ttl > 0 {
+		opts.TTL = time.Duration(ttl) * time.Second
+	}
+	if amount, err := strconv.ParseInt(params["decay_amount"], 10, 64); err == nil && amount > 0 {
+		opts.DecayAmount = amount
+	}
+	if interval, err := strconv.Atoi(params["decay_interval"]); err == nil && interval > 0 {
+		opts.DecayInterval = time.Duration(interval) * time.Second
+	}
+	return opts
+}
+
+// redirectParam accepts either a location string or a map with location and status
+func redirectParam(param interface{}) (string, int) {
+	if location, ok := param.(string); ok {
+		return location, http.StatusFound
+	}
+	params := paramMap(param)
+	status, err := strconv.Atoi(params["status"])
+	if err != nil || status < 300 || status > 399 {
+		status = http.StatusFound
+	}
+	return params["location"], status
+}
+
+// paramInt converts a numeric action parameter
+func paramInt(param interface{}) (int, bool) {
+	switch v := param.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// paramMap converts a mapping action parameter into string values
+func paramMap(param interface{}) map[string]string {
+	result := make(map[string]string)
+	if m, ok := param.(map[string]interface{}); ok {
+		for k, v := range m {
+			result[k] = fmt.Sprint(v)
+		}
+	}
+	return result
+}
+
+// paramList converts a string or list action parameter into a slice
+func paramList(param interface{}) []string {
+	switch v := param.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+// paramLists converts a mapping of lists (e.g. {ids: [...], tags: [...]}) into slices
+func paramLists(param interface{}) map[string][]string {
+	result := make(map[string][]string)
+	if m, ok := param.(map[string]interface{}); ok {
+		for k, v := range m {
+			result[strings.ToLower(k)] = paramList(v)
+		}
+	}
+	return result
+}
@@ -2,8 +2,9 @@ package detection
 
 // AnomalyScore tracks the anomaly score for a request
 type AnomalyScore struct {
-	Total int
-	Tags  map[string]int
+	Total   int
+	Tags    map[string]int
+	Matches []Match
 }
 
 // NewAnomalyScore creates a new AnomalyScore
@@ -27,3 +28,7 @@ func (a *AnomalyScore) GetTagScore(tag string) int {
 	return a.Tags[tag]
 }
 
+// AddMatch records the data that triggered a rule
+func (a *AnomalyScore) AddMatch(match Match) {
+	a.Matches = append(a.Matches, match)
+}
@@ -1,120 +1,375 @@
 package detection
 
 import (
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/waf-draft/waf/internal/detection/rules"
 	"github.com/waf-draft/waf/internal/normalize"
 )
 
-// EvaluateRequest evaluates a request against all rules and returns anomaly score and matched rules
-func EvaluateRequest(req *http.Request, norm *normalize.NormalizedRequest, ruleSet []rules.Rule) (*AnomalyScore, []rules.Rule, error) {
-	score := NewAnomalyScore()
-	var matchedRules []rules.Rule
+// EvaluateRequest evaluates a request against all rules and returns the resulting transaction
+// with the anomaly score, matched rules and any actions requested by those rules
+func EvaluateRequest(req *http.Request, norm *normalize.NormalizedRequest, ruleSet []rules.Rule) (*Transaction, error) {
+	tx := NewTransaction()
+	return tx, EvaluateTransaction(tx, req, norm, ruleSet)
+}
+
+// EvaluateTransaction evaluates a request against all rules using a prepared transaction,
+// e.g. one bound to persistent collections
+func EvaluateTransaction(tx *Transaction, req *http.Request, norm *normalize.NormalizedRequest, ruleSet []rules.Rule) error {
+	return evaluatePhase(tx, req, norm, ruleSet, PhaseRequest)
+}
 
+// evaluatePhase evaluates the rules of one phase. Rules without a phase belong to
+// the request phase.
+func evaluatePhase(tx *Transaction, req *http.Request, norm *normalize.NormalizedRequest, ruleSet []rules.Rule, phase string) error {
 	for _, rule := range ruleSet {
 		// Skip rules that don't match the current phase
-		if rule.Phase != "request" && rule.Phase != "" {
+		rulePhase := rule.Phase
+		if rulePhase == "" {
+			rulePhase = PhaseRequest
+		}
+		if rulePhase != phase {
+			continue
+		}
+		if tx.shouldSkip(rule) {
 			continue
 		}
 
-		matched, err := evaluateRule(req, norm, rule)
+		match, matched, err := evaluateRule(req, norm, tx, rule)
 		if err != nil {
 			// Log error but continue with other rules
 			continue
 		}
 
 		if matched {
-			matchedRules = append(matchedRules, rule)
-			// Process actions
-			for _, action := range rule.Actions {
-				if action.Type == "add_score" {
-					var scoreValue int
-					switch v := action.Param.(type) {
-					case int:
-						scoreValue = v
-					case float64:
-						scoreValue = int(v)
-					default:
-						// Use rule severity as fallback
-						scoreValue = rule.Severity
-					}
-					score.Add(scoreValue, rule.Tags)
-				}
+			tx.MatchedRules = append(tx.MatchedRules, rule)
+			stop, err := executeActions(tx, rule, &match)
+			tx.Score.AddMatch(match)
+			if err != nil {
+				// A broken action must not stop the remaining rules from running
+				log.Printf("Warning: %v", err)
+			}
+			if stop {
+				break
 			}
 		}
 	}
 
-	return score, matchedRules, nil
+	return nil
 }
 
-// evaluateRule checks if a rule matches the request
-func evaluateRule(req *http.Request, norm *normalize.NormalizedRequest, rule rules.Rule) (bool, error) {
+// evaluateRule checks if a rule matches the request. The returned Match describes
+// the variable that satisfied the last condition, mirroring ModSecurity's MATCHED_VAR.
+func evaluateRule(req *http.Request, norm *normalize.NormalizedRequest, tx *Transaction, rule rules.Rule) (Match, bool, error) {
+	match := Match{RuleID: rule.ID}
+
 	// All conditions must match (AND logic)
 	for _, condition := range rule.Conditions {
-		matched, err := evaluateCondition(req, norm, condition)
+		variable, fragment, matched, err := evaluateCondition(req, norm, tx, condition)
 		if err != nil {
-			return false, err
+			return match, false, err
 		}
 		if !matched {
-			return false, nil
+			return match, false, nil
 		}
+		match.Variable = variable
+		match.Value = fragment
+		match.Operator = condition.Operator
 	}
 
-	return true, nil
+	return match, true, nil
 }
 
-// evaluateCondition checks if a condition matches
-func evaluateCondition(req *http.Request, norm *normalize.NormalizedRequest, condition rules.MatchCondition) (bool, error) {
-	var value string
+// evaluateCondition checks if a condition matches any of its target variables and
+// returns the name of the first matching variable together with the matched fragment
+func evaluateCondition(req *http.Request, norm *normalize.NormalizedRequest, tx *Transaction, condition rules.MatchCondition) (string, string, bool, error) {
+	for _, variable := range collectVariables(norm, tx, condition) {
+		value := variable.Value
+		for _, t := range condition.Transforms {
+			var err error
+			if value, err = normalize.Transform(t, value); err != nil {
+				return "", "", false, err
+			}
+		}
+		fragment, matched, err := (&condition).Find(value)
+		if err != nil {
+			return "", "", false, err
+		}
+		if matched {
+			return variable.Name, fragment, true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
 
+// collectVariables expands a condition target into the request variables it covers
+func collectVariables(norm *normalize.NormalizedRequest, tx *Transaction, condition rules.MatchCondition) []Variable {
 	switch condition.Target {
 	case "path":
 		// For path traversal detection, check original path
 		// For other checks, use normalized path
 		if strings.Contains(condition.Value, "..") || strings.Contains(condition.Value, "%2e") {
-			value = norm.OriginalPath
-		} else {
-			value = norm.Path
-		}
-	case "query":
-		// Check all query parameters
-		queryStr := ""
-		for k, vals := range norm.Query {
-			queryStr += k + "=" + strings.Join(vals, ",") + "&"
-		}
-		value = queryStr
-	case "query_param":
-		// This would need a specific parameter name, for now check all
-		queryStr := ""
-		for k, vals := range norm.Query {
-			queryStr += k + "=" + strings.Join(vals, ",") + "&"
-		}
-		value = queryStr
+			vars := []Variable{{Name: "REQUEST_URI_RAW", Value: norm.OriginalPath}}
+			if norm.DecodedPath != "" && norm.DecodedPath != norm.OriginalPath {
+				// Catches traversal hidden by nested, %u or overlong encodings
+				vars = append(vars, Variable{Name: "REQUEST_URI_DECODED", Value: norm.DecodedPath})
+			}
+			return vars
+		}
+		return []Variable{{Name: "REQUEST_FILENAME", Value: norm.Path}}
+	case "query", "query_param":
+		return argVariables("ARGS", norm.Query, condition)
+	case "body_args":
+		return argVariables("ARGS_POST", norm.BodyArgs, condition)
+	case "args":
+		return argVariables("ARGS", norm.Args(), condition)
+	case "args_repeated":
+		return repeatedArgVariables(norm, condition.Name)
 	case "header":
-		// Check all headers
-		headerStr := ""
-		for k, v := range norm.Headers {
-			headerStr += k + ":" + v + "\n"
+		return headerVariables(norm, condition.Name)
+	case "header_order":
+		if norm.HeaderOrder == nil {
+			return nil
 		}
-		value = headerStr
+		return []Variable{{Name: "REQUEST_HEADERS_ORDER", Value: strings.Join(norm.HeaderOrder, ",")}}
+	case "cookie":
+		return cookieVariables(norm, condition.Name)
 	case "body":
-		value = norm.Body
+		return []Variable{{Name: "REQUEST_BODY", Value: norm.Body}}
 	case "method":
-		value = norm.Method
+		return []Variable{{Name: "REQUEST_METHOD", Value: norm.Method}}
+	case "tx":
+		return txVariables(tx, condition.Name)
+	case "collection":
+		return collectionVariables(tx, condition.Name)
+	case "geo_country":
+		return []Variable{{Name: "GEO:COUNTRY_CODE", Value: norm.Country}}
+	case "geo_asn":
+		if norm.ASN == 0 {
+			return nil
+		}
+		return []Variable{{Name: "GEO:ASN", Value: strconv.FormatUint(uint64(norm.ASN), 10)}}
+	case "geo_org":
+		return []Variable{{Name: "GEO:ORGANIZATION", Value: norm.ASOrganization}}
+	case "decode_layers":
+		return []Variable{{Name: "DECODE_LAYERS", Value: strconv.Itoa(norm.DecodeLayers)}}
+	case "protocol":
+		return protocolVariables(norm, condition.Name)
+	case "response_status":
+		if tx.Response == nil {
+			return nil
+		}
+		return []Variable{{Name: "RESPONSE_STATUS", Value: strconv.Itoa(tx.Response.Status)}}
+	case "response_header":
+		return responseHeaderVariables(tx, condition.Name)
+	case "response_body":
+		if tx.Response == nil {
+			return nil
+		}
+		return []Variable{{Name: "RESPONSE_BODY", Value: tx.Response.Body}}
+	case "response_detector":
+		return detectorVariables(tx, condition.Name)
+	case "ip_reputation":
+		vars := make([]Variable, 0, len(tx.IPLists))
+		for _, list := range tx.IPLists {
+			vars = append(vars, Variable{Name: "IP_REPUTATION", Value: list})
+		}
+		return vars
 	default:
 		// Try to match against all fields
-		allFields := norm.Path + " " + norm.Body
-		for k, vals := range norm.Query {
-			allFields += " " + k + "=" + strings.Join(vals, ",")
+		vars := []Variable{{Name: "REQUEST_FILENAME", Value: norm.Path}}
+		if norm.Body != "" {
+			vars = append(vars, Variable{Name: "REQUEST_BODY", Value: norm.Body})
+		}
+		vars = append(vars, queryVariables(norm)...)
+		return append(vars, headerVariables(norm, "")...)
+	}
+}
+
+// argVariables returns the parameter variables for a condition, restricted to
+// one parameter when a name is given and combining the occurrences of each
+// parameter when the condition asks for it
+func argVariables(collection string, args map[string][]string, condition rules.MatchCondition) []Variable {
+	if condition.Name != "" {
+		values, ok := args[condition.Name]
+		if !ok {
+			return nil
+		}
+		args = map[string][]string{condition.Name: values}
+	}
+	if condition.Combine == "" {
+		return paramVariables(collection, args)
+	}
+
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys))
+	for _, k := range keys {
+		// The mode is validated before variables are collected
+		value, _ := normalize.CombineValues(args[k], condition.Combine)
+		vars = append(vars, Variable{Name: collection + ":" + k, Value: value})
+	}
+	return vars
+}
+
+// repeatedArgVariables returns one ARGS_REPEATED variable per parameter sent
+// more than once, holding its number of occurrences
+func repeatedArgVariables(norm *normalize.NormalizedRequest, name string) []Variable {
+	keys := make([]string, 0, len(norm.RepeatedArgs))
+	for k := range norm.RepeatedArgs {
+		if name == "" || k == name {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, Variable{Name: "ARGS_REPEATED:" + k, Value: strconv.Itoa(norm.RepeatedArgs[k])})
+	}
+	return vars
+}
+
+// queryVariables returns one ARGS variable per query value and one ARGS_NAMES variable per parameter
+func queryVariables(norm *normalize.NormalizedRequest) []Variable {
+	return paramVariables("ARGS", norm.Query)
+}
+
+// paramVariables returns one variable per parameter occurrence and one _NAMES
+// variable per parameter
+func paramVariables(collection string, args map[string][]string) []Variable {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys)*2)
+	for _, k := range keys {
+		vars = append(vars, Variable{Name: collection + "_NAMES:" + k, Value: k})
+		for _, v := range args[k] {
+			vars = append(vars, Variable{Name: collection + ":" + k, Value: v})
 		}
+	}
+	return vars
+}
+
+// headerVariables returns one REQUEST_HEADERS variable per header value,
+// restricted to one header when a name is given
+func headerVariables(norm *normalize.NormalizedRequest, name string) []Variable {
+	values := norm.HeaderValues
+	if values == nil {
+		values = make(map[string][]string, len(norm.Headers))
 		for k, v := range norm.Headers {
-			allFields += " " + k + ":" + v
+			values[k] = []string{v}
+		}
+	}
+	if name != "" {
+		name = strings.ToLower(name)
+		vars := make([]Variable, 0, len(values[name]))
+		for _, v := range values[name] {
+			vars = append(vars, Variable{Name: "REQUEST_HEADERS:" + name, Value: v})
+		}
+		return vars
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range values[k] {
+			vars = append(vars, Variable{Name: "REQUEST_HEADERS:" + k, Value: v})
+		}
+	}
+	return vars
+}
+
+// cookieVariables returns one REQUEST_COOKIES variable per cookie value and one
+// REQUEST_COOKIES_NAMES variable per cookie, restricted to one cookie when a
+// name is given
+func cookieVariables(norm *normalize.NormalizedRequest, name string) []Variable {
+	if name != "" {
+		vars := make([]Variable, 0, len(norm.Cookies[name]))
+		for _, v := range norm.Cookies[name] {
+			vars = append(vars, Variable{Name: "REQUEST_COOKIES:" + name, Value: v})
+		}
+		return vars
+	}
+
+	keys := make([]string, 0, len(norm.Cookies))
+	for k := range norm.Cookies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys)*2)
+	for _, k := range keys {
+		vars = append(vars, Variable{Name: "REQUEST_COOKIES_NAMES:" + k, Value: k})
+		for _, v := range norm.Cookies[k] {
+			vars = append(vars, Variable{Name: "REQUEST_COOKIES:" + k, Value: v})
+		}
+	}
+	return vars
+}
+
+// protocolVariables returns one PROTOCOL variable per protocol anomaly, restricted
+// to one anomaly name when given
+func protocolVariables(norm *normalize.NormalizedRequest, name string) []Variable {
+	vars := make([]Variable, 0, len(norm.ProtocolAnomalies))
+	for _, a := range norm.ProtocolAnomalies {
+		if name == "" || a.Name == name {
+			vars = append(vars, Variable{Name: "PROTOCOL:" + a.Name, Value: a.Detail})
 		}
-		value = allFields
 	}
+	return vars
+}
+
+// txVariables returns per-request variables, restricted to one name when given.
+// The built-in anomaly_score variable holds the score accumulated so far.
+func txVariables(tx *Transaction, name string) []Variable {
+	if name != "" {
+		value, ok := tx.getVar(name)
+		if !ok {
+			return nil
+		}
+		return []Variable{{Name: "TX:" + name, Value: value}}
+	}
+
+	keys := make([]string, 0, len(tx.Vars))
+	for k := range tx.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	return (&condition).Match(value)
+	vars := make([]Variable, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, Variable{Name: "TX:" + k, Value: tx.Vars[k]})
+	}
+	return vars
 }
 
+// collectionVariables returns a persistent collection variable such as "ip.score"
+func collectionVariables(tx *Transaction, name string) []Variable {
+	if tx.Collections == nil {
+		return nil
+	}
+	value, ok := tx.Collections.Get(name)
+	if !ok {
+		return nil
+	}
+	return []Variable{{Name: strings.ToUpper(name), Value: value}}
+}
@@ -0,0 +1,26 @@
+package detection
+
+import "strings"
+
+// Match records the request data that triggered a rule
+type Match struct {
+	RuleID   string `json:"rule_id"`
+	Variable string `json:"variable"`
+	Value    string `json:"value"`
+	Operator string `json:"operator"`
+	Score    int    `json:"score"`
+}
+
+// Variable is a named piece of request data that conditions are evaluated against
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// Collection returns the collection part of a variable name (e.g. ARGS for ARGS:q)
+func Collection(variable string) string {
+	if i := strings.Index(variable, ":"); i >= 0 {
+		return variable[:i]
+	}
+	return variable
+}
@@ -0,0 +1,122 @@
+package detection
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/normalize"
+	"github.com/waf-draft/waf/internal/response"
+)
+
+// Rule evaluation phases. Rules without a phase run in the request phase.
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
+// ResponseData is the upstream response inspected by response-phase rules
+type ResponseData struct {
+	Status  int
+	Headers http.Header
+	// Body is the decompressed body, truncated to the inspection limit
+	Body string
+	// Findings are the leaks reported by the built-in detectors
+	Findings []response.Finding
+	// Masks are the parts of Body that mask actions asked to hide
+	Masks []response.Finding
+}
+
+// EvaluateResponse evaluates the response-phase rules against an upstream response,
+// continuing the transaction built for the request
+func EvaluateResponse(tx *Transaction, req *http.Request, norm *normalize.NormalizedRequest, resp *ResponseData, ruleSet []rules.Rule) error {
+	tx.Response = resp
+	return evaluatePhase(tx, req, norm, ruleSet, PhaseResponse)
+}
+
+// mask records the parts of the response body matched by the response_body and
+// response_detector conditions of a rule. Transforms are not applied, so the
+// recorded offsets always refer to the body as sent. Outside the response phase
+// there is nothing to mask.
+func (tx *Transaction) mask(rule rules.Rule) error {
+	if tx.Response == nil {
+		return nil
+	}
+	for _, condition := range rule.Conditions {
+		switch condition.Target {
+		case "response_body":
+			locs, err := condition.FindAllIndex(tx.Response.Body)
+			if err != nil {
+				return err
+			}
+			for _, loc := range locs {
+				tx.Response.Masks = append(tx.Response.Masks, response.Finding{
+					Detector: rule.ID,
+					Value:    tx.Response.Body[loc[0]:loc[1]],
+					Start:    loc[0],
+					End:      loc[1],
+				})
+			}
+		case "response_detector":
+			for _, f := range tx.Response.Findings {
+				if condition.Name != "" && f.Detector != condition.Name {
+					continue
+				}
+				matched, err := condition.Match(f.Value)
+				if err != nil {
+					return err
+				}
+				if matched {
+					tx.Response.Masks = append(tx.Response.Masks, f)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// responseHeaderVariables returns one RESPONSE_HEADERS variable per response
+// header value, restricted to one header when a name is given
+func responseHeaderVariables(tx *Transaction, name string) []Variable {
+	if tx.Response == nil {
+		return nil
+	}
+	if name != "" {
+		values := tx.Response.Headers.Values(name)
+		vars := make([]Variable, 0, len(values))
+		for _, v := range values {
+			vars = append(vars, Variable{Name: "RESPONSE_HEADERS:" + strings.ToLower(name), Value: v})
+		}
+		return vars
+	}
+
+	keys := make([]string, 0, len(tx.Response.Headers))
+	for k := range tx.Response.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]Variable, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range tx.Response.Headers[k] {
+			vars = append(vars, Variable{Name: "RESPONSE_HEADERS:" + strings.ToLower(k), Value: v})
+		}
+	}
+	return vars
+}
+
+// detectorVariables returns one RESPONSE_DETECTOR variable per detector finding,
+// restricted to one detector when a name is given
+func detectorVariables(tx *Transaction, name string) []Variable {
+	if tx.Response == nil {
+		return nil
+	}
+	vars := make([]Variable, 0, len(tx.Response.Findings))
+	for _, f := range tx.Response.Findings {
+		if name == "" || f.Detector == name {
+			vars = append(vars, Variable{Name: "RESPONSE_DETECTOR:" + f.Detector, Value: f.Value})
+		}
+	}
+	return vars
+}
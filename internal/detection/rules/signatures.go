@@ -4,28 +4,40 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/waf-draft/waf/internal/normalize"
 	"gopkg.in/yaml.v3"
 )
 
 // Rule represents a WAF detection rule
 type Rule struct {
-	ID         string          `json:"id" yaml:"id"`
-	Name       string          `json:"name" yaml:"name"`
-	Severity   int             `json:"severity" yaml:"severity"`
-	Phase      string          `json:"phase" yaml:"phase"`
+	ID         string           `json:"id" yaml:"id"`
+	Name       string           `json:"name" yaml:"name"`
+	Severity   int              `json:"severity" yaml:"severity"`
+	Phase      string           `json:"phase" yaml:"phase"`
 	Conditions []MatchCondition `json:"conditions" yaml:"conditions"`
-	Actions    []Action        `json:"actions" yaml:"actions"`
-	Tags       []string        `json:"tags" yaml:"tags"`
-	Enabled    bool            `json:"enabled" yaml:"enabled"`
+	Actions    []Action         `json:"actions" yaml:"actions"`
+	Tags       []string         `json:"tags" yaml:"tags"`
+	Enabled    bool             `json:"enabled" yaml:"enabled"`
 }
 
 // MatchCondition defines a condition to match against request data
 type MatchCondition struct {
 	Target   string `json:"target" yaml:"target"`
+	Name     string `json:"name,omitempty" yaml:"name,omitempty"`
 	Operator string `json:"operator" yaml:"operator"`
 	Value    string `json:"value" yaml:"value"`
+	// Transforms are applied in order to each variable value before matching
+	Transforms []string `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	// Combine resolves repeated parameters into one value per parameter
+	// ("first", "last" or "join") instead of matching each occurrence
+	Combine string `json:"combine,omitempty" yaml:"combine,omitempty"`
+
+	// re is the compiled pattern of a regex condition, or the case-insensitive
+	// literal of a contains condition, set by Compile
+	re *regexp.Regexp
 }
 
 // Action defines an action to take when a rule matches
@@ -50,6 +62,19 @@ func LoadRules(filePaths []string) ([]Rule, error) {
 	enabledRules := make([]Rule, 0)
 	for _, rule := range allRules {
 		if rule.Enabled {
+			for i := range rule.Conditions {
+				if err := rule.Conditions[i].Compile(); err != nil {
+					return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+				}
+				if err := rule.Conditions[i].Validate(); err != nil {
+					return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+				}
+			}
+			for _, action := range rule.Actions {
+				if err := action.Validate(); err != nil {
+					return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+				}
+			}
 			enabledRules = append(enabledRules, rule)
 		}
 	}
@@ -72,25 +97,222 @@ func loadRulesFromFile(filePath string) ([]Rule, error) {
 	return rules, nil
 }
 
+// Validate checks that an action type is known and that its parameter is usable
+func (a Action) Validate() error {
+	switch a.Type {
+	case "allow", "drop", "challenge", "pass", "log", "nolog", "mask", "tag", "skip_rules":
+		return nil
+	case "add_score":
+		if _, ok := actionInt(a.Param); a.Param != nil && !ok {
+			return fmt.Errorf("add_score requires a numeric score, got %v", a.Param)
+		}
+	case "deny":
+		if a.Param == nil {
+			return nil
+		}
+		status, ok := actionInt(a.Param)
+		if !ok || status < 400 || status > 599 {
+			return fmt.Errorf("deny requires a 4xx or 5xx status, got %v", a.Param)
+		}
+	case "redirect":
+		location, _ := a.Param.(string)
+		if m, ok := a.Param.(map[string]interface{}); ok {
+			location, _ = m["location"].(string)
+		}
+		if location == "" {
+			return fmt.Errorf("redirect requires a location")
+		}
+	case "set_header", "set_var":
+		m, _ := a.Param.(map[string]interface{})
+		if name, _ := m["name"].(string); name == "" {
+			return fmt.Errorf("%s requires a name", a.Type)
+		}
+	default:
+		return fmt.Errorf("unknown action type: %s", a.Type)
+	}
+	return nil
+}
+
+// actionInt converts a numeric action parameter
+func actionInt(param interface{}) (int, bool) {
+	switch v := param.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Compile precompiles the pattern of a regex or contains condition so that it
+// is not compiled again for every value matched
+func (c *MatchCondition) Compile() error {
+	switch c.Operator {
+	case "contains":
+		c.re = c.literal()
+	case "regex":
+		re, err := c.pattern()
+		if err != nil {
+			return err
+		}
+		c.re = re
+	}
+	return nil
+}
+
+// Validate checks that a condition's transformations and combine mode are known
+func (c MatchCondition) Validate() error {
+	for _, t := range c.Transforms {
+		if !normalize.ValidTransform(t) {
+			return fmt.Errorf("unknown transformation: %s", t)
+		}
+	}
+	if c.Combine != "" && !normalize.ValidCombine(c.Combine) {
+		return fmt.Errorf("unknown combine mode: %s", c.Combine)
+	}
+	return nil
+}
+
+// pattern returns the compiled pattern of a regex condition, compiling it if
+// the condition was not loaded through LoadRules
+func (c *MatchCondition) pattern() (*regexp.Regexp, error) {
+	if c.re != nil {
+		return c.re, nil
+	}
+	re, err := regexp.Compile(c.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern: %w", err)
+	}
+	return re, nil
+}
+
+// literal returns the case-insensitive pattern of a contains condition. Matches
+// are located in the value itself: lowercasing it first can change its length
+// and shift the offsets.
+func (c *MatchCondition) literal() *regexp.Regexp {
+	if c.re != nil {
+		return c.re
+	}
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(c.Value))
+}
+
 // Match checks if a condition matches the given value
 func (c *MatchCondition) Match(value string) (bool, error) {
+	_, matched, err := c.Find(value)
+	return matched, err
+}
+
+// Find checks if a condition matches the given value and returns the matched fragment
+func (c *MatchCondition) Find(value string) (string, bool, error) {
 	switch c.Operator {
 	case "equals":
-		return value == c.Value, nil
+		if value == c.Value {
+			return value, true, nil
+		}
+		return "", false, nil
 	case "contains":
-		return strings.Contains(strings.ToLower(value), strings.ToLower(c.Value)), nil
+		idx := strings.Index(strings.ToLower(value), strings.ToLower(c.Value))
+		if idx < 0 {
+			return "", false, nil
+		}
+		return fragment(value, idx, idx+len(c.Value)), true, nil
 	case "regex":
-		re, err := regexp.Compile(c.Value)
+		re, err := c.pattern()
 		if err != nil {
-			return false, fmt.Errorf("invalid regex pattern: %w", err)
+			return "", false, err
+		}
+		loc := re.FindStringIndex(value)
+		if loc == nil {
+			return "", false, nil
 		}
-		return re.MatchString(value), nil
+		return value[loc[0]:loc[1]], true, nil
 	case "starts_with":
-		return strings.HasPrefix(strings.ToLower(value), strings.ToLower(c.Value)), nil
+		if strings.HasPrefix(strings.ToLower(value), strings.ToLower(c.Value)) {
+			return fragment(value, 0, len(c.Value)), true, nil
+		}
+		return "", false, nil
 	case "ends_with":
-		return strings.HasSuffix(strings.ToLower(value), strings.ToLower(c.Value)), nil
+		if strings.HasSuffix(strings.ToLower(value), strings.ToLower(c.Value)) {
+			return fragment(value, len(value)-len(c.Value), len(value)), true, nil
+		}
+		return "", false, nil
+	case "gt", "ge", "lt", "le", "eq":
+		return c.compare(value)
+	default:
+		return "", false, fmt.Errorf("unknown operator: %s", c.Operator)
+	}
+}
+
+// FindAllIndex returns the byte ranges of every match of the condition in value.
+// Operators other than contains and regex cover the whole value when they match.
+func (c *MatchCondition) FindAllIndex(value string) ([][]int, error) {
+	switch c.Operator {
+	case "contains":
+		if c.Value == "" {
+			return nil, nil
+		}
+		return c.literal().FindAllStringIndex(value, -1), nil
+	case "regex":
+		re, err := c.pattern()
+		if err != nil {
+			return nil, err
+		}
+		return re.FindAllStringIndex(value, -1), nil
 	default:
-		return false, fmt.Errorf("unknown operator: %s", c.Operator)
+		_, matched, err := c.Find(value)
+		if err != nil || !matched {
+			return nil, err
+		}
+		return [][]int{{0, len(value)}}, nil
 	}
 }
 
+// compare performs a numeric comparison between the value and the condition value
+func (c *MatchCondition) compare(value string) (string, bool, error) {
+	want, err := strconv.ParseFloat(strings.TrimSpace(c.Value), 64)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid numeric value %q: %w", c.Value, err)
+	}
+	got, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		// Non-numeric data never satisfies a numeric comparison
+		return "", false, nil
+	}
+
+	var matched bool
+	switch c.Operator {
+	case "gt":
+		matched = got > want
+	case "ge":
+		matched = got >= want
+	case "lt":
+		matched = got < want
+	case "le":
+		matched = got <= want
+	case "eq":
+		matched = got == want
+	}
+	if !matched {
+		return "", false, nil
+	}
+	return value, true, nil
+}
+
+// fragment returns value[start:end] clamped to the bounds of value. Case folding
+// can change byte lengths, so offsets found in a lowered copy may not line up exactly.
+func fragment(value string, start, end int) string {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(value) {
+		end = len(value)
+	}
+	if start >= end {
+		return value
+	}
+	return value[start:end]
+}
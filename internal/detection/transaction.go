@@ -0,0 +1,77 @@
+package detection
+
+import (
+	"net/http"
+
+	"github.com/waf-draft/waf/internal/collections"
+	"github.com/waf-draft/waf/internal/detection/rules"
+)
+
+// Disruptive actions stop rule evaluation and determine how the request is handled
+const (
+	DisruptDeny      = "deny"
+	DisruptAllow     = "allow"
+	DisruptRedirect  = "redirect"
+	DisruptDrop      = "drop"
+	DisruptChallenge = "challenge"
+)
+
+// Disruption records the disruptive action taken by a rule
+type Disruption struct {
+	Action   string
+	Status   int
+	Location string
+	RuleID   string
+}
+
+// Transaction holds the state built up while evaluating rules against one request
+type Transaction struct {
+	Score        *AnomalyScore
+	MatchedRules []rules.Rule
+	// Vars holds per-request variables written by set_var and readable via the "tx" target
+	Vars map[string]string
+	// Tags holds tags added by the tag action
+	Tags []string
+	// Disruption is set by the first deny, allow, redirect, drop or challenge action
+	Disruption *Disruption
+	// RequestHeaders are set on the request before it is forwarded upstream
+	RequestHeaders http.Header
+	// ResponseHeaders are set on the response sent to the client
+	ResponseHeaders http.Header
+	// NoLog suppresses the log entry for this request
+	NoLog bool
+	// IPLists holds the reputation lists the client IP appears on
+	IPLists []string
+	// Collections gives rules access to persistent per-client variables (nil when disabled)
+	Collections *collections.Scope
+	// Response holds the upstream response during the response phase
+	Response *ResponseData
+
+	skipIDs  map[string]bool
+	skipTags map[string]bool
+}
+
+// NewTransaction creates an empty transaction
+func NewTransaction() *Transaction {
+	return &Transaction{
+		Score:           NewAnomalyScore(),
+		Vars:            make(map[string]string),
+		RequestHeaders:  make(http.Header),
+		ResponseHeaders: make(http.Header),
+		skipIDs:         make(map[string]bool),
+		skipTags:        make(map[string]bool),
+	}
+}
+
+// shouldSkip reports whether a previous skip_rules action excluded this rule
+func (tx *Transaction) shouldSkip(rule rules.Rule) bool {
+	if tx.skipIDs[rule.ID] {
+		return true
+	}
+	for _, tag := range rule.Tags {
+		if tx.skipTags[tag] {
+			return true
+		}
+	}
+	return false
+}
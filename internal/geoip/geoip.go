@@ -0,0 +1,184 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is the GeoIP and ASN information for a client address
+type Record struct {
+	Country      string `json:"country,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
+
+// Database is a MaxMind DB file that is reloaded when it changes on disk
+type Database struct {
+	path    string
+	reader  *Reader
+	modTime time.Time
+	size    int64
+	mu      sync.RWMutex
+}
+
+// OpenDatabase loads a .mmdb file
+func OpenDatabase(path string) (*Database, error) {
+	db := &Database{path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload re-reads the database file. On error the previous database stays in use.
+func (db *Database) Reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	buf, err := os.ReadFile(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	reader, err := NewReader(buf)
+	if err != nil {
+		return fmt.Errorf("failed to load GeoIP database %s: %w", db.path, err)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.reader = reader
+	db.modTime = info.ModTime()
+	db.size = info.Size()
+	return nil
+}
+
+// changed reports whether the file differs from the loaded version
+func (db *Database) changed() bool {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return !info.ModTime().Equal(db.modTime) || info.Size() != db.size
+}
+
+// Lookup returns the raw record for an address
+func (db *Database) Lookup(addr netip.Addr) (map[string]interface{}, error) {
+	db.mu.RLock()
+	reader := db.reader
+	db.mu.RUnlock()
+	return reader.Lookup(addr)
+}
+
+// Metadata returns the metadata of the loaded database
+func (db *Database) Metadata() Metadata {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.reader.Metadata()
+}
+
+// Resolver enriches client addresses from a country (or city) database and an ASN database
+type Resolver struct {
+	country *Database
+	asn     *Database
+	refresh *time.Ticker
+	done    chan struct{}
+	stopped sync.Once
+}
+
+// NewResolver opens the given databases (either path may be empty) and, if interval
+// is positive, reloads them when they change on disk
+func NewResolver(countryPath, asnPath string, interval time.Duration) (*Resolver, error) {
+	r := &Resolver{done: make(chan struct{})}
+	var err error
+	if countryPath != "" {
+		if r.country, err = OpenDatabase(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = OpenDatabase(asnPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if interval > 0 {
+		r.refresh = time.NewTicker(interval)
+		go r.watch()
+	}
+
+	return r, nil
+}
+
+// Lookup returns the country, ASN and organization for an IP. Unknown fields are empty.
+func (r *Resolver) Lookup(ipStr string) Record {
+	var record Record
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return record
+	}
+
+	if r.country != nil {
+		if data, err := r.country.Lookup(addr); err == nil && data != nil {
+			record.Country = countryCode(data)
+		}
+	}
+	if r.asn != nil {
+		if data, err := r.asn.Lookup(addr); err == nil && data != nil {
+			record.ASN = uint(toUint(data["autonomous_system_number"]))
+			record.Organization, _ = data["autonomous_system_organization"].(string)
+		}
+	}
+	return record
+}
+
+// countryCode reads country.iso_code, falling back to registered_country.iso_code
+func countryCode(data map[string]interface{}) string {
+	for _, field := range []string{"country", "registered_country"} {
+		if c, ok := data[field].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return strings.ToUpper(code)
+			}
+		}
+	}
+	return ""
+}
+
+// watch reloads databases whose files have changed
+func (r *Resolver) watch() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.refresh.C:
+		}
+
+		for _, db := range []*Database{r.country, r.asn} {
+			if db == nil || !db.changed() {
+				continue
+			}
+			if err := db.Reload(); err != nil {
+				log.Printf("Failed to reload GeoIP database: %v", err)
+			} else {
+				log.Printf("Reloaded GeoIP database %s", db.path)
+			}
+		}
+	}
+}
+
+// Stop stops watching the database files
+func (r *Resolver) Stop() {
+	r.stopped.Do(func() {
+		if r.refresh != nil {
+			r.refresh.Stop()
+		}
+		close(r.done)
+	})
+}
@@ -0,0 +1,376 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+)
+
+// metadataMarker precedes the metadata section at the end of every MaxMind DB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the size of the zero padding between tree and data
+const dataSectionSeparator = 16
+
+// maxDecodeDepth bounds nesting while decoding to guard against malformed files
+const maxDecodeDepth = 32
+
+// ErrInvalidDatabase is returned for files that are not valid MaxMind DB databases
+var ErrInvalidDatabase = errors.New("invalid MaxMind DB file")
+
+// Metadata describes a MaxMind DB database
+type Metadata struct {
+	DatabaseType string
+	IPVersion    int
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+// Reader reads MaxMind DB (.mmdb) files entirely in memory
+type Reader struct {
+	buf         []byte
+	metadata    Metadata
+	treeSize    uint
+	dataStart   uint
+	ipv4Start   uint
+	ipv4Depth   int
+	nodeByteLen uint
+}
+
+// NewReader parses a MaxMind DB file held in memory
+func NewReader(buf []byte) (*Reader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidDatabase)
+	}
+	metaStart := uint(idx + len(metadataMarker))
+	d := decoder{buf: buf[metaStart:]}
+	raw, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{buf: buf}
+	r.metadata.DatabaseType, _ = meta["database_type"].(string)
+	r.metadata.IPVersion = int(toUint(meta["ip_version"]))
+	r.metadata.NodeCount = uint(toUint(meta["node_count"]))
+	r.metadata.RecordSize = uint(toUint(meta["record_size"]))
+	r.metadata.BuildEpoch = toUint(meta["build_epoch"])
+
+	switch r.metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.metadata.RecordSize)
+	}
+	if r.metadata.IPVersion != 4 && r.metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported ip version %d", ErrInvalidDatabase, r.metadata.IPVersion)
+	}
+
+	r.nodeByteLen = r.metadata.RecordSize / 4
+	r.treeSize = r.metadata.NodeCount * r.nodeByteLen
+	r.dataStart = r.treeSize + dataSectionSeparator
+	if r.dataStart > uint(idx) {
+		return nil, fmt.Errorf("%w: search tree exceeds file size", ErrInvalidDatabase)
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 trees; find that node once
+	if r.metadata.IPVersion == 6 {
+		node := uint(0)
+		depth := 0
+		for ; depth < 96 && node < r.metadata.NodeCount; depth++ {
+			node, err = r.readNode(node, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4Start = node
+		r.ipv4Depth = depth
+	}
+
+	return r, nil
+}
+
+// Metadata returns the database metadata
+func (r *Reader) Metadata() Metadata {
+	return r.metadata
+}
+
+// Lookup returns the decoded record for addr, or nil if the address is not in the database
+func (r *Reader) Lookup(addr netip.Addr) (map[string]interface{}, error) {
+	addr = addr.Unmap()
+	if addr.Is6() && r.metadata.IPVersion == 4 {
+		return nil, fmt.Errorf("cannot look up IPv6 address in an IPv4-only database")
+	}
+
+	var ip []byte
+	node := uint(0)
+	if addr.Is4() {
+		a := addr.As4()
+		ip = a[:]
+		if r.metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		a := addr.As16()
+		ip = a[:]
+	}
+
+	bitCount := uint(len(ip) * 8)
+	for i := uint(0); i < bitCount && node < r.metadata.NodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-(i&7))) & 1
+		var err error
+		node, err = r.readNode(node, bit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case node == r.metadata.NodeCount:
+		return nil, nil
+	case node < r.metadata.NodeCount:
+		return nil, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+
+	offset := node - r.metadata.NodeCount - dataSectionSeparator
+	d := decoder{buf: r.buf[r.dataStart:]}
+	value, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a search tree node
+func (r *Reader) readNode(node, bit uint) (uint, error) {
+	base := node * r.nodeByteLen
+	if base+r.nodeByteLen > r.treeSize {
+		return 0, fmt.Errorf("%w: node %d out of range", ErrInvalidDatabase, node)
+	}
+	b := r.buf[base : base+r.nodeByteLen]
+
+	switch r.metadata.RecordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		off := bit * 4
+		return uint(binary.BigEndian.Uint32(b[off : off+4])), nil
+	}
+}
+
+// MaxMind DB data types
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// decoder decodes the MaxMind DB data section format
+type decoder struct {
+	buf []byte
+}
+
+// decode decodes the value at offset and returns it with the offset following it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("maximum data structure depth exceeded")
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typeNum := int(ctrl >> 5)
+
+	if typeNum == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typeNum = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typeNum {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			key, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) || end < offset {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset:end]
+
+	switch typeNum {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid int32 size")
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), end, nil
+	case typeUint128:
+		// Not used by GeoIP or ASN fields; keep the raw big-endian bytes
+		return append([]byte(nil), b...), end, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", typeNum)
+	}
+}
+
+// size decodes the payload size that follows a control byte
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	var v uint
+	for _, c := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		return 29 + v, offset + n, nil
+	case 30:
+		return 285 + v, offset + n, nil
+	default:
+		return 65821 + v, offset + n, nil
+	}
+}
+
+// pointer decodes a pointer and returns its target and the offset after the pointer
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+n]
+	var target uint
+	switch n {
+	case 1:
+		target = uint(ctrl&0x7)<<8 | uint(b[0])
+	case 2:
+		target = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		target = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+	return target, offset + n, nil
+}
+
+// toUint converts decoded integer values
+func toUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// ErrBodyTooSlow is returned when a client sends its request body below the
+// minimum data rate
+var ErrBodyTooSlow = errors.New("request body below minimum data rate")
+
+// minRateBody enforces a minimum average data rate on a request body. Before
+// each read the connection's read deadline is moved to the time by which the
+// next byte must arrive, so a trickling client is cut off by the server rather
+// than holding the connection open.
+type minRateBody struct {
+	body      io.ReadCloser
+	rc        *http.ResponseController
+	rate      float64 // bytes per second
+	grace     time.Duration
+	start     time.Time
+	read      int64
+	deadlines bool
+	slow      bool
+}
+
+// newMinRateBody wraps the request body of r
+func newMinRateBody(w http.ResponseWriter, r *http.Request, rate int, grace time.Duration) *minRateBody {
+	return &minRateBody{
+		body:      r.Body,
+		rc:        http.NewResponseController(w),
+		rate:      float64(rate),
+		grace:     grace,
+		start:     time.Now(),
+		deadlines: true,
+	}
+}
+
+// Read reads from the body, failing with ErrBodyTooSlow once the client falls behind
+func (b *minRateBody) Read(p []byte) (int, error) {
+	if b.slow {
+		return 0, ErrBodyTooSlow
+	}
+
+	deadline := b.start.Add(b.grace + time.Duration(float64(b.read+1)/b.rate*float64(time.Second)))
+	if b.deadlines {
+		// Connections that do not support deadlines are checked after each read
+		b.deadlines = b.rc.SetReadDeadline(deadline) == nil
+	}
+
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if isTimeout(err) || (err == nil && !b.deadlines && time.Now().After(deadline)) {
+		b.slow = true
+		telemetry.GetMetrics().IncrementSlowRequests()
+		telemetry.GetPrometheusMetrics().SlowRequests.Inc()
+		return n, ErrBodyTooSlow
+	}
+	if err == io.EOF && b.deadlines {
+		// The body is complete: stop enforcing the rate
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// Close closes the underlying body
+func (b *minRateBody) Close() error {
+	return b.body.Close()
+}
+
+// isTimeout reports whether err is a read deadline expiry
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,70 @@
+package httpserver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/mitigation"
+	"github.com/waf-draft/waf/internal/normalize"
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// newChallenge sets up the challenge action. If the configuration is invalid,
+// challenged requests are blocked instead.
+func newChallenge(cfg config.ChallengeConfig) *mitigation.Challenge {
+	challenge, err := mitigation.NewChallenge(cfg)
+	if err != nil {
+		log.Printf("Warning: %v, challenges will block", err)
+		return nil
+	}
+	return challenge
+}
+
+// resolveChallenge lets clients holding a valid clearance cookie through a
+// challenge decision. Without the challenge configured, challenges block.
+func (h *WAFHandler) resolveChallenge(r *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision) decision.Decision {
+	if dec.Action != "challenge" {
+		return dec
+	}
+	if h.challenge == nil {
+		dec.Action = "block"
+		return dec
+	}
+	if h.challenge.Cleared(r, norm.ClientIP) {
+		dec.Action = "allow"
+		dec.Reason += " (challenge solved)"
+	}
+	return dec
+}
+
+// serveChallenge sends the challenge page
+func (h *WAFHandler) serveChallenge(w http.ResponseWriter, r *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision) {
+	if err := h.challenge.Write(w, r, norm.ClientIP, dec); err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	h.countChallenge("issued")
+}
+
+// verifyChallenge handles solutions submitted to the challenge path. A correct
+// solution sets the clearance cookie and returns the client to the page it asked for.
+func (h *WAFHandler) verifyChallenge(w http.ResponseWriter, r *http.Request, norm *normalize.NormalizedRequest) {
+	w.Header().Set("Cache-Control", "no-store")
+	cookie, target, err := h.challenge.Verify(r, norm.ClientIP)
+	if err != nil {
+		h.countChallenge("failed")
+		http.Error(w, "Challenge failed, reload the page to try again", http.StatusForbidden)
+		return
+	}
+	h.countChallenge("passed")
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// countChallenge records a challenge outcome
+func (h *WAFHandler) countChallenge(outcome string) {
+	telemetry.GetMetrics().IncrementChallenge(outcome)
+	telemetry.GetPrometheusMetrics().Challenges.WithLabelValues(outcome).Inc()
+}
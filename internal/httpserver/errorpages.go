@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/logging"
+	"github.com/waf-draft/waf/internal/mitigation"
+	"github.com/waf-draft/waf/internal/response"
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// errorPageHeaders describe the upstream body and are dropped with it
+var errorPageHeaders = []string{
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Content-Range",
+	"Content-Disposition",
+	"ETag",
+	"Last-Modified",
+}
+
+// errorPages replaces upstream error responses with the WAF error page
+type errorPages struct {
+	cfg      config.ErrorPagesConfig
+	page     *mitigation.ErrorPage
+	excluded map[int]bool
+}
+
+// newErrorPages loads the error page template, returning nil when it cannot be parsed
+func newErrorPages(cfg config.ErrorPagesConfig) *errorPages {
+	page, err := mitigation.NewErrorPage(cfg.Template)
+	if err != nil {
+		log.Printf("Warning: %v, error pages disabled", err)
+		return nil
+	}
+	e := &errorPages{cfg: cfg, page: page, excluded: make(map[int]bool)}
+	for _, status := range cfg.ExcludeStatuses {
+		e.excluded[status] = true
+	}
+	return e
+}
+
+// intercepts reports whether an upstream response with the status is replaced
+func (e *errorPages) intercepts(status int) bool {
+	if e.excluded[status] {
+		return false
+	}
+	return status >= 500 || (e.cfg.Intercept4xx && status >= 400 && status < 500)
+}
+
+// replaceErrorResponse serves the error page in place of an upstream error
+// response, keeping its status. The original status and the start of its body
+// are logged. It reports whether the response was replaced.
+func (h *WAFHandler) replaceErrorResponse(resp *http.Response, state *responseState) bool {
+	if !h.errorPages.intercepts(resp.StatusCode) {
+		return false
+	}
+	requestID := state.req.Header.Get(logging.RequestIDHeader)
+	page, err := h.errorPages.page.Render(resp.StatusCode, requestID)
+	if err != nil {
+		log.Printf("Warning: upstream error passed through: %v", err)
+		return false
+	}
+
+	snippet := response.Snippet(resp, int64(h.errorPages.cfg.SnippetBytes))
+	for _, name := range errorPageHeaders {
+		resp.Header.Del(name)
+	}
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(page)))
+	resp.Header.Set("Cache-Control", "no-store")
+	resp.Body = io.NopCloser(bytes.NewReader(page))
+	resp.ContentLength = int64(len(page))
+
+	telemetry.GetMetrics().IncrementResponseAction("error_page")
+	telemetry.GetPrometheusMetrics().ResponseActions.WithLabelValues("error_page").Inc()
+	if state.tx == nil || !state.tx.NoLog {
+		dec := decision.Decision{Action: "allow", Reason: "Upstream error response replaced"}
+		if state.tx != nil {
+			dec.Score = state.tx.Score.Total
+		}
+		h.logger.LogUpstreamError(state.req, state.norm, dec, resp.StatusCode, snippet, resp.StatusCode)
+	}
+	return true
+}
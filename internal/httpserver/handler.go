@@ -1,88 +1,809 @@
 package httpserver
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/waf-draft/waf/internal/cluster"
+	"github.com/waf-draft/waf/internal/collections"
 	"github.com/waf-draft/waf/internal/config"
 	"github.com/waf-draft/waf/internal/decision"
 	"github.com/waf-draft/waf/internal/detection"
 	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/geoip"
+	"github.com/waf-draft/waf/internal/ipfilter"
 	"github.com/waf-draft/waf/internal/logging"
 	"github.com/waf-draft/waf/internal/mitigation"
 	"github.com/waf-draft/waf/internal/normalize"
+	"github.com/waf-draft/waf/internal/ratelimit"
+	"github.com/waf-draft/waf/internal/state"
 	"github.com/waf-draft/waf/internal/telemetry"
 )
 
 // WAFHandler wraps the WAF processing logic
 type WAFHandler struct {
-	cfg        *config.Config
-	rules      []rules.Rule
-	logger     *logging.Logger
-	proxy      http.Handler
+	cfg         *config.Config
+	rules       []rules.Rule
+	logger      *logging.Logger
+	proxy       http.Handler
+	collections *collections.Store
+	ipFilter    *ipfilter.IPFilter
+	rateLimits  *ratelimit.PolicySet
+	bans        *ipfilter.BanManager
+	reputation  *ipfilter.ReputationLists
+	listConfigs map[string]config.ReputationListConfig
+	geo         *geoip.Resolver
+	// trustedProxies are the proxies whose forwarding headers identify the client
+	trustedProxies normalize.TrustedProxies
+	state          state.Backend
+	cluster        *cluster.Node
+	// unicodeTargets and unicodeTransforms configure the Unicode pre-pass
+	unicodeTargets    []string
+	unicodeTransforms []string
+	// hookedResponses is set when the response phase or PII masking is hooked
+	// into the proxy
+	hookedResponses   bool
+	inspectResponses  bool
+	responseDetectors []string
+	piiMasking        *piiMasking
+	headerPolicy      *mitigation.HeaderPolicy
+	errorPages        *errorPages
+	blockPages        *mitigation.BlockPages
+	challenge         *mitigation.Challenge
 }
 
-// NewWAFHandler creates a new WAF handler
-func NewWAFHandler(cfg *config.Config, rules []rules.Rule, logger *logging.Logger, proxy http.Handler) *WAFHandler {
-	return &WAFHandler{
+// NewWAFHandler creates a new WAF handler. It fails if shared state or rate
+// limiting is misconfigured rather than starting without them.
+func NewWAFHandler(cfg *config.Config, rules []rules.Rule, logger *logging.Logger, proxy http.Handler) (*WAFHandler, error) {
+	h := &WAFHandler{
 		cfg:    cfg,
 		rules:  rules,
 		logger: logger,
 		proxy:  proxy,
 	}
+
+	trusted, err := normalize.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Printf("Warning: %v, forwarding headers will be ignored", err)
+	}
+	h.trustedProxies = trusted
+
+	if cfg.Security.Collections.Enabled {
+		h.collections = newCollectionStore(cfg.Security.Collections)
+	}
+	if cfg.Security.IPFilter.Enabled {
+		h.ipFilter = newIPFilter(cfg.Security.IPFilter)
+		if len(cfg.Security.IPFilter.Lists) > 0 {
+			h.reputation = newReputationLists(cfg.Security.IPFilter)
+			h.listConfigs = make(map[string]config.ReputationListConfig)
+			for _, list := range cfg.Security.IPFilter.Lists {
+				h.listConfigs[list.Name] = list
+			}
+		}
+	}
+	if cfg.Security.GeoIP.Enabled {
+		h.geo = newGeoResolver(cfg.Security.GeoIP)
+	}
+	if cfg.Security.Unicode.Enabled {
+		h.unicodeTargets, h.unicodeTransforms = unicodeFolding(cfg.Security.Unicode)
+	}
+	if cfg.Security.Headers.Enabled {
+		h.headerPolicy = newHeaderPolicy(cfg.Security.Headers)
+	}
+	h.blockPages = newBlockPages(cfg.Security.BlockPage)
+	if cfg.Security.Challenge.Enabled {
+		h.challenge = newChallenge(cfg.Security.Challenge)
+	}
+	if cfg.Security.Response.Enabled || cfg.Security.PIIMasking.Enabled || cfg.Security.ErrorPages.Enabled {
+		h.hookResponses()
+	}
+	if err := h.initLimits(cfg); err != nil {
+		h.Close()
+		return nil, err
+	}
+	if cfg.Security.Bans.Enabled {
+		h.bans = newBanManager(cfg.Security.Bans)
+		if h.state != nil {
+			h.bans.SetBackend(h.state, time.Duration(cfg.State.CacheTTLMs)*time.Millisecond, cfg.State.FailMode)
+		}
+	}
+
+	return h, nil
+}
+
+// initLimits sets up shared state and rate limiting. Errors are returned rather
+// than logged so that a misconfigured instance refuses to start instead of
+// running without the limits it was configured with.
+func (h *WAFHandler) initLimits(cfg *config.Config) error {
+	if cfg.State.Backend == "gossip" {
+		node, err := newClusterNode(cfg.State.Cluster)
+		if err != nil {
+			return err
+		}
+		h.cluster = node
+		h.state = node
+	} else {
+		backend, err := newStateBackend(cfg.State)
+		if err != nil {
+			return err
+		}
+		h.state = backend
+	}
+	if cfg.Security.RateLimit.Enabled {
+		set, err := newRateLimits(cfg.Security.RateLimit, cfg.State, h.state)
+		if err != nil {
+			return err
+		}
+		h.rateLimits = set
+	}
+	return nil
+}
+
+// requestLimits converts the limits configuration
+func requestLimits(cfg config.LimitsConfig) normalize.Limits {
+	return normalize.Limits{
+		MaxURLLength:         cfg.MaxURLLength,
+		MaxHeaderCount:       cfg.MaxHeaderCount,
+		MaxHeaderSize:        cfg.MaxHeaderSize,
+		MaxArgCount:          cfg.MaxArgCount,
+		MaxArgNameLength:     cfg.MaxArgNameLength,
+		MaxArgValueLength:    cfg.MaxArgValueLength,
+		MaxBodySize:          cfg.MaxBodySize,
+		MaxMultipartBodySize: cfg.MaxMultipartBodySize,
+		MaxFileSize:          cfg.MaxFileSize,
+		InspectOversized:     cfg.Action == "score",
+	}
+}
+
+// unicodeFolding returns the Unicode pre-pass targets and transformations,
+// dropping unknown names
+func unicodeFolding(cfg config.UnicodeConfig) ([]string, []string) {
+	targets := cfg.Targets
+	if len(targets) == 0 {
+		targets = []string{normalize.UnicodeTargetPath, normalize.UnicodeTargetQuery, normalize.UnicodeTargetBody, normalize.UnicodeTargetHeader}
+	}
+	var validTargets []string
+	for _, target := range targets {
+		switch target {
+		case normalize.UnicodeTargetPath, normalize.UnicodeTargetQuery, normalize.UnicodeTargetBody, normalize.UnicodeTargetHeader:
+			validTargets = append(validTargets, target)
+		default:
+			log.Printf("Warning: unknown unicode normalization target %q, ignoring", target)
+		}
+	}
+
+	transforms := cfg.Transforms
+	if len(transforms) == 0 {
+		transforms = []string{normalize.TransformCompat, normalize.TransformConfusables, normalize.TransformCaseFold}
+	}
+	var validTransforms []string
+	for _, t := range transforms {
+		if !normalize.ValidTransform(t) {
+			log.Printf("Warning: unknown unicode transformation %q, ignoring", t)
+			continue
+		}
+		validTransforms = append(validTransforms, t)
+	}
+	return validTargets, validTransforms
+}
+
+// newHeaderPolicy compiles the security header policy, or returns nil with a
+// warning if a route is invalid
+func newHeaderPolicy(cfg config.SecurityHeadersConfig) *mitigation.HeaderPolicy {
+	policy, err := mitigation.NewHeaderPolicy(cfg)
+	if err != nil {
+		log.Printf("Warning: security headers disabled: %v", err)
+		return nil
+	}
+	return policy
+}
+
+// newStateBackend creates the backend shared by replicas, or nil for per-instance state
+func newStateBackend(cfg config.StateConfig) (state.Backend, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "memory":
+		return state.NewMemoryBackend(), nil
+	case "redis":
+		return state.NewRedisBackend(state.RedisOptions{
+			Address:   cfg.Redis.Address,
+			Password:  cfg.Redis.Password,
+			DB:        cfg.Redis.DB,
+			KeyPrefix: cfg.Redis.KeyPrefix,
+			Timeout:   time.Duration(cfg.Redis.TimeoutMs) * time.Millisecond,
+			PoolSize:  cfg.Redis.PoolSize,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", cfg.Backend)
+	}
+}
+
+// newClusterNode joins the gossip cluster. It fails if no secret is configured
+// or the gossip listener cannot be started.
+func newClusterNode(cfg config.ClusterConfig) (*cluster.Node, error) {
+	node, err := cluster.NewNode(cluster.Config{
+		NodeID:           cfg.NodeID,
+		BindAddress:      cfg.BindAddress,
+		AdvertiseAddress: cfg.AdvertiseAddress,
+		Peers:            cfg.Peers,
+		Interval:         time.Duration(cfg.GossipIntervalMs) * time.Millisecond,
+		Fanout:           cfg.Fanout,
+		SuspectAfter:     time.Duration(cfg.SuspectAfterMs) * time.Millisecond,
+		DeadAfter:        time.Duration(cfg.DeadAfterMs) * time.Millisecond,
+		Secret:           cfg.Secret,
+		MaxTTL:           time.Duration(cfg.MaxTTLSeconds) * time.Second,
+		MaxMembers:       cfg.MaxMembers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to join cluster: %w", err)
+	}
+	return node, nil
+}
+
+// newRateLimits creates the rate limit policies. Without explicit policies the
+// top-level limit applies per client IP to every request.
+func newRateLimits(cfg config.RateLimitConfig, stateCfg config.StateConfig, backend state.Backend) (*ratelimit.PolicySet, error) {
+	policies := cfg.Policies
+	if len(policies) == 0 {
+		policies = []config.RateLimitPolicyConfig{{Name: "default"}}
+	}
+
+	specs := make([]ratelimit.Policy, 0, len(policies))
+	for _, p := range policies {
+		spec := ratelimit.Policy{
+			Name:       p.Name,
+			PathPrefix: p.Match.PathPrefix,
+			PathRegex:  p.Match.PathRegex,
+			Methods:    p.Match.Methods,
+			Hosts:      p.Match.Hosts,
+			Key:        p.Key,
+			Action:     p.Action,
+			Score:      p.Score,
+			Limiter: ratelimit.Config{
+				Algorithm: p.Algorithm,
+				Limit:     p.MaxRequests,
+				Window:    time.Duration(p.WindowSeconds) * time.Second,
+				Burst:     p.Burst,
+				Shards:    cfg.Shards,
+				MaxKeys:   cfg.MaxKeys,
+			},
+		}
+		if backend != nil {
+			spec.Limiter.Backend = backend
+			spec.Limiter.SyncInterval = time.Duration(stateCfg.SyncIntervalMs) * time.Millisecond
+			spec.Limiter.FailMode = stateCfg.FailMode
+		}
+		if spec.Limiter.Algorithm == "" {
+			spec.Limiter.Algorithm = cfg.Algorithm
+		}
+		if spec.Limiter.Limit == 0 {
+			spec.Limiter.Limit = cfg.MaxRequests
+			if spec.Limiter.Burst == 0 {
+				spec.Limiter.Burst = cfg.Burst
+			}
+		}
+		if spec.Limiter.Window == 0 {
+			spec.Limiter.Window = time.Duration(cfg.WindowSeconds) * time.Second
+		}
+		specs = append(specs, spec)
+	}
+
+	set, err := ratelimit.NewPolicySet(specs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit configuration: %w", err)
+	}
+	return set, nil
+}
+
+// newCollectionStore creates the persistent collection store, falling back to
+// memory only if the persisted state cannot be loaded
+func newCollectionStore(cfg config.CollectionsConfig) *collections.Store {
+	var backend collections.Backend
+	if cfg.PersistFile != "" {
+		backend = collections.NewFileBackend(cfg.PersistFile)
+	}
+	limits := collections.Limits{
+		MaxEntries: cfg.MaxEntries,
+		DefaultTTL: time.Duration(cfg.DefaultTTLSeconds) * time.Second,
+	}
+	store, err := collections.NewStore(backend, limits)
+	if err != nil {
+		log.Printf("Failed to load collections from %s, starting empty: %v", cfg.PersistFile, err)
+		store, _ = collections.NewStore(nil, limits)
+	}
+	return store
+}
+
+// newIPFilter creates the IP filter from the configured static lists
+func newIPFilter(cfg config.IPFilterConfig) *ipfilter.IPFilter {
+	filter := ipfilter.NewIPFilter()
+	for _, entry := range cfg.Whitelist {
+		if err := filter.AddToWhitelist(entry); err != nil {
+			log.Printf("Ignoring whitelist entry %q: %v", entry, err)
+		}
+	}
+	for _, entry := range cfg.Blacklist {
+		if err := filter.AddToBlacklist(entry); err != nil {
+			log.Printf("Ignoring blacklist entry %q: %v", entry, err)
+		}
+	}
+	return filter
+}
+
+// newReputationLists loads the configured reputation feeds. Lists that fail to load
+// are reported and the WAF starts without reputation data rather than failing.
+func newReputationLists(cfg config.IPFilterConfig) *ipfilter.ReputationLists {
+	sources := make([]ipfilter.FeedSource, 0, len(cfg.Lists))
+	for _, list := range cfg.Lists {
+		sources = append(sources, ipfilter.FeedSource{Name: list.Name, Path: list.Path, Format: list.Format})
+	}
+	refresh := time.Duration(cfg.RefreshSeconds) * time.Second
+	lists, err := ipfilter.NewReputationLists(sources, refresh)
+	if err != nil {
+		log.Printf("Failed to load reputation lists: %v", err)
+		lists, _ = ipfilter.NewReputationLists(nil, 0)
+	}
+	return lists
+}
+
+// newGeoResolver opens the GeoIP databases. If they cannot be loaded, GeoIP
+// enrichment and country/ASN policies are disabled.
+func newGeoResolver(cfg config.GeoIPConfig) *geoip.Resolver {
+	resolver, err := geoip.NewResolver(cfg.CountryDatabase, cfg.ASNDatabase, time.Duration(cfg.RefreshSeconds)*time.Second)
+	if err != nil {
+		log.Printf("GeoIP disabled: %v", err)
+		return nil
+	}
+	return resolver
+}
+
+// newBlockPages loads the block response templates, falling back to the
+// built-in responses if they cannot be loaded
+func newBlockPages(cfg config.BlockPageConfig) *mitigation.BlockPages {
+	pages, err := mitigation.NewBlockPages(cfg)
+	if err != nil {
+		log.Printf("Warning: %v, using the built-in block responses", err)
+		return nil
+	}
+	return pages
+}
+
+// newBanManager creates the ban manager, falling back to memory only if the
+// persisted bans cannot be loaded
+func newBanManager(cfg config.BanConfig) *ipfilter.BanManager {
+	policy := ipfilter.BanPolicy{
+		BlockThreshold:     cfg.BlockThreshold,
+		RateLimitThreshold: cfg.RateLimitThreshold,
+		Window:             time.Duration(cfg.WindowSeconds) * time.Second,
+		BanRules:           cfg.BanRules,
+		Duration:           time.Duration(cfg.BanSeconds) * time.Second,
+		MaxDuration:        time.Duration(cfg.MaxBanSeconds) * time.Second,
+		EscalationFactor:   cfg.EscalationFactor,
+		OffenseMemory:      time.Duration(cfg.OffenseMemoryHours) * time.Hour,
+	}
+	bans, err := ipfilter.NewBanManager(policy, cfg.PersistFile)
+	if err != nil {
+		log.Printf("Failed to load bans from %s, starting empty: %v", cfg.PersistFile, err)
+		bans, _ = ipfilter.NewBanManager(policy, "")
+	}
+	return bans
+}
+
+// Bans returns the ban manager, or nil if automatic bans are disabled
+func (h *WAFHandler) Bans() *ipfilter.BanManager {
+	return h.bans
+}
+
+// Cluster returns the gossip cluster node, or nil when not clustered
+func (h *WAFHandler) Cluster() *cluster.Node {
+	return h.cluster
+}
+
+// Close releases resources held by the handler and persists state
+func (h *WAFHandler) Close() error {
+	var firstErr error
+	if h.reputation != nil {
+		h.reputation.Stop()
+	}
+	if h.geo != nil {
+		h.geo.Stop()
+	}
+	if h.rateLimits != nil {
+		h.rateLimits.Stop()
+	}
+	if h.blockPages != nil {
+		h.blockPages.Stop()
+	}
+	if h.bans != nil {
+		if err := h.bans.Stop(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if h.collections != nil {
+		if err := h.collections.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if h.state != nil {
+		if err := h.state.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // ServeHTTP implements http.Handler
 func (h *WAFHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	metrics := telemetry.GetMetrics()
-	
+
 	// Track total requests
 	metrics.IncrementTotalRequests()
 
-	// Normalize request
-	norm, err := normalize.Request(r, h.cfg.Security.LogRequestBody)
+	// Assign a request ID shared by the log entry, block response and upstream
+	logging.EnsureRequestID(r)
+
+	// Enforce the minimum request body data rate
+	if rate := h.cfg.Server.MinBodyRateBytes; rate > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = newMinRateBody(w, r, rate, time.Duration(h.cfg.Server.BodyRateGraceSeconds)*time.Second)
+	}
+
+	// Normalize request, applying the size and shape limits
+	limits := requestLimits(h.cfg.Security.Limits)
+	norm, err := normalize.RequestWithLimits(r, h.cfg.Security.LogRequestBody, limits)
+	if errors.Is(err, ErrBodyTooSlow) {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Request Timeout", http.StatusRequestTimeout)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	norm.ClientIP = h.trustedProxies.ClientIP(r)
+
+	rejectLimits := h.cfg.Security.Limits.Action != "score"
+	if len(norm.Violations) > 0 && rejectLimits {
+		v := norm.Violations[0]
+		h.respond(w, r, norm, decision.Block("Request exceeds limit "+v.String(), v.Status), nil, start)
+		return
+	}
+	if max := normalize.BodyLimit(r, limits); max > 0 && rejectLimits && !h.cfg.Security.LogRequestBody {
+		// Bodies without a declared length are cut off while streaming
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+
+	// Enrich with GeoIP country and ASN
+	if h.geo != nil {
+		record := h.geo.Lookup(norm.ClientIP)
+		norm.Country = record.Country
+		norm.ASN = record.ASN
+		norm.ASOrganization = record.Organization
+	}
+
+	// Look up the client in the reputation lists
+	lists := h.lookupReputation(norm.ClientIP)
+
+	// Check client-level controls (IP lists, bans, rate limits) before rules
+	dec, limited, blocked := h.checkClient(w, r, norm, lists)
+	if blocked {
+		h.respond(w, r, norm, dec, nil, start)
+		return
+	}
+
+	// Challenge solutions are checked before the rules that challenged them, but
+	// only for clients that passed the IP filter, bans and rate limits
+	if h.challenge != nil && r.URL.Path == h.challenge.Path() {
+		h.verifyChallenge(w, r, norm)
+		return
+	}
 
 	// Evaluate request against rules
-	score, matchedRules, err := detection.EvaluateRequest(r, norm, h.rules)
-	if err != nil {
+	tx := detection.NewTransaction()
+	if h.collections != nil {
+		tx.Collections = collections.NewScope(h.collections, r, norm.ClientIP, collections.KeyConfig{
+			SessionCookie: h.cfg.Security.Collections.SessionCookie,
+			APIKeyHeader:  h.cfg.Security.Collections.APIKeyHeader,
+		})
+	}
+	tx.IPLists = lists
+	h.scoreReputation(tx, lists)
+	h.scoreRateLimits(tx, limited)
+	h.scoreLimits(tx, norm.Violations)
+	if err := detection.EvaluateTransaction(tx, r, h.inspected(norm), h.rules); err != nil {
 		// Log error but continue
 	}
 
 	// Track rule matches
-	for _, rule := range matchedRules {
+	for _, rule := range tx.MatchedRules {
 		metrics.IncrementRuleMatch(rule.ID)
 	}
+	for _, match := range tx.Score.Matches {
+		metrics.IncrementVariableMatch(match.Variable)
+		telemetry.GetPrometheusMetrics().VariableMatches.WithLabelValues(match.RuleID, detection.Collection(match.Variable)).Inc()
+	}
+
+	// Make decision, letting clients that solved a challenge through challenges
+	dec = decision.Decide(tx, h.cfg)
+	dec = h.resolveChallenge(r, norm, dec)
+
+	// Feed blocks into the ban manager
+	if h.bans != nil && dec.Action != "allow" && dec.Action != "challenge" {
+		if ban, banned := h.bans.RecordBlock(norm.ClientIP, dec.MatchedRules); banned {
+			log.Printf("Banned %s until %s: %s", ban.IP, ban.ExpiresAt.Format(time.RFC3339), ban.Reason)
+		}
+	}
+
+	h.respond(w, r, norm, dec, tx, start)
+}
+
+// inspected returns the request as the rules see it, with Unicode look-alikes
+// folded. Routing keeps using norm so that paths match as sent.
+func (h *WAFHandler) inspected(norm *normalize.NormalizedRequest) *normalize.NormalizedRequest {
+	if len(h.unicodeTargets) == 0 || len(h.unicodeTransforms) == 0 {
+		return norm
+	}
+	folded, err := norm.FoldUnicode(h.unicodeTargets, h.unicodeTransforms)
+	if err != nil {
+		log.Printf("Warning: unicode normalization failed: %v", err)
+		return norm
+	}
+	return folded
+}
+
+// lookupReputation returns the reputation lists containing the client IP.
+// Whitelisted clients are never reported as listed.
+func (h *WAFHandler) lookupReputation(clientIP string) []string {
+	if h.reputation == nil || h.ipFilter.IsWhitelisted(clientIP) {
+		return nil
+	}
+	return h.reputation.Lookup(clientIP)
+}
+
+// scoreReputation adds the configured score for each "score" list the client is on
+func (h *WAFHandler) scoreReputation(tx *detection.Transaction, lists []string) {
+	for _, name := range lists {
+		list := h.listConfigs[name]
+		if list.Action != "score" || list.Score == 0 {
+			continue
+		}
+		tx.Score.Add(list.Score, []string{"reputation"})
+		tx.Score.AddMatch(detection.Match{
+			RuleID:   "REPUTATION",
+			Variable: "REMOTE_ADDR",
+			Value:    name,
+			Operator: "ip_list",
+			Score:    list.Score,
+		})
+	}
+}
+
+// scoreRateLimits adds the score of each exceeded "score" rate limit policy
+func (h *WAFHandler) scoreRateLimits(tx *detection.Transaction, violations []ratelimit.PolicyResult) {
+	for _, v := range violations {
+		if v.Score == 0 {
+			continue
+		}
+		tx.Score.Add(v.Score, []string{"ratelimit"})
+		tx.Score.AddMatch(detection.Match{
+			RuleID:   "RATELIMIT",
+			Variable: "REMOTE_ADDR",
+			Value:    v.Policy,
+			Operator: "rate_limit",
+			Score:    v.Score,
+		})
+	}
+}
+
+// scoreLimits adds the configured score for each exceeded size or shape limit
+func (h *WAFHandler) scoreLimits(tx *detection.Transaction, violations []normalize.Violation) {
+	for _, v := range violations {
+		tx.Score.Add(h.cfg.Security.Limits.Score, []string{"limits"})
+		tx.Score.AddMatch(detection.Match{
+			RuleID:   "LIMIT",
+			Variable: limitVariable(v.Limit),
+			Value:    v.String(),
+			Operator: "limit",
+			Score:    h.cfg.Security.Limits.Score,
+		})
+	}
+}
+
+// limitVariable names the request variable a limit applies to
+func limitVariable(limit string) string {
+	switch limit {
+	case normalize.LimitURLLength:
+		return "REQUEST_URI"
+	case normalize.LimitHeaderCount, normalize.LimitHeaderSize:
+		return "REQUEST_HEADERS"
+	case normalize.LimitArgCount, normalize.LimitArgNameLength, normalize.LimitArgValueLength:
+		return "ARGS"
+	case normalize.LimitFileSize:
+		return "FILES"
+	default:
+		return "REQUEST_BODY"
+	}
+}
+
+// checkClient applies the IP filter, reputation lists, active bans and the rate
+// limit policies. Whitelisted clients bypass these checks but are still inspected
+// by the rules. RateLimit-* headers for the most restrictive policy are added to
+// the response. Exceeded score and log-only policies are returned for scoring.
+func (h *WAFHandler) checkClient(w http.ResponseWriter, r *http.Request, norm *normalize.NormalizedRequest, lists []string) (decision.Decision, []ratelimit.PolicyResult, bool) {
+	clientIP := norm.ClientIP
+	if h.ipFilter != nil {
+		if h.ipFilter.IsWhitelisted(clientIP) {
+			return decision.Decision{}, nil, false
+		}
+		if h.ipFilter.IsBlacklisted(clientIP) {
+			return decision.Block("Client IP is blacklisted", http.StatusForbidden), nil, true
+		}
+		if reason, denied := h.checkGeoPolicy(norm); denied {
+			return decision.Block(reason, http.StatusForbidden), nil, true
+		}
+	}
+
+	for _, name := range lists {
+		if h.listConfigs[name].Action == "block" {
+			dec := decision.Block("Client IP is listed in reputation list "+name, http.StatusForbidden)
+			dec.ReputationLists = lists
+			return dec, nil, true
+		}
+	}
+
+	if h.bans != nil {
+		if ban, banned := h.bans.IsBanned(clientIP); banned {
+			return decision.Block("Client IP is temporarily banned: "+ban.Reason, http.StatusForbidden), nil, true
+		}
+	}
+
+	if h.rateLimits == nil {
+		return decision.Decision{}, nil, false
+	}
+
+	results := h.rateLimits.Check(r, norm.Path, clientIP)
+	if binding, ok := ratelimit.Binding(results); ok && !h.cfg.Security.RateLimit.DisableHeaders {
+		ratelimit.SetHeaders(w.Header(), binding, h.cfg.Security.RateLimit.LegacyHeaders)
+	}
+
+	var scored []ratelimit.PolicyResult
+	for _, result := range results {
+		if result.Allowed {
+			continue
+		}
+		switch result.Action {
+		case ratelimit.ActionChallenge:
+			if h.challenge != nil && !h.challenge.Cleared(r, clientIP) {
+				return decision.Challenge("Rate limit exceeded: "+result.Policy, http.StatusTooManyRequests), nil, true
+			}
+			// Without the challenge configured, challenges are answered with a
+			// block; so are clients that solved one and still exceed the limit
+			fallthrough
+		case ratelimit.ActionBlock:
+			if h.bans != nil {
+				if ban, banned := h.bans.RecordRateLimit(clientIP); banned {
+					log.Printf("Banned %s until %s: %s", ban.IP, ban.ExpiresAt.Format(time.RFC3339), ban.Reason)
+				}
+			}
+			dec := decision.Block("Rate limit exceeded: "+result.Policy, http.StatusTooManyRequests)
+			dec.RetryAfter = int(math.Ceil(result.RetryAfter.Seconds()))
+			return dec, nil, true
+		case ratelimit.ActionScore:
+			scored = append(scored, result)
+		case ratelimit.ActionLog:
+			log.Printf("Rate limit policy %s exceeded by %s (log only)", result.Policy, clientIP)
+		}
+	}
 
-	// Make decision
-	dec := decision.Decide(score, matchedRules, h.cfg)
+	return decision.Decision{}, scored, false
+}
+
+// respond records metrics, logs the request and applies the decision. tx is nil
+// when the request was decided before rule evaluation.
+func (h *WAFHandler) respond(w http.ResponseWriter, r *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision, tx *detection.Transaction, start time.Time) {
+	metrics := telemetry.GetMetrics()
 
 	// Determine status code
-	statusCode := http.StatusOK
-	if dec.Action == "block" {
-		statusCode = http.StatusForbidden
-		metrics.IncrementBlockedRequests()
-	} else {
+	if dec.Action == "block" && dec.Status == 0 {
+		dec.Status = h.blockPages.Status(r, norm.Path)
+	}
+	statusCode := mitigation.StatusCode(dec)
+	if dec.Action == "allow" {
 		metrics.IncrementAllowedRequests()
+	} else {
+		metrics.IncrementBlockedRequests()
 	}
 
 	// Track latency
 	latency := time.Since(start)
 	metrics.AddLatency(latency.Nanoseconds())
 
+	var matchedRules []rules.Rule
+	if tx != nil {
+		matchedRules = tx.MatchedRules
+	}
+
 	// Log request
-	h.logger.LogRequest(r, norm, dec, matchedRules, statusCode)
+	if tx == nil || !tx.NoLog {
+		h.logger.LogRequest(r, norm, dec, matchedRules, statusCode)
+	}
+
+	// Apply headers requested by rule actions
+	if tx != nil {
+		for name, values := range tx.RequestHeaders {
+			r.Header[name] = values
+		}
+		if len(tx.ResponseHeaders) > 0 {
+			w = mitigation.NewHeaderWriter(w, tx.ResponseHeaders)
+		}
+	}
+
+	// Apply the security header policy; headers set by rule actions take precedence
+	if h.headerPolicy != nil {
+		w = h.headerPolicy.Writer(w, r, norm.Path)
+	}
 
 	// Apply mitigation
-	if dec.Action == "block" {
-		mitigation.ApplyDecision(dec, w, r, nil)
+	if dec.Action == "challenge" {
+		h.serveChallenge(w, r, norm, dec)
+	} else if dec.Action != "allow" {
+		mitigation.ApplyDecision(dec, w, r, norm.Path, nil, h.blockPages)
 	} else {
-		// Forward to upstream
+		// Forward to upstream, keeping the state needed to inspect the response
+		if h.hookedResponses {
+			r = withResponseState(r, norm, tx)
+			if h.piiMasking != nil {
+				h.piiMasking.prepareRequest(r, norm.Path)
+			}
+		}
 		h.proxy.ServeHTTP(w, r)
 	}
 }
 
+// checkGeoPolicy applies the country and ASN allow/deny lists from the IP filter config
+func (h *WAFHandler) checkGeoPolicy(norm *normalize.NormalizedRequest) (string, bool) {
+	policy := h.cfg.Security.IPFilter
+
+	if norm.Country != "" {
+		for _, country := range policy.DenyCountries {
+			if strings.EqualFold(country, norm.Country) {
+				return "Access from country " + norm.Country + " is denied", true
+			}
+		}
+		if len(policy.AllowCountries) > 0 {
+			allowed := false
+			for _, country := range policy.AllowCountries {
+				allowed = allowed || strings.EqualFold(country, norm.Country)
+			}
+			if !allowed {
+				return "Access from country " + norm.Country + " is not allowed", true
+			}
+		}
+	}
+
+	if norm.ASN != 0 {
+		asn := fmt.Sprintf("AS%d", norm.ASN)
+		for _, denied := range policy.DenyASNs {
+			if denied == norm.ASN {
+				return "Access from " + asn + " is denied", true
+			}
+		}
+		if len(policy.AllowASNs) > 0 {
+			allowed := false
+			for _, a := range policy.AllowASNs {
+				allowed = allowed || a == norm.ASN
+			}
+			if !allowed {
+				return "Access from " + asn + " is not allowed", true
+			}
+		}
+	}
+
+	return "", false
+}
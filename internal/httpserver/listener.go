@@ -0,0 +1,124 @@
+package httpserver
+
+import (
+	"net"
+	"sync"
+
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// Connection rejection reasons reported in metrics
+const (
+	rejectMaxConnections = "max_connections"
+	rejectPerIPLimit     = "per_ip_limit"
+)
+
+// LimitListener caps the total number of open connections and the number of
+// concurrent connections per client IP. Connections over a limit are closed
+// as soon as they are accepted.
+type LimitListener struct {
+	net.Listener
+	maxConns int
+	maxPerIP int
+	mu       sync.Mutex
+	total    int
+	perIP    map[string]int
+}
+
+// NewLimitListener wraps l. A limit of zero disables that check.
+func NewLimitListener(l net.Listener, maxConns, maxPerIP int) *LimitListener {
+	return &LimitListener{
+		Listener: l,
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// Accept waits for the next connection within the limits
+func (l *LimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if reason, ok := l.acquire(ip); !ok {
+			telemetry.GetMetrics().IncrementRejectedConnection(reason)
+			telemetry.GetPrometheusMetrics().ConnectionsRejected.WithLabelValues(reason).Inc()
+			conn.Close()
+			continue
+		}
+
+		telemetry.GetPrometheusMetrics().ActiveConnections.Inc()
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// acquire reserves a connection slot for ip
+func (l *LimitListener) acquire(ip string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxConns > 0 && l.total >= l.maxConns {
+		return rejectMaxConnections, false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return rejectPerIPLimit, false
+	}
+	l.total++
+	l.perIP[ip]++
+	return "", true
+}
+
+// release frees the slot held by a closed connection
+func (l *LimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+	telemetry.GetPrometheusMetrics().ActiveConnections.Dec()
+}
+
+// GetStats returns connection statistics
+func (l *LimitListener) GetStats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return map[string]interface{}{
+		"active_connections": l.total,
+		"client_ips":         len(l.perIP),
+		"max_connections":    l.maxConns,
+		"max_per_ip":         l.maxPerIP,
+	}
+}
+
+// limitedConn releases its slot exactly once when closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and releases its slot
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// remoteIP returns the peer IP of a connection. Limits apply to the socket peer,
+// not to forwarded headers, which the client controls.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -20,7 +20,7 @@ func NewLogsHandler(logFile string) *LogsHandler {
 // ServeHTTP implements http.Handler for logs
 func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	
+
 	// Get query parameters
 	limit := 100 // default
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
@@ -28,14 +28,17 @@ func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			limit = l
 		}
 	}
-	
+
 	filter := r.URL.Query().Get("filter") // "blocked", "allowed", "all"
 	if filter == "" {
 		filter = "all"
 	}
-	
+
+	// Lookup of a single request, e.g. from the request ID shown on a block page
+	requestID := r.URL.Query().Get("request_id")
+
 	// Read log file
-	logs, err := h.readLogs(limit, filter)
+	logs, err := h.readLogs(limit, filter, requestID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -43,49 +46,55 @@ func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	
+
 	response := map[string]interface{}{
 		"logs":   logs,
 		"count":  len(logs),
 		"filter": filter,
 	}
-	
+
 	json.NewEncoder(w).Encode(response)
 }
 
 // readLogs reads and parses log entries
-func (h *LogsHandler) readLogs(limit int, filter string) ([]map[string]interface{}, error) {
+func (h *LogsHandler) readLogs(limit int, filter, requestID string) ([]map[string]interface{}, error) {
 	if h.logFile == "" || h.logFile == "stdout" {
 		// Can't read from stdout, return empty
 		return []map[string]interface{}{}, nil
 	}
-	
+
 	data, err := os.ReadFile(h.logFile)
 	if err != nil {
 		return nil, err
 	}
-	
+
 	lines := strings.Split(string(data), "\n")
 	var logs []map[string]interface{}
-	
+
 	// Read from end (most recent first)
 	start := len(lines) - 1
 	if start < 0 {
 		start = 0
 	}
-	
+
 	count := 0
 	for i := start; i >= 0 && count < limit; i-- {
 		line := strings.TrimSpace(lines[i])
 		if line == "" {
 			continue
 		}
-		
+
 		var logEntry map[string]interface{}
 		if err := json.Unmarshal([]byte(line), &logEntry); err != nil {
 			continue
 		}
-		
+
+		if requestID != "" {
+			if id, _ := logEntry["request_id"].(string); id != requestID {
+				continue
+			}
+		}
+
 		// Apply filter
 		if filter != "all" {
 			decision, ok := logEntry["decision"].(map[string]interface{})
@@ -96,7 +105,7 @@ func (h *LogsHandler) readLogs(limit int, filter string) ([]map[string]interface
 			if !ok {
 				continue
 			}
-			
+
 			if filter == "blocked" && action != "block" {
 				continue
 			}
@@ -104,11 +113,11 @@ func (h *LogsHandler) readLogs(limit int, filter string) ([]map[string]interface
 				continue
 			}
 		}
-		
+
 		logs = append(logs, logEntry)
 		count++
 	}
-	
+
 	return logs, nil
 }
 
@@ -123,4 +132,3 @@ func parseInt(s string) int {
 	}
 	return result
 }
-
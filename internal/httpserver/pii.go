@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/mitigation"
+	"github.com/waf-draft/waf/internal/response"
+)
+
+// piiMasking is the compiled PII masking policy
+type piiMasking struct {
+	cfg       config.PIIMaskingConfig
+	detectors []string
+	routes    []piiRoute
+}
+
+// piiRoute turns masking off or selects the detectors for matching requests
+type piiRoute struct {
+	route     *mitigation.Route
+	disabled  bool
+	detectors []string
+}
+
+// newPIIMasking compiles the masking policy, skipping routes with invalid
+// patterns and unknown detectors
+func newPIIMasking(cfg config.PIIMaskingConfig) *piiMasking {
+	p := &piiMasking{cfg: cfg, detectors: piiDetectors(cfg.Detectors, response.PIIDetectors)}
+	for i, rc := range cfg.Routes {
+		route, err := mitigation.NewRoute(rc.Match)
+		if err != nil {
+			log.Printf("Warning: ignoring PII masking route %d: %v", i, err)
+			continue
+		}
+		p.routes = append(p.routes, piiRoute{
+			route:     route,
+			disabled:  rc.Disabled,
+			detectors: piiDetectors(rc.Detectors, p.detectors),
+		})
+	}
+	return p
+}
+
+// piiDetectors returns the valid detector names, or the defaults when none are listed
+func piiDetectors(names, defaults []string) []string {
+	if len(names) == 0 {
+		return defaults
+	}
+	detectors := make([]string, 0, len(names))
+	for _, name := range names {
+		if !response.ValidDetector(name) {
+			log.Printf("Warning: unknown PII detector %q, ignoring", name)
+			continue
+		}
+		detectors = append(detectors, name)
+	}
+	return detectors
+}
+
+// detectorsFor returns the detectors for a request. Without routes every request
+// is masked; otherwise the first matching route decides.
+func (p *piiMasking) detectorsFor(r *http.Request, path string) ([]string, bool) {
+	if len(p.cfg.Routes) == 0 {
+		return p.detectors, true
+	}
+	for _, rt := range p.routes {
+		if rt.route.Matches(r, path) {
+			return rt.detectors, !rt.disabled && len(rt.detectors) > 0
+		}
+	}
+	return nil, false
+}
+
+// prepareRequest drops Accept-Encoding from requests whose responses are masked.
+// The proxy transport then negotiates gzip itself and decompresses the response,
+// so upstreams cannot answer with an encoding the masker cannot read.
+func (p *piiMasking) prepareRequest(r *http.Request, path string) {
+	if _, ok := p.detectorsFor(r, path); ok {
+		r.Header.Del("Accept-Encoding")
+	}
+}
+
+// maskResponse masks personal data and secrets in an upstream response body
+func (h *WAFHandler) maskResponse(resp *http.Response, state *responseState) {
+	detectors, ok := h.piiMasking.detectorsFor(state.req, state.norm.Path)
+	if !ok || !response.Inspectable(resp, h.piiMasking.cfg.ContentTypes) {
+		return
+	}
+	if err := response.MaskBody(resp, detectors, h.piiMasking.cfg.MaxBufferBytes); err != nil {
+		log.Printf("Warning: response body not masked: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// Raw head recording bounds
+const (
+	maxRawHeadBytes = 1<<20 + 4096 // net/http's default MaxHeaderBytes plus slack
+	maxPendingHeads = 16
+)
+
+// Recorder states
+const (
+	headState = iota
+	bodyState
+	chunkSizeState
+	chunkDataState
+	trailerState
+	brokenState
+)
+
+// headListener records the raw request heads read from each connection so the
+// protocol validation can see headers as sent rather than as parsed
+type headListener struct {
+	net.Listener
+}
+
+// Accept wraps the next connection with a head recorder
+func (l headListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headConn{Conn: conn}, nil
+}
+
+// headConn follows HTTP/1.1 message framing on the bytes read from a
+// connection, queueing each request head until its handler takes it
+type headConn struct {
+	net.Conn
+	mu      sync.Mutex
+	state   int
+	buf     []byte
+	remain  int64
+	pending [][]byte
+}
+
+// Read records the bytes read by the HTTP server
+func (c *headConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.feed(p[:n])
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// next returns the oldest request head not yet taken
+func (c *headConn) next() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) == 0 {
+		return nil
+	}
+	head := c.pending[0]
+	c.pending = c.pending[1:]
+	return head
+}
+
+// feed advances the framing state machine over p
+func (c *headConn) feed(p []byte) {
+	for len(p) > 0 && c.state != brokenState {
+		switch c.state {
+		case headState:
+			if len(c.buf) == 0 {
+				// Skip empty lines between requests
+				p = bytes.TrimLeft(p, "\r\n")
+				if len(p) == 0 {
+					return
+				}
+			}
+			line, rest, ok := c.line(p, "\n\r\n", "\n\n")
+			p = rest
+			if !ok {
+				continue
+			}
+			c.endHead(line)
+		case bodyState, chunkDataState:
+			n := int64(len(p))
+			if n > c.remain {
+				n = c.remain
+			}
+			p = p[n:]
+			c.remain -= n
+			if c.remain == 0 {
+				if c.state == chunkDataState {
+					c.state = chunkSizeState
+				} else {
+					c.state = headState
+				}
+			}
+		case chunkSizeState:
+			line, rest, ok := c.line(p, "\n")
+			p = rest
+			if !ok {
+				continue
+			}
+			sizeField, _, _ := strings.Cut(strings.TrimSpace(string(line)), ";")
+			size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+			switch {
+			case err != nil || size < 0:
+				c.fail()
+			case size == 0:
+				c.state = trailerState
+			default:
+				c.state, c.remain = chunkDataState, size+2
+			}
+		case trailerState:
+			line, rest, ok := c.line(p, "\n")
+			p = rest
+			if ok && len(bytes.TrimSpace(line)) == 0 {
+				c.state = headState
+			}
+		}
+	}
+}
+
+// line accumulates p until the earliest of the terminators, returning the
+// complete line (including the terminator) and the unread part of p
+func (c *headConn) line(p []byte, terms ...string) ([]byte, []byte, bool) {
+	prev := len(c.buf)
+	from := prev - 2
+	if from < 0 {
+		from = 0
+	}
+	c.buf = append(c.buf, p...)
+
+	end := -1
+	for _, term := range terms {
+		if i := bytes.Index(c.buf[from:], []byte(term)); i >= 0 && (end < 0 || from+i+len(term) < end) {
+			end = from + i + len(term)
+		}
+	}
+	if end < 0 {
+		if len(c.buf) > maxRawHeadBytes {
+			c.fail()
+		}
+		return nil, nil, false
+	}
+	line := c.buf[:end]
+	c.buf = nil
+	return line, p[end-prev:], true
+}
+
+// endHead queues a complete request head and sets up skipping its body
+func (c *headConn) endHead(head []byte) {
+	if len(c.pending) >= maxPendingHeads {
+		// Heads are no longer being taken, so pairing them with requests is lost
+		c.fail()
+		return
+	}
+	c.pending = append(c.pending, head)
+
+	chunked, length := framing(head)
+	switch {
+	case chunked:
+		c.state = chunkSizeState
+	case length > 0:
+		c.state, c.remain = bodyState, length
+	default:
+		c.state = headState
+	}
+}
+
+// fail stops recording once the stream can no longer be followed
+func (c *headConn) fail() {
+	c.state = brokenState
+	c.buf = nil
+	c.pending = nil
+}
+
+// framing returns how the body following head is delimited, resolving
+// conflicts the way net/http does: Transfer-Encoding wins over Content-Length
+func framing(head []byte) (chunked bool, length int64) {
+	length = -1
+	for _, line := range strings.Split(string(head), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			chunked = true
+		case strings.EqualFold(name, "Content-Length") && length < 0:
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				length = n
+			}
+		}
+	}
+	return chunked, length
+}
+
+type headConnKey struct{}
+
+// headConnContext stores the head recorder in the connection context
+func headConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if hc, ok := conn.(*headConn); ok {
+		return context.WithValue(ctx, headConnKey{}, hc)
+	}
+	return ctx
+}
+
+// withRawHead attaches each request's raw head to its context. Every request
+// on the connection must pass through here to keep heads and requests paired.
+func withRawHead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hc, ok := r.Context().Value(headConnKey{}).(*headConn); ok && r.ProtoMajor == 1 {
+			if head := hc.next(); head != nil {
+				r = r.WithContext(normalize.WithRawHead(r.Context(), head))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
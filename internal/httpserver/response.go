@@ -0,0 +1,214 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/detection"
+	"github.com/waf-draft/waf/internal/logging"
+	"github.com/waf-draft/waf/internal/mitigation"
+	"github.com/waf-draft/waf/internal/normalize"
+	"github.com/waf-draft/waf/internal/response"
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// responseStateKey is the context key for the state carried from the request
+// phase to the response phase
+type responseStateKey struct{}
+
+// responseState is the request-phase state needed to inspect the upstream response
+type responseState struct {
+	req  *http.Request
+	norm *normalize.NormalizedRequest
+	tx   *detection.Transaction
+}
+
+// blockedResponse is returned by ModifyResponse when the response phase blocks
+// an upstream response; the proxy error handler then applies the decision
+type blockedResponse struct {
+	dec  decision.Decision
+	path string
+}
+
+func (e *blockedResponse) Error() string {
+	return fmt.Sprintf("upstream response blocked: %s", e.dec.Reason)
+}
+
+// hookResponses installs the response phase, PII masking and error pages on the
+// reverse proxy. They need the proxy created by NewProxy; any other handler
+// disables them.
+func (h *WAFHandler) hookResponses() {
+	proxy, ok := h.proxy.(*httputil.ReverseProxy)
+	if !ok {
+		log.Printf("Warning: response inspection, masking and error pages need a reverse proxy upstream, disabling them")
+		return
+	}
+	if h.cfg.Security.Response.Enabled {
+		h.inspectResponses = true
+		h.responseDetectors = responseDetectors(h.cfg.Security.Response.Detectors)
+	}
+	if h.cfg.Security.PIIMasking.Enabled {
+		h.piiMasking = newPIIMasking(h.cfg.Security.PIIMasking)
+	}
+	if h.cfg.Security.ErrorPages.Enabled {
+		h.errorPages = newErrorPages(h.cfg.Security.ErrorPages)
+	}
+
+	modifyResponse := proxy.ModifyResponse
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if modifyResponse != nil {
+			if err := modifyResponse(resp); err != nil {
+				return err
+			}
+		}
+		state, ok := resp.Request.Context().Value(responseStateKey{}).(*responseState)
+		if !ok {
+			return nil
+		}
+		// Replaced error responses carry no upstream data to inspect or mask
+		if h.errorPages != nil && h.replaceErrorResponse(resp, state) {
+			return nil
+		}
+		// Requests allowed by a rule skip the response rules but are still masked
+		if h.inspectResponses && state.tx != nil && state.tx.Disruption == nil {
+			if err := h.inspectResponse(resp, state); err != nil {
+				return err
+			}
+		}
+		if h.piiMasking != nil {
+			h.maskResponse(resp, state)
+		}
+		return nil
+	}
+
+	errorHandler := proxy.ErrorHandler
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var blocked *blockedResponse
+		if errors.As(err, &blocked) {
+			mitigation.ApplyDecision(blocked.dec, w, r, blocked.path, nil, h.blockPages)
+			return
+		}
+		if errorHandler != nil {
+			errorHandler(w, r, err)
+			return
+		}
+		// The reverse proxy's default behaviour
+		log.Printf("http: proxy error: %v", err)
+		if h.errorPages != nil {
+			if err := h.errorPages.page.Write(w, http.StatusBadGateway, r.Header.Get(logging.RequestIDHeader)); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	h.hookedResponses = true
+}
+
+// responseDetectors returns the configured leak detectors, dropping unknown names.
+// nil runs all detectors.
+func responseDetectors(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	detectors := make([]string, 0, len(names))
+	for _, name := range names {
+		if !response.ValidDetector(name) {
+			log.Printf("Warning: unknown response detector %q, ignoring", name)
+			continue
+		}
+		detectors = append(detectors, name)
+	}
+	return detectors
+}
+
+// withResponseState attaches the request-phase state so that the response can
+// be inspected and masked once the upstream answers
+func withResponseState(r *http.Request, norm *normalize.NormalizedRequest, tx *detection.Transaction) *http.Request {
+	state := &responseState{req: r, norm: norm, tx: tx}
+	return r.WithContext(context.WithValue(r.Context(), responseStateKey{}, state))
+}
+
+// inspectResponse runs the response-phase rules against an upstream response.
+// A blocking decision is returned as a *blockedResponse error. Otherwise the
+// parts of the body matched by mask actions are masked and the response is sent.
+func (h *WAFHandler) inspectResponse(resp *http.Response, state *responseState) error {
+	cfg := h.cfg.Security.Response
+	tx := state.tx
+
+	data := &detection.ResponseData{Status: resp.StatusCode, Headers: resp.Header}
+	var body *response.Body
+	if response.Inspectable(resp, cfg.ContentTypes) {
+		var err error
+		body, err = response.ReadBody(resp, cfg.MaxBodyBytes)
+		if err != nil {
+			log.Printf("Warning: response body not inspected: %v", err)
+			body.Restore(resp)
+			body = nil
+		} else {
+			data.Body = body.Text
+			data.Findings = response.Detect(body.Text, h.responseDetectors)
+		}
+	}
+
+	requestScore := tx.Score.Total
+	matchedRules, matches := len(tx.MatchedRules), len(tx.Score.Matches)
+	if err := detection.EvaluateResponse(tx, state.req, state.norm, data, h.rules); err != nil {
+		// Log error but continue
+	}
+	if len(tx.MatchedRules) == matchedRules {
+		if body != nil {
+			body.Restore(resp)
+		}
+		return nil
+	}
+
+	// Track rule matches
+	metrics := telemetry.GetMetrics()
+	for _, rule := range tx.MatchedRules[matchedRules:] {
+		metrics.IncrementRuleMatch(rule.ID)
+	}
+	for _, match := range tx.Score.Matches[matches:] {
+		metrics.IncrementVariableMatch(match.Variable)
+		telemetry.GetPrometheusMetrics().VariableMatches.WithLabelValues(match.RuleID, detection.Collection(match.Variable)).Inc()
+	}
+
+	dec := decision.DecideResponse(tx, h.cfg, requestScore)
+	if dec.Action == "block" && dec.Status == 0 {
+		dec.Status = h.blockPages.Status(state.req, state.norm.Path)
+	}
+	if dec.Action != "allow" {
+		metrics.IncrementResponseAction("block")
+		telemetry.GetPrometheusMetrics().ResponseActions.WithLabelValues("block").Inc()
+		if !tx.NoLog {
+			h.logger.LogResponse(state.req, state.norm, dec, tx.MatchedRules, mitigation.StatusCode(dec))
+		}
+		return &blockedResponse{dec: dec, path: state.norm.Path}
+	}
+
+	// Apply headers requested by response rules
+	for name, values := range tx.ResponseHeaders {
+		resp.Header[name] = values
+	}
+
+	if body != nil {
+		if len(data.Masks) > 0 {
+			body.Replace(resp, response.Mask(body.Text, data.Masks))
+			metrics.IncrementResponseAction("mask")
+			telemetry.GetPrometheusMetrics().ResponseActions.WithLabelValues("mask").Inc()
+		} else {
+			body.Restore(resp)
+		}
+	}
+
+	if !tx.NoLog {
+		h.logger.LogResponse(state.req, state.norm, dec, tx.MatchedRules, resp.StatusCode)
+	}
+	return nil
+}
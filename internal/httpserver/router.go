@@ -1,8 +1,11 @@
 package httpserver
 
 import (
+	"log"
 	"net/http"
 	"strings"
+
+	"github.com/waf-draft/waf/api/management"
 )
 
 // Router handles routing for WAF endpoints
@@ -11,16 +14,25 @@ type Router struct {
 	healthHandler  *HealthHandler
 	metricsHandler *MetricsHandler
 	logsHandler    *LogsHandler
+	adminHandler   *management.Handler
 }
 
 // NewRouter creates a new router
 func NewRouter(wafHandler *WAFHandler, logFile string) *Router {
-	return &Router{
+	router := &Router{
 		wafHandler:     wafHandler,
 		healthHandler:  &HealthHandler{},
 		metricsHandler: &MetricsHandler{},
 		logsHandler:    NewLogsHandler(logFile),
 	}
+	// The management API is only served with a token; otherwise its paths go
+	// through the WAF like any other request
+	if token := wafHandler.cfg.Admin.Token; token != "" {
+		router.adminHandler = management.NewHandler(token, wafHandler.Bans(), wafHandler.Cluster())
+	} else {
+		log.Printf("Warning: no admin token configured, management API disabled")
+	}
+	return router
 }
 
 // ServeHTTP implements http.Handler
@@ -43,7 +55,11 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if r.adminHandler != nil && strings.HasPrefix(path, management.Prefix+"/") {
+		r.adminHandler.ServeHTTP(w, req)
+		return
+	}
+
 	// All other requests go through WAF
 	r.wafHandler.ServeHTTP(w, req)
 }
-
@@ -3,6 +3,7 @@ package httpserver
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 
 	"github.com/waf-draft/waf/internal/config"
@@ -12,6 +13,7 @@ import (
 type Server struct {
 	httpServer *http.Server
 	handler    *WAFHandler
+	cfg        config.ServerConfig
 }
 
 // NewServer creates a new HTTP server
@@ -19,13 +21,16 @@ func NewServer(cfg *config.Config, handler *WAFHandler) *Server {
 	router := NewRouter(handler, cfg.Logging.Output)
 	return &Server{
 		httpServer: &http.Server{
-			Addr:         cfg.Server.ListenAddress,
-			Handler:      router,
-			ReadTimeout:  cfg.Server.ReadTimeout(),
-			WriteTimeout: cfg.Server.WriteTimeout(),
-			IdleTimeout:  cfg.Server.IdleTimeout(),
+			Addr:              cfg.Server.ListenAddress,
+			Handler:           withRawHead(router),
+			ConnContext:       headConnContext,
+			ReadTimeout:       cfg.Server.ReadTimeout(),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout(),
+			WriteTimeout:      cfg.Server.WriteTimeout(),
+			IdleTimeout:       cfg.Server.IdleTimeout(),
 		},
 		handler: handler,
+		cfg:     cfg.Server,
 	}
 }
 
@@ -33,11 +38,24 @@ func NewServer(cfg *config.Config, handler *WAFHandler) *Server {
 func (s *Server) Start() error {
 	log.Printf("Starting WAF server on %s", s.httpServer.Addr)
 	log.Printf("Upstream: %s", s.httpServer.Addr) // This will be logged from config
-	return s.httpServer.ListenAndServe()
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln, applying the connection limits and recording
+// raw request heads for protocol validation
+func (s *Server) Serve(ln net.Listener) error {
+	return s.httpServer.Serve(headListener{NewLimitListener(ln, s.cfg.MaxConnections, s.cfg.MaxConnectionsPerIP)})
 }
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	if closeErr := s.handler.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
-
@@ -0,0 +1,632 @@
+package ipfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waf-draft/waf/internal/state"
+)
+
+// Ban is a temporary block on a client IP
+type Ban struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Offense is the number of times the IP has been banned, including this ban
+	Offense int `json:"offense"`
+}
+
+// BanPolicy configures when clients are banned and for how long
+type BanPolicy struct {
+	// BlockThreshold bans a client after this many blocked requests within Window (0 disables)
+	BlockThreshold int
+	// RateLimitThreshold bans a client after this many rate-limit violations within Window (0 disables)
+	RateLimitThreshold int
+	Window             time.Duration
+	// BanRules bans a client immediately when any of these rule IDs fires
+	BanRules []string
+	// Duration is the first ban's length; repeat bans are multiplied by EscalationFactor
+	Duration         time.Duration
+	MaxDuration      time.Duration
+	EscalationFactor float64
+	// OffenseMemory is how long past bans count towards escalation
+	OffenseMemory time.Duration
+}
+
+// offense tracks a client's ban history for escalation
+type offense struct {
+	Count   int       `json:"count"`
+	LastBan time.Time `json:"last_ban"`
+}
+
+// BanManager maintains a TTL-based blocklist driven by WAF decisions
+type BanManager struct {
+	policy   BanPolicy
+	banRules map[string]bool
+	bans     map[string]*Ban
+	offenses map[string]*offense
+	blocks   map[string][]time.Time
+	limits   map[string][]time.Time
+	path     string
+	mu       sync.Mutex
+	cleanup  *time.Ticker
+	done     chan struct{}
+	closed   sync.Once
+
+	// dirty signals the writer that bans changed; saveMu orders file writes
+	dirty   chan struct{}
+	saveMu  sync.Mutex
+	workers sync.WaitGroup
+
+	// shared replicates bans to other replicas through a state backend
+	shared   state.Backend
+	cacheTTL time.Duration
+	failMode string
+	remote   map[string]remoteBan
+}
+
+// remoteBan caches a ban lookup in the shared backend. Failed lookups are
+// cached too, so an unreachable backend costs one call per cacheTTL.
+type remoteBan struct {
+	ban     *Ban
+	checked time.Time
+	failed  bool
+}
+
+// banKeyPrefix prefixes ban keys in the shared backend
+const banKeyPrefix = "ban:"
+
+// saveDelay batches ban changes into one write of the ban file
+const saveDelay = time.Second
+
+// banState is the persisted form of the ban manager
+type banState struct {
+	Bans     map[string]*Ban     `json:"bans"`
+	Offenses map[string]*offense `json:"offenses"`
+}
+
+// NewBanManager creates a ban manager. If path is non-empty, bans are loaded from and
+// saved to that file so they survive restarts.
+func NewBanManager(policy BanPolicy, path string) (*BanManager, error) {
+	if policy.Window <= 0 {
+		policy.Window = time.Minute
+	}
+	if policy.Duration <= 0 {
+		policy.Duration = 5 * time.Minute
+	}
+	if policy.EscalationFactor < 1 {
+		policy.EscalationFactor = 1
+	}
+	if policy.OffenseMemory <= 0 {
+		policy.OffenseMemory = 24 * time.Hour
+	}
+
+	m := &BanManager{
+		policy:   policy,
+		banRules: make(map[string]bool),
+		bans:     make(map[string]*Ban),
+		offenses: make(map[string]*offense),
+		blocks:   make(map[string][]time.Time),
+		limits:   make(map[string][]time.Time),
+		path:     path,
+		cleanup:  time.NewTicker(1 * time.Minute),
+		done:     make(chan struct{}),
+		dirty:    make(chan struct{}, 1),
+	}
+	for _, id := range policy.BanRules {
+		m.banRules[id] = true
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	m.workers.Add(2)
+	go m.cleanupExpired()
+	go m.writeChanges()
+
+	return m, nil
+}
+
+// SetBackend shares bans with other replicas through a state backend. Remote
+// lookups are cached for cacheTTL. With state.FailClosed, clients are treated as
+// banned while the backend cannot be reached.
+func (m *BanManager) SetBackend(backend state.Backend, cacheTTL time.Duration, failMode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shared = backend
+	m.cacheTTL = cacheTTL
+	m.failMode = failMode
+	m.remote = make(map[string]remoteBan)
+}
+
+// IsBanned returns the active ban for an IP, if any
+func (m *BanManager) IsBanned(ip string) (Ban, bool) {
+	m.mu.Lock()
+	now := time.Now()
+	if ban, ok := m.bans[ip]; ok {
+		defer m.mu.Unlock()
+		if now.After(ban.ExpiresAt) {
+			delete(m.bans, ip)
+			return Ban{}, false
+		}
+		return *ban, true
+	}
+	if m.shared == nil {
+		m.mu.Unlock()
+		return Ban{}, false
+	}
+	if cached, ok := m.remote[ip]; ok && now.Sub(cached.checked) < m.cacheTTL {
+		m.mu.Unlock()
+		if cached.failed {
+			return m.unavailable(ip)
+		}
+		if cached.ban == nil || now.After(cached.ban.ExpiresAt) {
+			return Ban{}, false
+		}
+		return *cached.ban, true
+	}
+	backend := m.shared
+	m.mu.Unlock()
+
+	// Look the IP up in the shared backend without holding the lock
+	ban, err := fetchBan(backend, ip)
+	m.mu.Lock()
+	m.remote[ip] = remoteBan{ban: ban, checked: now, failed: err != nil}
+	m.mu.Unlock()
+	if err != nil {
+		return m.unavailable(ip)
+	}
+	if ban == nil || now.After(ban.ExpiresAt) {
+		return Ban{}, false
+	}
+	return *ban, true
+}
+
+// unavailable applies the fail mode to a client whose ban state is unknown
+func (m *BanManager) unavailable(ip string) (Ban, bool) {
+	if m.failMode == state.FailClosed {
+		return Ban{IP: ip, Reason: "ban state unavailable"}, true
+	}
+	return Ban{}, false
+}
+
+// fetchBan reads a ban from the shared backend; a nil ban means none exists
+func fetchBan(backend state.Backend, ip string) (*Ban, error) {
+	value, ok, err := backend.Get(banKeyPrefix + ip)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var ban Ban
+	if err := json.Unmarshal([]byte(value), &ban); err != nil {
+		return nil, nil
+	}
+	return &ban, nil
+}
+
+// RecordBlock registers a blocked request and bans the client if a trigger fires.
+// It returns the new ban, if one was created.
+func (m *BanManager) RecordBlock(ip string, ruleIDs []string) (Ban, bool) {
+	m.mu.Lock()
+	ban := m.recordBlockLocked(ip, ruleIDs)
+	m.mu.Unlock()
+	if ban == nil {
+		return Ban{}, false
+	}
+	return m.share(ban), true
+}
+
+// recordBlockLocked counts a blocked request and returns the ban it triggers,
+// if any. Caller must hold m.mu.
+func (m *BanManager) recordBlockLocked(ip string, ruleIDs []string) *Ban {
+	if m.bannedLocked(ip) {
+		return nil
+	}
+
+	for _, id := range ruleIDs {
+		if m.banRules[id] {
+			return m.banLocked(ip, fmt.Sprintf("rule %s fired", id), 0)
+		}
+	}
+
+	if m.policy.BlockThreshold > 0 {
+		m.blocks[ip] = appendWithin(m.blocks[ip], time.Now(), m.policy.Window)
+		if len(m.blocks[ip]) >= m.policy.BlockThreshold {
+			delete(m.blocks, ip)
+			reason := fmt.Sprintf("%d blocked requests within %s", m.policy.BlockThreshold, m.policy.Window)
+			return m.banLocked(ip, reason, 0)
+		}
+	}
+	return nil
+}
+
+// RecordRateLimit registers a rate-limit violation and bans the client if the
+// violation threshold is reached. It returns the new ban, if one was created.
+func (m *BanManager) RecordRateLimit(ip string) (Ban, bool) {
+	m.mu.Lock()
+	ban := m.recordRateLimitLocked(ip)
+	m.mu.Unlock()
+	if ban == nil {
+		return Ban{}, false
+	}
+	return m.share(ban), true
+}
+
+// recordRateLimitLocked counts a rate-limit violation and returns the ban it
+// triggers, if any. Caller must hold m.mu.
+func (m *BanManager) recordRateLimitLocked(ip string) *Ban {
+	if m.policy.RateLimitThreshold <= 0 || m.bannedLocked(ip) {
+		return nil
+	}
+
+	m.limits[ip] = appendWithin(m.limits[ip], time.Now(), m.policy.Window)
+	if len(m.limits[ip]) >= m.policy.RateLimitThreshold {
+		delete(m.limits, ip)
+		reason := fmt.Sprintf("%d rate-limit violations within %s", m.policy.RateLimitThreshold, m.policy.Window)
+		return m.banLocked(ip, reason, 0)
+	}
+	return nil
+}
+
+// Ban bans an IP manually. A zero duration uses the escalating policy duration.
+func (m *BanManager) Ban(ip, reason string, duration time.Duration) Ban {
+	m.mu.Lock()
+	ban := m.banLocked(ip, reason, duration)
+	m.mu.Unlock()
+	return m.share(ban)
+}
+
+// banLocked creates a ban, escalating its duration for repeat offenders. The
+// ban is held locally until share has published it. Caller must hold m.mu.
+func (m *BanManager) banLocked(ip, reason string, duration time.Duration) *Ban {
+	now := time.Now()
+
+	off, ok := m.offenses[ip]
+	if !ok || now.Sub(off.LastBan) > m.policy.OffenseMemory {
+		off = &offense{}
+		m.offenses[ip] = off
+	}
+	off.Count++
+	off.LastBan = now
+
+	if duration <= 0 {
+		duration = m.escalatedDuration(off.Count)
+	}
+
+	ban := &Ban{
+		IP:        ip,
+		Reason:    reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+		Offense:   off.Count,
+	}
+	m.bans[ip] = ban
+	return ban
+}
+
+// share publishes a new ban to the shared backend without holding the lock.
+// Shared bans live in the backend so that an unban on any replica applies
+// everywhere; they are only kept locally if the backend cannot be reached.
+func (m *BanManager) share(ban *Ban) Ban {
+	m.mu.Lock()
+	backend := m.shared
+	m.mu.Unlock()
+
+	if backend != nil && publishBan(backend, ban) {
+		m.mu.Lock()
+		// Skip the cache if the ban was lifted while it was being published
+		if m.bans[ban.IP] == ban {
+			delete(m.bans, ban.IP)
+			m.remote[ban.IP] = remoteBan{ban: ban, checked: time.Now()}
+		}
+		m.mu.Unlock()
+		return *ban
+	}
+
+	m.mu.Lock()
+	m.changedLocked()
+	m.mu.Unlock()
+	return *ban
+}
+
+// publishBan writes a ban to the shared backend
+func publishBan(backend state.Backend, ban *Ban) bool {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return false
+	}
+	if err := backend.Set(banKeyPrefix+ban.IP, string(data), time.Until(ban.ExpiresAt)); err != nil {
+		log.Printf("Warning: failed to share ban for %s: %v", ban.IP, err)
+		return false
+	}
+	return true
+}
+
+// bannedLocked reports whether an IP has a local or cached shared ban.
+// Caller must hold m.mu.
+func (m *BanManager) bannedLocked(ip string) bool {
+	if _, ok := m.bans[ip]; ok {
+		return true
+	}
+	cached, ok := m.remote[ip]
+	return ok && cached.ban != nil && time.Now().Before(cached.ban.ExpiresAt)
+}
+
+// escalatedDuration returns Duration * EscalationFactor^(offense-1), capped at MaxDuration
+func (m *BanManager) escalatedDuration(offenseCount int) time.Duration {
+	duration := float64(m.policy.Duration)
+	for i := 1; i < offenseCount; i++ {
+		duration *= m.policy.EscalationFactor
+		if m.policy.MaxDuration > 0 && duration >= float64(m.policy.MaxDuration) {
+			return m.policy.MaxDuration
+		}
+	}
+	if m.policy.MaxDuration > 0 && duration > float64(m.policy.MaxDuration) {
+		return m.policy.MaxDuration
+	}
+	return time.Duration(duration)
+}
+
+// Unban removes an active ban. It returns false if the IP was not banned.
+func (m *BanManager) Unban(ip string) bool {
+	m.mu.Lock()
+	_, local := m.bans[ip]
+	delete(m.bans, ip)
+	backend := m.shared
+	if backend != nil {
+		delete(m.remote, ip)
+	}
+	if local {
+		m.changedLocked()
+	}
+	m.mu.Unlock()
+
+	// Remove the shared ban without holding the lock
+	found := local
+	if backend != nil {
+		if ban, err := fetchBan(backend, ip); err == nil && ban != nil {
+			found = true
+		}
+		if err := backend.Delete(banKeyPrefix + ip); err != nil {
+			log.Printf("Warning: failed to remove shared ban for %s: %v", ip, err)
+		}
+	}
+	return found
+}
+
+// List returns all active bans ordered by expiry
+func (m *BanManager) List() []Ban {
+	m.mu.Lock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(m.bans))
+	local := make(map[string]bool, len(m.bans))
+	for ip, ban := range m.bans {
+		local[ip] = true
+		if now.Before(ban.ExpiresAt) {
+			bans = append(bans, *ban)
+		}
+	}
+	backend := m.shared
+	m.mu.Unlock()
+
+	if backend != nil {
+		bans = append(bans, listShared(backend, local, now)...)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ExpiresAt.Before(bans[j].ExpiresAt) })
+	return bans
+}
+
+// listShared returns active bans from the shared backend that are not held locally
+func listShared(backend state.Backend, local map[string]bool, now time.Time) []Ban {
+	keys, err := backend.Keys(banKeyPrefix)
+	if err != nil {
+		log.Printf("Warning: failed to list shared bans: %v", err)
+		return nil
+	}
+	var bans []Ban
+	for _, key := range keys {
+		ip := strings.TrimPrefix(key, banKeyPrefix)
+		if local[ip] {
+			continue
+		}
+		if ban, err := fetchBan(backend, ip); err == nil && ban != nil && now.Before(ban.ExpiresAt) {
+			bans = append(bans, *ban)
+		}
+	}
+	return bans
+}
+
+// GetStats returns ban manager statistics
+func (m *BanManager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return map[string]interface{}{
+		"active_bans":     len(m.bans),
+		"tracked_clients": len(m.offenses),
+	}
+}
+
+// Stop stops the background goroutines and saves the ban list
+func (m *BanManager) Stop() error {
+	m.closed.Do(func() {
+		m.cleanup.Stop()
+		close(m.done)
+	})
+	m.workers.Wait()
+	return m.save()
+}
+
+// cleanupExpired removes expired bans, stale trigger history and forgotten offenses
+func (m *BanManager) cleanupExpired() {
+	defer m.workers.Done()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.cleanup.C:
+		}
+
+		m.mu.Lock()
+		now := time.Now()
+		changed := false
+		for ip, ban := range m.bans {
+			if now.After(ban.ExpiresAt) {
+				delete(m.bans, ip)
+				changed = true
+			}
+		}
+		for ip, off := range m.offenses {
+			if now.Sub(off.LastBan) > m.policy.OffenseMemory {
+				delete(m.offenses, ip)
+				changed = true
+			}
+		}
+		for ip, cached := range m.remote {
+			if now.Sub(cached.checked) > m.cacheTTL {
+				delete(m.remote, ip)
+			}
+		}
+		pruneWindow(m.blocks, now, m.policy.Window)
+		pruneWindow(m.limits, now, m.policy.Window)
+		if changed {
+			m.changedLocked()
+		}
+		m.mu.Unlock()
+	}
+}
+
+// changedLocked schedules a save of the ban file. Caller must hold m.mu.
+func (m *BanManager) changedLocked() {
+	if m.path == "" {
+		return
+	}
+	select {
+	case m.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// writeChanges saves the ban file in the background, at most once per saveDelay,
+// so that bans are not written to disk under the lock on every request
+func (m *BanManager) writeChanges() {
+	defer m.workers.Done()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.dirty:
+		}
+		select {
+		case <-m.done:
+			// Stop saves the final state
+			return
+		case <-time.After(saveDelay):
+		}
+		if err := m.save(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// load restores persisted bans and offense history
+func (m *BanManager) load() error {
+	if m.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read ban file: %w", err)
+	}
+
+	var state banState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse ban file: %w", err)
+	}
+
+	now := time.Now()
+	for ip, ban := range state.Bans {
+		if ban != nil && now.Before(ban.ExpiresAt) {
+			m.bans[ip] = ban
+		}
+	}
+	for ip, off := range state.Offenses {
+		if off != nil && now.Sub(off.LastBan) <= m.policy.OffenseMemory {
+			m.offenses[ip] = off
+		}
+	}
+	return nil
+}
+
+// save writes bans to disk atomically
+func (m *BanManager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	data, err := json.Marshal(banState{Bans: m.bans, Offenses: m.offenses})
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode bans: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".bans-*")
+	if err != nil {
+		return fmt.Errorf("failed to write ban file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write ban file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write ban file: %w", err)
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// appendWithin appends t to times, dropping entries older than window
+func appendWithin(times []time.Time, t time.Time, window time.Duration) []time.Time {
+	cutoff := t.Add(-window)
+	valid := times[:0]
+	for _, ts := range times {
+		if ts.After(cutoff) {
+			valid = append(valid, ts)
+		}
+	}
+	return append(valid, t)
+}
+
+// pruneWindow removes timestamps older than window and drops empty keys
+func pruneWindow(history map[string][]time.Time, now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	for ip, times := range history {
+		valid := times[:0]
+		for _, ts := range times {
+			if ts.After(cutoff) {
+				valid = append(valid, ts)
+			}
+		}
+		if len(valid) == 0 {
+			delete(history, ip)
+		} else {
+			history[ip] = valid
+		}
+	}
+}
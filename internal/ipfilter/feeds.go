@@ -0,0 +1,373 @@
+package ipfilter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feed formats
+const (
+	FormatPlain = "plain"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+)
+
+// FeedSource describes a named reputation list loaded from a file or directory
+type FeedSource struct {
+	Name string
+	// Path is a file, or a directory whose regular files are all loaded
+	Path string
+	// Format is plain, csv or json; empty selects by file extension
+	Format string
+}
+
+// ReputationLists holds IP reputation feeds (e.g. FireHOL, Spamhaus DROP) and
+// reloads them when the underlying files change
+type ReputationLists struct {
+	sources  []FeedSource
+	entries  *Trie[[]string]
+	counts   map[string]int
+	invalid  map[string]int
+	versions string
+	loadedAt time.Time
+	mu       sync.RWMutex
+	refresh  *time.Ticker
+	done     chan struct{}
+	stopped  sync.Once
+}
+
+// NewReputationLists loads the given feeds and, if interval is positive, checks
+// them for changes at that interval
+func NewReputationLists(sources []FeedSource, interval time.Duration) (*ReputationLists, error) {
+	r := &ReputationLists{sources: sources, done: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		r.refresh = time.NewTicker(interval)
+		go r.watch()
+	}
+
+	return r, nil
+}
+
+// Lookup returns the names of all lists containing the IP
+func (r *ReputationLists) Lookup(ipStr string) []string {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	r.entries.Matches(addr.Unmap(), func(_ netip.Prefix, lists []string) {
+		for _, name := range lists {
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	})
+	return names
+}
+
+// Reload rebuilds all lists from disk. On error the previously loaded lists are kept.
+func (r *ReputationLists) Reload() error {
+	entries := &Trie[[]string]{}
+	counts := make(map[string]int)
+	invalid := make(map[string]int)
+
+	for _, source := range r.sources {
+		files, err := sourceFiles(source.Path)
+		if err != nil {
+			return fmt.Errorf("failed to load list %s: %w", source.Name, err)
+		}
+		for _, file := range files {
+			loaded, bad, err := loadFeedFile(file, source.Format, func(p netip.Prefix) {
+				lists, _ := entries.Get(p)
+				if !containsString(lists, source.Name) {
+					entries.Insert(p, append(lists, source.Name))
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("failed to load list %s: %w", source.Name, err)
+			}
+			counts[source.Name] += loaded
+			invalid[source.Name] += bad
+		}
+		if invalid[source.Name] > 0 {
+			log.Printf("Reputation list %s: skipped %d invalid entries", source.Name, invalid[source.Name])
+		}
+	}
+
+	versions, _ := r.fingerprint()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = entries
+	r.counts = counts
+	r.invalid = invalid
+	r.versions = versions
+	r.loadedAt = time.Now()
+	return nil
+}
+
+// watch reloads the lists whenever a file's size or modification time changes
+func (r *ReputationLists) watch() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.refresh.C:
+		}
+
+		versions, err := r.fingerprint()
+		if err != nil {
+			log.Printf("Failed to check reputation lists: %v", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := versions != r.versions
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			log.Printf("Failed to reload reputation lists: %v", err)
+		}
+	}
+}
+
+// fingerprint summarises the name, size and modification time of every feed file
+func (r *ReputationLists) fingerprint() (string, error) {
+	var b strings.Builder
+	for _, source := range r.sources {
+		files, err := sourceFiles(source.Path)
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "%s|%d|%d\n", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String(), nil
+}
+
+// Stop stops watching the feed files
+func (r *ReputationLists) Stop() {
+	r.stopped.Do(func() {
+		if r.refresh != nil {
+			r.refresh.Stop()
+		}
+		close(r.done)
+	})
+}
+
+// GetStats returns per-list entry counts
+func (r *ReputationLists) GetStats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lists := make(map[string]interface{}, len(r.counts))
+	for name, count := range r.counts {
+		lists[name] = map[string]int{"entries": count, "invalid": r.invalid[name]}
+	}
+	return map[string]interface{}{
+		"lists":     lists,
+		"prefixes":  r.entries.Len(),
+		"loaded_at": r.loadedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// sourceFiles expands a feed path into the files to load, in name order
+func sourceFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range dirEntries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadFeedFile parses one feed file, calling add for each valid entry. It returns the
+// number of valid and invalid entries.
+func loadFeedFile(path, format string, add func(netip.Prefix)) (int, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			format = FormatJSON
+		case ".csv":
+			format = FormatCSV
+		default:
+			format = FormatPlain
+		}
+	}
+
+	var values []string
+	switch format {
+	case FormatPlain:
+		values, err = parsePlainFeed(data)
+	case FormatCSV:
+		values, err = parseCSVFeed(data)
+	case FormatJSON:
+		values, err = parseJSONFeed(data)
+	default:
+		return 0, 0, fmt.Errorf("unknown feed format: %s", format)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var loaded, invalid int
+	for _, v := range values {
+		prefix, err := ParsePrefix(v)
+		if err != nil {
+			invalid++
+			continue
+		}
+		add(prefix)
+		loaded++
+	}
+	return loaded, invalid, nil
+}
+
+// parsePlainFeed reads one IP or CIDR per line. Text after "#" or ";" is a comment,
+// which covers FireHOL netsets and the Spamhaus DROP "cidr ; SBL id" format.
+func parsePlainFeed(data []byte) ([]string, error) {
+	var values []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			values = append(values, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// parseCSVFeed reads the first column that holds an IP or CIDR from each record.
+// Lines starting with "#" are comments; records without an address count as invalid.
+func parseCSVFeed(data []byte) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var values []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := ""
+		for _, field := range record {
+			if _, err := ParsePrefix(field); err == nil {
+				value = field
+				break
+			}
+		}
+		if value == "" && len(record) > 0 {
+			value = record[0]
+		}
+		values = append(values, value)
+	}
+}
+
+// parseJSONFeed accepts an array of strings, an array of objects with an "ip",
+// "cidr" or "network" field, or an object wrapping either under "entries"
+func parseJSONFeed(data []byte) ([]string, error) {
+	var wrapped struct {
+		Entries json.RawMessage `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err == nil && wrapped.Entries != nil {
+		data = wrapped.Entries
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of entries: %w", err)
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			values = append(values, s)
+			continue
+		}
+		var obj struct {
+			IP      string `json:"ip"`
+			CIDR    string `json:"cidr"`
+			Network string `json:"network"`
+		}
+		if err := json.Unmarshal(item, &obj); err != nil {
+			values = append(values, "")
+			continue
+		}
+		switch {
+		case obj.CIDR != "":
+			values = append(values, obj.CIDR)
+		case obj.Network != "":
+			values = append(values, obj.Network)
+		default:
+			values = append(values, obj.IP)
+		}
+	}
+	return values, nil
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,132 +1,100 @@
 package ipfilter
 
 import (
-	"net"
+	"net/netip"
 	"sync"
 )
 
 // IPFilter manages IP whitelist and blacklist
 type IPFilter struct {
-	whitelist map[string]bool
-	blacklist map[string]bool
+	whitelist *Trie[struct{}]
+	blacklist *Trie[struct{}]
 	mu        sync.RWMutex
 }
 
 // NewIPFilter creates a new IP filter
 func NewIPFilter() *IPFilter {
 	return &IPFilter{
-		whitelist: make(map[string]bool),
-		blacklist: make(map[string]bool),
+		whitelist: &Trie[struct{}]{},
+		blacklist: &Trie[struct{}]{},
 	}
 }
 
 // AddToWhitelist adds an IP or CIDR to the whitelist
 func (f *IPFilter) AddToWhitelist(ipOrCIDR string) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	// Check if it's a CIDR
-	if _, ipnet, err := net.ParseCIDR(ipOrCIDR); err == nil {
-		// For CIDR, we'll store the network
-		f.whitelist[ipnet.String()] = true
-		return nil
-	}
-
-	// Single IP
-	if ip := net.ParseIP(ipOrCIDR); ip != nil {
-		f.whitelist[ip.String()] = true
-		return nil
+	prefix, err := ParsePrefix(ipOrCIDR)
+	if err != nil {
+		return err
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.whitelist.Insert(prefix, struct{}{})
 	return nil
 }
 
 // AddToBlacklist adds an IP or CIDR to the blacklist
 func (f *IPFilter) AddToBlacklist(ipOrCIDR string) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	// Check if it's a CIDR
-	if _, ipnet, err := net.ParseCIDR(ipOrCIDR); err == nil {
-		f.blacklist[ipnet.String()] = true
-		return nil
-	}
-
-	// Single IP
-	if ip := net.ParseIP(ipOrCIDR); ip != nil {
-		f.blacklist[ip.String()] = true
-		return nil
+	prefix, err := ParsePrefix(ipOrCIDR)
+	if err != nil {
+		return err
 	}
 
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blacklist.Insert(prefix, struct{}{})
 	return nil
 }
 
 // IsWhitelisted checks if an IP is whitelisted
 func (f *IPFilter) IsWhitelisted(ipStr string) bool {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
 		return false
 	}
 
-	// Check exact match
-	if f.whitelist[ip.String()] {
-		return true
-	}
-
-	// Check CIDR matches
-	for cidrStr := range f.whitelist {
-		if _, ipnet, err := net.ParseCIDR(cidrStr); err == nil {
-			if ipnet.Contains(ip) {
-				return true
-			}
-		}
-	}
-
-	return false
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.whitelist.Contains(addr.Unmap())
 }
 
 // IsBlacklisted checks if an IP is blacklisted
 func (f *IPFilter) IsBlacklisted(ipStr string) bool {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
 		return false
 	}
 
-	// Check exact match
-	if f.blacklist[ip.String()] {
-		return true
-	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.blacklist.Contains(addr.Unmap())
+}
 
-	// Check CIDR matches
-	for cidrStr := range f.blacklist {
-		if _, ipnet, err := net.ParseCIDR(cidrStr); err == nil {
-			if ipnet.Contains(ip) {
-				return true
-			}
-		}
+// RemoveFromWhitelist removes an IP or CIDR from the whitelist. The entry is matched by
+// its canonical form, so "10.0.0.7/8" removes "10.0.0.0/8". It returns false if the
+// entry was not present.
+func (f *IPFilter) RemoveFromWhitelist(ipOrCIDR string) (bool, error) {
+	prefix, err := ParsePrefix(ipOrCIDR)
+	if err != nil {
+		return false, err
 	}
 
-	return false
-}
-
-// RemoveFromWhitelist removes an IP from whitelist
-func (f *IPFilter) RemoveFromWhitelist(ipOrCIDR string) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	delete(f.whitelist, ipOrCIDR)
+	return f.whitelist.Delete(prefix), nil
 }
 
-// RemoveFromBlacklist removes an IP from blacklist
-func (f *IPFilter) RemoveFromBlacklist(ipOrCIDR string) {
+// RemoveFromBlacklist removes an IP or CIDR from the blacklist by canonical form.
+// It returns false if the entry was not present.
+func (f *IPFilter) RemoveFromBlacklist(ipOrCIDR string) (bool, error) {
+	prefix, err := ParsePrefix(ipOrCIDR)
+	if err != nil {
+		return false, err
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	delete(f.blacklist, ipOrCIDR)
+	return f.blacklist.Delete(prefix), nil
 }
 
 // GetStats returns filter statistics
@@ -135,8 +103,7 @@ func (f *IPFilter) GetStats() map[string]interface{} {
 	defer f.mu.RUnlock()
 
 	return map[string]interface{}{
-		"whitelist_count": len(f.whitelist),
-		"blacklist_count": len(f.blacklist),
+		"whitelist_count": f.whitelist.Len(),
+		"blacklist_count": f.blacklist.Len(),
 	}
 }
-
@@ -0,0 +1,305 @@
+package ipfilter
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+// Trie is a path-compressed binary (PATRICIA) trie of IP prefixes. IPv4 prefixes
+// are stored as IPv4-mapped IPv6 prefixes so both families share one tree. Lookups
+// walk at most one node per distinct branching bit, i.e. O(prefix length), and
+// memory is O(number of prefixes).
+type Trie[V any] struct {
+	root *trieNode[V]
+	size int
+}
+
+type trieNode[V any] struct {
+	key   key128
+	bits  int
+	set   bool
+	value V
+	child [2]*trieNode[V]
+}
+
+// key128 holds a 128-bit address, most significant bits first
+type key128 struct {
+	hi, lo uint64
+}
+
+// Insert adds or replaces a prefix
+func (t *Trie[V]) Insert(prefix netip.Prefix, value V) {
+	k, b := prefixKey(prefix)
+	n := &t.root
+	for {
+		cur := *n
+		if cur == nil {
+			*n = &trieNode[V]{key: k, bits: b, set: true, value: value}
+			t.size++
+			return
+		}
+
+		common := commonLen(cur.key, k, min(cur.bits, b))
+		switch {
+		case common == cur.bits && common == b:
+			// Exact prefix already present
+			if !cur.set {
+				t.size++
+			}
+			cur.set = true
+			cur.value = value
+			return
+		case common == cur.bits:
+			// cur is an ancestor of the new prefix
+			n = &cur.child[k.bit(cur.bits)]
+		case common == b:
+			// The new prefix is an ancestor of cur
+			leaf := &trieNode[V]{key: k, bits: b, set: true, value: value}
+			leaf.child[cur.key.bit(b)] = cur
+			*n = leaf
+			t.size++
+			return
+		default:
+			// Prefixes diverge: split with an internal node at the common length
+			branch := &trieNode[V]{key: k.mask(common), bits: common}
+			branch.child[cur.key.bit(common)] = cur
+			branch.child[k.bit(common)] = &trieNode[V]{key: k, bits: b, set: true, value: value}
+			*n = branch
+			t.size++
+			return
+		}
+	}
+}
+
+// Delete removes an exact prefix. It returns false if the prefix was not present.
+func (t *Trie[V]) Delete(prefix netip.Prefix) bool {
+	k, b := prefixKey(prefix)
+	var deleted bool
+	t.root = t.delete(t.root, k, b, &deleted)
+	if deleted {
+		t.size--
+	}
+	return deleted
+}
+
+func (t *Trie[V]) delete(n *trieNode[V], k key128, b int, deleted *bool) *trieNode[V] {
+	if n == nil || n.bits > b || commonLen(n.key, k, n.bits) < n.bits {
+		return n
+	}
+	if n.bits == b {
+		if !n.set {
+			return n
+		}
+		*deleted = true
+		n.set = false
+		var zero V
+		n.value = zero
+	} else {
+		i := k.bit(n.bits)
+		n.child[i] = t.delete(n.child[i], k, b, deleted)
+	}
+
+	// Collapse nodes that no longer carry a value or a branch
+	if n.set {
+		return n
+	}
+	switch {
+	case n.child[0] == nil:
+		return n.child[1]
+	case n.child[1] == nil:
+		return n.child[0]
+	}
+	return n
+}
+
+// Lookup returns the value of the longest prefix containing addr
+func (t *Trie[V]) Lookup(addr netip.Addr) (V, netip.Prefix, bool) {
+	var (
+		best  *trieNode[V]
+		value V
+	)
+	k := addrKey(addr)
+	for n := t.root; n != nil; {
+		if commonLen(n.key, k, n.bits) < n.bits {
+			break
+		}
+		if n.set {
+			best = n
+		}
+		if n.bits == 128 {
+			break
+		}
+		n = n.child[k.bit(n.bits)]
+	}
+	if best == nil {
+		return value, netip.Prefix{}, false
+	}
+	return best.value, best.prefix(), true
+}
+
+// Matches calls fn for every prefix containing addr, from least to most specific
+func (t *Trie[V]) Matches(addr netip.Addr, fn func(netip.Prefix, V)) {
+	k := addrKey(addr)
+	for n := t.root; n != nil; {
+		if commonLen(n.key, k, n.bits) < n.bits {
+			return
+		}
+		if n.set {
+			fn(n.prefix(), n.value)
+		}
+		if n.bits == 128 {
+			return
+		}
+		n = n.child[k.bit(n.bits)]
+	}
+}
+
+// Contains reports whether any prefix contains addr
+func (t *Trie[V]) Contains(addr netip.Addr) bool {
+	_, _, ok := t.Lookup(addr)
+	return ok
+}
+
+// Get returns the value stored for an exact prefix
+func (t *Trie[V]) Get(prefix netip.Prefix) (V, bool) {
+	k, b := prefixKey(prefix)
+	for n := t.root; n != nil; {
+		if n.bits > b || commonLen(n.key, k, n.bits) < n.bits {
+			break
+		}
+		if n.bits == b {
+			return n.value, n.set
+		}
+		n = n.child[k.bit(n.bits)]
+	}
+	var zero V
+	return zero, false
+}
+
+// Len returns the number of prefixes stored
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Walk calls fn for every prefix in address order until fn returns false
+func (t *Trie[V]) Walk(fn func(netip.Prefix, V) bool) {
+	var walk func(n *trieNode[V]) bool
+	walk = func(n *trieNode[V]) bool {
+		if n == nil {
+			return true
+		}
+		if n.set && !fn(n.prefix(), n.value) {
+			return false
+		}
+		return walk(n.child[0]) && walk(n.child[1])
+	}
+	walk(t.root)
+}
+
+// prefix converts the node back to a netip.Prefix, unmapping IPv4 prefixes
+func (n *trieNode[V]) prefix() netip.Prefix {
+	var b [16]byte
+	for i := 0; i < 8; i++ {
+		b[i] = byte(n.key.hi >> (56 - 8*i))
+		b[8+i] = byte(n.key.lo >> (56 - 8*i))
+	}
+	addr := netip.AddrFrom16(b)
+	if addr.Is4In6() && n.bits >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), n.bits-96)
+	}
+	return netip.PrefixFrom(addr, n.bits)
+}
+
+// ParsePrefix parses an IP address or CIDR into its canonical masked prefix.
+// Single addresses become /32 or /128 prefixes and IPv4-mapped IPv6 input is unmapped.
+func ParsePrefix(ipOrCIDR string) (netip.Prefix, error) {
+	s := strings.TrimSpace(ipOrCIDR)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", ipOrCIDR, err)
+		}
+		addr := prefix.Addr()
+		if addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q: %w", ipOrCIDR, err)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// CanonicalString returns the canonical form of a prefix: the bare address for
+// single hosts, CIDR notation otherwise
+func CanonicalString(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+// prefixKey converts a prefix into a 128-bit key and length
+func prefixKey(prefix netip.Prefix) (key128, int) {
+	prefix = prefix.Masked()
+	b := prefix.Bits()
+	if prefix.Addr().Is4() {
+		b += 96
+	}
+	return addrKey(prefix.Addr()), b
+}
+
+// addrKey converts an address into a 128-bit key (IPv4 as IPv4-mapped IPv6)
+func addrKey(addr netip.Addr) key128 {
+	b := addr.As16()
+	var k key128
+	for i := 0; i < 8; i++ {
+		k.hi = k.hi<<8 | uint64(b[i])
+		k.lo = k.lo<<8 | uint64(b[8+i])
+	}
+	return k
+}
+
+// bit returns bit i of the key (0 is the most significant bit)
+func (k key128) bit(i int) int {
+	if i < 64 {
+		return int(k.hi>>(63-i)) & 1
+	}
+	return int(k.lo>>(127-i)) & 1
+}
+
+// mask keeps the first n bits of the key
+func (k key128) mask(n int) key128 {
+	switch {
+	case n <= 0:
+		return key128{}
+	case n < 64:
+		return key128{hi: k.hi &^ (^uint64(0) >> n)}
+	case n == 64:
+		return key128{hi: k.hi}
+	case n < 128:
+		return key128{hi: k.hi, lo: k.lo &^ (^uint64(0) >> (n - 64))}
+	default:
+		return k
+	}
+}
+
+// commonLen returns the number of leading bits a and b share, up to limit
+func commonLen(a, b key128, limit int) int {
+	var n int
+	if x := a.hi ^ b.hi; x != 0 {
+		n = bits.LeadingZeros64(x)
+	} else {
+		n = 64 + bits.LeadingZeros64(a.lo^b.lo)
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
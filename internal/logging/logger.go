@@ -1,6 +1,8 @@
 package logging
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,6 +15,9 @@ import (
 	"github.com/waf-draft/waf/internal/normalize"
 )
 
+// RequestIDHeader is the header used to carry the request ID
+const RequestIDHeader = "X-Request-ID"
+
 // Logger handles structured JSON logging
 type Logger struct {
 	output *os.File
@@ -37,17 +42,44 @@ func NewLogger(output string) (*Logger, error) {
 
 // LogRequest logs a request event
 func (l *Logger) LogRequest(req *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision, matchedRules []rules.Rule, statusCode int) {
+	l.writeJSON(newEvent(req, norm, dec, matchedRules, statusCode))
+}
+
+// LogResponse logs a response-phase event for an upstream response that matched
+// response rules
+func (l *Logger) LogResponse(req *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision, matchedRules []rules.Rule, statusCode int) {
+	event := newEvent(req, norm, dec, matchedRules, statusCode)
+	event.Phase = "response"
+	l.writeJSON(event)
+}
+
+// LogUpstreamError logs an upstream error response replaced by an error page,
+// with the original status and the start of the original body
+func (l *Logger) LogUpstreamError(req *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision, upstreamStatus int, snippet string, statusCode int) {
+	event := newEvent(req, norm, dec, nil, statusCode)
+	event.Phase = "response"
+	event.UpstreamStatus = upstreamStatus
+	event.UpstreamBodySnippet = snippet
+	l.writeJSON(event)
+}
+
+// newEvent builds the log event for a decision
+func newEvent(req *http.Request, norm *normalize.NormalizedRequest, dec decision.Decision, matchedRules []rules.Rule, statusCode int) LogEvent {
 	event := LogEvent{
 		Timestamp: time.Now().UTC(),
-		SourceIP:  getSourceIP(req),
+		SourceIP:  norm.ClientIP,
 		Method:    req.Method,
 		Path:      norm.Path,
 		Status:    statusCode,
 		Decision:  dec,
 		RequestID: getRequestID(req),
 		UserAgent: req.UserAgent(),
+
+		Country:        norm.Country,
+		ASN:            norm.ASN,
+		ASOrganization: norm.ASOrganization,
 	}
-	
+
 	// Add severity level based on decision
 	if dec.Action == "block" {
 		event.Severity = "HIGH"
@@ -70,7 +102,7 @@ func (l *Logger) LogRequest(req *http.Request, norm *normalize.NormalizedRequest
 		}
 		event.QueryString = queryStr
 	}
-	
+
 	// Detect attack type from matched rules
 	if len(matchedRules) > 0 {
 		attackTypes := make(map[string]bool)
@@ -89,10 +121,12 @@ func (l *Logger) LogRequest(req *http.Request, norm *normalize.NormalizedRequest
 					attackTypes["File Inclusion"] = true
 				case "header-injection":
 					attackTypes["Header Injection"] = true
+				case "data-leakage":
+					attackTypes["Data Leakage"] = true
 				}
 			}
 		}
-		
+
 		types := make([]string, 0, len(attackTypes))
 		for at := range attackTypes {
 			types = append(types, at)
@@ -102,7 +136,7 @@ func (l *Logger) LogRequest(req *http.Request, norm *normalize.NormalizedRequest
 		}
 	}
 
-	l.writeJSON(event)
+	return event
 }
 
 // writeJSON writes a JSON-encoded log event
@@ -115,30 +149,50 @@ func (l *Logger) writeJSON(event LogEvent) {
 	}
 }
 
-// getSourceIP extracts the client IP from the request
-func getSourceIP(req *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
-	}
-	// Check X-Real-IP header
-	if xri := req.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	// Fallback to RemoteAddr
-	return req.RemoteAddr
-}
-
 // getRequestID extracts or generates a request ID
 func getRequestID(req *http.Request) string {
 	// Check if request ID is already set
-	if id := req.Header.Get("X-Request-ID"); id != "" {
+	if id := req.Header.Get(RequestIDHeader); validRequestID(id) {
 		return id
 	}
 	// Generate a simple ID based on timestamp and path
 	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), req.URL.Path)
 }
 
+// EnsureRequestID returns the request ID carried by the request, assigning a new
+// random one if the client did not send a valid one. The ID is stored on the request
+// headers so that the log entry, the block response and the upstream all see the same value.
+func EnsureRequestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return getRequestID(req)
+	}
+	id := hex.EncodeToString(buf)
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+// maxRequestIDLength bounds the length of a client-supplied request ID
+const maxRequestIDLength = 128
+
+// validRequestID reports whether a client-supplied request ID is short enough and
+// uses only letters, digits, '.', '_' and '-', so that it is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
 // Close closes the logger output file
 func (l *Logger) Close() error {
 	if l.output != os.Stdout && l.output != os.Stderr {
@@ -146,4 +200,3 @@ func (l *Logger) Close() error {
 	}
 	return nil
 }
-
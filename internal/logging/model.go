@@ -8,16 +8,24 @@ import (
 
 // LogEvent represents a structured log event
 type LogEvent struct {
-	Timestamp   time.Time        `json:"timestamp"`
-	SourceIP    string           `json:"source_ip"`
-	Method      string           `json:"method"`
-	Path        string           `json:"path"`
-	Status      int              `json:"status"`
-	Decision    decision.Decision `json:"decision"`
-	RequestID   string           `json:"request_id"`
-	UserAgent   string           `json:"user_agent"`
-	QueryString string           `json:"query_string,omitempty"`
-	Severity    string           `json:"severity,omitempty"`
-	AttackType  string           `json:"attack_type,omitempty"`
+	Timestamp      time.Time         `json:"timestamp"`
+	SourceIP       string            `json:"source_ip"`
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	Status         int               `json:"status"`
+	Decision       decision.Decision `json:"decision"`
+	RequestID      string            `json:"request_id"`
+	UserAgent      string            `json:"user_agent"`
+	QueryString    string            `json:"query_string,omitempty"`
+	Severity       string            `json:"severity,omitempty"`
+	AttackType     string            `json:"attack_type,omitempty"`
+	Country        string            `json:"country,omitempty"`
+	ASN            uint              `json:"asn,omitempty"`
+	ASOrganization string            `json:"as_organization,omitempty"`
+	// Phase is "response" for events logged by response inspection
+	Phase string `json:"phase,omitempty"`
+	// UpstreamStatus and UpstreamBodySnippet record an upstream error response
+	// replaced by a WAF error page
+	UpstreamStatus      int    `json:"upstream_status,omitempty"`
+	UpstreamBodySnippet string `json:"upstream_body_snippet,omitempty"`
 }
-
@@ -1,7 +1,9 @@
 package mitigation
 
 import (
-	"encoding/json"
+	"bufio"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -9,28 +11,112 @@ import (
 	"github.com/waf-draft/waf/internal/decision"
 )
 
-// ApplyDecision applies the WAF decision to the request
-func ApplyDecision(dec decision.Decision, w http.ResponseWriter, r *http.Request, proxy *httputil.ReverseProxy) {
-	if dec.Action == "block" {
-		blockRequest(w, dec)
-	} else {
+// StatusDropped is logged for requests whose connection was closed without a response
+// (the nginx convention)
+const StatusDropped = 444
+
+// ApplyDecision applies the WAF decision to the request. Blocks are answered
+// from the block pages, routed by the normalized path; nil pages send the
+// built-in responses.
+func ApplyDecision(dec decision.Decision, w http.ResponseWriter, r *http.Request, path string, proxy *httputil.ReverseProxy, pages *BlockPages) {
+	switch dec.Action {
+	case "block":
+		pages.Write(w, r, path, dec)
+	case "redirect":
+		http.Redirect(w, r, dec.Location, StatusCode(dec))
+	case "drop":
+		dropConnection(w)
+	default:
 		// Forward request to upstream
 		proxy.ServeHTTP(w, r)
 	}
 }
 
-// blockRequest returns a 403 Forbidden response
-func blockRequest(w http.ResponseWriter, dec decision.Decision) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusForbidden)
+// StatusCode returns the response status the decision will produce
+func StatusCode(dec decision.Decision) int {
+	switch dec.Action {
+	case "block":
+		if dec.Status != 0 {
+			return dec.Status
+		}
+		return http.StatusForbidden
+	case "redirect":
+		if dec.Status != 0 {
+			return dec.Status
+		}
+		return http.StatusFound
+	case "drop":
+		return StatusDropped
+	case "challenge":
+		if dec.Status != 0 {
+			return dec.Status
+		}
+		return http.StatusForbidden
+	default:
+		return http.StatusOK
+	}
+}
 
-	response := map[string]interface{}{
-		"error":   "Forbidden",
-		"message": "Request blocked by WAF",
-		"reason":  dec.Reason,
+// dropConnection closes the client connection without sending a response
+func dropConnection(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
 	}
+	// Connections that cannot be hijacked (e.g. HTTP/2) are aborted by the server
+	panic(http.ErrAbortHandler)
+}
+
+// HeaderWriter sets headers on a response just before it is written, overriding
+// any values set by the upstream
+type HeaderWriter struct {
+	http.ResponseWriter
+	apply       func(http.Header)
+	wroteHeader bool
+}
 
-	json.NewEncoder(w).Encode(response)
+// NewHeaderWriter wraps w so that headers are applied to the response
+func NewHeaderWriter(w http.ResponseWriter, headers http.Header) *HeaderWriter {
+	return &HeaderWriter{ResponseWriter: w, apply: func(h http.Header) {
+		for name, values := range headers {
+			h[name] = values
+		}
+	}}
+}
+
+// WriteHeader applies the headers and writes the status code
+func (hw *HeaderWriter) WriteHeader(statusCode int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		hw.apply(hw.ResponseWriter.Header())
+	}
+	hw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the response body, applying headers first if needed
+func (hw *HeaderWriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for streaming upstream responses
+func (hw *HeaderWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so the drop action keeps working when headers are set
+func (hw *HeaderWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hj.Hijack()
 }
 
 // NewReverseProxy creates a new reverse proxy for the upstream server
@@ -51,4 +137,3 @@ func NewReverseProxy(upstreamURL string) (*httputil.ReverseProxy, error) {
 
 	return proxy, nil
 }
-
@@ -0,0 +1,427 @@
+package mitigation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/logging"
+)
+
+// Block response formats, chosen by the request's Accept header
+const (
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+// formatContentTypes are the Content-Type headers of the block response formats
+var formatContentTypes = map[string]string{
+	FormatJSON: "application/json",
+	FormatHTML: "text/html; charset=utf-8",
+	FormatText: "text/plain; charset=utf-8",
+}
+
+// defaultBlockHTML is the built-in HTML block page
+const defaultBlockHTML = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.Error}}</title></head>
+<body>
+<h1>{{.Status}} {{.Error}}</h1>
+<p>{{.Message}}</p>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}
+{{if .RequestID}}<p>Request ID: <code>{{.RequestID}}</code></p>{{end}}
+{{if .Support}}<p>{{.Support}}</p>{{end}}
+</body>
+</html>
+`
+
+// defaultBlockText is the built-in plain text block response
+const defaultBlockText = `{{.Status}} {{.Error}}: {{.Message}}
+{{if .Reason}}Reason: {{.Reason}}
+{{end}}{{if .RequestID}}Request ID: {{.RequestID}}
+{{end}}{{if .Support}}{{.Support}}
+{{end}}`
+
+var (
+	defaultHTMLTemplate = htmltemplate.Must(htmltemplate.New("block_html").Parse(defaultBlockHTML))
+	defaultTextTemplate = texttemplate.Must(texttemplate.New("block_text").Parse(defaultBlockText))
+)
+
+// BlockPageData is the data available to block response templates
+type BlockPageData struct {
+	Status     int
+	Error      string
+	Message    string
+	Reason     string
+	RequestID  string
+	Support    string
+	RetryAfter int
+}
+
+// executor is a parsed html/template or text/template
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// blockTemplate is a template file and the version of it that was loaded
+type blockTemplate struct {
+	tmpl    executor
+	modTime time.Time
+	size    int64
+}
+
+// blockRoute overrides the block response for matching requests
+type blockRoute struct {
+	route     *Route
+	status    int
+	templates config.BlockTemplatesConfig
+}
+
+// BlockPages renders the responses sent to blocked requests from templates
+// that are reloaded when they change on disk
+type BlockPages struct {
+	cfg     config.BlockPageConfig
+	routes  []blockRoute
+	files   map[string]*blockTemplate
+	mu      sync.RWMutex
+	refresh *time.Ticker
+	done    chan struct{}
+	stopped sync.Once
+}
+
+// NewBlockPages loads the block response templates and, if reloading is
+// configured, watches them for changes
+func NewBlockPages(cfg config.BlockPageConfig) (*BlockPages, error) {
+	p := &BlockPages{cfg: cfg, done: make(chan struct{})}
+	for _, rc := range cfg.Routes {
+		route, err := NewRoute(rc.Match)
+		if err != nil {
+			return nil, err
+		}
+		p.routes = append(p.routes, blockRoute{route: route, status: rc.Status, templates: rc.Templates})
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadSeconds > 0 {
+		p.refresh = time.NewTicker(time.Duration(cfg.ReloadSeconds) * time.Second)
+		go p.watch()
+	}
+	return p, nil
+}
+
+// templateFiles returns the configured template files keyed by format and path
+func (p *BlockPages) templateFiles() map[string]string {
+	files := make(map[string]string)
+	add := func(t config.BlockTemplatesConfig) {
+		for format, path := range map[string]string{FormatHTML: t.HTML, FormatJSON: t.JSON, FormatText: t.Text} {
+			if path != "" {
+				files[format+":"+path] = path
+			}
+		}
+	}
+	add(p.cfg.Templates)
+	for _, rt := range p.routes {
+		add(rt.templates)
+	}
+	return files
+}
+
+// Reload re-reads the template files. On error the previous templates stay in use.
+func (p *BlockPages) Reload() error {
+	files := make(map[string]*blockTemplate)
+	for key, path := range p.templateFiles() {
+		format, _, _ := strings.Cut(key, ":")
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to open block page template: %w", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read block page template: %w", err)
+		}
+		tmpl, err := parseBlockTemplate(format, path, string(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse block page template %s: %w", path, err)
+		}
+		files[key] = &blockTemplate{tmpl: tmpl, modTime: info.ModTime(), size: info.Size()}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files = files
+	return nil
+}
+
+// parseBlockTemplate parses HTML templates with html/template, which escapes
+// the data, and other formats with text/template. JSON templates can escape
+// values with the json function.
+func parseBlockTemplate(format, name, text string) (executor, error) {
+	if format == FormatHTML {
+		return htmltemplate.New(name).Parse(text)
+	}
+	return texttemplate.New(name).Funcs(texttemplate.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// changed reports whether any template file differs from the loaded version
+func (p *BlockPages) changed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for key, path := range p.templateFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if t := p.files[key]; t == nil || !info.ModTime().Equal(t.modTime) || info.Size() != t.size {
+			return true
+		}
+	}
+	return false
+}
+
+// watch reloads the templates when their files change
+func (p *BlockPages) watch() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refresh.C:
+		}
+
+		if !p.changed() {
+			continue
+		}
+		if err := p.Reload(); err != nil {
+			log.Printf("Failed to reload block page templates: %v", err)
+		} else {
+			log.Printf("Reloaded block page templates")
+		}
+	}
+}
+
+// Stop stops watching the template files
+func (p *BlockPages) Stop() {
+	p.stopped.Do(func() {
+		if p.refresh != nil {
+			p.refresh.Stop()
+		}
+		close(p.done)
+	})
+}
+
+// route returns the first route matching the normalized request path, or nil
+func (p *BlockPages) route(r *http.Request, path string) *blockRoute {
+	if p == nil {
+		return nil
+	}
+	for i := range p.routes {
+		if p.routes[i].route.Matches(r, path) {
+			return &p.routes[i]
+		}
+	}
+	return nil
+}
+
+// Status returns the configured status for blocks of the request, or 0 for the
+// default. path is the normalized request path.
+func (p *BlockPages) Status(r *http.Request, path string) int {
+	if rt := p.route(r, path); rt != nil && rt.status != 0 {
+		return rt.status
+	}
+	if p == nil {
+		return 0
+	}
+	return p.cfg.Status
+}
+
+// template returns the configured template for a format, or nil for the built-in response
+func (p *BlockPages) template(r *http.Request, path, format string) executor {
+	if p == nil {
+		return nil
+	}
+	file := templatePath(p.cfg.Templates, format)
+	if rt := p.route(r, path); rt != nil {
+		if routeFile := templatePath(rt.templates, format); routeFile != "" {
+			file = routeFile
+		}
+	}
+	if file == "" {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if t := p.files[format+":"+file]; t != nil {
+		return t.tmpl
+	}
+	return nil
+}
+
+// templatePath returns the template file for a format
+func templatePath(t config.BlockTemplatesConfig, format string) string {
+	switch format {
+	case FormatHTML:
+		return t.HTML
+	case FormatText:
+		return t.Text
+	default:
+		return t.JSON
+	}
+}
+
+// Write sends the block response for a decision in the format the client
+// accepts. path is the normalized request path.
+func (p *BlockPages) Write(w http.ResponseWriter, r *http.Request, path string, dec decision.Decision) {
+	status := StatusCode(dec)
+	data := BlockPageData{
+		Status:     status,
+		Error:      http.StatusText(status),
+		Message:    "Request blocked by WAF",
+		Reason:     dec.Reason,
+		RequestID:  r.Header.Get(logging.RequestIDHeader),
+		RetryAfter: dec.RetryAfter,
+	}
+	if status == http.StatusTooManyRequests {
+		data.Message = "Rate limit exceeded, retry later"
+	}
+	if p != nil {
+		data.Support = p.cfg.Support
+		if p.cfg.HideReason {
+			data.Reason = ""
+		}
+	}
+
+	format := NegotiateFormat(r.Header.Get("Accept"))
+	body, err := renderBlockPage(p.template(r, path, format), format, data)
+	if err != nil {
+		log.Printf("Warning: %v, using the built-in block response", err)
+		body, _ = renderBlockPage(nil, format, data)
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept")
+	if dec.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(dec.RetryAfter))
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// renderBlockPage executes a template, or the built-in response when tmpl is nil
+func renderBlockPage(tmpl executor, format string, data BlockPageData) ([]byte, error) {
+	if tmpl == nil {
+		switch format {
+		case FormatHTML:
+			tmpl = defaultHTMLTemplate
+		case FormatText:
+			tmpl = defaultTextTemplate
+		default:
+			return defaultBlockJSON(data)
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render block page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// defaultBlockJSON is the built-in JSON block response
+func defaultBlockJSON(data BlockPageData) ([]byte, error) {
+	response := map[string]interface{}{
+		"error":   data.Error,
+		"message": data.Message,
+	}
+	if data.Reason != "" {
+		response["reason"] = data.Reason
+	}
+	if data.RetryAfter > 0 {
+		response["retry_after"] = data.RetryAfter
+	}
+	// The request ID lets operators look up the matched data in the WAF logs
+	if data.RequestID != "" {
+		response["request_id"] = data.RequestID
+	}
+	if data.Support != "" {
+		response["support"] = data.Support
+	}
+	b, err := json.Marshal(response)
+	return append(b, '\n'), err
+}
+
+// NegotiateFormat picks the block response format for an Accept header. JSON is
+// preferred when the client accepts any type, so API clients keep getting JSON.
+func NegotiateFormat(accept string) string {
+	best, bestQ, bestSpecificity := FormatJSON, 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		for _, format := range []string{FormatJSON, FormatHTML, FormatText} {
+			specificity := acceptMatch(mediaType, format)
+			if specificity < 0 {
+				continue
+			}
+			if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = format, q, specificity
+			}
+		}
+	}
+	return best
+}
+
+// acceptMatch reports how specifically a media range matches a format:
+// 2 for the exact type, 1 for type/*, 0 for */* and -1 for no match
+func acceptMatch(mediaRange, format string) int {
+	var types []string
+	switch format {
+	case FormatHTML:
+		types = []string{"text/html", "application/xhtml+xml"}
+	case FormatText:
+		types = []string{"text/plain"}
+	default:
+		types = []string{"application/json", "application/problem+json"}
+	}
+	if mediaRange == "*/*" {
+		return 0
+	}
+	for _, t := range types {
+		if mediaRange == t {
+			return 2
+		}
+		if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(t, strings.TrimSuffix(mediaRange, "*")) {
+			return 1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,307 @@
+package mitigation
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+)
+
+// maxChallengeDifficulty bounds the proof-of-work so that browsers can solve it
+const maxChallengeDifficulty = 32
+
+// challengePage is the interstitial served to challenged requests. Browsers with
+// JavaScript search for a nonce whose SHA-256 hash with the token starts with
+// the required number of zero bits; others are sent to the fallback after a delay.
+const challengePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Checking your browser</title>
+{{if .FallbackURL}}<noscript><meta http-equiv="refresh" content="{{.FallbackDelay}};url={{.FallbackURL}}"></noscript>{{end}}
+</head>
+<body>
+<h1>Checking your browser</h1>
+<p id="status">This check runs once and takes a moment.</p>
+<form id="challenge" method="POST" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="nonce" id="nonce">
+<input type="hidden" name="return" value="{{.Return}}">
+</form>
+<noscript><p>{{if .FallbackURL}}JavaScript is disabled, you will be redirected in {{.FallbackDelay}} seconds.{{else}}Please enable JavaScript to continue.{{end}}</p></noscript>
+<script>
+(async function () {
+  var token = {{.Token}}, difficulty = {{.Difficulty}}, fallback = {{.FallbackURL}};
+  if (!window.crypto || !crypto.subtle || !window.TextEncoder) {
+    if (fallback) {
+      setTimeout(function () { location.replace(fallback); }, {{.FallbackDelay}} * 1000);
+    } else {
+      document.getElementById("status").textContent = "Your browser cannot complete this check.";
+    }
+    return;
+  }
+  var encoder = new TextEncoder();
+  function zeroBits(hash) {
+    var n = 0;
+    for (var i = 0; i < hash.length; i++) {
+      if (hash[i] === 0) { n += 8; continue; }
+      for (var b = hash[i]; (b & 0x80) === 0; b <<= 1) { n++; }
+      break;
+    }
+    return n;
+  }
+  for (var nonce = 0; ; nonce++) {
+    var hash = await crypto.subtle.digest("SHA-256", encoder.encode(token + ":" + nonce));
+    if (zeroBits(new Uint8Array(hash)) >= difficulty) {
+      document.getElementById("nonce").value = nonce;
+      document.getElementById("challenge").submit();
+      return;
+    }
+  }
+})();
+</script>
+</body>
+</html>
+`
+
+var challengeTemplate = template.Must(template.New("challenge").Parse(challengePage))
+
+// challengePageData is the data rendered into the challenge page
+type challengePageData struct {
+	Action        string
+	Token         string
+	Return        string
+	Difficulty    int
+	FallbackURL   string
+	FallbackDelay int
+}
+
+// Challenge serves proof-of-work interstitials and issues the signed clearance
+// cookies that let clients who solved one through. Challenges and clearances are
+// bound to the client IP and User-Agent, and each challenge can be redeemed once.
+type Challenge struct {
+	cfg    config.ChallengeConfig
+	secret []byte
+
+	// redeemed holds the nonces of redeemed challenges until they expire
+	redeemed map[string]time.Time
+	pruned   time.Time
+	mu       sync.Mutex
+}
+
+// NewChallenge validates the challenge configuration. Without a configured
+// secret a random one is generated.
+func NewChallenge(cfg config.ChallengeConfig) (*Challenge, error) {
+	if cfg.Difficulty < 1 || cfg.Difficulty > maxChallengeDifficulty {
+		return nil, fmt.Errorf("challenge difficulty must be between 1 and %d, got %d", maxChallengeDifficulty, cfg.Difficulty)
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, fmt.Errorf("challenge path must start with /, got %q", cfg.Path)
+	}
+	c := &Challenge{cfg: cfg, secret: []byte(cfg.Secret), redeemed: make(map[string]time.Time)}
+	if cfg.Secret == "" {
+		c.secret = make([]byte, 32)
+		if _, err := rand.Read(c.secret); err != nil {
+			return nil, fmt.Errorf("failed to generate challenge secret: %w", err)
+		}
+		log.Printf("Warning: no challenge secret configured, clearances will not survive a restart")
+	}
+	return c, nil
+}
+
+// Path returns the path solutions are submitted to
+func (c *Challenge) Path() string {
+	return c.cfg.Path
+}
+
+// sign returns the MAC of the fields, bound to the client
+func (c *Challenge) sign(kind, value string, r *http.Request, clientIP string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", kind, value, clientIP, r.UserAgent())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Cleared reports whether the request carries a valid clearance cookie for the client
+func (c *Challenge) Cleared(r *http.Request, clientIP string) bool {
+	cookie, err := r.Cookie(c.cfg.CookieName)
+	if err != nil {
+		return false
+	}
+	expires, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(c.sign("clearance", expires, r, clientIP)))
+}
+
+// Write serves the challenge page for a decision
+func (c *Challenge) Write(w http.ResponseWriter, r *http.Request, clientIP string, dec decision.Decision) error {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	payload := fmt.Sprintf("%d.%d.%s", time.Now().Unix(), c.cfg.Difficulty, hex.EncodeToString(nonce))
+	token := payload + "." + c.sign("challenge", payload, r, clientIP)
+
+	data := challengePageData{
+		Action:     c.cfg.Path,
+		Token:      token,
+		Return:     r.URL.RequestURI(),
+		Difficulty: c.cfg.Difficulty,
+	}
+	if c.cfg.Fallback {
+		data.FallbackDelay = c.cfg.FallbackDelaySeconds
+		data.FallbackURL = c.cfg.Path + "?" + url.Values{
+			"fallback": {"1"},
+			"token":    {token},
+			"return":   {data.Return},
+		}.Encode()
+	}
+
+	var buf bytes.Buffer
+	if err := challengeTemplate.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render challenge page: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(StatusCode(dec))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Verify checks a submitted solution, or a fallback request made after the
+// fallback delay, and returns the clearance cookie and the path to return to.
+// A challenge that was already redeemed is rejected.
+func (c *Challenge) Verify(r *http.Request, clientIP string) (*http.Cookie, string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, "", fmt.Errorf("invalid challenge submission: %w", err)
+	}
+	token := r.Form.Get("token")
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return nil, "", errors.New("malformed challenge token")
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(c.sign("challenge", payload, r, clientIP))) {
+		return nil, "", errors.New("invalid challenge token")
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, "", errors.New("malformed challenge token")
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, "", errors.New("malformed challenge token")
+	}
+	now := time.Now()
+	age := now.Sub(time.Unix(issued, 0))
+	if age > time.Duration(c.cfg.ChallengeSeconds)*time.Second {
+		return nil, "", errors.New("challenge expired")
+	}
+
+	if r.Form.Get("fallback") != "" {
+		if !c.cfg.Fallback {
+			return nil, "", errors.New("challenge fallback is disabled")
+		}
+		if age < time.Duration(c.cfg.FallbackDelaySeconds)*time.Second {
+			return nil, "", errors.New("challenge fallback used before the delay")
+		}
+	} else {
+		nonce := r.Form.Get("nonce")
+		if nonce == "" || len(nonce) > 32 {
+			return nil, "", errors.New("missing challenge solution")
+		}
+		if leadingZeroBits(sha256.Sum256([]byte(token+":"+nonce))) < difficulty {
+			return nil, "", errors.New("incorrect challenge solution")
+		}
+	}
+	if !c.redeem(parts[2], time.Unix(issued, 0).Add(time.Duration(c.cfg.ChallengeSeconds)*time.Second)) {
+		return nil, "", errors.New("challenge already redeemed")
+	}
+
+	expires := strconv.FormatInt(now.Add(time.Duration(c.cfg.ClearanceSeconds)*time.Second).Unix(), 10)
+	cookie := &http.Cookie{
+		Name:     c.cfg.CookieName,
+		Value:    expires + "." + c.sign("clearance", expires, r, clientIP),
+		Path:     "/",
+		MaxAge:   c.cfg.ClearanceSeconds,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie, localPath(r.Form.Get("return")), nil
+}
+
+// redeem records a challenge nonce until the challenge expires and reports
+// whether it was not redeemed before
+func (c *Challenge) redeem(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.pruned) > time.Second {
+		for n, exp := range c.redeemed {
+			if now.After(exp) {
+				delete(c.redeemed, n)
+			}
+		}
+		c.pruned = now
+	}
+	if _, ok := c.redeemed[nonce]; ok {
+		return false
+	}
+	c.redeemed[nonce] = expires
+	return true
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// localPath returns path if it stays on this site, or "/" so that the
+// challenge cannot be used as an open redirect
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	// Browsers drop tabs and newlines from URLs, so "/\t/evil.com" would
+	// otherwise be followed as "//evil.com"
+	for i := 0; i < len(path); i++ {
+		if path[i] < 0x20 || path[i] == 0x7f {
+			return "/"
+		}
+	}
+	if u, err := url.Parse(path); err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,73 @@
+package mitigation
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// defaultErrorPage is served in place of upstream errors when no template is configured
+const defaultErrorPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.StatusText}}</title></head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p>The server could not complete your request. Please try again later.</p>
+{{if .RequestID}}<p>Request ID: <code>{{.RequestID}}</code></p>{{end}}
+</body>
+</html>
+`
+
+// ErrorPageData is the data available to error page templates
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	RequestID  string
+}
+
+// ErrorPage renders the WAF-owned page that replaces upstream error responses
+type ErrorPage struct {
+	tmpl *template.Template
+}
+
+// NewErrorPage parses the error page template at path; an empty path uses the
+// built-in page
+func NewErrorPage(path string) (*ErrorPage, error) {
+	var tmpl *template.Template
+	var err error
+	if path == "" {
+		tmpl, err = template.New("error_page").Parse(defaultErrorPage)
+	} else {
+		tmpl, err = template.ParseFiles(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse error page template: %w", err)
+	}
+	return &ErrorPage{tmpl: tmpl}, nil
+}
+
+// Render returns the page for a status and request ID
+func (p *ErrorPage) Render(status int, requestID string) ([]byte, error) {
+	var buf bytes.Buffer
+	data := ErrorPageData{Status: status, StatusText: http.StatusText(status), RequestID: requestID}
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render error page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Write sends the page with the given status
+func (p *ErrorPage) Write(w http.ResponseWriter, status int, requestID string) error {
+	page, err := p.Render(status, requestID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err = w.Write(page)
+	return err
+}
@@ -0,0 +1,126 @@
+package mitigation
+
+import (
+	"net/http"
+
+	"github.com/waf-draft/waf/internal/config"
+)
+
+// DefaultStripHeaders are the fingerprinting headers removed from responses
+// when no list is configured
+var DefaultStripHeaders = []string{
+	"Server",
+	"X-Powered-By",
+	"X-AspNet-Version",
+	"X-AspNetMvc-Version",
+	"X-Generator",
+}
+
+// HeaderPolicy adds security headers such as Strict-Transport-Security and
+// Content-Security-Policy to responses and strips headers that reveal the
+// upstream software. Routes can override the global policy.
+type HeaderPolicy struct {
+	global *headerRules
+	routes []headerRoute
+}
+
+// headerRoute is a route-specific policy; nil rules turn the policy off
+type headerRoute struct {
+	route *Route
+	rules *headerRules
+}
+
+// headerRules are the header changes applied to one response
+type headerRules struct {
+	set      map[string]string
+	defaults map[string]string
+	strip    []string
+}
+
+// NewHeaderPolicy compiles the security header configuration. Route settings
+// are merged over the global ones.
+func NewHeaderPolicy(cfg config.SecurityHeadersConfig) (*HeaderPolicy, error) {
+	strip := cfg.Strip
+	if strip == nil {
+		strip = DefaultStripHeaders
+	}
+	p := &HeaderPolicy{global: newHeaderRules(nil, cfg.Set, cfg.Defaults, strip)}
+
+	for _, rc := range cfg.Routes {
+		route, err := NewRoute(rc.Match)
+		if err != nil {
+			return nil, err
+		}
+		hr := headerRoute{route: route}
+		if !rc.Disabled {
+			hr.rules = newHeaderRules(p.global, rc.Set, rc.Defaults, rc.Strip)
+		}
+		p.routes = append(p.routes, hr)
+	}
+	return p, nil
+}
+
+// newHeaderRules merges header settings over base, canonicalizing the names
+func newHeaderRules(base *headerRules, set, defaults map[string]string, strip []string) *headerRules {
+	rules := &headerRules{set: make(map[string]string), defaults: make(map[string]string)}
+	if base != nil {
+		for name, value := range base.set {
+			rules.set[name] = value
+		}
+		for name, value := range base.defaults {
+			rules.defaults[name] = value
+		}
+		rules.strip = append(rules.strip, base.strip...)
+	}
+	for name, value := range set {
+		name = http.CanonicalHeaderKey(name)
+		delete(rules.defaults, name)
+		rules.set[name] = value
+	}
+	for name, value := range defaults {
+		name = http.CanonicalHeaderKey(name)
+		delete(rules.set, name)
+		rules.defaults[name] = value
+	}
+	for _, name := range strip {
+		rules.strip = append(rules.strip, http.CanonicalHeaderKey(name))
+	}
+	return rules
+}
+
+// Writer wraps w so that the policy for the request is applied to the response
+// just before it is written. The first matching route decides; the global
+// policy applies to requests no route matches.
+func (p *HeaderPolicy) Writer(w http.ResponseWriter, r *http.Request, path string) http.ResponseWriter {
+	rules := p.global
+	for _, hr := range p.routes {
+		if hr.route.Matches(r, path) {
+			rules = hr.rules
+			break
+		}
+	}
+	if rules == nil {
+		return w
+	}
+	return &HeaderWriter{ResponseWriter: w, apply: rules.apply}
+}
+
+// apply strips the listed headers, adds defaults the response lacks and sets the
+// overriding headers. An empty overriding value removes the header.
+func (rules *headerRules) apply(h http.Header) {
+	for _, name := range rules.strip {
+		h.Del(name)
+	}
+	for name, value := range rules.defaults {
+		if len(h.Values(name)) == 0 {
+			h.Set(name, value)
+		}
+	}
+	for name, value := range rules.set {
+		if value == "" {
+			h.Del(name)
+		} else {
+			h.Set(name, value)
+		}
+	}
+}
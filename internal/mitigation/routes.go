@@ -0,0 +1,52 @@
+package mitigation
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// Route selects requests by path and host for per-route response policies
+type Route struct {
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	hosts      []string
+}
+
+// NewRoute compiles a route match configuration. An empty configuration matches
+// every request.
+func NewRoute(cfg config.RouteMatchConfig) (*Route, error) {
+	route := &Route{pathPrefix: cfg.PathPrefix, hosts: cfg.Hosts}
+	if cfg.PathRegex != "" {
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path_regex %q: %w", cfg.PathRegex, err)
+		}
+		route.pathRegex = re
+	}
+	return route, nil
+}
+
+// Matches reports whether the request path and Host header match the route
+func (rt *Route) Matches(r *http.Request, path string) bool {
+	if rt.pathPrefix != "" && !strings.HasPrefix(path, rt.pathPrefix) {
+		return false
+	}
+	if rt.pathRegex != nil && !rt.pathRegex.MatchString(path) {
+		return false
+	}
+	if len(rt.hosts) > 0 {
+		host := normalize.Hostname(r.Host)
+		for _, h := range rt.hosts {
+			if strings.EqualFold(normalize.Hostname(h), host) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
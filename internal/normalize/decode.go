@@ -0,0 +1,166 @@
+package normalize
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxDecodeLayers bounds how many times a value is percent-decoded
+const MaxDecodeLayers = 4
+
+// Encoding anomaly names, reported with the protocol anomalies
+const (
+	AnomalyOverlongUTF8          = "overlong_utf8"
+	AnomalyUnicodeEncoding       = "unicode_percent_encoding"
+	AnomalyDecodeLimit           = "decode_limit_exceeded"
+	AnomalyInvalidNestedEncoding = "invalid_nested_encoding"
+)
+
+// decoded is the result of decoding a value until it stops changing
+type decoded struct {
+	value string
+	// layers counts the decoding passes that changed the value
+	layers int
+	// anomalies lists the encoding anomaly names found, once each
+	anomalies []string
+}
+
+// flag records an anomaly name once
+func (d *decoded) flag(name string) {
+	for _, a := range d.anomalies {
+		if a == name {
+			return
+		}
+	}
+	d.anomalies = append(d.anomalies, name)
+}
+
+// decode percent-decodes s repeatedly, up to MaxDecodeLayers passes. Each pass
+// also accepts IIS-style %uXXXX escapes and folds overlong UTF-8 sequences
+// such as %c0%ae into the character they encode. plusSpace decodes '+' as a
+// space in the first pass, as in query strings.
+func decode(s string, plusSpace bool) decoded {
+	d := decoded{value: s}
+	for d.layers < MaxDecodeLayers {
+		next, changed := d.decodeOnce(d.value, plusSpace && d.layers == 0)
+		if !changed {
+			return d
+		}
+		d.value = next
+		d.layers++
+	}
+	if _, changed := (&decoded{}).decodeOnce(d.value, false); changed {
+		d.flag(AnomalyDecodeLimit)
+	}
+	return d
+}
+
+// decodeOnce performs a single decoding pass. Malformed escapes are kept
+// literally; they are only flagged once a previous pass has produced them,
+// since the request target itself is checked by the protocol validation.
+func (d *decoded) decodeOnce(s string, plusSpace bool) (string, bool) {
+	if !strings.ContainsRune(s, '%') && !(plusSpace && strings.ContainsRune(s, '+')) {
+		return s, false
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	changed := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '+' && plusSpace:
+			b.WriteByte(' ')
+			changed = true
+		case c != '%':
+			b.WriteByte(c)
+		case i+5 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') && isHex(s[i+2]) && isHex(s[i+3]) && isHex(s[i+4]) && isHex(s[i+5]):
+			b.WriteRune(rune(unhex(s[i+2]))<<12 | rune(unhex(s[i+3]))<<8 | rune(unhex(s[i+4]))<<4 | rune(unhex(s[i+5])))
+			d.flag(AnomalyUnicodeEncoding)
+			i += 5
+			changed = true
+		case i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			changed = true
+		default:
+			if d.layers > 0 {
+				d.flag(AnomalyInvalidNestedEncoding)
+			}
+			b.WriteByte(c)
+		}
+	}
+	if !changed {
+		return s, false
+	}
+	return d.foldOverlong(b.String()), true
+}
+
+// foldOverlong replaces overlong two- and three-byte UTF-8 sequences with the
+// character they encode. Such sequences are invalid UTF-8 but some decoders
+// still accept them, e.g. %c0%ae as '.'.
+func (d *decoded) foldOverlong(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case (c == 0xc0 || c == 0xc1) && i+1 < len(s) && isContinuation(s[i+1]):
+			b.WriteByte((c&0x1f)<<6 | s[i+1]&0x3f)
+			d.flag(AnomalyOverlongUTF8)
+			i++
+		case c == 0xe0 && i+2 < len(s) && s[i+1] < 0xa0 && isContinuation(s[i+1]) && isContinuation(s[i+2]):
+			b.WriteRune(rune(s[i+1]&0x3f)<<6 | rune(s[i+2]&0x3f))
+			d.flag(AnomalyOverlongUTF8)
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isContinuation reports whether c is a UTF-8 continuation byte
+func isContinuation(c byte) bool {
+	return c&0xc0 == 0x80
+}
+
+// unhex returns the value of a hexadecimal digit
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// canonicalPath turns a decoded path into its canonical form: backslashes
+// become separators, path parameters (";jsessionid=...") are dropped from
+// each segment and dot segments and repeated slashes are resolved
+func canonicalPath(decodedPath string) string {
+	p := strings.ReplaceAll(decodedPath, "\\", "/")
+
+	if strings.ContainsRune(p, ';') {
+		segments := strings.Split(p, "/")
+		for i, segment := range segments {
+			if j := strings.IndexByte(segment, ';'); j >= 0 {
+				segments[i] = segment[:j]
+			}
+		}
+		p = strings.Join(segments, "/")
+	}
+
+	cleaned := path.Clean(p)
+	if !strings.HasPrefix(cleaned, "/") {
+		cleaned = "/" + cleaned
+	}
+	return cleaned
+}
@@ -0,0 +1,98 @@
+package normalize
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AnomalyDuplicateHeader is reported when a security-sensitive header is sent more than once
+const AnomalyDuplicateHeader = "duplicate_sensitive_header"
+
+// SensitiveHeaders are headers whose repetition lets the WAF and the upstream
+// disagree on the value in effect (lowercase names). Host and Content-Length
+// are covered by their own protocol anomalies.
+var SensitiveHeaders = []string{
+	"authorization",
+	"content-type",
+	"cookie",
+	"forwarded",
+	"origin",
+	"transfer-encoding",
+	"x-forwarded-for",
+	"x-forwarded-host",
+	"x-forwarded-proto",
+	"x-real-ip",
+}
+
+// readHeaders records every header value, the raw header order and the cookies
+func (n *NormalizedRequest) readHeaders(r *http.Request) {
+	for k, v := range r.Header {
+		if len(v) == 0 {
+			continue
+		}
+		name := strings.ToLower(k)
+		n.Headers[name] = v[0]
+		n.HeaderValues[name] = append([]string(nil), v...)
+	}
+
+	if head := RawHead(r); head != nil {
+		n.HeaderOrder = headerOrder(head)
+	}
+
+	for _, line := range n.HeaderValues["cookie"] {
+		n.parseCookies(line)
+	}
+
+	for _, name := range SensitiveHeaders {
+		if len(n.HeaderValues[name]) > 1 {
+			n.anomaly(AnomalyDuplicateHeader, name)
+		}
+	}
+}
+
+// headerOrder returns the header names of a raw request head in the order
+// and casing they were sent
+func headerOrder(head []byte) []string {
+	lines := strings.Split(string(head), "\n")
+	names := make([]string, 0, len(lines))
+	for _, line := range lines[1:] {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseCookies adds the name=value pairs of a Cookie header. Unlike
+// http.Request.Cookies, pairs with invalid names or values are kept.
+func (n *NormalizedRequest) parseCookies(line string) {
+	for _, pair := range strings.Split(line, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		n.Cookies[name] = append(n.Cookies[name], value)
+	}
+}
+
+// GetHeaderValues returns all values of a header in the order they were sent
+func (n *NormalizedRequest) GetHeaderValues(key string) []string {
+	return n.HeaderValues[strings.ToLower(key)]
+}
+
+// GetCookie returns a cookie value (first if multiple)
+func (n *NormalizedRequest) GetCookie(name string) string {
+	if values := n.Cookies[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
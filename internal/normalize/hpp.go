@@ -0,0 +1,94 @@
+package normalize
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Parameter pollution anomaly names
+const (
+	AnomalyRepeatedParameter     = "parameter_repeated"
+	AnomalyParameterQueryAndBody = "parameter_in_query_and_body"
+)
+
+// Combination semantics for repeated parameters, matching how frameworks
+// resolve them: first (Java servlets, Flask), last (PHP, Express with
+// plain names) and join (ASP.NET, comma-separated)
+const (
+	CombineFirst = "first"
+	CombineLast  = "last"
+	CombineJoin  = "join"
+)
+
+// CombineValues resolves the occurrences of a parameter into the single value
+// a framework would see
+func CombineValues(values []string, mode string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	switch mode {
+	case CombineFirst:
+		return values[0], nil
+	case CombineLast:
+		return values[len(values)-1], nil
+	case CombineJoin:
+		return strings.Join(values, ","), nil
+	default:
+		return "", fmt.Errorf("unknown combine mode: %s", mode)
+	}
+}
+
+// ValidCombine reports whether mode is a known combine mode
+func ValidCombine(mode string) bool {
+	_, err := CombineValues([]string{""}, mode)
+	return err == nil
+}
+
+// Args returns every occurrence of each parameter, query values before body values
+func (n *NormalizedRequest) Args() map[string][]string {
+	if len(n.BodyArgs) == 0 {
+		return n.Query
+	}
+	args := make(map[string][]string, len(n.Query)+len(n.BodyArgs))
+	for k, v := range n.Query {
+		args[k] = append(args[k], v...)
+	}
+	for k, v := range n.BodyArgs {
+		args[k] = append(args[k], v...)
+	}
+	return args
+}
+
+// checkParameterPollution records parameters sent more than once. Names
+// ending in "[]" are array parameters and only reported when they appear in
+// both the query and the body.
+func (n *NormalizedRequest) checkParameterPollution() {
+	names := make([]string, 0, len(n.Query)+len(n.BodyArgs))
+	for k := range n.Query {
+		names = append(names, k)
+	}
+	for k := range n.BodyArgs {
+		if _, ok := n.Query[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		inQuery, inBody := len(n.Query[name]), len(n.BodyArgs[name])
+		if inQuery+inBody < 2 {
+			continue
+		}
+		if n.RepeatedArgs == nil {
+			n.RepeatedArgs = make(map[string]int)
+		}
+		n.RepeatedArgs[name] = inQuery + inBody
+
+		if inQuery > 0 && inBody > 0 {
+			n.anomaly(AnomalyParameterQueryAndBody, name)
+		} else if !strings.HasSuffix(name, "[]") {
+			n.anomaly(AnomalyRepeatedParameter, name)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package normalize
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Limit names reported in violations
+const (
+	LimitURLLength         = "max_url_length"
+	LimitHeaderCount       = "max_header_count"
+	LimitHeaderSize        = "max_header_size"
+	LimitArgCount          = "max_arg_count"
+	LimitArgNameLength     = "max_arg_name_length"
+	LimitArgValueLength    = "max_arg_value_length"
+	LimitBodySize          = "max_body_size"
+	LimitMultipartBodySize = "max_multipart_body_size"
+	LimitFileSize          = "max_file_size"
+)
+
+// Limits bounds the size and shape of a request. Zero disables a limit.
+type Limits struct {
+	MaxURLLength int
+	// MaxHeaderCount counts header lines; MaxHeaderSize applies to each name plus value
+	MaxHeaderCount int
+	MaxHeaderSize  int
+	// Argument limits apply to query and URL-encoded body arguments
+	MaxArgCount       int
+	MaxArgNameLength  int
+	MaxArgValueLength int
+	// MaxBodySize applies to non-multipart bodies, MaxMultipartBodySize to
+	// multipart bodies and MaxFileSize to each uploaded file
+	MaxBodySize          int64
+	MaxMultipartBodySize int64
+	MaxFileSize          int64
+	// InspectOversized still reads and inspects the first bytes of a body that
+	// declares a length over the limit. Set it when violations only add to the
+	// anomaly score, so that the request is not let through uninspected.
+	InspectOversized bool
+}
+
+// Violation records a limit exceeded by a request
+type Violation struct {
+	Limit  string `json:"limit"`
+	Actual int64  `json:"actual"`
+	Max    int64  `json:"max"`
+	// Status is the response status for rejecting the request
+	Status int `json:"status"`
+}
+
+// String describes the violation, e.g. "max_header_count (42 > 32)"
+func (v Violation) String() string {
+	return fmt.Sprintf("%s (%d > %d)", v.Limit, v.Actual, v.Max)
+}
+
+// check records a violation if actual exceeds a non-zero max
+func (n *NormalizedRequest) check(limit string, actual, max int64, status int) {
+	if max > 0 && actual > max {
+		n.Violations = append(n.Violations, Violation{Limit: limit, Actual: actual, Max: max, Status: status})
+	}
+}
+
+// checkRequestLine applies the URL and header limits
+func (n *NormalizedRequest) checkRequestLine(r *http.Request, limits Limits) {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	n.check(LimitURLLength, int64(len(uri)), int64(limits.MaxURLLength), http.StatusRequestURITooLong)
+
+	count, largest := 0, 0
+	for name, values := range r.Header {
+		count += len(values)
+		for _, v := range values {
+			if size := len(name) + len(v); size > largest {
+				largest = size
+			}
+		}
+	}
+	n.check(LimitHeaderCount, int64(count), int64(limits.MaxHeaderCount), http.StatusRequestHeaderFieldsTooLarge)
+	n.check(LimitHeaderSize, int64(largest), int64(limits.MaxHeaderSize), http.StatusRequestHeaderFieldsTooLarge)
+}
+
+// checkArgs applies the argument limits to a set of arguments
+func (n *NormalizedRequest) checkArgs(args url.Values, limits Limits, status int) {
+	count, longestName, longestValue := 0, 0, 0
+	for name, values := range args {
+		count += len(values)
+		if len(name) > longestName {
+			longestName = len(name)
+		}
+		for _, v := range values {
+			if len(v) > longestValue {
+				longestValue = len(v)
+			}
+		}
+	}
+	n.check(LimitArgCount, int64(count), int64(limits.MaxArgCount), status)
+	n.check(LimitArgNameLength, int64(longestName), int64(limits.MaxArgNameLength), status)
+	n.check(LimitArgValueLength, int64(longestValue), int64(limits.MaxArgValueLength), status)
+}
+
+// bodyLimit returns the body size limit that applies to a request
+func bodyLimit(r *http.Request, limits Limits) (string, int64) {
+	if isMultipart(r) {
+		return LimitMultipartBodySize, limits.MaxMultipartBodySize
+	}
+	return LimitBodySize, limits.MaxBodySize
+}
+
+// BodyLimit returns the body size limit that applies to a request (0 for none)
+func BodyLimit(r *http.Request, limits Limits) int64 {
+	_, max := bodyLimit(r, limits)
+	return max
+}
+
+// readBody reads at most the body limit (plus one byte to detect overflow) and
+// restores the full body for the upstream
+func (n *NormalizedRequest) readBody(r *http.Request, limits Limits) error {
+	limit, max := bodyLimit(r, limits)
+	declaredOver := max > 0 && r.ContentLength > max
+	if declaredOver {
+		n.check(limit, r.ContentLength, max, http.StatusRequestEntityTooLarge)
+		if !limits.InspectOversized {
+			return nil
+		}
+	}
+
+	reader := io.Reader(r.Body)
+	if max > 0 {
+		reader = io.LimitReader(r.Body, max+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	// Restore body for downstream processing, including anything not read
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+
+	if max > 0 && int64(len(data)) > max {
+		if !declaredOver {
+			n.check(limit, int64(len(data)), max, http.StatusRequestEntityTooLarge)
+		}
+		data = data[:max]
+	}
+	n.Body = string(data)
+
+	if isMultipart(r) {
+		n.checkFiles(r, data, limits)
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if args, err := url.ParseQuery(n.Body); err == nil {
+			n.checkArgs(args, limits, http.StatusRequestEntityTooLarge)
+		}
+		n.BodyArgs = n.parseArgs(n.Body)
+	}
+	return nil
+}
+
+// checkFiles applies MaxFileSize to each uploaded file of a multipart body
+func (n *NormalizedRequest) checkFiles(r *http.Request, body []byte, limits Limits) {
+	if limits.MaxFileSize <= 0 {
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var largest int64
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			size, _ := io.Copy(io.Discard, io.LimitReader(part, limits.MaxFileSize+1))
+			if size > largest {
+				largest = size
+			}
+		}
+		part.Close()
+	}
+	n.check(LimitFileSize, largest, limits.MaxFileSize, http.StatusRequestEntityTooLarge)
+}
+
+// isMultipart reports whether the request body is multipart
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "multipart/")
+}
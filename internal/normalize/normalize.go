@@ -2,107 +2,227 @@ package normalize
 
 import (
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"net/url"
-	"path"
+	"net/netip"
 	"strings"
 )
 
 // NormalizedRequest represents a normalized HTTP request
 type NormalizedRequest struct {
-	Path        string
+	Path         string
 	OriginalPath string // Original path before normalization (for detection)
-	Query       map[string][]string
-	Body        string
-	Method      string
-	Headers     map[string]string
+	// DecodedPath is the fully decoded path before separators and dot segments are resolved
+	DecodedPath string
+	// DecodeLayers is the largest number of decoding passes any path or parameter needed
+	DecodeLayers int
+	Query        map[string][]string
+	// BodyArgs holds the URL-encoded body parameters when the body is read
+	BodyArgs map[string][]string
+	// RepeatedArgs counts the occurrences of each parameter sent more than
+	// once across the query and body
+	RepeatedArgs map[string]int
+	Body         string
+	Method       string
+	Headers      map[string]string
+	ClientIP     string
+	// HeaderValues holds every value of each header (lowercase names) in order
+	HeaderValues map[string][]string
+	// HeaderOrder lists header names in the order and casing they were sent.
+	// It is only known when the raw request head was recorded.
+	HeaderOrder []string
+	// Cookies holds every value of each cookie, from all Cookie headers
+	Cookies map[string][]string
+	// Country, ASN and ASOrganization are filled in by GeoIP enrichment when enabled
+	Country        string
+	ASN            uint
+	ASOrganization string
+	// Violations lists the size and shape limits the request exceeded
+	Violations []Violation
+	// ProtocolAnomalies lists the HTTP protocol violations found in the request
+	ProtocolAnomalies []ProtocolAnomaly
 }
 
 // Request normalizes an HTTP request
 func Request(r *http.Request, logBody bool) (*NormalizedRequest, error) {
+	return RequestWithLimits(r, logBody, Limits{})
+}
+
+// RequestWithLimits normalizes an HTTP request, recording the limits it exceeds
+// in Violations and protocol violations in ProtocolAnomalies. At most the body
+// size limit is read from the body.
+func RequestWithLimits(r *http.Request, logBody bool, limits Limits) (*NormalizedRequest, error) {
 	norm := &NormalizedRequest{
-		Method:  r.Method,
-		Headers: make(map[string]string),
-		Query:   make(map[string][]string),
+		Method:       r.Method,
+		Headers:      make(map[string]string),
+		HeaderValues: make(map[string][]string),
+		Cookies:      make(map[string][]string),
+		Query:        make(map[string][]string),
 	}
 
+	norm.ClientIP = ClientIP(r)
+
 	// Store original path for detection
 	norm.OriginalPath = r.URL.Path
 	// Normalize path
-	norm.Path = normalizePath(r.URL.Path)
+	norm.DecodedPath, norm.Path = norm.normalizePath(r.URL.EscapedPath())
 
 	// Normalize query parameters
-	norm.Query = normalizeQuery(r.URL.Query())
+	rawQuery := r.URL.Query()
+	norm.Query = norm.parseArgs(r.URL.RawQuery)
 
-	// Normalize headers (lowercase keys)
-	for k, v := range r.Header {
-		if len(v) > 0 {
-			norm.Headers[strings.ToLower(k)] = v[0]
-		}
-	}
+	norm.checkRequestLine(r, limits)
+	norm.checkArgs(rawQuery, limits, http.StatusRequestURITooLong)
+	norm.checkProtocol(r)
+
+	// Normalize headers (lowercase keys) and parse cookies
+	norm.readHeaders(r)
 
 	// Read body if enabled
 	if logBody && r.Body != nil {
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read request body: %w", err)
+		if err := norm.readBody(r, limits); err != nil {
+			return nil, err
 		}
-		// Restore body for downstream processing
-		r.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
-		norm.Body = string(bodyBytes)
+	} else if limit, max := bodyLimit(r, limits); max > 0 {
+		// The body is streamed to the upstream; the declared length can still be checked
+		norm.check(limit, r.ContentLength, max, http.StatusRequestEntityTooLarge)
 	}
 
+	norm.checkParameterPollution()
+
 	return norm, nil
 }
 
-// normalizePath cleans and normalizes the URL path
-func normalizePath(rawPath string) string {
-	// URL decode
-	decoded, err := url.PathUnescape(rawPath)
-	if err != nil {
-		// If decoding fails, use original
-		decoded = rawPath
+// Hostname returns a Host header value without its port, and IPv6 literals
+// without their brackets ("[::1]:8080" becomes "::1")
+func Hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
 	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// ClientIP returns the socket peer address of a request. Forwarding headers are
+// set by the client and ignored; behind a proxy use TrustedProxies.ClientIP.
+func ClientIP(r *http.Request) string {
+	return TrustedProxies(nil).ClientIP(r)
+}
 
-	// Clean path (collapse multiple slashes, resolve . and ..)
-	cleaned := path.Clean(decoded)
+// TrustedProxies are the networks of the proxies whose X-Forwarded-For and
+// X-Real-IP headers are believed
+type TrustedProxies []netip.Prefix
 
-	// Ensure it starts with /
-	if !strings.HasPrefix(cleaned, "/") {
-		cleaned = "/" + cleaned
+// ParseTrustedProxies parses CIDRs and single addresses
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, prefix.Masked())
 	}
+	return proxies, nil
+}
 
-	return cleaned
+// contains reports whether addr belongs to a trusted proxy
+func (t TrustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
-// normalizeQuery normalizes query parameters
-func normalizeQuery(rawQuery url.Values) map[string][]string {
-	normalized := make(map[string][]string)
+// ClientIP returns the client address for a request. Forwarding headers are
+// only honoured when the socket peer is a trusted proxy; the client is then the
+// rightmost X-Forwarded-For hop that is not a trusted proxy, or X-Real-IP.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !t.contains(addr) {
+		return peer
+	}
 
-	for key, values := range rawQuery {
-		// URL decode key
-		decodedKey, err := url.QueryUnescape(key)
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) == 0 {
+		if xri, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return xri.Unmap().String()
+		}
+		return peer
+	}
+	client := addr.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hops[i])
 		if err != nil {
-			decodedKey = key
+			// Hops beyond a malformed entry cannot be attributed
+			break
 		}
-
-		// URL decode values
-		decodedValues := make([]string, len(values))
-		for i, v := range values {
-			decoded, err := url.QueryUnescape(v)
-			if err != nil {
-				decoded = v
-			}
-			decodedValues[i] = decoded
+		client = hop.Unmap()
+		if !t.contains(hop) {
+			break
 		}
+	}
+	return client.String()
+}
 
-		normalized[decodedKey] = decodedValues
+// normalizePath decodes an escaped path and returns it both decoded and in
+// canonical form
+func (n *NormalizedRequest) normalizePath(escapedPath string) (string, string) {
+	d := n.decode(escapedPath, false)
+	return d.value, canonicalPath(d.value)
+}
+
+// parseArgs splits a raw query string or URL-encoded body and decodes each
+// name and value
+func (n *NormalizedRequest) parseArgs(rawQuery string) map[string][]string {
+	normalized := make(map[string][]string)
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		decodedKey := n.decode(key, true).value
+		normalized[decodedKey] = append(normalized[decodedKey], n.decode(value, true).value)
 	}
 
 	return normalized
 }
 
+// decode decodes a value, recording the layers needed and any encoding anomalies
+func (n *NormalizedRequest) decode(s string, plusSpace bool) decoded {
+	d := decode(s, plusSpace)
+	if d.layers > n.DecodeLayers {
+		n.DecodeLayers = d.layers
+	}
+	for _, name := range d.anomalies {
+		n.anomaly(name, s)
+	}
+	return d
+}
+
 // GetQueryString returns a single query parameter value (first if multiple)
 func (n *NormalizedRequest) GetQueryString(key string) string {
 	if values, ok := n.Query[key]; ok && len(values) > 0 {
@@ -119,8 +239,7 @@ func (n *NormalizedRequest) GetQueryValues(key string) []string {
 	return nil
 }
 
-// GetHeader returns a header value
+// GetHeader returns a header value (first if multiple)
 func (n *NormalizedRequest) GetHeader(key string) string {
 	return n.Headers[strings.ToLower(key)]
 }
-
@@ -0,0 +1,235 @@
+package normalize
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Protocol anomaly names, exposed to rules through the "protocol" target
+const (
+	AnomalyContentLengthWithTE = "content_length_with_transfer_encoding"
+	AnomalyDuplicateLength     = "duplicate_content_length"
+	AnomalyInvalidLength       = "invalid_content_length"
+	AnomalyUnsupportedEncoding = "unsupported_transfer_encoding"
+	AnomalyInvalidHeaderName   = "invalid_header_name"
+	AnomalyInvalidHeaderValue  = "invalid_header_value"
+	AnomalyObsoleteLineFolding = "obsolete_line_folding"
+	AnomalyNonStandardMethod   = "non_standard_method"
+	AnomalyMissingHost         = "missing_host"
+	AnomalyMultipleHost        = "multiple_host"
+	AnomalyHostMismatch        = "host_mismatch"
+	AnomalyInvalidURLEncoding  = "invalid_percent_encoding"
+)
+
+// standardMethods are the methods defined by RFC 9110 and RFC 5789
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPatch:   true,
+}
+
+// ProtocolAnomaly records an HTTP protocol violation found in a request
+type ProtocolAnomaly struct {
+	Name string `json:"name"`
+	// Detail holds the offending header, value or URI
+	Detail string `json:"detail"`
+}
+
+type rawHeadKey struct{}
+
+// WithRawHead returns a context carrying the request line and headers as
+// received on the wire
+func WithRawHead(ctx context.Context, head []byte) context.Context {
+	return context.WithValue(ctx, rawHeadKey{}, head)
+}
+
+// RawHead returns the raw request head attached to a request, if any
+func RawHead(r *http.Request) []byte {
+	head, _ := r.Context().Value(rawHeadKey{}).([]byte)
+	return head
+}
+
+// rawField is a header line from the raw request head
+type rawField struct {
+	name, value string
+}
+
+// checkProtocol records protocol anomalies. The raw request head is used when
+// available since net/http folds, merges and drops some headers while parsing.
+func (n *NormalizedRequest) checkProtocol(r *http.Request) {
+	if !standardMethods[r.Method] {
+		n.anomaly(AnomalyNonStandardMethod, r.Method)
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	if invalidPercentEncoding(uri) {
+		n.anomaly(AnomalyInvalidURLEncoding, uri)
+	}
+
+	if head := RawHead(r); head != nil {
+		n.checkRawHead(r, head)
+		return
+	}
+
+	// Without the raw head only what survived parsing can be checked
+	var fields []rawField
+	for name, values := range r.Header {
+		for _, v := range values {
+			fields = append(fields, rawField{name: name, value: v})
+		}
+	}
+	if r.Host != "" {
+		fields = append(fields, rawField{name: "Host", value: r.Host})
+	}
+	if len(r.TransferEncoding) > 0 {
+		fields = append(fields, rawField{name: "Transfer-Encoding", value: strings.Join(r.TransferEncoding, ", ")})
+	}
+	n.checkFields(r, fields)
+}
+
+// checkRawHead parses the header lines of a raw request head
+func (n *NormalizedRequest) checkRawHead(r *http.Request, head []byte) {
+	lines := strings.Split(strings.TrimRight(string(head), "\r\n"), "\n")
+	var fields []rawField
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			n.anomaly(AnomalyObsoleteLineFolding, strings.TrimSpace(line))
+			if len(fields) > 0 {
+				fields[len(fields)-1].value += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			n.anomaly(AnomalyInvalidHeaderName, line)
+			continue
+		}
+		fields = append(fields, rawField{name: name, value: strings.Trim(value, " \t")})
+	}
+	n.checkFields(r, fields)
+
+	// Absolute-form request targets must agree with the Host header
+	if target, err := url.Parse(r.RequestURI); err == nil && target.IsAbs() {
+		for _, f := range fields {
+			if strings.EqualFold(f.name, "Host") && !strings.EqualFold(f.value, target.Host) {
+				n.anomaly(AnomalyHostMismatch, target.Host+" != "+f.value)
+			}
+		}
+	}
+}
+
+// checkFields validates header names and values and the message framing headers
+func (n *NormalizedRequest) checkFields(r *http.Request, fields []rawField) {
+	var lengths, encodings, hosts []string
+	for _, f := range fields {
+		if !validHeaderName(f.name) {
+			n.anomaly(AnomalyInvalidHeaderName, strconv.Quote(f.name))
+			continue
+		}
+		if !validHeaderValue(f.value) {
+			n.anomaly(AnomalyInvalidHeaderValue, f.name+": "+strconv.Quote(f.value))
+		}
+		switch {
+		case strings.EqualFold(f.name, "Content-Length"):
+			lengths = append(lengths, f.value)
+		case strings.EqualFold(f.name, "Transfer-Encoding"):
+			encodings = append(encodings, f.value)
+		case strings.EqualFold(f.name, "Host"):
+			hosts = append(hosts, f.value)
+		}
+	}
+
+	if len(lengths) > 1 {
+		n.anomaly(AnomalyDuplicateLength, strings.Join(lengths, ", "))
+	}
+	for _, l := range lengths {
+		if _, err := strconv.ParseUint(l, 10, 63); err != nil {
+			n.anomaly(AnomalyInvalidLength, strconv.Quote(l))
+		}
+	}
+	if len(encodings) > 0 {
+		if len(lengths) > 0 {
+			n.anomaly(AnomalyContentLengthWithTE, "Content-Length: "+lengths[0]+", Transfer-Encoding: "+encodings[0])
+		}
+		if te := strings.Join(encodings, ","); !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			n.anomaly(AnomalyUnsupportedEncoding, strconv.Quote(te))
+		}
+	}
+
+	switch {
+	case len(hosts) > 1:
+		n.anomaly(AnomalyMultipleHost, strings.Join(hosts, ", "))
+	case (len(hosts) == 0 || hosts[0] == "") && r.ProtoAtLeast(1, 1):
+		n.anomaly(AnomalyMissingHost, r.Proto)
+	}
+}
+
+// anomaly records a protocol anomaly
+func (n *NormalizedRequest) anomaly(name, detail string) {
+	if detail == "" {
+		detail = name
+	}
+	n.ProtocolAnomalies = append(n.ProtocolAnomalies, ProtocolAnomaly{Name: name, Detail: detail})
+}
+
+// validHeaderName reports whether name is a non-empty RFC 9110 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue rejects control characters other than horizontal tab
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidPercentEncoding reports a '%' not followed by two hex digits
+func invalidPercentEncoding(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return true
+		}
+		i += 2
+	}
+	return false
+}
+
+// isHex reports whether c is a hexadecimal digit
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,322 @@
+package normalize
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Transformation names usable in rule conditions and the Unicode pre-pass
+const (
+	TransformCompat      = "compat"
+	TransformCaseFold    = "case_fold"
+	TransformConfusables = "confusables"
+	// TransformUnicode applies compat, confusables and case_fold in that order
+	TransformUnicode = "unicode"
+)
+
+// Transform applies a named transformation to s
+func Transform(name, s string) (string, error) {
+	switch name {
+	case TransformCompat:
+		return FoldCompatibility(s), nil
+	case TransformCaseFold:
+		return FoldCase(s), nil
+	case TransformConfusables:
+		return FoldConfusables(s), nil
+	case TransformUnicode:
+		return FoldCase(FoldConfusables(FoldCompatibility(s))), nil
+	default:
+		return s, fmt.Errorf("unknown transformation: %s", name)
+	}
+}
+
+// ValidTransform reports whether name is a known transformation
+func ValidTransform(name string) bool {
+	_, err := Transform(name, "")
+	return err == nil
+}
+
+// FoldCompatibility applies the subset of the Unicode compatibility (NFKC)
+// mappings used to smuggle ASCII past filters: full-width and small form
+// variants, mathematical and enclosed alphanumerics, letterlike symbols,
+// super- and subscripts, ligatures and spacing characters. It is not full NFKC:
+// other compatibility characters are left alone and combining sequences are
+// not composed; FoldConfusables strips combining marks instead.
+func FoldCompatibility(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if m, ok := compatibility(r); ok {
+			b.WriteString(m)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// compatibility returns the compatibility decomposition of r, if it has one
+func compatibility(r rune) (string, bool) {
+	switch {
+	case r >= 0xff01 && r <= 0xff5e:
+		// Full-width ASCII
+		return string(r - 0xfee0), true
+	case r >= 0x1d400 && r < 0x1d6a4:
+		// Mathematical alphanumeric letters: 13 styles of A-Z followed by a-z
+		off := (r - 0x1d400) % 52
+		if off < 26 {
+			return string('A' + off), true
+		}
+		return string('a' + off - 26), true
+	case r >= 0x1d7ce && r <= 0x1d7ff:
+		// Mathematical digits: 5 styles of 0-9
+		return string('0' + (r-0x1d7ce)%10), true
+	case r >= 0x24b6 && r <= 0x24cf:
+		return string('A' + r - 0x24b6), true
+	case r >= 0x24d0 && r <= 0x24e9:
+		return string('a' + r - 0x24d0), true
+	case r >= 0x2460 && r <= 0x2473:
+		// Circled numbers one to twenty
+		return fmt.Sprint(r - 0x2460 + 1), true
+	case r >= 0x2074 && r <= 0x2079:
+		return string('0' + r - 0x2070), true
+	case r >= 0x2080 && r <= 0x2089:
+		return string('0' + r - 0x2080), true
+	case r >= 0x2000 && r <= 0x200a:
+		return " ", true
+	}
+	m, ok := compatibilityMap[r]
+	return m, ok
+}
+
+// compatibilityMap holds the compatibility decompositions not covered by the
+// ranges in compatibility
+var compatibilityMap = map[rune]string{
+	// Spaces
+	0x00a0: " ", 0x202f: " ", 0x205f: " ", 0x3000: " ",
+	// Small form variants
+	0xfe50: ",", 0xfe52: ".", 0xfe54: ";", 0xfe55: ":", 0xfe56: "?", 0xfe57: "!",
+	0xfe59: "(", 0xfe5a: ")", 0xfe5b: "{", 0xfe5c: "}", 0xfe5f: "#", 0xfe60: "&",
+	0xfe61: "*", 0xfe62: "+", 0xfe63: "-", 0xfe64: "<", 0xfe65: ">", 0xfe66: "=",
+	0xfe68: "\\", 0xfe69: "$", 0xfe6a: "%", 0xfe6b: "@",
+	// Super- and subscripts
+	0x00aa: "a", 0x00b2: "2", 0x00b3: "3", 0x00b9: "1", 0x00ba: "o",
+	0x2070: "0", 0x2071: "i", 0x207a: "+", 0x207c: "=", 0x207d: "(", 0x207e: ")", 0x207f: "n",
+	0x208a: "+", 0x208c: "=", 0x208d: "(", 0x208e: ")",
+	// Enclosed alphanumerics
+	0x24ea: "0",
+	// Letterlike symbols
+	0x2102: "C", 0x210a: "g", 0x210b: "H", 0x210c: "H", 0x210d: "H", 0x210e: "h",
+	0x2110: "I", 0x2111: "I", 0x2112: "L", 0x2113: "l", 0x2115: "N", 0x2116: "No",
+	0x2119: "P", 0x211a: "Q", 0x211b: "R", 0x211c: "R", 0x211d: "R", 0x2122: "TM",
+	0x2124: "Z", 0x212a: "K", 0x212c: "B", 0x212d: "C", 0x212f: "e", 0x2130: "E",
+	0x2131: "F", 0x2133: "M", 0x2134: "o", 0x2139: "i", 0x2145: "D", 0x2146: "d",
+	0x2147: "e", 0x2148: "i", 0x2149: "j",
+	// Roman numerals
+	0x2160: "I", 0x2161: "II", 0x2162: "III", 0x2163: "IV", 0x2164: "V", 0x2165: "VI",
+	0x2166: "VII", 0x2167: "VIII", 0x2168: "IX", 0x2169: "X", 0x216a: "XI", 0x216b: "XII",
+	0x216c: "L", 0x216d: "C", 0x216e: "D", 0x216f: "M",
+	0x2170: "i", 0x2171: "ii", 0x2172: "iii", 0x2173: "iv", 0x2174: "v", 0x2175: "vi",
+	0x2176: "vii", 0x2177: "viii", 0x2178: "ix", 0x2179: "x", 0x217a: "xi", 0x217b: "xii",
+	0x217c: "l", 0x217d: "c", 0x217e: "d", 0x217f: "m",
+	// Ligatures and letters with compatibility forms
+	0x0132: "IJ", 0x0133: "ij", 0x017f: "s", 0x00b5: "μ",
+	0xfb00: "ff", 0xfb01: "fi", 0xfb02: "fl", 0xfb03: "ffi", 0xfb04: "ffl", 0xfb05: "st", 0xfb06: "st",
+	// Leaders and Greek punctuation
+	0x2024: ".", 0x2025: "..", 0x2026: "...", 0x037e: ";", 0x0387: "·",
+}
+
+// FoldCase applies Unicode case folding, including the full foldings that
+// expand to several characters such as "ß" to "ss"
+func FoldCase(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch r {
+		case 'ß', 'ẞ':
+			b.WriteString("ss")
+		case 'ſ':
+			b.WriteByte('s')
+		case 'ς':
+			b.WriteRune('σ')
+		case 'İ':
+			b.WriteByte('i')
+		default:
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// FoldConfusables maps characters that look like ASCII letters and
+// punctuation to the ASCII character, and drops combining marks and
+// invisible formatting characters
+func FoldConfusables(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if m, ok := confusables[r]; ok {
+			b.WriteRune(m)
+			continue
+		}
+		if unicode.Is(unicode.Mn, r) || invisible(r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// invisible reports default-ignorable characters used to split keywords
+func invisible(r rune) bool {
+	switch {
+	case r == 0x00ad, r == 0x034f, r == 0x180e, r == 0xfeff:
+		return true
+	case r >= 0x200b && r <= 0x200f, r >= 0x202a && r <= 0x202e, r >= 0x2060 && r <= 0x2064:
+		return true
+	}
+	return false
+}
+
+// confusables maps look-alike characters to ASCII, after the Unicode
+// confusables data for the characters seen in filter bypasses
+var confusables = map[rune]rune{
+	// Cyrillic
+	'А': 'A', 'В': 'B', 'С': 'C', 'Е': 'E', 'Н': 'H', 'І': 'I', 'Ј': 'J', 'К': 'K',
+	'М': 'M', 'О': 'O', 'Р': 'P', 'Ѕ': 'S', 'Т': 'T', 'Х': 'X', 'У': 'Y',
+	'а': 'a', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'о': 'o',
+	'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'ԝ': 'w', 'х': 'x', 'у': 'y',
+	// Greek
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	'α': 'a', 'ι': 'i', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'υ': 'u',
+	// Latin
+	'ı': 'i', 'ȷ': 'j', 'ɑ': 'a', 'ɡ': 'g', 'ɩ': 'i', 'ʏ': 'y',
+	// Quotes
+	'‘': '\'', '’': '\'', '‚': '\'', '‛': '\'', '′': '\'', 'ʹ': '\'', 'ʼ': '\'', 'ˈ': '\'',
+	'“': '"', '”': '"', '„': '"', '″': '"', '˝': '"',
+	// Brackets
+	'‹': '<', '˂': '<', 'ᐸ': '<', '〈': '<', '⟨': '<',
+	'›': '>', '˃': '>', 'ᐳ': '>', '〉': '>', '⟩': '>',
+	// Slashes, dashes and other punctuation
+	'⁄': '/', '∕': '/', '⧸': '/',
+	'∖': '\\', '⧵': '\\', '⧹': '\\',
+	'‐': '-', '‑': '-', '‒': '-', '–': '-', '—': '-', '−': '-',
+	'ǀ': '|', '∣': '|',
+	'꞉': ':', '∶': ':', 'ː': ':',
+	'⁎': '*', '∗': '*', 'ꓸ': '.',
+}
+
+// isASCII reports whether s contains only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Unicode pre-pass targets
+const (
+	UnicodeTargetPath   = "path"
+	UnicodeTargetQuery  = "query"
+	UnicodeTargetBody   = "body"
+	UnicodeTargetHeader = "header"
+)
+
+// FoldUnicode returns a copy of the request with the transformations applied
+// to the selected targets, for rule evaluation. The copy's canonical path is
+// rebuilt from the transformed decoded path so full-width separators and dots
+// are resolved; n itself is left unchanged so that routing still sees the
+// path as sent.
+func (n *NormalizedRequest) FoldUnicode(targets, transforms []string) (*NormalizedRequest, error) {
+	apply := func(s string) (string, error) {
+		for _, t := range transforms {
+			var err error
+			if s, err = Transform(t, s); err != nil {
+				return s, err
+			}
+		}
+		return s, nil
+	}
+	// applyAll folds every value into a new map
+	applyAll := func(m map[string][]string) (map[string][]string, error) {
+		folded := make(map[string][]string, len(m))
+		for k, values := range m {
+			out := make([]string, len(values))
+			for i, v := range values {
+				var err error
+				if out[i], err = apply(v); err != nil {
+					return nil, err
+				}
+			}
+			folded[k] = out
+		}
+		return folded, nil
+	}
+
+	folded := *n
+	var err error
+	for _, target := range targets {
+		switch target {
+		case UnicodeTargetPath:
+			if folded.OriginalPath, err = apply(n.OriginalPath); err != nil {
+				return nil, err
+			}
+			if folded.DecodedPath, err = apply(n.DecodedPath); err != nil {
+				return nil, err
+			}
+			folded.Path = canonicalPath(folded.DecodedPath)
+		case UnicodeTargetQuery:
+			query := make(map[string][]string, len(n.Query))
+			for k, values := range n.Query {
+				key, err := apply(k)
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range values {
+					value, err := apply(v)
+					if err != nil {
+						return nil, err
+					}
+					query[key] = append(query[key], value)
+				}
+			}
+			folded.Query = query
+		case UnicodeTargetBody:
+			if folded.Body, err = apply(n.Body); err != nil {
+				return nil, err
+			}
+			if folded.BodyArgs, err = applyAll(n.BodyArgs); err != nil {
+				return nil, err
+			}
+		case UnicodeTargetHeader:
+			folded.Headers = make(map[string]string, len(n.Headers))
+			for k, v := range n.Headers {
+				if folded.Headers[k], err = apply(v); err != nil {
+					return nil, err
+				}
+			}
+			if folded.HeaderValues, err = applyAll(n.HeaderValues); err != nil {
+				return nil, err
+			}
+			if folded.Cookies, err = applyAll(n.Cookies); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown unicode normalization target: %s", target)
+		}
+	}
+	return &folded, nil
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// slidingWindow approximates a sliding window by weighting the previous fixed
+// window's count by how much of it still overlaps the sliding window.
+// State: a = previous window count, b = current window count, stamp = current window start.
+type slidingWindow struct {
+	limit  float64
+	window time.Duration
+}
+
+func (w *slidingWindow) allow(s *keyState, now time.Time) Result {
+	start := now.Truncate(w.window)
+	switch {
+	case s.stamp.Equal(start):
+	case s.stamp.Add(w.window).Equal(start):
+		s.a, s.b = s.b, 0
+		s.stamp = start
+	default:
+		s.a, s.b = 0, 0
+		s.stamp = start
+	}
+
+	elapsed := now.Sub(start)
+	overlap := 1 - float64(elapsed)/float64(w.window)
+	// The quota is fully restored once both windows' requests have slid out
+	reset := w.window - elapsed
+	if s.b > 0 {
+		reset += w.window
+	}
+
+	if s.a*overlap+s.b >= w.limit {
+		return Result{Reset: reset, RetryAfter: w.retryAfter(s, elapsed)}
+	}
+	s.b++
+	return Result{
+		Allowed:   true,
+		Remaining: int(math.Max(0, math.Floor(w.limit-s.a*overlap-s.b))),
+		Reset:     reset,
+	}
+}
+
+// retryAfter returns the time until the weighted count drops below the limit
+func (w *slidingWindow) retryAfter(s *keyState, elapsed time.Duration) time.Duration {
+	window := float64(w.window)
+	if s.b < w.limit && s.a > 0 {
+		// Wait for enough of the previous window to slide out
+		x := 1 - (w.limit-s.b)/s.a
+		return time.Duration(x*window) - elapsed + 1
+	}
+	// The current window alone is at the limit: its requests become the previous
+	// window, which has to slide out far enough
+	x := 1 - w.limit/s.b
+	return w.window - elapsed + time.Duration(math.Max(0, x)*window) + 1
+}
+
+// tokenBucket refills capacity tokens over the window and spends one per request.
+// State: a = tokens available, stamp = last refill.
+type tokenBucket struct {
+	capacity float64
+	rate     float64 // tokens per second
+}
+
+func (b *tokenBucket) allow(s *keyState, now time.Time) Result {
+	if s.stamp.IsZero() {
+		s.a = b.capacity
+	} else if elapsed := now.Sub(s.stamp).Seconds(); elapsed > 0 {
+		s.a += elapsed * b.rate
+		if s.a > b.capacity {
+			s.a = b.capacity
+		}
+	}
+	s.stamp = now
+
+	if s.a < 1 {
+		return Result{
+			Reset:      b.duration(b.capacity - s.a),
+			RetryAfter: b.duration(1 - s.a),
+		}
+	}
+	s.a--
+	return Result{
+		Allowed:   true,
+		Remaining: int(s.a),
+		Reset:     b.duration(b.capacity - s.a),
+	}
+}
+
+// duration returns the time needed to refill n tokens
+func (b *tokenBucket) duration(n float64) time.Duration {
+	return time.Duration(n / b.rate * float64(time.Second))
+}
+
+// gcra implements the generic cell rate algorithm: each request advances the
+// theoretical arrival time (TAT) by one emission interval, and requests arriving
+// more than the burst tolerance before their TAT are rejected.
+// State: stamp = TAT.
+type gcra struct {
+	interval  time.Duration
+	tolerance time.Duration
+}
+
+func (g *gcra) allow(s *keyState, now time.Time) Result {
+	tat := s.stamp
+	if tat.Before(now) {
+		tat = now
+	}
+	if ahead := tat.Sub(now); ahead > g.tolerance {
+		return Result{Reset: ahead, RetryAfter: ahead - g.tolerance}
+	}
+	s.stamp = tat.Add(g.interval)
+	ahead := s.stamp.Sub(now)
+	return Result{
+		Allowed:   true,
+		Remaining: int((g.tolerance + g.interval - ahead) / g.interval),
+		Reset:     ahead,
+	}
+}
@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHeaders adds the IETF RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers for a result, plus Retry-After when the request was
+// rejected. legacy also adds the X-RateLimit-* equivalents, whose reset is a Unix time.
+func SetHeaders(h http.Header, result Result, legacy bool) {
+	reset := seconds(result.Reset)
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(reset))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+	}
+
+	if legacy {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.Reset).Unix(), 10))
+	}
+}
+
+// seconds rounds a duration up to whole seconds so clients never retry early
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
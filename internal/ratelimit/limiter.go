@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/waf-draft/waf/internal/state"
+)
+
+// Algorithm names
+const (
+	AlgorithmSlidingLog    = "sliding_log"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmGCRA          = "gcra"
+)
+
+// Default sizing for the sharded key table
+const (
+	DefaultShards  = 64
+	DefaultMaxKeys = 100000
+)
+
+// Limiter decides whether a request for a key (e.g. a client IP) is within its limit
+type Limiter interface {
+	Allow(key string) Result
+	GetStats() map[string]interface{}
+	Stop()
+}
+
+// Config configures a rate limiter
+type Config struct {
+	Algorithm string
+	// Limit is the number of requests allowed per Window
+	Limit  int
+	Window time.Duration
+	// Burst is the bucket size for token_bucket and gcra (defaults to Limit)
+	Burst int
+	// Shards is the number of independently locked key tables
+	Shards int
+	// MaxKeys bounds the number of tracked keys; the least recently used key is evicted
+	MaxKeys int
+	// Backend, if set, shares counts between replicas. Shared limits always use
+	// the sliding window counter.
+	Backend state.Backend
+	// Namespace separates the backend counters of different policies
+	Namespace string
+	// SyncInterval is how often a key's local count is flushed to the backend;
+	// zero writes every request through
+	SyncInterval time.Duration
+	// FailMode is state.FailOpen or state.FailClosed
+	FailMode string
+}
+
+// New creates a rate limiter for the configured algorithm
+func New(cfg Config) (Limiter, error) {
+	if cfg.Limit <= 0 || cfg.Window <= 0 {
+		return nil, fmt.Errorf("rate limit requires a positive limit and window")
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.Limit
+	}
+	if cfg.Shards <= 0 {
+		cfg.Shards = DefaultShards
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = DefaultMaxKeys
+	}
+
+	if cfg.Backend != nil {
+		return newSharedLimiter(cfg), nil
+	}
+
+	var algo algorithm
+	switch cfg.Algorithm {
+	case AlgorithmSlidingLog:
+		return NewRateLimiter(cfg.Limit, cfg.Window), nil
+	case AlgorithmSlidingWindow, "":
+		cfg.Algorithm = AlgorithmSlidingWindow
+		algo = &slidingWindow{limit: float64(cfg.Limit), window: cfg.Window}
+	case AlgorithmTokenBucket:
+		algo = &tokenBucket{
+			capacity: float64(cfg.Burst),
+			rate:     float64(cfg.Limit) / cfg.Window.Seconds(),
+		}
+	case AlgorithmGCRA:
+		interval := cfg.Window / time.Duration(cfg.Limit)
+		algo = &gcra{interval: interval, tolerance: interval * time.Duration(cfg.Burst-1)}
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm: %s", cfg.Algorithm)
+	}
+
+	return newShardedLimiter(cfg, algo), nil
+}
+
+// Result is the outcome of counting a request against a limit
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests allowed per window
+	Limit int
+	// Remaining is the number of requests the key may still make right now
+	Remaining int
+	// Reset is the time until the key's quota is fully restored
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed (zero when allowed)
+	RetryAfter time.Duration
+	// Policy names the rate limit policy that produced the result
+	Policy string
+}
+
+// algorithm updates a key's O(1) state and reports whether the request is allowed
+type algorithm interface {
+	allow(s *keyState, now time.Time) Result
+}
+
+// keyState is the fixed-size per-key state shared by all algorithms
+type keyState struct {
+	// a and b are algorithm specific: tokens/last refill, TAT, or window counts
+	a, b  float64
+	stamp time.Time
+}
+
+// entry is an LRU list element
+type entry struct {
+	key   string
+	state keyState
+}
+
+// shard is one independently locked LRU key table
+type shard struct {
+	mu      sync.Mutex
+	keys    map[string]*list.Element
+	lru     *list.List
+	maxKeys int
+	evicted int64
+}
+
+// shardedLimiter spreads keys over shards to reduce lock contention
+type shardedLimiter struct {
+	cfg    Config
+	algo   algorithm
+	shards []*shard
+}
+
+func newShardedLimiter(cfg Config, algo algorithm) *shardedLimiter {
+	perShard := cfg.MaxKeys / cfg.Shards
+	if perShard < 1 {
+		perShard = 1
+	}
+	l := &shardedLimiter{cfg: cfg, algo: algo, shards: make([]*shard, cfg.Shards)}
+	for i := range l.shards {
+		l.shards[i] = &shard{
+			keys:    make(map[string]*list.Element),
+			lru:     list.New(),
+			maxKeys: perShard,
+		}
+	}
+	return l
+}
+
+// Allow counts a request for the given key
+func (l *shardedLimiter) Allow(key string) Result {
+	s := l.shards[fnv32(key)%uint32(len(l.shards))]
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e *entry
+	if el, ok := s.keys[key]; ok {
+		s.lru.MoveToFront(el)
+		e = el.Value.(*entry)
+	} else {
+		if s.lru.Len() >= s.maxKeys {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.keys, oldest.Value.(*entry).key)
+			s.evicted++
+		}
+		e = &entry{key: key}
+		s.keys[key] = s.lru.PushFront(e)
+	}
+
+	result := l.algo.allow(&e.state, now)
+	result.Limit = l.cfg.Limit
+	return result
+}
+
+// GetStats returns rate limiter statistics
+func (l *shardedLimiter) GetStats() map[string]interface{} {
+	var tracked int
+	var evicted int64
+	for _, s := range l.shards {
+		s.mu.Lock()
+		tracked += s.lru.Len()
+		evicted += s.evicted
+		s.mu.Unlock()
+	}
+
+	return map[string]interface{}{
+		"algorithm":      l.cfg.Algorithm,
+		"tracked_keys":   tracked,
+		"evicted_keys":   evicted,
+		"max_requests":   l.cfg.Limit,
+		"burst":          l.cfg.Burst,
+		"window_seconds": l.cfg.Window.Seconds(),
+	}
+}
+
+// Stop is a no-op; the bounded LRU table needs no background cleanup
+func (l *shardedLimiter) Stop() {}
+
+// fnv32 hashes a key with FNV-1a without allocating
+func fnv32(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
@@ -0,0 +1,308 @@
+package ratelimit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// Policy actions
+const (
+	ActionBlock     = "block"
+	ActionChallenge = "challenge"
+	ActionScore     = "score"
+	ActionLog       = "log"
+)
+
+// Policy is a rate limit applied to the requests it matches, counted per key
+type Policy struct {
+	Name string
+	// PathPrefix and PathRegex match the normalized request path
+	PathPrefix string
+	PathRegex  string
+	Methods    []string
+	Hosts      []string
+	// Key is a "+" separated list of key parts: ip, ipv6_64, header:<name>,
+	// cookie:<name> or jwt:<claim>
+	Key string
+	// Limiter configures the algorithm, limit, window and burst
+	Limiter Config
+	// Action is block, challenge, score or log
+	Action string
+	// Score is added to the anomaly score by the score action
+	Score int
+}
+
+// PolicyResult is the result of counting a request against one policy
+type PolicyResult struct {
+	Result
+	Key    string
+	Action string
+	Score  int
+}
+
+// compiledPolicy is a policy with its regex, key parts and limiter prepared
+type compiledPolicy struct {
+	Policy
+	pathRegex *regexp.Regexp
+	keyParts  []keyPart
+	limiter   Limiter
+}
+
+// keyPart is one component of a policy key expression
+type keyPart struct {
+	kind string
+	name string
+}
+
+// PolicySet evaluates requests against an ordered list of policies
+type PolicySet struct {
+	policies []*compiledPolicy
+}
+
+// NewPolicySet compiles policies and creates a limiter for each
+func NewPolicySet(policies []Policy) (*PolicySet, error) {
+	set := &PolicySet{}
+	for i, p := range policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		if p.Action == "" {
+			p.Action = ActionBlock
+		}
+		switch p.Action {
+		case ActionBlock, ActionChallenge, ActionScore, ActionLog:
+		default:
+			set.Stop()
+			return nil, fmt.Errorf("rate limit policy %s: unknown action %q", p.Name, p.Action)
+		}
+
+		cp := &compiledPolicy{Policy: p}
+		if p.PathRegex != "" {
+			re, err := regexp.Compile(p.PathRegex)
+			if err != nil {
+				set.Stop()
+				return nil, fmt.Errorf("rate limit policy %s: invalid path_regex: %w", p.Name, err)
+			}
+			cp.pathRegex = re
+		}
+		parts, err := parseKey(p.Key)
+		if err != nil {
+			set.Stop()
+			return nil, fmt.Errorf("rate limit policy %s: %w", p.Name, err)
+		}
+		cp.keyParts = parts
+		if p.Limiter.Namespace == "" {
+			p.Limiter.Namespace = p.Name
+		}
+		limiter, err := New(p.Limiter)
+		if err != nil {
+			set.Stop()
+			return nil, fmt.Errorf("rate limit policy %s: %w", p.Name, err)
+		}
+		cp.limiter = limiter
+
+		set.policies = append(set.policies, cp)
+	}
+	return set, nil
+}
+
+// parseKey parses a key expression such as "ip+header:X-API-Key"
+func parseKey(expr string) ([]keyPart, error) {
+	if expr == "" {
+		expr = "ip"
+	}
+	var parts []keyPart
+	for _, raw := range strings.Split(expr, "+") {
+		kind, name, _ := strings.Cut(strings.TrimSpace(raw), ":")
+		kind = strings.ToLower(kind)
+		switch kind {
+		case "ip", "ipv6_64":
+		case "header", "cookie", "jwt":
+			if name == "" {
+				return nil, fmt.Errorf("key part %q requires a name", kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key part %q", raw)
+		}
+		parts = append(parts, keyPart{kind: kind, name: name})
+	}
+	return parts, nil
+}
+
+// Check counts the request against every matching policy and returns the
+// results in policy order. Policies whose key cannot be resolved from the
+// request (e.g. a missing API key header) do not apply.
+func (s *PolicySet) Check(r *http.Request, path, clientIP string) []PolicyResult {
+	var results []PolicyResult
+	for _, p := range s.policies {
+		if !p.matches(r, path) {
+			continue
+		}
+		key, ok := p.key(r, clientIP)
+		if !ok {
+			continue
+		}
+		result := p.limiter.Allow(key)
+		result.Policy = p.Name
+		results = append(results, PolicyResult{
+			Result: result,
+			Key:    key,
+			Action: p.Action,
+			Score:  p.Score,
+		})
+	}
+	return results
+}
+
+// Binding returns the result clients should be told about: the first exceeded
+// block or challenge policy, otherwise the policy with the fewest remaining
+// requests. Score and log policies never reject a request, so they are reported
+// as allowed and do not produce a Retry-After.
+func Binding(results []PolicyResult) (Result, bool) {
+	for _, r := range results {
+		if !r.Allowed && (r.Action == ActionBlock || r.Action == ActionChallenge) {
+			return r.Result, true
+		}
+	}
+	var binding Result
+	found := false
+	for _, r := range results {
+		if !found || r.Remaining < binding.Remaining {
+			binding = r.Result
+			binding.Allowed = true
+			found = true
+		}
+	}
+	return binding, found
+}
+
+// GetStats returns per-policy limiter statistics
+func (s *PolicySet) GetStats() map[string]interface{} {
+	stats := make(map[string]interface{}, len(s.policies))
+	for _, p := range s.policies {
+		stats[p.Name] = p.limiter.GetStats()
+	}
+	return stats
+}
+
+// Stop stops all policy limiters
+func (s *PolicySet) Stop() {
+	for _, p := range s.policies {
+		p.limiter.Stop()
+	}
+}
+
+// matches checks the path, method and host conditions
+func (p *compiledPolicy) matches(r *http.Request, path string) bool {
+	if p.PathPrefix != "" && !strings.HasPrefix(path, p.PathPrefix) {
+		return false
+	}
+	if p.pathRegex != nil && !p.pathRegex.MatchString(path) {
+		return false
+	}
+	if len(p.Methods) > 0 && !containsFold(p.Methods, r.Method) {
+		return false
+	}
+	if len(p.Hosts) > 0 {
+		host := normalize.Hostname(r.Host)
+		matched := false
+		for _, h := range p.Hosts {
+			if strings.EqualFold(normalize.Hostname(h), host) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// key builds the limiter key from the policy's key parts
+func (p *compiledPolicy) key(r *http.Request, clientIP string) (string, bool) {
+	values := make([]string, 0, len(p.keyParts))
+	for _, part := range p.keyParts {
+		var value string
+		switch part.kind {
+		case "ip":
+			value = clientIP
+		case "ipv6_64":
+			value = ipv6Prefix(clientIP)
+		case "header":
+			value = r.Header.Get(part.name)
+		case "cookie":
+			if c, err := r.Cookie(part.name); err == nil {
+				value = c.Value
+			}
+		case "jwt":
+			value = jwtClaim(r.Header.Get("Authorization"), part.name)
+		}
+		if value == "" {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "|"), true
+}
+
+// ipv6Prefix returns the /64 network of an IPv6 address so that clients
+// rotating through their allocation share a key. IPv4 addresses are returned as is.
+func ipv6Prefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+// jwtClaim extracts a claim from a bearer token's payload. The signature is not
+// verified; the claim is only used to group requests for counting.
+func jwtClaim(authorization, claim string) string {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	switch v := claims[claim].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
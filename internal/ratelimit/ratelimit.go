@@ -5,7 +5,7 @@ import (
 	"time"
 )
 
-// RateLimiter implements per-IP rate limiting
+// RateLimiter implements per-IP rate limiting with an exact sliding log of request times
 type RateLimiter struct {
 	requests map[string][]time.Time
 	mu       sync.RWMutex
@@ -29,8 +29,8 @@ func NewRateLimiter(maxRequests int, window time.Duration) *RateLimiter {
 	return rl
 }
 
-// Allow checks if a request from the given IP should be allowed
-func (rl *RateLimiter) Allow(ip string) bool {
+// Allow counts a request from the given IP
+func (rl *RateLimiter) Allow(ip string) Result {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -41,7 +41,7 @@ func (rl *RateLimiter) Allow(ip string) bool {
 	requests, exists := rl.requests[ip]
 	if !exists {
 		rl.requests[ip] = []time.Time{now}
-		return true
+		return Result{Allowed: true, Limit: rl.maxReqs, Remaining: rl.maxReqs - 1, Reset: rl.window}
 	}
 
 	// Remove old requests outside the window
@@ -54,14 +54,25 @@ func (rl *RateLimiter) Allow(ip string) bool {
 
 	// Check if limit exceeded
 	if len(validRequests) >= rl.maxReqs {
-		return false
+		result := Result{Limit: rl.maxReqs, Reset: rl.window, RetryAfter: rl.window}
+		if len(validRequests) > 0 {
+			// A slot frees up when the oldest request leaves the window
+			result.Reset = validRequests[len(validRequests)-1].Sub(cutoff)
+			result.RetryAfter = validRequests[0].Sub(cutoff)
+		}
+		return result
 	}
 
 	// Add current request
 	validRequests = append(validRequests, now)
 	rl.requests[ip] = validRequests
 
-	return true
+	return Result{
+		Allowed:   true,
+		Limit:     rl.maxReqs,
+		Remaining: rl.maxReqs - len(validRequests),
+		Reset:     rl.window,
+	}
 }
 
 // cleanupOldEntries removes old entries to prevent memory leaks
@@ -0,0 +1,293 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/waf-draft/waf/internal/state"
+)
+
+// sharedEntry caches a key's counters from the state backend together with the
+// requests counted locally since the last sync
+type sharedEntry struct {
+	mu sync.Mutex
+	// window is the index of the current fixed window
+	window int64
+	// prev and curr are the cluster-wide counts as of the last sync
+	prev, curr int64
+	prevSynced bool
+	pending    int64
+	lastSync   time.Time
+	lastUsed   time.Time
+	failed     bool
+	// evicted is set once the entry is dropped from the cache; requests still
+	// holding it write their counts straight through
+	evicted bool
+}
+
+// sharedLimiter is a sliding window counter whose per-window counts live in a
+// state backend shared by all replicas. Local counts are flushed at most once
+// per sync interval, trading a little accuracy for fewer round trips.
+type sharedLimiter struct {
+	cfg     Config
+	algo    slidingWindow
+	backend state.Backend
+	entries map[string]*sharedEntry
+	mu      sync.Mutex
+	cleanup *time.Ticker
+	done    chan struct{}
+	stopped sync.Once
+	errors  int64
+}
+
+func newSharedLimiter(cfg Config) *sharedLimiter {
+	if cfg.FailMode == "" {
+		cfg.FailMode = state.FailOpen
+	}
+	l := &sharedLimiter{
+		cfg:     cfg,
+		algo:    slidingWindow{limit: float64(cfg.Limit), window: cfg.Window},
+		backend: cfg.Backend,
+		entries: make(map[string]*sharedEntry),
+		cleanup: time.NewTicker(1 * time.Minute),
+		done:    make(chan struct{}),
+	}
+	go l.cleanupIdle()
+	return l
+}
+
+// Allow counts a request for the given key against the cluster-wide limit
+func (l *sharedLimiter) Allow(key string) Result {
+	now := time.Now()
+	e := l.entry(key, now)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastUsed = now
+
+	window := now.UnixNano() / int64(l.cfg.Window)
+	if window != e.window {
+		// Flush what was counted in the old window before moving on
+		l.flush(key, e)
+		if window == e.window+1 {
+			e.prev = e.curr + e.pending
+		} else {
+			e.prev = 0
+		}
+		e.window, e.curr, e.pending, e.prevSynced = window, 0, 0, false
+		e.lastSync = time.Time{}
+	}
+	if now.Sub(e.lastSync) >= l.cfg.SyncInterval {
+		l.sync(key, e)
+		e.lastSync = now
+	}
+
+	start := time.Unix(0, window*int64(l.cfg.Window))
+	elapsed := now.Sub(start)
+	counts := keyState{a: float64(e.prev), b: float64(e.curr + e.pending)}
+	reset := l.cfg.Window - elapsed
+	if counts.b > 0 {
+		reset += l.cfg.Window
+	}
+
+	if e.failed && l.cfg.FailMode == state.FailClosed {
+		return Result{Limit: l.cfg.Limit, Reset: reset, RetryAfter: l.cfg.SyncInterval + time.Second}
+	}
+
+	overlap := 1 - float64(elapsed)/float64(l.cfg.Window)
+	estimate := counts.a*overlap + counts.b
+	if estimate >= l.algo.limit {
+		return Result{Limit: l.cfg.Limit, Reset: reset, RetryAfter: l.algo.retryAfter(&counts, elapsed)}
+	}
+	e.pending++
+	if l.cfg.SyncInterval <= 0 || e.evicted {
+		// Without caching every request is written through
+		l.flush(key, e)
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     l.cfg.Limit,
+		Remaining: int(math.Max(0, math.Floor(l.algo.limit-estimate-1))),
+		Reset:     reset,
+	}
+}
+
+// entry returns the cached entry for a key, evicting another entry when full.
+// An evicted entry's pending count is flushed so that it is not lost.
+func (l *sharedLimiter) entry(key string, now time.Time) *sharedEntry {
+	l.mu.Lock()
+	e, ok := l.entries[key]
+	var victimKey string
+	var victim *sharedEntry
+	if !ok {
+		if l.cfg.MaxKeys > 0 && len(l.entries) >= l.cfg.MaxKeys {
+			victimKey, victim = l.evictLocked()
+		}
+		e = &sharedEntry{window: now.UnixNano() / int64(l.cfg.Window), lastUsed: now}
+		l.entries[key] = e
+	}
+	l.mu.Unlock()
+
+	if victim != nil {
+		victim.mu.Lock()
+		victim.evicted = true
+		l.flush(victimKey, victim)
+		victim.mu.Unlock()
+	}
+	return e
+}
+
+// evictLocked removes an entry to make room, preferring one with nothing
+// pending. An entry that still has to be flushed is returned. Caller must hold l.mu.
+func (l *sharedLimiter) evictLocked() (string, *sharedEntry) {
+	var fallbackKey string
+	var fallback *sharedEntry
+	for k, e := range l.entries {
+		if e.mu.TryLock() {
+			idle := e.pending == 0
+			if idle {
+				e.evicted = true
+			}
+			e.mu.Unlock()
+			if idle {
+				delete(l.entries, k)
+				return "", nil
+			}
+		}
+		if fallback == nil {
+			fallbackKey, fallback = k, e
+		}
+	}
+	delete(l.entries, fallbackKey)
+	return fallbackKey, fallback
+}
+
+// sync flushes pending requests and refreshes the cluster-wide counts. On failure
+// the pending count is kept for the next attempt. Caller must hold e.mu.
+func (l *sharedLimiter) sync(key string, e *sharedEntry) {
+	e.failed = false
+	if e.pending > 0 {
+		if !l.flush(key, e) {
+			return
+		}
+	} else {
+		value, ok, err := l.backend.Get(l.windowKey(key, e.window))
+		if err != nil {
+			l.fail(err, e)
+			return
+		}
+		e.curr = 0
+		if ok {
+			e.curr, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if !e.prevSynced {
+		value, ok, err := l.backend.Get(l.windowKey(key, e.window-1))
+		if err != nil {
+			l.fail(err, e)
+			return
+		}
+		if ok {
+			e.prev, _ = strconv.ParseInt(value, 10, 64)
+		}
+		e.prevSynced = true
+	}
+}
+
+// flush adds the pending count to the current window's counter. Caller must hold e.mu.
+func (l *sharedLimiter) flush(key string, e *sharedEntry) bool {
+	if e.pending == 0 {
+		return true
+	}
+	total, err := l.backend.IncrBy(l.windowKey(key, e.window), e.pending, 2*l.cfg.Window)
+	if err != nil {
+		l.fail(err, e)
+		return false
+	}
+	e.curr, e.pending = total, 0
+	return true
+}
+
+// fail records a backend error
+func (l *sharedLimiter) fail(err error, e *sharedEntry) {
+	e.failed = true
+	l.mu.Lock()
+	l.errors++
+	first := l.errors == 1
+	l.mu.Unlock()
+	if first {
+		log.Printf("Warning: rate limit state backend unavailable (fail %s): %v", l.cfg.FailMode, err)
+	}
+}
+
+// windowKey names the backend counter for a key's fixed window
+func (l *sharedLimiter) windowKey(key string, window int64) string {
+	return "rl:" + l.cfg.Namespace + ":" + key + ":" + strconv.FormatInt(window, 10)
+}
+
+// GetStats returns rate limiter statistics
+func (l *sharedLimiter) GetStats() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return map[string]interface{}{
+		"algorithm":      AlgorithmSlidingWindow,
+		"shared":         true,
+		"tracked_keys":   len(l.entries),
+		"backend_errors": l.errors,
+		"max_requests":   l.cfg.Limit,
+		"window_seconds": l.cfg.Window.Seconds(),
+	}
+}
+
+// Stop flushes pending counts and stops the cleanup goroutine
+func (l *sharedLimiter) Stop() {
+	l.stopped.Do(func() {
+		l.cleanup.Stop()
+		close(l.done)
+	})
+	l.mu.Lock()
+	entries := make(map[string]*sharedEntry, len(l.entries))
+	for k, e := range l.entries {
+		entries[k] = e
+	}
+	l.mu.Unlock()
+
+	for key, e := range entries {
+		e.mu.Lock()
+		l.flush(key, e)
+		e.mu.Unlock()
+	}
+}
+
+// cleanupIdle drops cached entries unused for two windows, flushing them first
+func (l *sharedLimiter) cleanupIdle() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.cleanup.C:
+		}
+
+		cutoff := time.Now().Add(-2 * l.cfg.Window)
+		l.mu.Lock()
+		var idle []string
+		for key, e := range l.entries {
+			if e.mu.TryLock() {
+				if e.lastUsed.Before(cutoff) && e.pending == 0 {
+					e.evicted = true
+					idle = append(idle, key)
+				}
+				e.mu.Unlock()
+			}
+		}
+		for _, key := range idle {
+			delete(l.entries, key)
+		}
+		l.mu.Unlock()
+	}
+}
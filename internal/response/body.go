@@ -0,0 +1,213 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the amount of decoded response body inspected when no limit is configured
+const DefaultMaxBodyBytes = 512 * 1024
+
+// DefaultContentTypes are the response media types inspected when none are configured.
+// Entries ending in "/" match a whole type, e.g. "text/".
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/problem+json",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/javascript",
+}
+
+// Inspectable reports whether a response carries a body that can be inspected:
+// one with a listed content type and an encoding the WAF can decompress.
+// Event streams and responses without a body are never buffered.
+func Inspectable(resp *http.Response, contentTypes []string) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if resp.ContentLength == 0 {
+		return false
+	}
+
+	if !decodable(resp) {
+		return false
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		// Error pages are often sent without a content type
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultContentTypes
+	}
+	for _, t := range contentTypes {
+		t = strings.ToLower(t)
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodable reports whether the response body uses an encoding the WAF can decompress
+func decodable(resp *http.Response) bool {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity", "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// Snippet reads and decompresses up to max bytes of a response body for logging
+// and closes it. Bodies in encodings the WAF cannot decompress yield "".
+func Snippet(resp *http.Response, max int64) string {
+	defer resp.Body.Close()
+	if max <= 0 || !decodable(resp) {
+		return ""
+	}
+	b, err := ReadBody(resp, max)
+	if err != nil && b.Text == "" {
+		return ""
+	}
+	return strings.ToValidUTF8(b.Text, "\uFFFD")
+}
+
+// Body is the start of a response body buffered for inspection
+type Body struct {
+	// Text is the decompressed body, up to the inspection limit
+	Text string
+	// Complete reports whether Text holds the whole body
+	Complete bool
+
+	original io.ReadCloser
+	raw      *capture
+	decoded  io.Reader
+}
+
+// capture records the bytes read from the upstream until it is switched off
+type capture struct {
+	buf bytes.Buffer
+	off bool
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	if !c.off {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// ReadBody reads and decompresses up to max bytes of a response body. The bytes
+// consumed from the upstream are kept so that the body can be restored unchanged.
+func ReadBody(resp *http.Response, max int64) (*Body, error) {
+	if max <= 0 {
+		max = DefaultMaxBodyBytes
+	}
+	b := &Body{original: resp.Body, raw: &capture{}}
+	tee := io.TeeReader(resp.Body, b.raw)
+
+	var err error
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		b.decoded, err = gzip.NewReader(tee)
+	case "deflate":
+		b.decoded, err = zlib.NewReader(tee)
+	default:
+		b.decoded = tee
+	}
+	if err != nil {
+		return b, fmt.Errorf("failed to decompress response body: %w", err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(b.decoded, max+1))
+	if err != nil {
+		return b, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(data)) > max {
+		// The extra byte is served with the rest of the body
+		b.decoded = io.MultiReader(bytes.NewReader(data[max:]), b.decoded)
+		data = data[:max]
+	} else {
+		b.Complete = true
+	}
+	b.Text = string(data)
+	b.raw.off = true
+	return b, nil
+}
+
+// Restore puts the body back on the response exactly as the upstream sent it
+func (b *Body) Restore(resp *http.Response) {
+	resp.Body = &body{
+		Reader: io.MultiReader(bytes.NewReader(b.raw.buf.Bytes()), b.original),
+		Closer: b.original,
+	}
+}
+
+// Replace serves text in place of the inspected part of the body. The response
+// is sent uncompressed; the length is recomputed when the whole body was read.
+func (b *Body) Replace(resp *http.Response, text string) {
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	if b.Complete {
+		b.original.Close()
+		resp.Body = io.NopCloser(strings.NewReader(text))
+		resp.ContentLength = int64(len(text))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(text)))
+		return
+	}
+	resp.Body = &body{
+		Reader: io.MultiReader(strings.NewReader(text), b.decoded),
+		Closer: b.original,
+	}
+	resp.ContentLength = -1
+}
+
+// Discard closes the upstream body
+func (b *Body) Discard() {
+	b.original.Close()
+}
+
+// body joins a replacement reader with the upstream body's Close
+type body struct {
+	io.Reader
+	io.Closer
+}
+
+// Mask hides the given findings in text, replacing letters and digits with '*'
+// and keeping separators so that the shape of the data is preserved
+func Mask(text string, findings []Finding) string {
+	if len(findings) == 0 {
+		return text
+	}
+	masked := []byte(text)
+	for _, f := range findings {
+		if f.Start < 0 || f.End > len(masked) || f.Start >= f.End {
+			continue
+		}
+		for i := f.Start; i < f.End; i++ {
+			if c := masked[i]; c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+				masked[i] = '*'
+			}
+		}
+	}
+	return string(masked)
+}
@@ -0,0 +1,259 @@
+package response
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in detector names
+const (
+	DetectorStackTrace       = "stack_trace"
+	DetectorSQLError         = "sql_error"
+	DetectorDirectoryListing = "directory_listing"
+	DetectorCreditCard       = "credit_card"
+	DetectorSSN              = "ssn"
+	DetectorNationalID       = "national_id"
+	DetectorEmail            = "email"
+	DetectorAPIKey           = "api_key"
+)
+
+// Detectors lists the built-in detectors in evaluation order
+var Detectors = []string{
+	DetectorStackTrace,
+	DetectorSQLError,
+	DetectorDirectoryListing,
+	DetectorCreditCard,
+	DetectorSSN,
+	DetectorNationalID,
+	DetectorEmail,
+	DetectorAPIKey,
+}
+
+// PIIDetectors are the detectors used for masking personal data and secrets
+var PIIDetectors = []string{
+	DetectorCreditCard,
+	DetectorNationalID,
+	DetectorEmail,
+	DetectorAPIKey,
+}
+
+// Finding is a piece of leaked data found in a response body
+type Finding struct {
+	Detector string
+	Value    string
+	// Start and End are byte offsets of Value in the body
+	Start int
+	End   int
+}
+
+var (
+	stackTracePattern = regexp.MustCompile(`(?m)` + strings.Join([]string{
+		// Java and other JVM languages
+		`^\s*at [\w$.<>]+\([\w$]+\.(?:java|kt|scala):\d+\)`,
+		`Exception in thread "[^"]*"`,
+		// Python
+		`Traceback \(most recent call last\):`,
+		// .NET
+		`^\s*at [\w.]+\(.*\) in .+:line \d+`,
+		`--- End of (?:inner exception )?stack trace`,
+		// PHP
+		`(?:Fatal error|Parse error|Warning): .+ in \S+\.php on line \d+`,
+		`Stack trace:\s*#0 `,
+		// Go
+		`goroutine \d+ \[running\]:`,
+		// Node.js
+		`^\s*at .+ \(\S+\.(?:js|mjs|cjs|ts):\d+:\d+\)`,
+	}, "|"))
+
+	sqlErrorPattern = regexp.MustCompile(`(?i)` + strings.Join([]string{
+		`you have an error in your sql syntax`,
+		`warning: mysqli?_\w+\(`,
+		`\bORA-\d{5}\b`,
+		`PG::\w*Error`,
+		`ERROR:\s+syntax error at or near`,
+		`unclosed quotation mark after the character string`,
+		`microsoft (?:ole db provider|odbc driver) for sql server`,
+		`SQLSTATE\[\w+\]`,
+		`sqlite3?\.OperationalError|SQLite3::\w*Exception`,
+		`java\.sql\.SQLException`,
+		`quoted string not properly terminated`,
+	}, "|"))
+
+	directoryListingPattern = regexp.MustCompile(`(?i)<title>\s*Index of /|<h1>\s*Index of /|Directory listing for /|\[To Parent Directory\]`)
+
+	cardCandidatePattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	ssnPattern = regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+
+	// UK National Insurance numbers, e.g. AB 12 34 56 C
+	ninoPattern = regexp.MustCompile(`\b[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z] ?\d{2} ?\d{2} ?\d{2} ?[A-D]\b`)
+
+	// Canadian Social Insurance Numbers, only when written with separators
+	sinPattern = regexp.MustCompile(`\b\d{3}([ -])\d{3}([ -])\d{3}\b`)
+
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@(?:[A-Za-z0-9-]+\.)+([A-Za-z]{2,24})\b`)
+
+	apiKeyPattern = regexp.MustCompile(strings.Join([]string{
+		// AWS access key IDs
+		`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+		// GitHub tokens
+		`\bgh[pousr]_[A-Za-z0-9]{36}\b`,
+		`\bgithub_pat_[A-Za-z0-9_]{82}\b`,
+		// Slack tokens
+		`\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+		// Stripe secret and restricted keys
+		`\b[sr]k_(?:live|test)_[A-Za-z0-9]{16,}\b`,
+		// Google API keys
+		`\bAIza[0-9A-Za-z_-]{35}`,
+		// PEM private keys
+		`-----BEGIN (?:RSA |EC |DSA |OPENSSH )?PRIVATE KEY-----`,
+	}, "|"))
+)
+
+// fileExtensions are email-like TLDs that are really file names, e.g. logo@2x.png
+var fileExtensions = map[string]bool{
+	"png": true, "jpg": true, "jpeg": true, "gif": true, "svg": true, "webp": true,
+	"css": true, "js": true, "ico": true, "avif": true,
+}
+
+// Detect runs the named detectors over body. A nil list runs all of them.
+func Detect(body string, detectors []string) []Finding {
+	if detectors == nil {
+		detectors = Detectors
+	}
+
+	var findings []Finding
+	for _, name := range detectors {
+		switch name {
+		case DetectorStackTrace:
+			findings = appendMatches(findings, name, body, stackTracePattern, nil)
+		case DetectorSQLError:
+			findings = appendMatches(findings, name, body, sqlErrorPattern, nil)
+		case DetectorDirectoryListing:
+			findings = appendMatches(findings, name, body, directoryListingPattern, nil)
+		case DetectorCreditCard:
+			findings = appendMatches(findings, name, body, cardCandidatePattern, isCardNumber)
+		case DetectorSSN:
+			findings = appendMatches(findings, name, body, ssnPattern, isSSN)
+		case DetectorNationalID:
+			findings = appendMatches(findings, name, body, ssnPattern, isSSN)
+			findings = appendMatches(findings, name, body, ninoPattern, isNINO)
+			findings = appendMatches(findings, name, body, sinPattern, isSIN)
+		case DetectorEmail:
+			findings = appendMatches(findings, name, body, emailPattern, isEmail)
+		case DetectorAPIKey:
+			findings = appendMatches(findings, name, body, apiKeyPattern, nil)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings
+}
+
+// ValidDetector reports whether name is a built-in detector
+func ValidDetector(name string) bool {
+	for _, d := range Detectors {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMatches adds a finding for each match of re accepted by valid
+func appendMatches(findings []Finding, name, body string, re *regexp.Regexp, valid func(string) bool) []Finding {
+	for _, loc := range re.FindAllStringIndex(body, -1) {
+		value := body[loc[0]:loc[1]]
+		if valid != nil && !valid(value) {
+			continue
+		}
+		findings = append(findings, Finding{Detector: name, Value: value, Start: loc[0], End: loc[1]})
+	}
+	return findings
+}
+
+// isCardNumber accepts digit runs with a card network prefix and a valid Luhn checksum
+func isCardNumber(candidate string) bool {
+	digits := make([]byte, 0, len(candidate))
+	for i := 0; i < len(candidate); i++ {
+		if c := candidate[i]; c >= '0' && c <= '9' {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 || !cardPrefix(string(digits)) {
+		return false
+	}
+	return Luhn(string(digits))
+}
+
+// cardPrefix reports whether a number starts with a Visa, Mastercard, American
+// Express, Discover or JCB prefix
+func cardPrefix(n string) bool {
+	switch {
+	case n[0] == '4':
+		return true
+	case n[:2] >= "51" && n[:2] <= "55", n[:4] >= "2221" && n[:4] <= "2720":
+		return true
+	case n[:2] == "34", n[:2] == "37":
+		return true
+	case n[:4] == "6011", n[:2] == "65", n[:3] >= "644" && n[:3] <= "649":
+		return true
+	case n[:2] == "35":
+		return true
+	}
+	return false
+}
+
+// Luhn reports whether a string of digits has a valid Luhn checksum
+func Luhn(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// isSSN rejects number ranges the Social Security Administration never issues
+func isSSN(candidate string) bool {
+	m := ssnPattern.FindStringSubmatch(candidate)
+	if m == nil {
+		return false
+	}
+	area, group, serial := m[1], m[2], m[3]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// isNINO rejects National Insurance prefixes that are never allocated
+func isNINO(candidate string) bool {
+	switch candidate[:2] {
+	case "BG", "GB", "KN", "NK", "NT", "TN", "ZZ":
+		return false
+	}
+	return true
+}
+
+// isSIN accepts consistently separated numbers with a valid Luhn checksum that
+// do not start with 0 or 8, which are never issued
+func isSIN(candidate string) bool {
+	m := sinPattern.FindStringSubmatch(candidate)
+	if m == nil || m[1] != m[2] || candidate[0] == '0' || candidate[0] == '8' {
+		return false
+	}
+	return Luhn(candidate[0:3] + candidate[4:7] + candidate[8:11])
+}
+
+// isEmail rejects image and asset names such as logo@2x.png
+func isEmail(candidate string) bool {
+	m := emailPattern.FindStringSubmatch(candidate)
+	return m != nil && !fileExtensions[strings.ToLower(m[1])]
+}
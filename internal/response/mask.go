@@ -0,0 +1,144 @@
+package response
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maskChunkSize is the amount read from the upstream per pass of the masking reader
+	maskChunkSize = 32 * 1024
+	// maskOverlap is held back between passes so that data split across reads
+	// is still found; longer matches are only found within one read
+	maskOverlap = 512
+	// maxMaskPending bounds the data held back while a match is still growing
+	maxMaskPending = 4 * maskChunkSize
+)
+
+// DefaultMaxBufferBytes is the amount of decompressed body buffered to recompute
+// Content-Length when no limit is configured
+const DefaultMaxBufferBytes = 1024 * 1024
+
+// MaskBody masks the findings of the given detectors in a response body.
+// Masking keeps the length of the data, so uncompressed bodies are masked as
+// they stream and keep their Content-Length. Compressed bodies and bodies of
+// unknown length are decompressed and buffered up to max bytes to compute the
+// new Content-Length; longer bodies are streamed without one.
+func MaskBody(resp *http.Response, detectors []string, max int64) error {
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if (encoding == "" || encoding == "identity") && resp.ContentLength >= 0 {
+		resp.Body = &body{Reader: NewMaskingReader(resp.Body, detectors), Closer: resp.Body}
+		return nil
+	}
+
+	if max <= 0 {
+		max = DefaultMaxBufferBytes
+	}
+	b, err := ReadBody(resp, max)
+	if err != nil {
+		b.Restore(resp)
+		return err
+	}
+	if b.Complete {
+		b.Replace(resp, Mask(b.Text, Detect(b.Text, detectors)))
+		return nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Body = &body{
+		Reader: NewMaskingReader(io.MultiReader(strings.NewReader(b.Text), b.decoded), detectors),
+		Closer: b.original,
+	}
+	return nil
+}
+
+// maskingReader masks detector findings in a stream. It holds back the end of
+// each read until the following data shows whether a match continues into it.
+type maskingReader struct {
+	src       io.Reader
+	detectors []string
+	chunk     []byte
+	pending   []byte
+	out       []byte
+	err       error
+}
+
+// NewMaskingReader returns a reader that masks the findings of the given
+// detectors in the data read from r
+func NewMaskingReader(r io.Reader, detectors []string) io.Reader {
+	return &maskingReader{src: r, detectors: detectors, chunk: make([]byte, maskChunkSize)}
+}
+
+func (m *maskingReader) Read(p []byte) (int, error) {
+	for len(m.out) == 0 {
+		if m.err != nil {
+			return 0, m.err
+		}
+		m.fill()
+	}
+	n := copy(p, m.out)
+	m.out = m.out[n:]
+	return n, nil
+}
+
+// fill reads from the source and moves the data that can no longer be part of
+// an incomplete match to the output, masked
+func (m *maskingReader) fill() {
+	n, err := m.src.Read(m.chunk)
+	m.pending = append(m.pending, m.chunk[:n]...)
+	if err != nil {
+		m.err = err
+		m.emit(len(m.pending))
+		return
+	}
+	if len(m.pending) <= maskOverlap {
+		return
+	}
+	m.emit(m.cut())
+}
+
+// cut returns how much pending data can be emitted: everything except the
+// overlap, without splitting a match or a run of word characters
+func (m *maskingReader) cut() int {
+	cut := len(m.pending) - maskOverlap
+	findings := Detect(string(m.pending), m.detectors)
+	for moved := true; moved; {
+		moved = false
+		for _, f := range findings {
+			if f.Start < cut && f.End > cut {
+				cut = f.Start
+				moved = true
+			}
+		}
+	}
+	floor := cut - maskOverlap
+	for cut > 0 && cut > floor && isWordByte(m.pending[cut-1]) && isWordByte(m.pending[cut]) {
+		cut--
+	}
+	if cut <= floor {
+		// A run of word characters longer than the overlap cannot be a single match
+		cut = floor + maskOverlap
+	}
+	if cut <= 0 && len(m.pending) >= maxMaskPending {
+		cut = len(m.pending)
+	}
+	return cut
+}
+
+// emit masks and outputs the first n pending bytes
+func (m *maskingReader) emit(n int) {
+	if n <= 0 {
+		return
+	}
+	data := string(m.pending[:n])
+	m.out = []byte(Mask(data, Detect(data, m.detectors)))
+	m.pending = append(m.pending[:0], m.pending[n:]...)
+}
+
+// isWordByte reports whether c can be part of the data the detectors match
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c == '_' || c == '-' || c == '.' || c == '@' || c == '+' || c == '%'
+}
@@ -0,0 +1,33 @@
+package state
+
+import (
+	"errors"
+	"time"
+)
+
+// Fail modes applied when the backend is unreachable
+const (
+	// FailOpen decides from locally cached state
+	FailOpen = "open"
+	// FailClosed rejects requests whose shared state cannot be verified
+	FailClosed = "closed"
+)
+
+// ErrClosed is returned by a backend after Close
+var ErrClosed = errors.New("state backend closed")
+
+// Backend stores state shared by WAF replicas, such as rate limit counters
+// and bans. Keys expire after their TTL.
+type Backend interface {
+	// IncrBy adds delta to a counter, creating it with the given TTL, and returns the new value
+	IncrBy(key string, delta int64, ttl time.Duration) (int64, error)
+	// Get returns a value; ok is false if the key does not exist
+	Get(key string) (value string, ok bool, err error)
+	// Set stores a value with a TTL
+	Set(key, value string, ttl time.Duration) error
+	// Delete removes a key
+	Delete(key string) error
+	// Keys returns the keys starting with prefix
+	Keys(prefix string) ([]string, error)
+	Close() error
+}
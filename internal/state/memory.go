@@ -0,0 +1,154 @@
+package state
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryItem is a value with its expiry
+type memoryItem struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryBackend keeps state in process. It is the default for single instances.
+type MemoryBackend struct {
+	items   map[string]memoryItem
+	mu      sync.Mutex
+	cleanup *time.Ticker
+	done    chan struct{}
+	closed  bool
+}
+
+// NewMemoryBackend creates an in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	b := &MemoryBackend{
+		items:   make(map[string]memoryItem),
+		cleanup: time.NewTicker(1 * time.Minute),
+		done:    make(chan struct{}),
+	}
+	go b.cleanupExpired()
+	return b
+}
+
+// IncrBy adds delta to a counter
+func (b *MemoryBackend) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+
+	item, ok := b.live(key)
+	if !ok {
+		item = memoryItem{value: "0", expiresAt: time.Now().Add(ttl)}
+	}
+	n, err := strconv.ParseInt(item.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+	item.value = strconv.FormatInt(n, 10)
+	b.items[key] = item
+	return n, nil
+}
+
+// Get returns a value
+func (b *MemoryBackend) Get(key string) (string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return "", false, ErrClosed
+	}
+
+	item, ok := b.live(key)
+	return item.value, ok, nil
+}
+
+// Set stores a value with a TTL
+func (b *MemoryBackend) Set(key, value string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.items[key] = memoryItem{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Delete removes a key
+func (b *MemoryBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	delete(b.items, key)
+	return nil
+}
+
+// Keys returns the live keys starting with prefix
+func (b *MemoryBackend) Keys(prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	var keys []string
+	for key := range b.items {
+		if _, ok := b.live(key); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Close stops the cleanup goroutine
+func (b *MemoryBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.cleanup.Stop()
+		close(b.done)
+	}
+	return nil
+}
+
+// live returns an unexpired item. Caller must hold b.mu.
+func (b *MemoryBackend) live(key string) (memoryItem, bool) {
+	item, ok := b.items[key]
+	if !ok {
+		return memoryItem{}, false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(b.items, key)
+		return memoryItem{}, false
+	}
+	return item, true
+}
+
+// cleanupExpired removes expired items
+func (b *MemoryBackend) cleanupExpired() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.cleanup.C:
+		}
+
+		b.mu.Lock()
+		now := time.Now()
+		for key, item := range b.items {
+			if now.After(item.expiresAt) {
+				delete(b.items, key)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,370 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisOptions configures the Redis backend
+type RedisOptions struct {
+	Address  string
+	Password string
+	DB       int
+	// KeyPrefix namespaces all keys, e.g. "waf:"
+	KeyPrefix string
+	Timeout   time.Duration
+	PoolSize  int
+}
+
+// RedisBackend speaks the Redis protocol (RESP) to Redis or any compatible server
+type RedisBackend struct {
+	opts RedisOptions
+	pool chan *redisConn
+	mu   sync.Mutex
+	done bool
+}
+
+// redisConn is a pooled connection with buffered I/O
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisBackend creates a Redis backend. Connections are opened lazily, so an
+// unreachable server is reported by the first command rather than here.
+func NewRedisBackend(opts RedisOptions) *RedisBackend {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 500 * time.Millisecond
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	return &RedisBackend{opts: opts, pool: make(chan *redisConn, opts.PoolSize)}
+}
+
+// IncrBy adds delta to a counter and sets its TTL when the counter is created.
+// Both run in one MULTI/EXEC transaction, so a counter never exists without a TTL.
+func (b *RedisBackend) IncrBy(key string, delta int64, ttl time.Duration) (int64, error) {
+	key = b.opts.KeyPrefix + key
+	replies, err := b.transaction(
+		[]string{"SET", key, "0", "PX", strconv.FormatInt(ttl.Milliseconds(), 10), "NX"},
+		[]string{"INCRBY", key, strconv.FormatInt(delta, 10)},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if err, ok := replies[1].(error); ok {
+		return 0, err
+	}
+	n, ok := replies[1].(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %v", replies[1])
+	}
+	return n, nil
+}
+
+// Get returns a value
+func (b *RedisBackend) Get(key string) (string, bool, error) {
+	reply, err := b.do("GET", b.opts.KeyPrefix+key)
+	if err != nil || reply == nil {
+		return "", false, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return "", false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+// Set stores a value with a TTL
+func (b *RedisBackend) Set(key, value string, ttl time.Duration) error {
+	_, err := b.do("SET", b.opts.KeyPrefix+key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete removes a key
+func (b *RedisBackend) Delete(key string) error {
+	_, err := b.do("DEL", b.opts.KeyPrefix+key)
+	return err
+}
+
+// Keys returns the keys starting with prefix using SCAN
+func (b *RedisBackend) Keys(prefix string) ([]string, error) {
+	pattern := escapeGlob(b.opts.KeyPrefix+prefix) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := b.do("SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = parts[0].(string)
+		batch, _ := parts[1].([]interface{})
+		for _, k := range batch {
+			if s, ok := k.(string); ok {
+				keys = append(keys, strings.TrimPrefix(s, b.opts.KeyPrefix))
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// Close closes all pooled connections
+func (b *RedisBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return nil
+	}
+	b.done = true
+	for {
+		select {
+		case c := <-b.pool:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends a command and reads its reply. Connections that fail are discarded.
+func (b *RedisBackend) do(args ...string) (interface{}, error) {
+	c, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn.SetDeadline(time.Now().Add(b.opts.Timeout))
+	reply, err := c.command(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	b.put(c)
+	return reply, err
+}
+
+// transaction runs commands atomically in MULTI/EXEC and returns their replies.
+// Commands that fail inside the transaction are returned as error replies.
+func (b *RedisBackend) transaction(cmds ...[]string) ([]interface{}, error) {
+	c, err := b.get()
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn.SetDeadline(time.Now().Add(b.opts.Timeout))
+	replies, err := c.transaction(cmds)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	b.put(c)
+	return replies, err
+}
+
+// get takes a pooled connection or dials a new one
+func (b *RedisBackend) get() (*redisConn, error) {
+	b.mu.Lock()
+	done := b.done
+	b.mu.Unlock()
+	if done {
+		return nil, ErrClosed
+	}
+
+	select {
+	case c := <-b.pool:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", b.opts.Address, b.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	conn.SetDeadline(time.Now().Add(b.opts.Timeout))
+	if b.opts.Password != "" {
+		if _, err := c.command("AUTH", b.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if b.opts.DB != 0 {
+		if _, err := c.command("SELECT", strconv.Itoa(b.opts.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full or closed
+func (b *RedisBackend) put(c *redisConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		c.conn.Close()
+		return
+	}
+	select {
+	case b.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// command writes a RESP array of bulk strings and reads the reply
+func (c *redisConn) command(args ...string) (interface{}, error) {
+	c.write(args)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// transaction pipelines MULTI, the commands and EXEC, and returns the replies
+// to the commands. All replies are read so the connection stays usable.
+func (c *redisConn) transaction(cmds [][]string) ([]interface{}, error) {
+	c.write([]string{"MULTI"})
+	for _, cmd := range cmds {
+		c.write(cmd)
+	}
+	c.write([]string{"EXEC"})
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// MULTI and each queued command are acknowledged before the EXEC reply
+	var queueErr error
+	for i := 0; i <= len(cmds); i++ {
+		if _, err := readReply(c.r); err != nil {
+			var replyErr redisError
+			if !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			if queueErr == nil {
+				queueErr = err
+			}
+		}
+	}
+	reply, err := readReply(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if queueErr != nil {
+		return nil, queueErr
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(cmds) {
+		return nil, fmt.Errorf("redis: unexpected EXEC reply %v", reply)
+	}
+	return replies, nil
+}
+
+// write buffers a RESP array of bulk strings
+func (c *redisConn) write(args []string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readReply parses one RESP reply. Bulk strings are returned as string, integers
+// as int64, arrays as []interface{} and nil bulk strings or arrays as nil.
+// Error replies are returned as a redisError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			// Error replies inside an array, as from EXEC, are returned as items
+			item, err := readReply(r)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
+
+// readLine reads a CRLF terminated line without the terminator
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// escapeGlob escapes Redis glob metacharacters in a literal key prefix
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
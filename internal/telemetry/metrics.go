@@ -1,6 +1,7 @@
 package telemetry
 
 import (
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -8,14 +9,28 @@ import (
 
 // Metrics tracks WAF performance and security metrics
 type Metrics struct {
-	TotalRequests     int64
-	BlockedRequests   int64
-	AllowedRequests   int64
-	TotalLatency      int64 // nanoseconds
-	RuleMatches       sync.Map // map[string]int64
-	StartTime         time.Time
+	TotalRequests   int64
+	BlockedRequests int64
+	AllowedRequests int64
+	TotalLatency    int64    // nanoseconds
+	RuleMatches     sync.Map // map[string]int64
+	VariableMatches sync.Map // map[string]int64
+	variableCount   int64
+	// RejectedConnections counts connections closed by the listener, by reason
+	RejectedConnections sync.Map // map[string]int64
+	SlowRequests        int64
+	// ResponseActions counts upstream responses blocked or masked, by action
+	ResponseActions sync.Map // map[string]int64
+	// Challenges counts challenge pages issued and solved or failed, by outcome
+	Challenges sync.Map // map[string]int64
+	StartTime  time.Time
 }
 
+// maxTrackedVariables bounds the number of distinct variable names tracked. Variable
+// names embed client-chosen parameter and header names, so once the limit is reached
+// new names are counted under their collection (e.g. ARGS) instead.
+const maxTrackedVariables = 1000
+
 var globalMetrics = &Metrics{
 	StartTime: time.Now(),
 }
@@ -47,10 +62,48 @@ func (m *Metrics) AddLatency(nanoseconds int64) {
 
 // IncrementRuleMatch increments the match count for a specific rule
 func (m *Metrics) IncrementRuleMatch(ruleID string) {
+	incrementCounter(&m.RuleMatches, ruleID)
+}
+
+// IncrementVariableMatch increments the match count for a request variable (e.g. ARGS:q)
+func (m *Metrics) IncrementVariableMatch(variable string) {
+	if _, ok := m.VariableMatches.Load(variable); !ok {
+		if atomic.AddInt64(&m.variableCount, 1) > maxTrackedVariables {
+			atomic.AddInt64(&m.variableCount, -1)
+			if i := strings.Index(variable, ":"); i >= 0 {
+				variable = variable[:i]
+			}
+		}
+	}
+	incrementCounter(&m.VariableMatches, variable)
+}
+
+// IncrementRejectedConnection counts a connection rejected by a listener limit
+func (m *Metrics) IncrementRejectedConnection(reason string) {
+	incrementCounter(&m.RejectedConnections, reason)
+}
+
+// IncrementSlowRequests counts a request aborted for a slow request body
+func (m *Metrics) IncrementSlowRequests() {
+	atomic.AddInt64(&m.SlowRequests, 1)
+}
+
+// IncrementResponseAction counts an upstream response blocked or masked by the response phase
+func (m *Metrics) IncrementResponseAction(action string) {
+	incrementCounter(&m.ResponseActions, action)
+}
+
+// IncrementChallenge counts a challenge outcome: issued, passed or failed
+func (m *Metrics) IncrementChallenge(outcome string) {
+	incrementCounter(&m.Challenges, outcome)
+}
+
+// incrementCounter atomically increments an int64 counter stored in a sync.Map
+func incrementCounter(counters *sync.Map, key string) {
 	for {
-		value, _ := m.RuleMatches.LoadOrStore(ruleID, int64(0))
+		value, _ := counters.LoadOrStore(key, int64(0))
 		oldCount := value.(int64)
-		if m.RuleMatches.CompareAndSwap(ruleID, oldCount, oldCount+1) {
+		if counters.CompareAndSwap(key, oldCount, oldCount+1) {
 			break
 		}
 	}
@@ -85,6 +138,45 @@ func (m *Metrics) GetStats() map[string]interface{} {
 		stats["rule_matches"] = ruleStats
 	}
 
+	variableStats := make(map[string]int64)
+	m.VariableMatches.Range(func(key, value interface{}) bool {
+		variableStats[key.(string)] = value.(int64)
+		return true
+	})
+	if len(variableStats) > 0 {
+		stats["variable_matches"] = variableStats
+	}
+
+	rejectedStats := make(map[string]int64)
+	m.RejectedConnections.Range(func(key, value interface{}) bool {
+		rejectedStats[key.(string)] = value.(int64)
+		return true
+	})
+	if len(rejectedStats) > 0 {
+		stats["rejected_connections"] = rejectedStats
+	}
+	if slow := atomic.LoadInt64(&m.SlowRequests); slow > 0 {
+		stats["slow_requests"] = slow
+	}
+
+	responseStats := make(map[string]int64)
+	m.ResponseActions.Range(func(key, value interface{}) bool {
+		responseStats[key.(string)] = value.(int64)
+		return true
+	})
+	if len(responseStats) > 0 {
+		stats["response_actions"] = responseStats
+	}
+
+	challengeStats := make(map[string]int64)
+	m.Challenges.Range(func(key, value interface{}) bool {
+		challengeStats[key.(string)] = value.(int64)
+		return true
+	})
+	if len(challengeStats) > 0 {
+		stats["challenges"] = challengeStats
+	}
+
 	return stats
 }
 
@@ -98,6 +190,23 @@ func (m *Metrics) Reset() {
 		m.RuleMatches.Delete(key)
 		return true
 	})
+	m.VariableMatches.Range(func(key, value interface{}) bool {
+		m.VariableMatches.Delete(key)
+		return true
+	})
+	atomic.StoreInt64(&m.variableCount, 0)
+	m.RejectedConnections.Range(func(key, value interface{}) bool {
+		m.RejectedConnections.Delete(key)
+		return true
+	})
+	atomic.StoreInt64(&m.SlowRequests, 0)
+	m.ResponseActions.Range(func(key, value interface{}) bool {
+		m.ResponseActions.Delete(key)
+		return true
+	})
+	m.Challenges.Range(func(key, value interface{}) bool {
+		m.Challenges.Delete(key)
+		return true
+	})
 	m.StartTime = time.Now()
 }
-
@@ -7,13 +7,22 @@ import (
 
 // PrometheusMetrics holds Prometheus metric collectors
 type PrometheusMetrics struct {
-	RequestsTotal      *prometheus.CounterVec
-	RequestsBlocked    prometheus.Counter
-	RequestsAllowed    prometheus.Counter
-	RequestDuration    *prometheus.HistogramVec
-	AnomalyScore       *prometheus.HistogramVec
-	RuleMatches        *prometheus.CounterVec
-	ActiveConnections  prometheus.Gauge
+	RequestsTotal     *prometheus.CounterVec
+	RequestsBlocked   prometheus.Counter
+	RequestsAllowed   prometheus.Counter
+	RequestDuration   *prometheus.HistogramVec
+	AnomalyScore      *prometheus.HistogramVec
+	RuleMatches       *prometheus.CounterVec
+	VariableMatches   *prometheus.CounterVec
+	ActiveConnections prometheus.Gauge
+	// ConnectionsRejected counts connections closed by the listener limits
+	ConnectionsRejected *prometheus.CounterVec
+	// SlowRequests counts requests aborted for sending their body too slowly
+	SlowRequests prometheus.Counter
+	// ResponseActions counts upstream responses blocked or masked by the response phase
+	ResponseActions *prometheus.CounterVec
+	// Challenges counts challenge pages issued and solved or failed
+	Challenges *prometheus.CounterVec
 }
 
 var promMetrics *PrometheusMetrics
@@ -67,12 +76,46 @@ func InitPrometheus() *PrometheusMetrics {
 			},
 			[]string{"rule_id", "rule_tag"},
 		),
+		VariableMatches: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "waf_variable_matches_total",
+				Help: "Total number of rule matches by request variable collection",
+			},
+			[]string{"rule_id", "collection"},
+		),
 		ActiveConnections: promauto.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "waf_active_connections",
 				Help: "Number of active connections",
 			},
 		),
+		ConnectionsRejected: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "waf_connections_rejected_total",
+				Help: "Total number of connections rejected by connection limits",
+			},
+			[]string{"reason"},
+		),
+		SlowRequests: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "waf_slow_requests_total",
+				Help: "Total number of requests aborted for a request body below the minimum data rate",
+			},
+		),
+		ResponseActions: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "waf_response_actions_total",
+				Help: "Total number of upstream responses blocked or masked by response inspection",
+			},
+			[]string{"action"},
+		),
+		Challenges: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "waf_challenges_total",
+				Help: "Total number of challenges issued, passed and failed",
+			},
+			[]string{"outcome"},
+		),
 	}
 
 	return promMetrics
@@ -85,4 +128,3 @@ func GetPrometheusMetrics() *PrometheusMetrics {
 	}
 	return promMetrics
 }
-
@@ -0,0 +1,114 @@
+package benchmark
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/ipfilter"
+)
+
+// randomPrefixes generates n IPv4 (/16-/32) or IPv6 (/32-/128) prefixes
+func randomPrefixes(rng *rand.Rand, n int, v6 bool) []string {
+	entries := make([]string, n)
+	for i := range entries {
+		if v6 {
+			var b [16]byte
+			rng.Read(b[:])
+			b[0] = 0x20
+			entries[i] = netip.PrefixFrom(netip.AddrFrom16(b), 32+rng.Intn(97)).Masked().String()
+		} else {
+			var b [4]byte
+			rng.Read(b[:])
+			entries[i] = netip.PrefixFrom(netip.AddrFrom4(b), 16+rng.Intn(17)).Masked().String()
+		}
+	}
+	return entries
+}
+
+// randomAddrs generates lookup addresses of the requested family
+func randomAddrs(rng *rand.Rand, n int, v6 bool) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		if v6 {
+			var b [16]byte
+			rng.Read(b[:])
+			b[0] = 0x20
+			addrs[i] = netip.AddrFrom16(b).String()
+		} else {
+			var b [4]byte
+			rng.Read(b[:])
+			addrs[i] = netip.AddrFrom4(b).String()
+		}
+	}
+	return addrs
+}
+
+func BenchmarkIPFilterLookup(b *testing.B) {
+	for _, v6 := range []bool{false, true} {
+		for _, size := range []int{1000, 100000, 500000} {
+			family := "ipv4"
+			if v6 {
+				family = "ipv6"
+			}
+			b.Run(fmt.Sprintf("%s/%d", family, size), func(b *testing.B) {
+				rng := rand.New(rand.NewSource(42))
+				filter := ipfilter.NewIPFilter()
+				for _, entry := range randomPrefixes(rng, size, v6) {
+					if err := filter.AddToBlacklist(entry); err != nil {
+						b.Fatal(err)
+					}
+				}
+				addrs := randomAddrs(rng, 1024, v6)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					filter.IsBlacklisted(addrs[i%len(addrs)])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkIPFilterInsert(b *testing.B) {
+	rng := rand.New(rand.NewSource(42))
+	entries := randomPrefixes(rng, 100000, false)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filter := ipfilter.NewIPFilter()
+		for _, entry := range entries {
+			filter.AddToBlacklist(entry)
+		}
+	}
+}
+
+// BenchmarkLinearCIDRScan measures the previous map-based approach, which parsed
+// every CIDR string on each lookup, as a baseline
+func BenchmarkLinearCIDRScan(b *testing.B) {
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("ipv4/%d", size), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(42))
+			list := make(map[string]bool, size)
+			for _, entry := range randomPrefixes(rng, size, false) {
+				list[entry] = true
+			}
+			addrs := randomAddrs(rng, 1024, false)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ip := net.ParseIP(addrs[i%len(addrs)])
+				for cidr := range list {
+					if _, ipnet, err := net.ParseCIDR(cidr); err == nil && ipnet.Contains(ip) {
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package benchmark
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/ratelimit"
+)
+
+var rateLimitAlgorithms = []string{
+	ratelimit.AlgorithmSlidingLog,
+	ratelimit.AlgorithmSlidingWindow,
+	ratelimit.AlgorithmTokenBucket,
+	ratelimit.AlgorithmGCRA,
+}
+
+func newBenchLimiter(b *testing.B, algorithm string, limit int) ratelimit.Limiter {
+	limiter, err := ratelimit.New(ratelimit.Config{
+		Algorithm: algorithm,
+		Limit:     limit,
+		Window:    time.Minute,
+		MaxKeys:   100000,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(limiter.Stop)
+	return limiter
+}
+
+// BenchmarkRateLimitHotKey hammers a single key from all goroutines, which is
+// the worst case for the sliding log (its history grows to the full limit)
+func BenchmarkRateLimitHotKey(b *testing.B) {
+	for _, algorithm := range rateLimitAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			limiter := newBenchLimiter(b, algorithm, 10000)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					limiter.Allow("203.0.113.7")
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRateLimitManyKeys spreads requests over many client keys
+func BenchmarkRateLimitManyKeys(b *testing.B) {
+	keys := make([]string, 50000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+
+	for _, algorithm := range rateLimitAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			limiter := newBenchLimiter(b, algorithm, 100)
+			var counter uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddUint64(&counter, 1)
+					limiter.Allow(keys[i%uint64(len(keys))])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/ipfilter"
+	"github.com/waf-draft/waf/internal/logging"
+)
+
+// newTestHandler creates a WAF handler for a custom configuration
+func newTestHandler(t *testing.T, cfg *config.Config) *httpserver.WAFHandler {
+	if len(cfg.Rules.Files) == 0 {
+		cfg.Rules.Files = []string{"../../configs/ruleset.yaml"}
+	}
+	if cfg.Security.AnomalyThreshold == 0 {
+		cfg.Security.AnomalyThreshold = 10
+	}
+	ruleSet, err := rules.LoadRules(cfg.Rules.Files)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if cfg.Logging.Output == "" {
+		cfg.Logging.Output = filepath.Join(t.TempDir(), "waf.log")
+	}
+	logger, err := logging.NewLogger(cfg.Logging.Output)
+	if err != nil {
+		t.Fatalf("Failed to initialize logger: %v", err)
+	}
+	proxy, err := httpserver.NewProxy(cfg.Server.UpstreamURL)
+	if err != nil {
+		t.Fatalf("Failed to create reverse proxy: %v", err)
+	}
+	handler, err := httpserver.NewWAFHandler(cfg, ruleSet, logger, proxy)
+	if err != nil {
+		t.Fatalf("Failed to create WAF handler: %v", err)
+	}
+	t.Cleanup(func() {
+		handler.Close()
+		logger.Close()
+	})
+	return handler
+}
+
+func TestBanAfterRepeatedBlocks(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Admin.Token = "secret"
+	cfg.Security.Bans = config.BanConfig{
+		Enabled:          true,
+		BlockThreshold:   2,
+		WindowSeconds:    60,
+		BanSeconds:       60,
+		EscalationFactor: 2,
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("/api/users?id=1"); code != http.StatusOK {
+		t.Fatalf("Expected 200 before ban, got %d", code)
+	}
+	get("/api/users?id=1%20OR%201=1")
+	get("/api/users?id=1%20OR%201=1")
+	if code := get("/api/users?id=1"); code != http.StatusForbidden {
+		t.Fatalf("Expected benign request to be rejected while banned, got %d", code)
+	}
+
+	// Listing requires the admin token
+	if code := get("/admin/api/v1/bans"); code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without token, got %d", code)
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/admin/api/v1/bans", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var listing struct {
+		Bans []ipfilter.Ban `json:"bans"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if len(listing.Bans) != 1 || listing.Bans[0].IP != "127.0.0.1" {
+		t.Fatalf("Expected one ban for 127.0.0.1, got %+v", listing.Bans)
+	}
+
+	req, _ = http.NewRequest("DELETE", server.URL+"/admin/api/v1/bans/127.0.0.1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected unban to succeed, got %d", resp.StatusCode)
+	}
+	if code := get("/api/users?id=1"); code != http.StatusOK {
+		t.Fatalf("Expected 200 after unban, got %d", code)
+	}
+}
+
+func TestManualBanViaAdminAPI(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = "http://127.0.0.1:1"
+	cfg.Admin.Token = "secret"
+	cfg.Security.Bans.Enabled = true
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	post := func(body string) int {
+		req, _ := http.NewRequest("POST", server.URL+"/admin/api/v1/bans", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(`{"ip": "not-an-ip"}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for invalid ip, got %d", code)
+	}
+	if code := post(`{"ip": "2001:db8::1", "duration_seconds": 30}`); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if _, banned := handler.Bans().IsBanned("2001:db8::1"); !banned {
+		t.Fatal("Expected manual ban to be active")
+	}
+
+	unban := func(ip string) int {
+		req, _ := http.NewRequest("DELETE", server.URL+"/admin/api/v1/bans/"+ip, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := unban("not-an-ip"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid ip, got %d", code)
+	}
+	// A non-canonical spelling lifts the ban created for the canonical address
+	if code := unban("2001:DB8:0::1"); code != http.StatusOK {
+		t.Errorf("Expected unban to succeed, got %d", code)
+	}
+	if _, banned := handler.Bans().IsBanned("2001:db8::1"); banned {
+		t.Error("Expected the ban to be lifted")
+	}
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = "http://127.0.0.1:1"
+	cfg.Security.Bans.Enabled = true
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	body := bytes.NewBufferString(`{"ip": "2001:db8::1", "duration_seconds": 30}`)
+	resp, err := http.Post(server.URL+"/admin/api/v1/bans", "application/json", body)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		t.Fatal("Expected the management API not to be served without a token")
+	}
+	if _, banned := handler.Bans().IsBanned("2001:db8::1"); banned {
+		t.Fatal("Expected no ban to be created without a token")
+	}
+}
+
+func TestBanEscalationAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	policy := ipfilter.BanPolicy{
+		BanRules:         []string{"CI-001"},
+		Duration:         time.Minute,
+		MaxDuration:      3 * time.Minute,
+		EscalationFactor: 2,
+	}
+
+	bans, err := ipfilter.NewBanManager(policy, path)
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	var durations []time.Duration
+	for i := 0; i < 3; i++ {
+		ban, ok := bans.RecordBlock("203.0.113.5", []string{"CI-001"})
+		if !ok {
+			t.Fatalf("Offense %d: expected ban", i+1)
+		}
+		durations = append(durations, ban.ExpiresAt.Sub(ban.CreatedAt))
+		bans.Unban("203.0.113.5")
+	}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute}
+	for i := range want {
+		if durations[i] != want[i] {
+			t.Errorf("Offense %d: expected %s, got %s", i+1, want[i], durations[i])
+		}
+	}
+
+	bans.Ban("203.0.113.9", "manual", time.Hour)
+	if err := bans.Stop(); err != nil {
+		t.Fatalf("Failed to save bans: %v", err)
+	}
+
+	restored, err := ipfilter.NewBanManager(policy, path)
+	if err != nil {
+		t.Fatalf("Failed to restore bans: %v", err)
+	}
+	defer restored.Stop()
+	if _, banned := restored.IsBanned("203.0.113.9"); !banned {
+		t.Error("Expected ban to survive restart")
+	}
+	ban, _ := restored.RecordBlock("203.0.113.5", []string{"CI-001"})
+	if ban.Offense != 4 {
+		t.Errorf("Expected offense history to survive restart, got offense %d", ban.Offense)
+	}
+}
+
+func TestBanPersistenceInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	bans, err := ipfilter.NewBanManager(ipfilter.BanPolicy{}, path)
+	if err != nil {
+		t.Fatalf("Failed to create ban manager: %v", err)
+	}
+	defer bans.Stop()
+
+	for i := 0; i < 50; i++ {
+		bans.Ban(fmt.Sprintf("203.0.113.%d", i), "manual", time.Hour)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Expected bans to be written in the background, not on every ban")
+	}
+	waitFor(t, 5*time.Second, "ban file", func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Contains(string(data), `"203.0.113.49"`)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- bans.Stop() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Failed to stop ban manager: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Stop to return")
+	}
+}
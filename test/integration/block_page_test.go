@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/mitigation"
+)
+
+func TestNegotiateBlockFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mitigation.FormatJSON},
+		{"*/*", mitigation.FormatJSON},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", mitigation.FormatHTML},
+		{"application/json, text/plain, */*", mitigation.FormatJSON},
+		{"text/plain", mitigation.FormatText},
+		{"text/*", mitigation.FormatHTML},
+		{"text/html;q=0.5, text/plain", mitigation.FormatText},
+		{"text/html;q=0, */*", mitigation.FormatJSON},
+		{"image/png", mitigation.FormatJSON},
+	}
+	for _, tt := range tests {
+		if got := mitigation.NegotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("Accept %q: expected %s, got %s", tt.accept, tt.want, got)
+		}
+	}
+}
+
+func TestBlockPages(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	htmlTemplate := filepath.Join(dir, "block.html")
+	apiTemplate := filepath.Join(dir, "api.json")
+	writeFile := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write template: %v", err)
+		}
+	}
+	writeFile(htmlTemplate, `<h1>Blocked</h1><p>{{.RequestID}}</p><p>{{.Reason}}</p>`)
+	writeFile(apiTemplate, `{"code":{{.Status}},"ref":{{json .RequestID}}}`)
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.BlockPage = config.BlockPageConfig{
+		HideReason: true,
+		Support:    "Contact support@example.com",
+		Templates:  config.BlockTemplatesConfig{HTML: htmlTemplate},
+		Routes: []config.BlockPageRouteConfig{{
+			Match:     config.RouteMatchConfig{PathPrefix: "/api/"},
+			Status:    http.StatusNotAcceptable,
+			Templates: config.BlockTemplatesConfig{JSON: apiTemplate},
+		}},
+		ReloadSeconds: 1,
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	get := func(path, accept, requestID string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path+"?id=1%20OR%201=1", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		req.Header.Set("X-Request-ID", requestID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	// Built-in JSON without the reason
+	resp, body := get("/search", "", "req-1")
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatalf("Expected a JSON block response, got %q", body)
+	}
+	if resp.StatusCode != http.StatusForbidden || payload["request_id"] != "req-1" || payload["support"] != "Contact support@example.com" {
+		t.Errorf("Unexpected JSON block response %d %v", resp.StatusCode, payload)
+	}
+	if _, ok := payload["reason"]; ok || strings.Contains(body, "threshold") {
+		t.Errorf("Expected the reason to be hidden, got %q", body)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %q", got)
+	}
+
+	// HTML template for browsers
+	resp, body = get("/search", "text/html,*/*;q=0.8", "req-2")
+	if want := "<h1>Blocked</h1><p>req-2</p><p></p>"; body != want {
+		t.Errorf("Expected HTML %q, got %q", want, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected an HTML content type, got %q", ct)
+	}
+
+	// Built-in plain text
+	_, body = get("/search", "text/plain", "req-3")
+	if !strings.HasPrefix(body, "403 Forbidden: Request blocked by WAF") || !strings.Contains(body, "Request ID: req-3") {
+		t.Errorf("Unexpected text block response %q", body)
+	}
+
+	// Route status and template
+	resp, body = get("/api/users", "application/json", "req-4")
+	if resp.StatusCode != http.StatusNotAcceptable || body != `{"code":406,"ref":"req-4"}` {
+		t.Errorf("Unexpected route block response %d %q", resp.StatusCode, body)
+	}
+
+	// Request IDs that are not safe to echo are replaced with a generated one
+	for _, id := range []string{"<b>req-2</b>", `req-"4"`, strings.Repeat("a", 129)} {
+		_, body = get("/search", "text/html", id)
+		if !regexp.MustCompile(`^<h1>Blocked</h1><p>[0-9a-f]{24}</p><p></p>$`).MatchString(body) {
+			t.Errorf("Expected request ID %q to be replaced, got %q", id, body)
+		}
+	}
+
+	// Routes match the normalized path, not the raw one
+	resp, _ = get("/%2561pi/users", "application/json", "req-5")
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("Expected the route to match an encoded path, got %d", resp.StatusCode)
+	}
+
+	// Templates are reloaded when they change
+	writeFile(htmlTemplate, `<h1>Access denied</h1>`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, body = get("/search", "text/html", "req-6"); body == "<h1>Access denied</h1>" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Template was not reloaded, got %q", body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
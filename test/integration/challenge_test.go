@@ -0,0 +1,360 @@
+package integration
+
+import (
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+)
+
+// challengeToken extracts the challenge token from a challenge page
+var challengeToken = regexp.MustCompile(`name="token" value="([^"]+)"`)
+
+// solveChallenge finds a nonce whose hash with the token has the given number
+// of leading zero bits, as the challenge page script does
+func solveChallenge(token string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		hash := sha256.Sum256([]byte(token + ":" + strconv.Itoa(nonce)))
+		zeros := 0
+		for _, b := range hash {
+			zeros += bits.LeadingZeros8(b)
+			if b != 0 {
+				break
+			}
+		}
+		if zeros >= difficulty {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "CHAL-LOGIN"
+  name: "Challenge logins"
+  severity: 0
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/login"
+  actions:
+    - type: "challenge"
+
+- id: "CHAL-SCORE"
+  name: "Borderline request"
+  severity: 5
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/suspicious"
+  actions:
+    - type: "add_score"
+`)
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	const difficulty = 8
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.Challenge = config.ChallengeConfig{
+		Enabled:              true,
+		ScoreThreshold:       5,
+		Difficulty:           difficulty,
+		Secret:               "test-secret",
+		CookieName:           "waf_clearance",
+		ClearanceSeconds:     3600,
+		ChallengeSeconds:     300,
+		Path:                 "/.waf/challenge",
+		Fallback:             true,
+		FallbackDelaySeconds: 0,
+	}
+	cfg.Security.RateLimit = config.RateLimitConfig{
+		Enabled:       true,
+		MaxRequests:   100,
+		WindowSeconds: 60,
+		Policies: []config.RateLimitPolicyConfig{{
+			Name:        "export",
+			Match:       config.RateLimitMatchConfig{PathPrefix: "/export"},
+			MaxRequests: 1,
+			Action:      "challenge",
+		}},
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	do := func(method, path, ua string, form url.Values, cookie *http.Cookie) (*http.Response, string) {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("User-Agent", ua)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+	challenge := func(path, ua string) string {
+		resp, body := do(http.MethodGet, path, ua, nil, nil)
+		m := challengeToken.FindStringSubmatch(body)
+		if resp.StatusCode != http.StatusForbidden || m == nil {
+			t.Fatalf("%s: expected a challenge page, got %d %.200q", path, resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Expected Cache-Control no-store on the challenge page, got %q", got)
+		}
+		return m[1]
+	}
+
+	// Solving the challenge sets a clearance cookie and returns to the page
+	token := challenge("/login?next=/account", "browser-a")
+	resp, _ := do(http.MethodPost, "/.waf/challenge", "browser-a", url.Values{
+		"token":  {token},
+		"nonce":  {solveChallenge(token, difficulty)},
+		"return": {"/login?next=/account"},
+	}, nil)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?next=/account" {
+		t.Fatalf("Expected a redirect back to the page, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	var clearance *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "waf_clearance" {
+			clearance = c
+		}
+	}
+	if clearance == nil || !clearance.HttpOnly {
+		t.Fatalf("Expected an HttpOnly clearance cookie, got %v", resp.Cookies())
+	}
+
+	if resp, _ := do(http.MethodGet, "/login", "browser-a", nil, clearance); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the clearance cookie to pass the challenge, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, "/suspicious", "browser-a", nil, clearance); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the clearance cookie to pass the score challenge, got %d", resp.StatusCode)
+	}
+	// The clearance is bound to the User-Agent
+	challenge("/login", "browser-b")
+	forged := &http.Cookie{Name: "waf_clearance", Value: "9999999999." + strings.Repeat("A", 43)}
+	if resp, _ := do(http.MethodGet, "/login", "browser-a", nil, forged); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a forged clearance to be challenged, got %d", resp.StatusCode)
+	}
+
+	// Borderline scores are challenged
+	challenge("/suspicious", "browser-c")
+
+	// Wrong solutions, tampered tokens and open redirects
+	token = challenge("/login", "browser-c")
+	nonce := solveChallenge(token, difficulty)
+	failures := []struct {
+		name string
+		ua   string
+		form url.Values
+	}{
+		{"wrong nonce", "browser-c", url.Values{"token": {token}, "nonce": {"not-a-solution"}}},
+		{"lowered difficulty", "browser-c", url.Values{
+			"token": {strings.Replace(token, "."+strconv.Itoa(difficulty)+".", ".1.", 1)},
+			"nonce": {solveChallenge(token, 1)},
+		}},
+		{"other client", "browser-d", url.Values{"token": {token}, "nonce": {nonce}}},
+	}
+	for _, f := range failures {
+		if resp, _ := do(http.MethodPost, "/.waf/challenge", f.ua, f.form, nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", f.name, resp.StatusCode)
+		}
+	}
+	resp, _ = do(http.MethodPost, "/.waf/challenge", "browser-c", url.Values{
+		"token": {token}, "nonce": {nonce}, "return": {"//evil.example.com/"},
+	}, nil)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Errorf("Expected an off-site return to be replaced with /, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	// Each challenge can be redeemed once
+	if resp, _ := do(http.MethodPost, "/.waf/challenge", "browser-c", url.Values{"token": {token}, "nonce": {nonce}}, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a redeemed challenge to be rejected, got %d", resp.StatusCode)
+	}
+	for i, ret := range []string{"/\t/evil.example.com/", "/\r\n/evil.example.com/", "/\\evil.example.com/"} {
+		ua := "browser-redirect-" + strconv.Itoa(i)
+		token := challenge("/login", ua)
+		resp, _ := do(http.MethodPost, "/.waf/challenge", ua, url.Values{
+			"token": {token}, "nonce": {solveChallenge(token, difficulty)}, "return": {ret},
+		}, nil)
+		if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+			t.Errorf("Expected return %q to be replaced with /, got %d %q", ret, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	// Browsers without JavaScript follow the fallback after the delay
+	token = challenge("/login", "text-browser")
+	fallback := url.Values{"fallback": {"1"}, "token": {token}, "return": {"/login"}}
+	resp, _ = do(http.MethodGet, "/.waf/challenge?"+fallback.Encode(), "text-browser", nil, nil)
+	if resp.StatusCode != http.StatusSeeOther || len(resp.Cookies()) == 0 {
+		t.Errorf("Expected the fallback to set a clearance cookie, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, "/.waf/challenge?"+fallback.Encode(), "text-browser", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a redeemed fallback to be rejected, got %d", resp.StatusCode)
+	}
+
+	// Rate limit policies with the challenge action
+	if resp, _ := do(http.MethodGet, "/export", "browser-e", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the first export to pass, got %d", resp.StatusCode)
+	}
+	resp, body := do(http.MethodGet, "/export", "browser-e", nil, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || !challengeToken.MatchString(body) {
+		t.Errorf("Expected a challenge for the exceeded policy, got %d", resp.StatusCode)
+	}
+	// A clearance does not lift the rate limit
+	resp, body = do(http.MethodGet, "/export", "browser-a", nil, clearance)
+	if resp.StatusCode != http.StatusTooManyRequests || challengeToken.MatchString(body) || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected a cleared client over the limit to be blocked, got %d", resp.StatusCode)
+	}
+}
+
+func TestChallengeSolutionsFromBlockedClients(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.Challenge = config.ChallengeConfig{
+		Enabled:          true,
+		Difficulty:       8,
+		CookieName:       "waf_clearance",
+		ClearanceSeconds: 3600,
+		ChallengeSeconds: 300,
+		Path:             "/.waf/challenge",
+	}
+	cfg.Security.IPFilter = config.IPFilterConfig{Enabled: true, Blacklist: []string{"127.0.0.1", "::1"}}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/.waf/challenge", url.Values{"token": {"x"}, "nonce": {"1"}})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("Expected a blacklisted client to be blocked before the challenge is checked, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestChallengeFallbackDisabledByDefault(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "CHAL-LOGIN"
+  name: "Challenge logins"
+  severity: 0
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/login"
+  actions:
+    - type: "challenge"
+`)
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.Challenge = config.ChallengeConfig{
+		Enabled:          true,
+		Difficulty:       8,
+		CookieName:       "waf_clearance",
+		ClearanceSeconds: 3600,
+		ChallengeSeconds: 300,
+		Path:             "/.waf/challenge",
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(server.URL + "/login")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := challengeToken.FindStringSubmatch(string(data))
+	if m == nil {
+		t.Fatalf("Expected a challenge page, got %d", resp.StatusCode)
+	}
+	if strings.Contains(string(data), "fallback=1") {
+		t.Error("Expected no fallback link without the fallback enabled")
+	}
+
+	fallback := url.Values{"fallback": {"1"}, "token": {m[1]}, "return": {"/login"}}
+	resp, err = client.Get(server.URL + "/.waf/challenge?" + fallback.Encode())
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || len(resp.Cookies()) != 0 {
+		t.Errorf("Expected the fallback to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestChallengeDisabledBlocks(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "CHAL-LOGIN"
+  name: "Challenge logins"
+  severity: 0
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/login"
+  actions:
+    - type: "challenge"
+`)
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/login")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		t.Errorf("Expected challenges to block without the challenge enabled, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,335 @@
+package integration
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/cluster"
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+)
+
+// freeAddr reserves a loopback address for a gossip listener
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClusterGossipReplication(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	// Three instances on loopback; the first is the only seed
+	addrs := []string{freeAddr(t), freeAddr(t), freeAddr(t)}
+	servers := make([]*httptest.Server, len(addrs))
+	for i, addr := range addrs {
+		cfg := &config.Config{}
+		cfg.Server.UpstreamURL = upstream.URL
+		cfg.Server.TrustedProxies = []string{"127.0.0.1", "::1"}
+		cfg.Admin.Token = "secret"
+		cfg.State = config.StateConfig{
+			Backend:  "gossip",
+			FailMode: "open",
+			Cluster: config.ClusterConfig{
+				NodeID:           fmt.Sprintf("waf-%d", i),
+				BindAddress:      addr,
+				GossipIntervalMs: 30,
+				Secret:           "cluster-secret",
+			},
+		}
+		if i > 0 {
+			cfg.State.Cluster.Peers = []string{addrs[0]}
+		}
+		cfg.Security.RateLimit = config.RateLimitConfig{Enabled: true, MaxRequests: 6, WindowSeconds: 60}
+		cfg.Security.Bans = config.BanConfig{Enabled: true, WindowSeconds: 60, BanSeconds: 60, EscalationFactor: 2}
+		handler := newTestHandler(t, cfg)
+		servers[i] = httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+		defer servers[i].Close()
+	}
+
+	admin := func(server *httptest.Server, method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+"/admin/api/v1"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	members := func(server *httptest.Server) []cluster.Member {
+		resp := admin(server, "GET", "/cluster", "")
+		defer resp.Body.Close()
+		var view struct {
+			Members []cluster.Member `json:"members"`
+		}
+		json.NewDecoder(resp.Body).Decode(&view)
+		return view.Members
+	}
+
+	// Instances learn about each other through the seed
+	waitFor(t, 5*time.Second, "full membership", func() bool {
+		for _, server := range servers {
+			alive := 0
+			for _, m := range members(server) {
+				if m.State == cluster.StateAlive {
+					alive++
+				}
+			}
+			if alive != 3 {
+				return false
+			}
+		}
+		return true
+	})
+
+	// A ban on one instance is enforced by the others
+	resp := admin(servers[1], "POST", "/bans", `{"ip":"203.0.113.50","duration_seconds":60}`)
+	resp.Body.Close()
+	bannedOn := func(server *httptest.Server) bool {
+		req, _ := http.NewRequest("GET", server.URL+"/api/users", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.50")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusForbidden
+	}
+	waitFor(t, 5*time.Second, "ban replication", func() bool {
+		return bannedOn(servers[0]) && bannedOn(servers[2])
+	})
+
+	// Lifting the ban on another instance wins over the older ban everywhere
+	resp = admin(servers[2], "DELETE", "/bans/203.0.113.50", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected unban to succeed, got %d", resp.StatusCode)
+	}
+	waitFor(t, 5*time.Second, "unban replication", func() bool {
+		return !bannedOn(servers[0]) && !bannedOn(servers[1])
+	})
+
+	// Rate limit counters are merged: 2 requests per instance use up the shared limit of 6
+	get := func(server *httptest.Server) int {
+		req, _ := http.NewRequest("GET", server.URL+"/api/users", nil)
+		req.Header.Set("X-Forwarded-For", "198.51.100.77")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, server := range servers {
+		for i := 0; i < 2; i++ {
+			if code := get(server); code != http.StatusOK {
+				t.Fatalf("Expected 200 within the shared limit, got %d", code)
+			}
+		}
+	}
+	waitFor(t, 5*time.Second, "counter convergence", func() bool {
+		return get(servers[0]) == http.StatusTooManyRequests
+	})
+}
+
+func TestClusterRejectsUnsignedGossip(t *testing.T) {
+	node, err := cluster.NewNode(cluster.Config{
+		NodeID:      "signed",
+		BindAddress: "127.0.0.1:0",
+		Interval:    time.Hour,
+		Secret:      "s3cret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	body := `{"from":"intruder","address":"127.0.0.1:1","heartbeat":1,"registers":{"ban:10.0.0.1":{"value":"x","updated":1,"node":"intruder","expires":9999999999999999999}}}`
+	resp, err := http.Post("http://"+node.Address()+cluster.GossipPath, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected unsigned gossip to be rejected, got %d", resp.StatusCode)
+	}
+	if len(node.Members()) != 1 {
+		t.Errorf("Expected rejected sender not to join, got %+v", node.Members())
+	}
+	if _, ok, _ := node.Get("ban:10.0.0.1"); ok {
+		t.Error("Expected rejected gossip not to be merged")
+	}
+}
+
+func TestClusterRequiresSecret(t *testing.T) {
+	node, err := cluster.NewNode(cluster.Config{NodeID: "open", BindAddress: "127.0.0.1:0"})
+	if err == nil {
+		node.Close()
+		t.Fatal("Expected a node without a secret to be refused")
+	}
+}
+
+func TestClusterBoundsPeerState(t *testing.T) {
+	const secret = "s3cret"
+	node, err := cluster.NewNode(cluster.Config{
+		NodeID:      "bounded",
+		BindAddress: "127.0.0.1:0",
+		Interval:    time.Hour,
+		Secret:      secret,
+		MaxTTL:      time.Minute,
+		MaxMembers:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	now := time.Now()
+	body := fmt.Sprintf(`{"from":"peer","address":"127.0.0.1:1","heartbeat":1,
+		"members":[{"id":"m1","address":"127.0.0.1:2","heartbeat":1},{"id":"m2","address":"127.0.0.1:3","heartbeat":1},{"id":"m3","address":"127.0.0.1:4","heartbeat":1}],
+		"registers":{
+			"ban:10.0.0.1":{"value":"x","updated":%d,"node":"peer","expires":9000000000000000000},
+			"ban:10.0.0.2":{"value":"x","updated":%d,"node":"peer","expires":%d}},
+		"counters":{"rl:10.0.0.3":{"counts":{"peer":5},"expires":9000000000000000000}}}`,
+		now.UnixNano(), now.Add(time.Hour).UnixNano(), now.Add(30*time.Second).UnixNano())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	req, _ := http.NewRequest("POST", "http://"+node.Address()+cluster.GossipPath, strings.NewReader(body))
+	req.Header.Set("X-Cluster-Signature", hex.EncodeToString(mac.Sum(nil)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var reply struct {
+		Registers map[string]struct{ Expires int64 } `json:"registers"`
+		Counters  map[string]struct{ Expires int64 } `json:"counters"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected signed gossip to be accepted, got %d", resp.StatusCode)
+	}
+
+	limit := time.Now().Add(time.Minute).UnixNano()
+	if r, ok := reply.Registers["ban:10.0.0.1"]; !ok || r.Expires > limit {
+		t.Errorf("Expected the register expiry to be clamped to MaxTTL, got %+v", reply.Registers)
+	}
+	if c, ok := reply.Counters["rl:10.0.0.3"]; !ok || c.Expires > limit {
+		t.Errorf("Expected the counter expiry to be clamped to MaxTTL, got %+v", reply.Counters)
+	}
+	if _, ok, _ := node.Get("ban:10.0.0.2"); ok {
+		t.Error("Expected a write stamped in the future to be dropped")
+	}
+	if members := node.Members(); len(members) != 3 {
+		t.Errorf("Expected MaxMembers peers besides the node itself, got %+v", members)
+	}
+}
+
+func TestClusterGossipSendsBoundedDeltas(t *testing.T) {
+	const secret = "s3cret"
+	node, err := cluster.NewNode(cluster.Config{
+		NodeID:      "delta",
+		BindAddress: "127.0.0.1:0",
+		Interval:    time.Hour,
+		Secret:      secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	type watermark struct {
+		Registers uint64 `json:"registers"`
+		Counters  uint64 `json:"counters"`
+	}
+	type reply struct {
+		Incarnation int64                      `json:"incarnation"`
+		Seq         watermark                  `json:"seq"`
+		Registers   map[string]json.RawMessage `json:"registers"`
+	}
+	gossip := func(incarnation int64, have watermark) (reply, int) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"from": "peer", "address": "127.0.0.1:1", "heartbeat": 1,
+			"incarnation": 1, "have_incarnation": incarnation, "have": have,
+		})
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req, _ := http.NewRequest("POST", "http://"+node.Address()+cluster.GossipPath, strings.NewReader(string(body)))
+		req.Header.Set("X-Cluster-Signature", hex.EncodeToString(mac.Sum(nil)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		var r reply
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatalf("Invalid gossip reply: %v", err)
+		}
+		return r, len(data)
+	}
+
+	// More state than fits one message is spread over several rounds
+	value := strings.Repeat("x", 60<<10)
+	for i := 0; i < 100; i++ {
+		if err := node.Set(fmt.Sprintf("ban:10.0.%d.1", i), value, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := node.Set("ban:oversized", strings.Repeat("x", 1<<20), time.Minute); err == nil {
+		t.Error("Expected a value too large to gossip to be refused")
+	}
+
+	received := 0
+	var have watermark
+	var incarnation int64
+	for round := 0; received < 100; round++ {
+		if round > 5 {
+			t.Fatalf("Expected all registers within a few rounds, got %d", received)
+		}
+		r, size := gossip(incarnation, have)
+		if size > 8<<20 {
+			t.Fatalf("Gossip reply of %d bytes exceeds the message limit", size)
+		}
+		received += len(r.Registers)
+		incarnation, have = r.Incarnation, r.Seq
+	}
+	if received != 100 {
+		t.Errorf("Expected each register to be sent once, got %d", received)
+	}
+
+	// Once acknowledged, only new changes are sent
+	node.Set("ban:10.1.0.1", "new", time.Minute)
+	r, _ := gossip(incarnation, have)
+	if _, ok := r.Registers["ban:10.1.0.1"]; !ok || len(r.Registers) != 1 {
+		t.Errorf("Expected only the new register, got %d registers", len(r.Registers))
+	}
+}
@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/collections"
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/detection"
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func TestAccumulatedScoreAcrossRequests(t *testing.T) {
+	ruleSet, err := rules.LoadRules([]string{
+		"../../configs/collections.yaml",
+		"../../configs/ruleset.yaml",
+		"../../configs/collections-accumulate.yaml",
+	})
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	store, err := collections.NewStore(nil, collections.Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// A high threshold means no single request is blocked on its own score
+	cfg := &config.Config{Security: config.SecurityConfig{AnomalyThreshold: 1000}}
+
+	send := func(target string) decision.Decision {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = "198.51.100.7:4321"
+		norm, err := normalize.Request(req, false)
+		if err != nil {
+			t.Fatalf("Failed to normalize request: %v", err)
+		}
+		tx := detection.NewTransaction()
+		tx.Collections = collections.NewScope(store, req, norm.ClientIP, collections.KeyConfig{})
+		if err := detection.EvaluateTransaction(tx, req, norm, ruleSet); err != nil {
+			t.Fatalf("Failed to evaluate request: %v", err)
+		}
+		return decision.Decide(tx, cfg)
+	}
+
+	for i := 0; i < 6; i++ {
+		if dec := send("/search?q=javascript:x"); dec.Action != "allow" {
+			t.Fatalf("Request %d: expected allow, got %s", i, dec.Action)
+		}
+	}
+
+	// 6 requests x score 9 = 54 > 50, so even a benign request is now denied
+	dec := send("/search?q=hello")
+	if dec.Action != "block" || dec.MatchedRules[0] != "COLL-001" {
+		t.Fatalf("Expected COLL-001 block, got %s %v", dec.Action, dec.MatchedRules)
+	}
+
+	if v, _ := store.Get("ip", "198.51.100.7", "anomaly_score"); v != "54" {
+		t.Errorf("Expected accumulated score 54, got %q", v)
+	}
+}
+
+func TestCollectionDecayAndPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collections.json")
+
+	store, err := collections.NewStore(collections.NewFileBackend(path), collections.Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.Set("ip", "10.0.0.1", "score", "+20", collections.Options{
+		TTL:           time.Hour,
+		DecayAmount:   5,
+		DecayInterval: 10 * time.Millisecond,
+	})
+	store.Set("ip", "10.0.0.2", "score", "7", collections.Options{TTL: time.Millisecond})
+	if err := store.Close(); err != nil {
+		t.Fatalf("Failed to persist store: %v", err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+
+	restored, err := collections.NewStore(collections.NewFileBackend(path), collections.Limits{})
+	if err != nil {
+		t.Fatalf("Failed to restore store: %v", err)
+	}
+	defer restored.Close()
+
+	v, ok := restored.Get("ip", "10.0.0.1", "score")
+	if !ok || v == "20" || v == "" {
+		t.Errorf("Expected decayed score below 20, got %q (present=%v)", v, ok)
+	}
+	if _, ok := restored.Get("ip", "10.0.0.2", "score"); ok {
+		t.Errorf("Expected expired entry to be dropped")
+	}
+}
+
+func TestCollectionStoreLimits(t *testing.T) {
+	store, err := collections.NewStore(nil, collections.Limits{MaxEntries: 2, DefaultTTL: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	store.Set("ip", "10.0.0.1", "score", "1", collections.Options{TTL: time.Hour})
+	store.Set("ip", "10.0.0.2", "score", "2", collections.Options{TTL: time.Hour})
+	store.Get("ip", "10.0.0.1", "score")
+	store.Set("ip", "10.0.0.3", "score", "3", collections.Options{TTL: time.Hour})
+	if _, ok := store.Get("ip", "10.0.0.2", "score"); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if _, ok := store.Get("ip", "10.0.0.1", "score"); !ok {
+		t.Error("Expected the recently read entry to be kept")
+	}
+
+	store.Set("ip", "10.0.0.4", "score", "4", collections.Options{})
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := store.Get("ip", "10.0.0.4", "score"); ok {
+		t.Error("Expected an entry without a TTL to expire after the default TTL")
+	}
+	if _, ok := store.Get("ip", "10.0.0.1", "score"); !ok {
+		t.Error("Expected an entry with its own TTL to be kept")
+	}
+}
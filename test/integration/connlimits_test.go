@@ -0,0 +1,178 @@
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/telemetry"
+)
+
+// closedByServer reports whether the server closes conn without responding
+func closedByServer(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1)
+	_, err := conn.Read(buf)
+	return err != nil && !strings.Contains(err.Error(), "timeout")
+}
+
+func TestPerIPConnectionLimit(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	handler := newTestHandler(t, cfg)
+
+	server := httptest.NewUnstartedServer(httpserver.NewRouter(handler, "stdout"))
+	limited := httpserver.NewLimitListener(server.Listener, 10, 2)
+	server.Listener = limited
+	server.Start()
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	// Two idle connections use up the per-IP allowance
+	var held []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		held = append(held, conn)
+	}
+	waitFor(t, 2*time.Second, "connections to be accepted", func() bool {
+		return limited.GetStats()["active_connections"] == 2
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if !closedByServer(conn) {
+		t.Fatal("Expected third connection from the same IP to be closed")
+	}
+	if n, _ := telemetry.GetMetrics().RejectedConnections.Load("per_ip_limit"); n == nil || n.(int64) < 1 {
+		t.Error("Expected rejected connection to be counted")
+	}
+
+	// Closing a connection frees a slot
+	held[0].Close()
+	waitFor(t, 2*time.Second, "slot release", func() bool {
+		return limited.GetStats()["active_connections"] == 1
+	})
+	resp, err := http.Get(server.URL + "/api/users")
+	if err != nil {
+		t.Fatalf("Request after release failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 after a slot was freed, got %d", resp.StatusCode)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := httpserver.NewLimitListener(ln, 1, 0)
+	defer limited.Close()
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			// Keep accepted connections open until the listener closes
+			defer conn.Close()
+		}
+	}()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	waitFor(t, 2*time.Second, "first connection", func() bool {
+		return limited.GetStats()["active_connections"] == 1
+	})
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if !closedByServer(second) {
+		t.Error("Expected connection over the global limit to be closed")
+	}
+}
+
+func TestMinimumBodyRate(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Server.MinBodyRateBytes = 1000
+	cfg.Server.BodyRateGraceSeconds = 1
+	cfg.Security.LogRequestBody = true
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	slowBefore := atomic.LoadInt64(&telemetry.GetMetrics().SlowRequests)
+
+	// A normal body is unaffected
+	resp, err := http.Post(server.URL+"/api/users", "text/plain", strings.NewReader("name=alice"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for a normal body, got %d", resp.StatusCode)
+	}
+
+	// Trickle a body at a few bytes per second
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /api/users HTTP/1.1\r\nHost: waf\r\nContent-Type: text/plain\r\nContent-Length: 100000\r\n\r\n")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 40; i++ {
+			if _, err := conn.Write([]byte("a")); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err == nil && !strings.Contains(status, "408") {
+		t.Errorf("Expected 408 for a trickled body, got %q", status)
+	}
+	if err != nil && strings.Contains(err.Error(), "timeout") {
+		t.Fatal("Expected the server to cut off the slow body")
+	}
+	conn.Close()
+	<-done
+
+	if atomic.LoadInt64(&telemetry.GetMetrics().SlowRequests) <= slowBefore {
+		t.Error("Expected the slow request to be counted")
+	}
+}
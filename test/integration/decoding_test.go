@@ -0,0 +1,121 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func hasAnomaly(norm *normalize.NormalizedRequest, name string) bool {
+	for _, a := range norm.ProtocolAnomalies {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNormalizePathEvasions(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		path    string
+		decoded string
+		layers  int
+		anomaly string
+	}{
+		{"double encoding", "/static/%252e%252e/%252e%252e/etc/passwd", "/etc/passwd", "/static/../../etc/passwd", 2, ""},
+		{"triple encoding", "/a/%25252e%25252e/b", "/b", "/a/../b", 3, ""},
+		{"overlong utf-8", "/static/%c0%ae%c0%ae/secret", "/secret", "/static/../secret", 1, normalize.AnomalyOverlongUTF8},
+		{"three byte overlong", "/static/%e0%80%ae%e0%80%ae/secret", "/secret", "/static/../secret", 1, normalize.AnomalyOverlongUTF8},
+		{"nested iis encoding", "/static/%25u002e%25u002e/secret", "/secret", "/static/../secret", 2, normalize.AnomalyUnicodeEncoding},
+		{"backslash separators", "/static/..%5c..%5cwin.ini", "/win.ini", "/static/..\\..\\win.ini", 1, ""},
+		{"path parameters", "/admin;jsessionid=abc/users;v=1", "/admin/users", "/admin;jsessionid=abc/users;v=1", 0, ""},
+		{"parameter hiding traversal", "/public/..;/admin", "/admin", "/public/..;/admin", 0, ""},
+		{"invalid nested encoding", "/a%25zz", "/a%zz", "/a%zz", 1, normalize.AnomalyInvalidNestedEncoding},
+		{"decode limit", "/%2525252525252e", "/%25252e", "/%25252e", normalize.MaxDecodeLayers, normalize.AnomalyDecodeLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			norm, err := normalize.Request(httptest.NewRequest("GET", tt.target, nil), false)
+			if err != nil {
+				t.Fatalf("Normalize failed: %v", err)
+			}
+			if norm.Path != tt.path {
+				t.Errorf("Expected path %q, got %q", tt.path, norm.Path)
+			}
+			if norm.DecodedPath != tt.decoded {
+				t.Errorf("Expected decoded path %q, got %q", tt.decoded, norm.DecodedPath)
+			}
+			if norm.DecodeLayers != tt.layers {
+				t.Errorf("Expected %d decode layers, got %d", tt.layers, norm.DecodeLayers)
+			}
+			if tt.anomaly != "" && !hasAnomaly(norm, tt.anomaly) {
+				t.Errorf("Expected anomaly %s, got %+v", tt.anomaly, norm.ProtocolAnomalies)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueryDecoding(t *testing.T) {
+	req := httptest.NewRequest("GET", "/search?q=%u003cscript%u003e&id=%2527%2520OR%25201%253D1&name=a+b", nil)
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := norm.GetQueryString("q"); got != "<script>" {
+		t.Errorf("Expected %%u escapes to be decoded, got %q", got)
+	}
+	if got := norm.GetQueryString("id"); got != "' OR 1=1" {
+		t.Errorf("Expected double encoding to be decoded, got %q", got)
+	}
+	if got := norm.GetQueryString("name"); got != "a b" {
+		t.Errorf("Expected '+' to decode to a space, got %q", got)
+	}
+	if norm.DecodeLayers != 2 {
+		t.Errorf("Expected 2 decode layers, got %d", norm.DecodeLayers)
+	}
+	if !hasAnomaly(norm, normalize.AnomalyUnicodeEncoding) {
+		t.Errorf("Expected %%u encoding anomaly, got %+v", norm.ProtocolAnomalies)
+	}
+}
+
+func TestEncodedTraversalBlocked(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	for _, target := range []string{
+		"/static/%252e%252e/%252e%252e/etc/passwd",
+		"/static/%c0%ae%c0%ae/%c0%ae%c0%ae/etc/passwd",
+		"/static/..%5c..%5cwin.ini",
+	} {
+		resp, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d", target, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/users?name=caf%C3%A9")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected singly encoded request to pass, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,151 @@
+package integration
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+)
+
+func TestErrorPages(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/status/"))
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"abc"`)
+		if r.URL.Query().Get("gzip") != "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(status)
+			w.Write(gzipBytes(javaStackTrace))
+			return
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, javaStackTrace)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "waf.log")
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Logging.Output = logFile
+	cfg.Security.ErrorPages = config.ErrorPagesConfig{
+		Enabled:         true,
+		ExcludeStatuses: []int{503},
+		SnippetBytes:    40,
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		status   int
+		replaced bool
+	}{
+		{"/status/500", 500, true},
+		{"/status/502?gzip=1", 502, true},
+		{"/status/404", 404, false},
+		{"/status/503", 503, false},
+		{"/status/200", 200, false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+		req.Header.Set("X-Request-ID", "req-"+strconv.Itoa(tt.status))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+		leaked := strings.Contains(string(body), "NullPointerException")
+		if tt.replaced {
+			if leaked {
+				t.Errorf("%s: upstream error body was passed through", tt.path)
+			}
+			if !strings.Contains(string(body), "req-"+strconv.Itoa(tt.status)) {
+				t.Errorf("%s: expected the request ID in the error page, got %q", tt.path, body)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+				t.Errorf("%s: expected Cache-Control no-store, got %q", tt.path, got)
+			}
+			if got := resp.Header.Get("ETag"); got != "" {
+				t.Errorf("%s: expected the upstream ETag to be dropped, got %q", tt.path, got)
+			}
+		} else if !leaked && tt.path != "/status/200" {
+			t.Errorf("%s: expected the upstream body to pass through", tt.path)
+		}
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	// The snippet is the first 40 bytes of the decompressed body
+	snippet := `"upstream_body_snippet":"java.lang.NullPointerException: null\n\tat"`
+	for _, want := range []string{`"upstream_status":500`, `"upstream_status":502`, snippet} {
+		if !strings.Contains(string(logs), want) {
+			t.Errorf("Expected %s in logs, got:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(string(logs), `"upstream_status":404`) {
+		t.Error("Expected 4xx responses not to be intercepted by default")
+	}
+
+	// Upstream connection failures get the error page too
+	upstream.Close()
+	cfg.Security.ErrorPages.Intercept4xx = true
+	handler = newTestHandler(t, cfg)
+	down := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer down.Close()
+	resp, err := http.Get(down.URL + "/status/404")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "502 Bad Gateway") {
+		t.Errorf("Expected the error page for an unreachable upstream, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestErrorPagesIntercept4xx(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "no route /debug/vars in app.routes")
+	}))
+	defer upstream.Close()
+
+	tmpl := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(tmpl, []byte(`<p>{{.Status}}: {{.StatusText}} ({{.RequestID}})</p>`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.ErrorPages = config.ErrorPagesConfig{Enabled: true, Intercept4xx: true, Template: tmpl}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/debug/vars", nil)
+	req.Header.Set("X-Request-ID", "req-abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "<p>404: Not Found (req-abc)</p>"; resp.StatusCode != http.StatusNotFound || string(body) != want {
+		t.Errorf("Expected 404 %q, got %d %q", want, resp.StatusCode, body)
+	}
+}
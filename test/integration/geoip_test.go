@@ -0,0 +1,165 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/geoip"
+)
+
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code":   code,
+			"geoname_id": uint32(1),
+			"names":      map[string]interface{}{"en": "Country " + code},
+		},
+	}
+}
+
+func asn(number uint32, org string) map[string]interface{} {
+	return map[string]interface{}{
+		"autonomous_system_number":       number,
+		"autonomous_system_organization": org,
+	}
+}
+
+func writeGeoDatabases(t *testing.T, dir string) (string, string) {
+	countryDB := filepath.Join(dir, "country.mmdb")
+	writeTestMMDB(t, countryDB, 28, map[string]map[string]interface{}{
+		"203.0.113.0/24":  country("NL"),
+		"198.51.100.0/24": country("KP"),
+		"2001:db8::/32":   country("DE"),
+	})
+	asnDB := filepath.Join(dir, "asn.mmdb")
+	writeTestMMDB(t, asnDB, 24, map[string]map[string]interface{}{
+		"203.0.113.0/25":   asn(64500, "Example Hosting"),
+		"203.0.113.128/25": asn(64501, "Example ISP"),
+	})
+	return countryDB, asnDB
+}
+
+func TestMMDBReaderRecordSizes(t *testing.T) {
+	dir := t.TempDir()
+	for _, size := range []int{24, 28, 32} {
+		path := filepath.Join(dir, "db.mmdb")
+		writeTestMMDB(t, path, size, map[string]map[string]interface{}{
+			"192.0.2.0/24":  country("FR"),
+			"2001:db8::/48": country("JP"),
+		})
+		db, err := geoip.OpenDatabase(path)
+		if err != nil {
+			t.Fatalf("record size %d: failed to open: %v", size, err)
+		}
+		if db.Metadata().RecordSize != uint(size) || db.Metadata().DatabaseType != "Test-DB" {
+			t.Errorf("record size %d: unexpected metadata %+v", size, db.Metadata())
+		}
+		for ip, want := range map[string]string{"192.0.2.9": "FR", "2001:db8::1": "JP", "192.0.3.1": "", "::ffff:192.0.2.1": "FR"} {
+			record, err := db.Lookup(netip.MustParseAddr(ip))
+			if err != nil {
+				t.Fatalf("record size %d: lookup %s: %v", size, ip, err)
+			}
+			got := ""
+			if record != nil {
+				got = record["country"].(map[string]interface{})["iso_code"].(string)
+			}
+			if got != want {
+				t.Errorf("record size %d: lookup %s = %q, want %q", size, ip, got, want)
+			}
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.mmdb"), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := geoip.OpenDatabase(filepath.Join(dir, "bad.mmdb")); err == nil {
+		t.Error("Expected error for invalid database")
+	}
+}
+
+func TestGeoIPResolverAndHotReload(t *testing.T) {
+	dir := t.TempDir()
+	countryDB, asnDB := writeGeoDatabases(t, dir)
+
+	resolver, err := geoip.NewResolver(countryDB, asnDB, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to open resolver: %v", err)
+	}
+	defer resolver.Stop()
+
+	record := resolver.Lookup("203.0.113.10")
+	if record.Country != "NL" || record.ASN != 64500 || record.Organization != "Example Hosting" {
+		t.Fatalf("Unexpected record: %+v", record)
+	}
+
+	// Replace the country database; the resolver should pick it up
+	time.Sleep(10 * time.Millisecond)
+	writeTestMMDB(t, countryDB, 28, map[string]map[string]interface{}{
+		"203.0.113.0/24": country("BE"),
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for resolver.Lookup("203.0.113.10").Country != "BE" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected country database to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGeoIPPoliciesAndLogging(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	countryDB, asnDB := writeGeoDatabases(t, dir)
+	logFile := filepath.Join(dir, "waf.log")
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Server.TrustedProxies = []string{"127.0.0.1", "::1"}
+	cfg.Logging.Output = logFile
+	cfg.Security.GeoIP = config.GeoIPConfig{Enabled: true, CountryDatabase: countryDB, ASNDatabase: asnDB}
+	cfg.Security.IPFilter = config.IPFilterConfig{
+		Enabled:       true,
+		DenyCountries: []string{"kp"},
+		DenyASNs:      []uint{64500},
+	}
+	server := httptest.NewServer(newTestHandler(t, cfg))
+	defer server.Close()
+
+	get := func(ip string) int {
+		req, _ := http.NewRequest("GET", server.URL+"/api/users", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("198.51.100.1"); code != http.StatusForbidden {
+		t.Errorf("Expected denied country to be blocked, got %d", code)
+	}
+	if code := get("203.0.113.5"); code != http.StatusForbidden {
+		t.Errorf("Expected denied ASN to be blocked, got %d", code)
+	}
+	if code := get("203.0.113.200"); code != http.StatusOK {
+		t.Errorf("Expected allowed ASN to pass, got %d", code)
+	}
+
+	logs, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if !strings.Contains(string(logs), `"country":"NL","asn":64501,"as_organization":"Example ISP"`) {
+		t.Errorf("Expected GeoIP enrichment in logs, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,188 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func TestNormalizeMultiValueHeadersAndCookies(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Add("X-Forwarded-For", "203.0.113.7")
+	req.Header.Add("X-Forwarded-For", "127.0.0.1")
+	req.Header.Add("Cookie", `session=abc; theme="dark"`)
+	req.Header.Add("Cookie", "role=user; role=admin; bad name=x;")
+	req.Header.Set("Accept", "text/html")
+
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := norm.GetHeaderValues("x-forwarded-for"); !reflect.DeepEqual(got, []string{"203.0.113.7", "127.0.0.1"}) {
+		t.Errorf("Expected both X-Forwarded-For values in order, got %v", got)
+	}
+	if got := norm.GetHeader("X-Forwarded-For"); got != "203.0.113.7" {
+		t.Errorf("Expected GetHeader to return the first value, got %q", got)
+	}
+
+	wantCookies := map[string][]string{
+		"session":  {"abc"},
+		"theme":    {"dark"},
+		"role":     {"user", "admin"},
+		"bad name": {"x"},
+	}
+	if !reflect.DeepEqual(norm.Cookies, wantCookies) {
+		t.Errorf("Expected cookies %v, got %v", wantCookies, norm.Cookies)
+	}
+	if got := norm.GetCookie("role"); got != "user" {
+		t.Errorf("Expected first role cookie, got %q", got)
+	}
+
+	var duplicates []string
+	for _, a := range norm.ProtocolAnomalies {
+		if a.Name == normalize.AnomalyDuplicateHeader {
+			duplicates = append(duplicates, a.Detail)
+		}
+	}
+	if !reflect.DeepEqual(duplicates, []string{"cookie", "x-forwarded-for"}) {
+		t.Errorf("Expected duplicate cookie and x-forwarded-for, got %v", duplicates)
+	}
+	if norm.HeaderOrder != nil {
+		t.Errorf("Expected no header order without the raw head, got %v", norm.HeaderOrder)
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	trusted, err := normalize.ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	if _, err := normalize.ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected an invalid trusted proxy to be rejected")
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    [][2]string
+		want       string
+	}{
+		{"no headers", "198.51.100.1:1234", nil, "198.51.100.1"},
+		{"untrusted peer", "198.51.100.1:1234", [][2]string{{"X-Forwarded-For", "203.0.113.7"}}, "198.51.100.1"},
+		{"untrusted peer real ip", "198.51.100.1:1234", [][2]string{{"X-Real-IP", "203.0.113.7"}}, "198.51.100.1"},
+		{"trusted peer", "10.1.2.3:1234", [][2]string{{"X-Forwarded-For", "203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed first hop", "10.1.2.3:1234", [][2]string{{"X-Forwarded-For", "127.0.0.1, 203.0.113.7"}}, "203.0.113.7"},
+		{"trusted hops skipped", "10.1.2.3:1234", [][2]string{{"X-Forwarded-For", "203.0.113.7, 192.0.2.1"}, {"X-Forwarded-For", "10.9.9.9"}}, "203.0.113.7"},
+		{"only trusted hops", "10.1.2.3:1234", [][2]string{{"X-Forwarded-For", "10.4.4.4, 10.5.5.5"}}, "10.4.4.4"},
+		{"malformed hop", "10.1.2.3:1234", [][2]string{{"X-Forwarded-For", "junk, 10.5.5.5"}}, "10.5.5.5"},
+		{"real ip", "192.0.2.1:1234", [][2]string{{"X-Real-IP", "203.0.113.9"}}, "203.0.113.9"},
+		{"ipv6 peer", "[2001:db8::1]:1234", [][2]string{{"X-Forwarded-For", "203.0.113.7"}}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, h := range tt.headers {
+			req.Header.Add(h[0], h[1])
+		}
+		if got := trusted.ClientIP(req); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := normalize.ClientIP(req); got != "10.1.2.3" {
+		t.Errorf("Expected ClientIP to ignore forwarding headers, got %q", got)
+	}
+}
+
+func TestNormalizeHeaderOrder(t *testing.T) {
+	head := "GET / HTTP/1.1\r\nhost: a\r\nUser-Agent: curl/8.0\r\nX-Folded: a\r\n b\r\naccept: */*\r\n\r\n"
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(normalize.WithRawHead(req.Context(), []byte(head)))
+
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	want := []string{"host", "User-Agent", "X-Folded", "accept"}
+	if !reflect.DeepEqual(norm.HeaderOrder, want) {
+		t.Errorf("Expected header order %v, got %v", want, norm.HeaderOrder)
+	}
+}
+
+func TestHeaderAndCookieRules(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "COOKIE-ROLE"
+  name: "Role cookie tampering"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "cookie"
+      name: "role"
+      operator: "equals"
+      value: "admin"
+  actions:
+    - type: "add_score"
+      param: 10
+
+- id: "XFF-LOOPBACK"
+  name: "Spoofed loopback X-Forwarded-For"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "header"
+      name: "X-Forwarded-For"
+      operator: "starts_with"
+      value: "127."
+  actions:
+    - type: "add_score"
+      param: 10
+`)
+
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		headers [][2]string
+		want    int
+	}{
+		{"clean", [][2]string{{"Cookie", "role=user"}, {"X-Forwarded-For", "203.0.113.7"}}, http.StatusOK},
+		{"second cookie header", [][2]string{{"Cookie", "role=user"}, {"Cookie", "role=admin"}}, http.StatusForbidden},
+		{"repeated cookie name", [][2]string{{"Cookie", "role=user; role=admin"}}, http.StatusForbidden},
+		{"second forwarded-for header", [][2]string{{"X-Forwarded-For", "203.0.113.7"}, {"X-Forwarded-For", "127.0.0.1"}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+"/api/users", nil)
+			for _, h := range tt.headers {
+				req.Header.Add(h[0], h[1])
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func TestNormalizeParameterPollution(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/items?id=1&id=2&tags[]=a&tags[]=b&role=user", strings.NewReader("role=admin&name=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	norm, err := normalize.Request(req, true)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+
+	if got := norm.BodyArgs["role"]; !reflect.DeepEqual(got, []string{"admin"}) {
+		t.Errorf("Expected body role parameter, got %v", got)
+	}
+	wantRepeated := map[string]int{"id": 2, "tags[]": 2, "role": 2}
+	if !reflect.DeepEqual(norm.RepeatedArgs, wantRepeated) {
+		t.Errorf("Expected repeated args %v, got %v", wantRepeated, norm.RepeatedArgs)
+	}
+	if got := norm.Args()["role"]; !reflect.DeepEqual(got, []string{"user", "admin"}) {
+		t.Errorf("Expected query value before body value, got %v", got)
+	}
+
+	anomalies := make(map[string]string)
+	for _, a := range norm.ProtocolAnomalies {
+		anomalies[a.Detail] = a.Name
+	}
+	want := map[string]string{
+		"id":   normalize.AnomalyRepeatedParameter,
+		"role": normalize.AnomalyParameterQueryAndBody,
+	}
+	if !reflect.DeepEqual(anomalies, want) {
+		t.Errorf("Expected anomalies %v, got %v", want, anomalies)
+	}
+}
+
+func TestCombineValues(t *testing.T) {
+	values := []string{"1", "union select 2"}
+	for mode, want := range map[string]string{
+		normalize.CombineFirst: "1",
+		normalize.CombineLast:  "union select 2",
+		normalize.CombineJoin:  "1,union select 2",
+	} {
+		got, err := normalize.CombineValues(values, mode)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", mode, want, got, err)
+		}
+	}
+	if _, err := normalize.CombineValues(values, "random"); err == nil {
+		t.Error("Expected an error for an unknown combine mode")
+	}
+}
+
+func TestParameterPollutionRules(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "ROLE-LAST"
+  name: "Admin role as seen by last-wins frameworks"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "args"
+      name: "role"
+      combine: "last"
+      operator: "equals"
+      value: "admin"
+  actions:
+    - type: "add_score"
+      param: 10
+
+- id: "SPLIT-SQLI"
+  name: "SQL injection split across occurrences"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "query"
+      combine: "join"
+      operator: "regex"
+      value: "(?i)union(\\s|,|/\\*.*?\\*/)+select"
+  actions:
+    - type: "add_score"
+      param: 10
+
+- id: "TOO-MANY"
+  name: "Parameter repeated many times"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "args_repeated"
+      operator: "ge"
+      value: "5"
+  actions:
+    - type: "add_score"
+      param: 10
+`)
+
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.LogRequestBody = true
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  int
+	}{
+		{"single role", "role=user", "", http.StatusOK},
+		{"first occurrence admin", "role=admin&role=user", "", http.StatusOK},
+		{"body overrides query", "role=user", "role=admin", http.StatusForbidden},
+		{"split injection", "id=1 union/*&id=*/select password", "", http.StatusForbidden},
+		{"occurrences alone look clean", "id=1 union&id=2", "", http.StatusOK},
+		{"many repeats", "a=1&a=2&a=3&a=4&a=5", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			for _, pair := range strings.Split(tt.query, "&") {
+				k, v, _ := strings.Cut(pair, "=")
+				query.Add(k, v)
+			}
+			req, _ := http.NewRequest("POST", server.URL+"/api/items?"+query.Encode(), strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -33,11 +33,11 @@ func createTestWAFServer(t *testing.T, upstreamURL string) (*httptest.Server, er
 	// Create minimal config
 	cfg := &config.Config{
 		Server: config.ServerConfig{
-			ListenAddress:      ":0", // Let system choose port
-			UpstreamURL:        upstreamURL,
-			ReadTimeoutSeconds: 10,
+			ListenAddress:       ":0", // Let system choose port
+			UpstreamURL:         upstreamURL,
+			ReadTimeoutSeconds:  10,
 			WriteTimeoutSeconds: 10,
-			IdleTimeoutSeconds: 60,
+			IdleTimeoutSeconds:  60,
 		},
 		Security: config.SecurityConfig{
 			AnomalyThreshold: 10,
@@ -71,7 +71,10 @@ func createTestWAFServer(t *testing.T, upstreamURL string) (*httptest.Server, er
 	}
 
 	// Create WAF handler
-	handler := httpserver.NewWAFHandler(cfg, ruleSet, logger, proxy)
+	handler, err := httpserver.NewWAFHandler(cfg, ruleSet, logger, proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAF handler: %w", err)
+	}
 
 	// Create test server
 	server := httptest.NewServer(handler)
@@ -260,4 +263,3 @@ func TestPOSTRequest(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
 }
-
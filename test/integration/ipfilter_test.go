@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/ipfilter"
+)
+
+func TestIPFilterRejectsInvalidEntries(t *testing.T) {
+	filter := ipfilter.NewIPFilter()
+	for _, entry := range []string{"", "not-an-ip", "10.0.0.0/33", "300.1.1.1", "2001:db8::/129"} {
+		if err := filter.AddToBlacklist(entry); err == nil {
+			t.Errorf("Expected error for %q", entry)
+		}
+		if err := filter.AddToWhitelist(entry); err == nil {
+			t.Errorf("Expected error for %q", entry)
+		}
+	}
+}
+
+func TestIPFilterIPv4AndIPv6(t *testing.T) {
+	filter := ipfilter.NewIPFilter()
+	for _, entry := range []string{"10.0.0.0/8", "192.0.2.55", "2001:db8::/32", "::ffff:198.51.100.0/120"} {
+		if err := filter.AddToBlacklist(entry); err != nil {
+			t.Fatalf("Failed to add %q: %v", entry, err)
+		}
+	}
+
+	cases := map[string]bool{
+		"10.1.2.3":            true,
+		"11.0.0.1":            false,
+		"192.0.2.55":          true,
+		"192.0.2.56":          false,
+		"2001:db8:1::1":       true,
+		"2001:db9::1":         false,
+		"198.51.100.20":       true,
+		"::ffff:10.9.9.9":     true,
+		"::ffff:198.51.101.1": false,
+		"garbage":             false,
+	}
+	for ip, want := range cases {
+		if got := filter.IsBlacklisted(ip); got != want {
+			t.Errorf("IsBlacklisted(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestIPFilterRemoveByCanonicalForm(t *testing.T) {
+	filter := ipfilter.NewIPFilter()
+	filter.AddToBlacklist("10.0.0.0/8")
+	filter.AddToBlacklist("10.1.0.0/16")
+	filter.AddToBlacklist("2001:DB8::1")
+
+	if removed, err := filter.RemoveFromBlacklist("10.200.3.4/8"); err != nil || !removed {
+		t.Fatalf("Expected non-canonical CIDR to remove 10.0.0.0/8, got %v %v", removed, err)
+	}
+	if !filter.IsBlacklisted("10.1.2.3") || filter.IsBlacklisted("10.2.0.1") {
+		t.Error("Expected only the /16 to remain")
+	}
+	if removed, _ := filter.RemoveFromBlacklist("2001:db8:0::1"); !removed {
+		t.Error("Expected IPv6 host to be removed by canonical form")
+	}
+	if removed, _ := filter.RemoveFromBlacklist("172.16.0.0/12"); removed {
+		t.Error("Expected removal of absent entry to report false")
+	}
+	if _, err := filter.RemoveFromBlacklist("bogus"); err == nil {
+		t.Error("Expected error for invalid entry")
+	}
+}
+
+func TestTrieMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var trie ipfilter.Trie[int]
+	var prefixes []netip.Prefix
+
+	randomAddr := func() netip.Addr {
+		if rng.Intn(2) == 0 {
+			var b [4]byte
+			rng.Read(b[:])
+			b[0] = byte(rng.Intn(4)) // cluster addresses so prefixes overlap
+			return netip.AddrFrom4(b)
+		}
+		var b [16]byte
+		rng.Read(b[:])
+		b[0], b[1] = 0x20, byte(rng.Intn(4))
+		return netip.AddrFrom16(b)
+	}
+
+	for i := 0; i < 2000; i++ {
+		addr := randomAddr()
+		p := netip.PrefixFrom(addr, rng.Intn(addr.BitLen()+1)).Masked()
+		trie.Insert(p, i)
+		prefixes = append(prefixes, p)
+	}
+	// Delete a third of them again
+	live := make(map[netip.Prefix]bool)
+	for _, p := range prefixes {
+		live[p] = true
+	}
+	for i, p := range prefixes {
+		if i%3 == 0 && live[p] {
+			if !trie.Delete(p) {
+				t.Fatalf("Delete(%s) reported missing", p)
+			}
+			delete(live, p)
+		}
+	}
+	if trie.Len() != len(live) {
+		t.Fatalf("Len() = %d, want %d", trie.Len(), len(live))
+	}
+
+	for i := 0; i < 5000; i++ {
+		addr := randomAddr()
+		var want netip.Prefix
+		for p := range live {
+			if p.Contains(addr) && (!want.IsValid() || p.Bits() > want.Bits()) {
+				want = p
+			}
+		}
+		_, got, ok := trie.Lookup(addr)
+		if ok != want.IsValid() || (ok && got != want) {
+			t.Fatalf("Lookup(%s) = %s %v, want %s", addr, got, ok, want)
+		}
+	}
+}
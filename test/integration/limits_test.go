@@ -0,0 +1,237 @@
+package integration
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func testLimits() config.LimitsConfig {
+	return config.LimitsConfig{
+		MaxURLLength:         200,
+		MaxHeaderCount:       20,
+		MaxHeaderSize:        256,
+		MaxArgCount:          5,
+		MaxArgNameLength:     20,
+		MaxArgValueLength:    50,
+		MaxBodySize:          100,
+		MaxMultipartBodySize: 4096,
+		MaxFileSize:          1024,
+		Action:               "reject",
+		Score:                5,
+	}
+}
+
+// multipartBody builds a multipart body with one file of the given size
+func multipartBody(t *testing.T, size int) (*bytes.Buffer, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("title", "upload")
+	fw, err := mw.CreateFormFile("file", "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bytes.Repeat([]byte("x"), size))
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestRequestLimitsReject(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.LogRequestBody = true
+	cfg.Security.Limits = testLimits()
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	do := func(req *http.Request) int {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(path string, header http.Header) int {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return do(req)
+	}
+	post := func(body io.Reader, contentType string) int {
+		req, _ := http.NewRequest("POST", server.URL+"/api/users", body)
+		req.Header.Set("Content-Type", contentType)
+		return do(req)
+	}
+
+	if code := get("/api/users?a=1&b=2", nil); code != http.StatusOK {
+		t.Fatalf("Expected 200 within limits, got %d", code)
+	}
+
+	tests := []struct {
+		name string
+		code int
+		want int
+	}{
+		{"url length", get("/api/"+strings.Repeat("a", 250), nil), http.StatusRequestURITooLong},
+		{"arg count", get("/api/users?a=1&b=2&c=3&d=4&e=5&f=6", nil), http.StatusRequestURITooLong},
+		{"arg name", get("/api/users?"+strings.Repeat("n", 30)+"=1", nil), http.StatusRequestURITooLong},
+		{"arg value", get("/api/users?q="+strings.Repeat("v", 60), nil), http.StatusRequestURITooLong},
+		{"header size", get("/api/users", http.Header{"X-Big": {strings.Repeat("h", 300)}}), http.StatusRequestHeaderFieldsTooLarge},
+		{"header count", get("/api/users", http.Header{"X-Many": strings.Split(strings.Repeat("v,", 25), ",")}), http.StatusRequestHeaderFieldsTooLarge},
+		{"body size", post(strings.NewReader(strings.Repeat("b", 200)), "text/plain"), http.StatusRequestEntityTooLarge},
+		{"form args", post(strings.NewReader("a=1&b=2&c=3&d=4&e=5&f=6"), "application/x-www-form-urlencoded"), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if tt.code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, tt.code)
+		}
+	}
+
+	// Multipart bodies have their own, larger limit with a per-file cap
+	body, contentType := multipartBody(t, 500)
+	if code := post(body, contentType); code != http.StatusOK {
+		t.Errorf("Expected multipart upload within limits to pass, got %d", code)
+	}
+	body, contentType = multipartBody(t, 2000)
+	if code := post(body, contentType); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized file to be rejected, got %d", code)
+	}
+	body, contentType = multipartBody(t, 8000)
+	if code := post(body, contentType); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized multipart body to be rejected, got %d", code)
+	}
+}
+
+func TestRequestLimitsScore(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.LogRequestBody = true
+	cfg.Security.AnomalyThreshold = 10
+	cfg.Security.Limits = testLimits()
+	cfg.Security.Limits.Action = "score"
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	get := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// One exceeded limit scores 5, below the threshold
+	if code := get("/api/users?q=" + strings.Repeat("v", 60)); code != http.StatusOK {
+		t.Errorf("Expected a single scored violation to pass, got %d", code)
+	}
+	// Two exceeded limits reach the threshold
+	if code := get("/api/users?q=" + strings.Repeat("v", 60) + "&" + strings.Repeat("n", 30) + "=1"); code != http.StatusForbidden {
+		t.Errorf("Expected two scored violations to block, got %d", code)
+	}
+}
+
+func TestRequestLimitsScoreInspectsOversizedBody(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "BODY-ATTACK"
+  name: "Attack in body"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "body"
+      operator: "contains"
+      value: "attack"
+  actions:
+    - type: "add_score"
+`)
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.LogRequestBody = true
+	cfg.Security.AnomalyThreshold = 10
+	cfg.Security.Limits = testLimits()
+	cfg.Security.Limits.Action = "score"
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	post := func(body string) int {
+		resp, err := http.Post(server.URL+"/upload", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The declared length exceeds the limit, but the start of the body is still inspected
+	if code := post("attack" + strings.Repeat("x", 500)); code != http.StatusForbidden {
+		t.Errorf("Expected an oversized body to be inspected in score mode, got %d", code)
+	}
+	if code := post(strings.Repeat("x", 500)); code != http.StatusOK {
+		t.Errorf("Expected a clean oversized body to only be scored, got %d", code)
+	}
+
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("b", 1000)))
+	req.Header.Set("Content-Type", "text/plain")
+	norm, err := normalize.RequestWithLimits(req, true, normalize.Limits{MaxBodySize: 100, InspectOversized: true})
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if len(norm.Body) != 100 || len(norm.Violations) != 1 {
+		t.Errorf("Expected the first 100 bytes and one violation, got %d bytes and %v", len(norm.Body), norm.Violations)
+	}
+	if data, _ := io.ReadAll(req.Body); len(data) != 1000 {
+		t.Errorf("Expected the full body to be restored, got %d bytes", len(data))
+	}
+}
+
+func TestNormalizeBodyReadIsBounded(t *testing.T) {
+	body := strings.Repeat("b", 1000)
+	req := httptest.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "text/plain")
+
+	norm, err := normalize.RequestWithLimits(req, true, normalize.Limits{MaxBodySize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(norm.Body) != 100 {
+		t.Errorf("Expected inspected body to be truncated to the limit, got %d bytes", len(norm.Body))
+	}
+	if len(norm.Violations) != 1 || norm.Violations[0].Limit != normalize.LimitBodySize {
+		t.Fatalf("Expected a body size violation, got %+v", norm.Violations)
+	}
+	if got := norm.Violations[0].String(); got != "max_body_size (101 > 100)" {
+		t.Errorf("Unexpected violation description %q", got)
+	}
+
+	// The full body is still available to the upstream
+	forwarded, _ := io.ReadAll(req.Body)
+	if string(forwarded) != body {
+		t.Errorf("Expected full body to be forwarded, got %d bytes", len(forwarded))
+	}
+}
@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/detection"
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// evaluate runs a request through normalization, detection and decision
+func evaluate(t *testing.T, req *http.Request, cfg *config.Config) decision.Decision {
+	ruleSet, err := rules.LoadRules([]string{"../../configs/ruleset.yaml"})
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Failed to normalize request: %v", err)
+	}
+	tx, err := detection.EvaluateRequest(req, norm, ruleSet)
+	if err != nil {
+		t.Fatalf("Failed to evaluate request: %v", err)
+	}
+	return decision.Decide(tx, cfg)
+}
+
+func TestMatchedDataCapture(t *testing.T) {
+	cfg := &config.Config{Security: config.SecurityConfig{AnomalyThreshold: 10}}
+	req := httptest.NewRequest("GET", "/search?page=1&q=%3Cscript%3Ealert(1)%3C/script%3E", nil)
+
+	dec := evaluate(t, req, cfg)
+	if dec.Action != "block" {
+		t.Fatalf("Expected block, got %s", dec.Action)
+	}
+
+	var found bool
+	for _, m := range dec.Matches {
+		if m.RuleID == "XSS-001" {
+			found = true
+			if m.Variable != "ARGS:q" {
+				t.Errorf("Expected variable ARGS:q, got %s", m.Variable)
+			}
+			if m.Value != "<script>" {
+				t.Errorf("Expected matched value <script>, got %q", m.Value)
+			}
+			if m.Operator != "regex" || m.Score != 10 {
+				t.Errorf("Unexpected operator/score: %s/%d", m.Operator, m.Score)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("Expected XSS-001 match, got %+v", dec.Matches)
+	}
+}
+
+func TestMatchedDataTruncationAndRedaction(t *testing.T) {
+	cfg := &config.Config{Security: config.SecurityConfig{AnomalyThreshold: 10}}
+	cfg.Logging.MatchedData.MaxLength = 4
+	req := httptest.NewRequest("GET", "/search?q=1%20UNION%20SELECT%20password", nil)
+
+	dec := evaluate(t, req, cfg)
+	if len(dec.Matches) == 0 {
+		t.Fatal("Expected matches")
+	}
+	for _, m := range dec.Matches {
+		if len(m.Value) > 4+len("...") {
+			t.Errorf("Expected truncated value, got %q", m.Value)
+		}
+	}
+
+	cfg.Logging.MatchedData.RedactVariables = []string{"ARGS:*"}
+	dec = evaluate(t, req, cfg)
+	for _, m := range dec.Matches {
+		if m.Value != "[REDACTED]" {
+			t.Errorf("Expected redacted value for %s, got %q", m.Variable, m.Value)
+		}
+	}
+}
+
+func TestBlockResponseIncludesRequestID(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	wafServer, err := createTestWAFServer(t, upstream.URL)
+	if err != nil {
+		t.Fatalf("Failed to create WAF server: %v", err)
+	}
+	defer wafServer.Close()
+
+	req, _ := http.NewRequest("GET", wafServer.URL+"/api/users?id=1%20OR%201=1", nil)
+	req.Header.Set("X-Request-ID", "test-request-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body["request_id"] != "test-request-123" {
+		t.Errorf("Expected request_id in block response, got %v", body["request_id"])
+	}
+}
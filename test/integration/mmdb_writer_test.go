@@ -0,0 +1,193 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"sort"
+	"testing"
+)
+
+// mmdbNode is a search tree node used when building test databases
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  int // offset into the data section for leaves, -1 otherwise
+	index int
+}
+
+// writeTestMMDB writes a minimal IPv6 MaxMind DB file mapping non-overlapping
+// prefixes to records. It supports the subset of types used by GeoIP/ASN databases.
+func writeTestMMDB(t *testing.T, path string, recordSize int, records map[string]map[string]interface{}) {
+	t.Helper()
+
+	var data bytes.Buffer
+	root := &mmdbNode{data: -1}
+
+	prefixes := make([]string, 0, len(records))
+	for p := range records {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	for _, p := range prefixes {
+		prefix := netip.MustParsePrefix(p)
+		bits := prefix.Bits()
+		addr := prefix.Addr().As16()
+		if prefix.Addr().Is4() {
+			// IPv4 lives under ::/96 in an IPv6 tree
+			a4 := prefix.Addr().As4()
+			addr = [16]byte{}
+			copy(addr[12:], a4[:])
+			bits += 96
+		}
+
+		offset := data.Len()
+		encodeMMDB(&data, records[p])
+
+		node := root
+		for i := 0; i < bits; i++ {
+			bit := (addr[i/8] >> (7 - i%8)) & 1
+			if node.child[bit] == nil {
+				node.child[bit] = &mmdbNode{data: -1}
+			}
+			node = node.child[bit]
+		}
+		node.data = offset
+	}
+
+	// Number internal nodes breadth first
+	var nodes []*mmdbNode
+	queue := []*mmdbNode{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.data >= 0 {
+			continue
+		}
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	recordValue := func(c *mmdbNode) uint32 {
+		switch {
+		case c == nil:
+			return uint32(nodeCount)
+		case c.data >= 0:
+			return uint32(nodeCount + 16 + c.data)
+		default:
+			return uint32(c.index)
+		}
+	}
+
+	var out bytes.Buffer
+	for _, n := range nodes {
+		left, right := recordValue(n.child[0]), recordValue(n.child[1])
+		switch recordSize {
+		case 24:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			out.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left),
+				byte((left>>24)&0x0F)<<4 | byte((right>>24)&0x0F),
+				byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&out, binary.BigEndian, left)
+			binary.Write(&out, binary.BigEndian, right)
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDB(&out, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test-DB",
+		"ip_version":                  uint16(6),
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write mmdb: %v", err)
+	}
+}
+
+// encodeMMDB encodes a value in the MaxMind DB data section format
+func encodeMMDB(buf *bytes.Buffer, v interface{}) {
+	writeCtrl := func(typeNum, size int) {
+		var first byte
+		var ext []byte
+		if typeNum > 7 {
+			ext = []byte{byte(typeNum - 7)}
+		} else {
+			first = byte(typeNum << 5)
+		}
+		var sizeBytes []byte
+		switch {
+		case size < 29:
+			first |= byte(size)
+		case size < 285:
+			first |= 29
+			sizeBytes = []byte{byte(size - 29)}
+		default:
+			first |= 30
+			s := size - 285
+			sizeBytes = []byte{byte(s >> 8), byte(s)}
+		}
+		buf.WriteByte(first)
+		buf.Write(ext)
+		buf.Write(sizeBytes)
+	}
+	writeUint := func(typeNum int, n uint64) {
+		var b []byte
+		for n > 0 {
+			b = append([]byte{byte(n)}, b...)
+			n >>= 8
+		}
+		writeCtrl(typeNum, len(b))
+		buf.Write(b)
+	}
+
+	switch val := v.(type) {
+	case string:
+		writeCtrl(2, len(val))
+		buf.WriteString(val)
+	case uint16:
+		writeUint(5, uint64(val))
+	case uint32:
+		writeUint(6, uint64(val))
+	case uint64:
+		writeUint(9, val)
+	case bool:
+		size := 0
+		if val {
+			size = 1
+		}
+		writeCtrl(14, size)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeCtrl(7, len(keys))
+		for _, k := range keys {
+			encodeMMDB(buf, k)
+			encodeMMDB(buf, val[k])
+		}
+	case []interface{}:
+		writeCtrl(11, len(val))
+		for _, item := range val {
+			encodeMMDB(buf, item)
+		}
+	default:
+		panic("unsupported mmdb test type")
+	}
+}
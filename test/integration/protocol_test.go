@@ -0,0 +1,129 @@
+package integration
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// startProtocolServer runs the full WAF server, including raw head recording,
+// with a threshold low enough for any protocol anomaly to block
+func startProtocolServer(t *testing.T) string {
+	upstream := createTestUpstreamServer(t)
+	t.Cleanup(upstream.Close)
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.AnomalyThreshold = 3
+	handler := newTestHandler(t, cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go httpserver.NewServer(cfg, handler).Serve(ln)
+	return ln.Addr().String()
+}
+
+// rawExchange writes raw requests on one connection and returns the status of
+// each response
+func rawExchange(t *testing.T, addr string, requests ...string) []int {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	var statuses []int
+	for _, raw := range requests {
+		if _, err := conn.Write([]byte(raw)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Reading response to %q failed: %v", raw, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+	return statuses
+}
+
+func TestProtocolAnomaliesBlocked(t *testing.T) {
+	addr := startProtocolServer(t)
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"content-length with transfer-encoding", "POST /api HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"duplicate content-length", "POST /api HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"},
+		{"obsolete line folding", "GET /api HTTP/1.1\r\nHost: a\r\nX-Test: a\r\n b\r\n\r\n"},
+		{"non-standard method", "FOO /api HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"absolute uri host mismatch", "GET http://internal.example/api HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"invalid percent-encoding", "GET /api?q=%G1 HTTP/1.1\r\nHost: a\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := rawExchange(t, addr, tt.raw)[0]; status != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d", status)
+			}
+		})
+	}
+}
+
+func TestProtocolHeadsFollowKeepAlive(t *testing.T) {
+	addr := startProtocolServer(t)
+
+	// Bodies on the same connection must not be mistaken for request heads
+	statuses := rawExchange(t, addr,
+		"POST /api HTTP/1.1\r\nHost: a\r\nContent-Length: 22\r\n\r\nX: y\r\nA: b\r\n  folded\r\n",
+		"POST /api HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nTrailer: x\r\n\r\n",
+		"GET /api HTTP/1.1\r\nHost: a\r\n\r\n",
+		"GET /api HTTP/1.1\r\nHost: a\r\nX-Test: a\r\n b\r\n\r\n",
+	)
+	want := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusForbidden}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("Request %d: expected status %d, got %d", i+1, want[i], statuses[i])
+		}
+	}
+}
+
+func TestProtocolAnomaliesWithoutRawHead(t *testing.T) {
+	req := httptest.NewRequest("PROPFIND", "/files?name=100%", nil)
+	req.Header["Bad Name"] = []string{"x"}
+	req.Header.Set("X-Test", "a\x01b")
+
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	found := make(map[string]bool)
+	for _, a := range norm.ProtocolAnomalies {
+		found[a.Name] = true
+	}
+	for _, name := range []string{
+		normalize.AnomalyNonStandardMethod,
+		normalize.AnomalyInvalidURLEncoding,
+		normalize.AnomalyInvalidHeaderName,
+		normalize.AnomalyInvalidHeaderValue,
+	} {
+		if !found[name] {
+			t.Errorf("Expected anomaly %s, got %+v", name, norm.ProtocolAnomalies)
+		}
+	}
+	if found[normalize.AnomalyMissingHost] {
+		t.Error("Host from the request target should count as present")
+	}
+}
@@ -0,0 +1,478 @@
+package integration
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/logging"
+	"github.com/waf-draft/waf/internal/mitigation"
+	"github.com/waf-draft/waf/internal/ratelimit"
+	"github.com/waf-draft/waf/internal/state"
+)
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	algorithms := []string{
+		ratelimit.AlgorithmSlidingLog,
+		ratelimit.AlgorithmSlidingWindow,
+		ratelimit.AlgorithmTokenBucket,
+		ratelimit.AlgorithmGCRA,
+	}
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, err := ratelimit.New(ratelimit.Config{
+				Algorithm: algorithm,
+				Limit:     5,
+				Window:    time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer limiter.Stop()
+
+			for i := 0; i < 5; i++ {
+				if !limiter.Allow("192.0.2.1").Allowed {
+					t.Fatalf("request %d should be allowed", i+1)
+				}
+			}
+			if limiter.Allow("192.0.2.1").Allowed {
+				t.Error("request over the limit should be rejected")
+			}
+			if !limiter.Allow("192.0.2.2").Allowed {
+				t.Error("other keys should have their own limit")
+			}
+		})
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+	for _, algorithm := range []string{ratelimit.AlgorithmTokenBucket, ratelimit.AlgorithmGCRA} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, err := ratelimit.New(ratelimit.Config{
+				Algorithm: algorithm,
+				Limit:     10,
+				Window:    100 * time.Millisecond,
+				Burst:     1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !limiter.Allow("k").Allowed {
+				t.Fatal("first request should be allowed")
+			}
+			if limiter.Allow("k").Allowed {
+				t.Error("burst of 1 should reject an immediate second request")
+			}
+			time.Sleep(15 * time.Millisecond)
+			if !limiter.Allow("k").Allowed {
+				t.Error("request should be allowed after one emission interval")
+			}
+		})
+	}
+}
+
+func TestRateLimitKeyEviction(t *testing.T) {
+	limiter, err := ratelimit.New(ratelimit.Config{
+		Algorithm: ratelimit.AlgorithmTokenBucket,
+		Limit:     1,
+		Window:    time.Hour,
+		Shards:    1,
+		MaxKeys:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter.Allow("first")
+	if limiter.Allow("first").Allowed {
+		t.Fatal("second request should be rejected")
+	}
+	for i := 0; i < 20; i++ {
+		limiter.Allow(fmt.Sprintf("key-%d", i))
+	}
+
+	stats := limiter.GetStats()
+	if stats["tracked_keys"] != 10 {
+		t.Errorf("tracked_keys = %v, want 10", stats["tracked_keys"])
+	}
+	if stats["evicted_keys"] != int64(11) {
+		t.Errorf("evicted_keys = %v, want 11", stats["evicted_keys"])
+	}
+	// The evicted key starts over with a fresh bucket
+	if !limiter.Allow("first").Allowed {
+		t.Error("evicted key should start with a fresh limit")
+	}
+}
+
+func TestSharedRateLimitEvictionKeepsCounts(t *testing.T) {
+	backend := state.NewMemoryBackend()
+	defer backend.Close()
+	limiter, err := ratelimit.New(ratelimit.Config{
+		Limit:        2,
+		Window:       time.Hour,
+		MaxKeys:      1,
+		Backend:      backend,
+		SyncInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Stop()
+
+	// Each request to "b" evicts "a"; its counts must reach the backend first
+	for i := 0; i < 2; i++ {
+		if !limiter.Allow("a").Allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+		limiter.Allow("b")
+	}
+	if limiter.Allow("a").Allowed {
+		t.Error("counts of an evicted key should not be lost")
+	}
+}
+
+func TestRateLimitUnknownAlgorithm(t *testing.T) {
+	if _, err := ratelimit.New(ratelimit.Config{Algorithm: "leaky", Limit: 1, Window: time.Second}); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
+
+func TestMisconfiguredLimitsRefuseToStart(t *testing.T) {
+	ruleSet, err := rules.LoadRules([]string{"../../configs/ruleset.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logging.NewLogger(filepath.Join(t.TempDir(), "waf.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	cases := map[string]func(cfg *config.Config){
+		"invalid policy": func(cfg *config.Config) {
+			cfg.Security.RateLimit = config.RateLimitConfig{Enabled: true, MaxRequests: 1, WindowSeconds: 60,
+				Policies: []config.RateLimitPolicyConfig{{Name: "bad", Match: config.RateLimitMatchConfig{PathRegex: "("}}}}
+		},
+		"unknown backend": func(cfg *config.Config) {
+			cfg.State.Backend = "etcd"
+		},
+		"cluster without secret": func(cfg *config.Config) {
+			cfg.State.Backend = "gossip"
+			cfg.State.Cluster.BindAddress = "127.0.0.1:0"
+		},
+	}
+	for name, configure := range cases {
+		cfg := &config.Config{}
+		configure(cfg)
+		handler, err := httpserver.NewWAFHandler(cfg, ruleSet, logger, http.NotFoundHandler())
+		if err == nil {
+			handler.Close()
+			t.Errorf("%s: expected the handler to refuse to start", name)
+		}
+	}
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.AnomalyThreshold = 10
+	cfg.Security.RateLimit = config.RateLimitConfig{
+		Enabled:       true,
+		MaxRequests:   100,
+		WindowSeconds: 60,
+		Policies: []config.RateLimitPolicyConfig{
+			{
+				Name:        "login",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/api/login", Methods: []string{"POST"}},
+				Key:         "ip",
+				MaxRequests: 2,
+			},
+			{
+				Name:        "partners",
+				Match:       config.RateLimitMatchConfig{PathRegex: "^/api/partner/"},
+				Key:         "header:X-API-Key",
+				MaxRequests: 1,
+			},
+			{
+				Name:        "search",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/search"},
+				MaxRequests: 1,
+				Action:      "score",
+				Score:       10,
+			},
+			{
+				Name:        "export",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/export"},
+				MaxRequests: 1,
+				Action:      "log",
+			},
+		},
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	do := func(method, path string, header map[string]string) int {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Login is limited per IP for POST only
+	do("POST", "/api/login", nil)
+	do("POST", "/api/login", nil)
+	if code := do("POST", "/api/login", nil); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for third login, got %d", code)
+	}
+	if code := do("GET", "/api/login", nil); code != http.StatusOK {
+		t.Errorf("Expected GET login to be unaffected, got %d", code)
+	}
+
+	// Partners are limited per API key
+	if code := do("GET", "/api/partner/orders", map[string]string{"X-API-Key": "a"}); code != http.StatusOK {
+		t.Errorf("Expected 200 for first partner request, got %d", code)
+	}
+	if code := do("GET", "/api/partner/orders", map[string]string{"X-API-Key": "a"}); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for second request with key a, got %d", code)
+	}
+	if code := do("GET", "/api/partner/orders", map[string]string{"X-API-Key": "b"}); code != http.StatusOK {
+		t.Errorf("Expected key b to have its own limit, got %d", code)
+	}
+
+	// The score action feeds the anomaly score instead of blocking directly
+	do("GET", "/search?q=a", nil)
+	if code := do("GET", "/search?q=b", nil); code != http.StatusForbidden {
+		t.Errorf("Expected anomaly block from scored rate limit, got %d", code)
+	}
+
+	// Log-only policies never block
+	do("GET", "/export", nil)
+	if code := do("GET", "/export", nil); code != http.StatusOK {
+		t.Errorf("Expected log-only policy to allow, got %d", code)
+	}
+}
+
+func TestRateLimitBindingAndHosts(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.RateLimit = config.RateLimitConfig{
+		Enabled:       true,
+		MaxRequests:   100,
+		WindowSeconds: 60,
+		Policies: []config.RateLimitPolicyConfig{
+			{
+				Name:        "export",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/export"},
+				MaxRequests: 1,
+				Action:      "log",
+			},
+			{
+				Name:        "export-total",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/export"},
+				MaxRequests: 10,
+			},
+			{
+				Name:        "v6",
+				Match:       config.RateLimitMatchConfig{PathPrefix: "/v6", Hosts: []string{"::1"}},
+				MaxRequests: 1,
+			},
+		},
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	do := func(path, host string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// An exceeded log-only policy does not tell clients to back off
+	do("/export", "")
+	resp := do("/export", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Retry-After") != "" {
+		t.Errorf("Expected no Retry-After from a log-only policy, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if got := resp.Header.Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("Expected the fewest remaining requests to be reported, got %q", got)
+	}
+
+	// Bracketed IPv6 hosts with a port match the host condition
+	do("/v6", "[::1]:8080")
+	if resp := do("/v6", "[::1]:8080"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected the IPv6 host policy to apply, got %d", resp.StatusCode)
+	}
+
+	route, err := mitigation.NewRoute(config.RouteMatchConfig{Hosts: []string{"::1", "example.com"}})
+	if err != nil {
+		t.Fatalf("Failed to compile route: %v", err)
+	}
+	for host, want := range map[string]bool{"[::1]:8080": true, "[::1]": true, "example.com:443": true, "[::2]:8080": false} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		if got := route.Matches(req, "/"); got != want {
+			t.Errorf("Route.Matches(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestRateLimitPolicyKeys(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"partner-42","tier":7}`))
+	token := "Bearer eyJhbGciOiJIUzI1NiJ9." + claims + ".sig"
+
+	set, err := ratelimit.NewPolicySet([]ratelimit.Policy{
+		{Name: "jwt", Key: "jwt:sub", Limiter: ratelimit.Config{Limit: 1, Window: time.Hour}},
+		{Name: "net", Key: "ipv6_64+cookie:session", Limiter: ratelimit.Config{Limit: 1, Window: time.Hour}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Stop()
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", token)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+
+	if v := set.Check(req, "/", "2001:db8::1"); len(v) != 2 || !v[0].Allowed || !v[1].Allowed {
+		t.Fatalf("Expected first request to pass both policies, got %+v", v)
+	}
+	results := set.Check(req, "/", "2001:db8::ffff")
+	if len(results) != 2 || results[0].Allowed || results[1].Allowed {
+		t.Fatalf("Expected both policies to trip within the same /64 and subject, got %+v", results)
+	}
+	if results[0].Key != "partner-42" || results[1].Key != "2001:db8::/64|s1" {
+		t.Errorf("Unexpected keys: %+v", results)
+	}
+	if results[0].Policy != "jwt" || results[0].RetryAfter <= 0 {
+		t.Errorf("Expected policy name and retry delay in result, got %+v", results[0])
+	}
+
+	// Requests without the key material are not counted by the policy
+	if v := set.Check(httptest.NewRequest("GET", "/", nil), "/", "2001:db8::1"); len(v) != 0 {
+		t.Errorf("Expected policies without resolvable keys to be skipped, got %+v", v)
+	}
+
+	if _, err := ratelimit.NewPolicySet([]ratelimit.Policy{{Key: "device", Limiter: ratelimit.Config{Limit: 1, Window: time.Second}}}); err == nil {
+		t.Error("Expected error for unknown key part")
+	}
+}
+
+func TestRateLimitResultAccounting(t *testing.T) {
+	for _, algorithm := range []string{
+		ratelimit.AlgorithmSlidingLog,
+		ratelimit.AlgorithmSlidingWindow,
+		ratelimit.AlgorithmTokenBucket,
+		ratelimit.AlgorithmGCRA,
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, err := ratelimit.New(ratelimit.Config{Algorithm: algorithm, Limit: 3, Window: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer limiter.Stop()
+
+			for want := 2; want >= 0; want-- {
+				result := limiter.Allow("k")
+				if !result.Allowed || result.Remaining != want || result.Limit != 3 {
+					t.Fatalf("Expected allowed with %d remaining, got %+v", want, result)
+				}
+				if result.Reset <= 0 || result.Reset > 2*time.Hour {
+					t.Errorf("Unexpected reset %s", result.Reset)
+				}
+			}
+			result := limiter.Allow("k")
+			if result.Allowed || result.Remaining != 0 {
+				t.Fatalf("Expected rejection, got %+v", result)
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > 2*time.Hour {
+				t.Errorf("Unexpected retry after %s", result.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.RateLimit = config.RateLimitConfig{
+		Enabled:       true,
+		MaxRequests:   2,
+		WindowSeconds: 60,
+		Algorithm:     ratelimit.AlgorithmTokenBucket,
+		LegacyHeaders: true,
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	get := func() *http.Response {
+		resp, err := http.Get(server.URL + "/api/users")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := get()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("RateLimit-Limit") != "2" || resp.Header.Get("RateLimit-Remaining") != "1" {
+		t.Errorf("Unexpected rate limit headers: %v", resp.Header)
+	}
+	if resp.Header.Get("RateLimit-Reset") != "30" || resp.Header.Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("Unexpected reset or legacy headers: %v", resp.Header)
+	}
+
+	get().Body.Close()
+	resp = get()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") != "30" || resp.Header.Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Unexpected throttled headers: %v", resp.Header)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Invalid 429 body: %v", err)
+	}
+	if body["error"] != "Too Many Requests" || body["retry_after"] != float64(30) {
+		t.Errorf("Unexpected 429 body: %v", body)
+	}
+}
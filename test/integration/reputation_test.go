@@ -0,0 +1,149 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/ipfilter"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestReputationFeedFormats(t *testing.T) {
+	dir := t.TempDir()
+	drop := filepath.Join(dir, "drop.txt")
+	writeFile(t, drop, "; Spamhaus DROP List\n1.10.16.0/20 ; SBL256894\n203.0.113.0/24 ; SBL1\nbogus\n")
+
+	abuse := filepath.Join(dir, "abuse")
+	os.Mkdir(abuse, 0755)
+	writeFile(t, filepath.Join(abuse, "a.csv"), "# ip,reason\n198.51.100.7,scanner\nfirst-seen,203.0.113.9\n")
+	writeFile(t, filepath.Join(abuse, "b.json"), `{"entries": ["2001:db8::/48", {"cidr": "192.0.2.0/28"}, {"ip": "203.0.113.50"}]}`)
+
+	lists, err := ipfilter.NewReputationLists([]ipfilter.FeedSource{
+		{Name: "spamhaus_drop", Path: drop},
+		{Name: "internal_abuse", Path: abuse},
+	}, 0)
+	if err != nil {
+		t.Fatalf("Failed to load lists: %v", err)
+	}
+
+	cases := map[string][]string{
+		"1.10.20.1":      {"spamhaus_drop"},
+		"198.51.100.7":   {"internal_abuse"},
+		"2001:db8::5":    {"internal_abuse"},
+		"192.0.2.3":      {"internal_abuse"},
+		"203.0.113.50":   {"internal_abuse", "spamhaus_drop"},
+		"203.0.113.9":    {"internal_abuse", "spamhaus_drop"},
+		"198.51.100.8":   nil,
+		"not-an-address": nil,
+	}
+	for ip, want := range cases {
+		got := lists.Lookup(ip)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	// Updating the file is picked up on reload
+	writeFile(t, drop, "100.64.0.0/10\n")
+	if err := lists.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got := lists.Lookup("1.10.20.1"); got != nil {
+		t.Errorf("Expected removed entry to be gone, got %v", got)
+	}
+	if got := lists.Lookup("100.64.1.1"); len(got) != 1 {
+		t.Errorf("Expected new entry to be loaded, got %v", got)
+	}
+}
+
+func TestReputationPlainFeedReadError(t *testing.T) {
+	// A line too long to scan is an error, not a silently truncated list
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "10.0.0.1\n"+strings.Repeat("x", 128<<10)+"\n10.0.0.2\n")
+
+	if _, err := ipfilter.NewReputationLists([]ipfilter.FeedSource{{Name: "broken", Path: path}}, 0); err == nil {
+		t.Error("Expected an unreadable plain feed to fail to load")
+	}
+}
+
+func TestReputationListsWatchForChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeFile(t, path, "10.0.0.1\n")
+
+	lists, err := ipfilter.NewReputationLists([]ipfilter.FeedSource{{Name: "watch", Path: path}}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to load lists: %v", err)
+	}
+	defer lists.Stop()
+
+	writeFile(t, path, "10.0.0.1\n10.0.0.2\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for len(lists.Lookup("10.0.0.2")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected list change to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReputationBlockAndScore(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "block.txt"), "198.51.100.0/24\n")
+	writeFile(t, filepath.Join(dir, "score.txt"), "192.0.2.0/24\n")
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Server.TrustedProxies = []string{"127.0.0.1", "::1"}
+	cfg.Security.IPFilter = config.IPFilterConfig{
+		Enabled: true,
+		Lists: []config.ReputationListConfig{
+			{Name: "firehol_level1", Path: filepath.Join(dir, "block.txt"), Action: "block"},
+			{Name: "tor_exits", Path: filepath.Join(dir, "score.txt"), Action: "score", Score: 5},
+		},
+	}
+	server := httptest.NewServer(newTestHandler(t, cfg))
+	defer server.Close()
+
+	get := func(ip, query string) int {
+		req, _ := http.NewRequest("GET", server.URL+"/api/search"+query, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("198.51.100.20", ""); code != http.StatusForbidden {
+		t.Errorf("Expected listed client to be blocked, got %d", code)
+	}
+	if code := get("192.0.2.1", ""); code != http.StatusOK {
+		t.Errorf("Expected score-only list not to block on its own, got %d", code)
+	}
+	// 5 from the list plus 8 from XSS-002 crosses the threshold of 10
+	if code := get("192.0.2.1", "?q=onload%3D1"); code != http.StatusForbidden {
+		t.Errorf("Expected list score to combine with rule score, got %d", code)
+	}
+	if code := get("203.0.113.1", "?q=onload%3D1"); code != http.StatusOK {
+		t.Errorf("Expected unlisted client below threshold to pass, got %d", code)
+	}
+}
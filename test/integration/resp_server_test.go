@@ -0,0 +1,227 @@
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// respServer is a minimal stand-in for Redis implementing the commands used by
+// the state backend: AUTH, SELECT, GET, SET (PX, NX), DEL, INCRBY, PEXPIRE, SCAN
+// and MULTI/EXEC
+type respServer struct {
+	listener net.Listener
+	password string
+	mu       sync.Mutex
+	values   map[string]string
+	expiry   map[string]time.Time
+	commands int
+}
+
+func newRESPServer(t *testing.T, password string) *respServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &respServer{
+		listener: ln,
+		password: password,
+		values:   make(map[string]string),
+		expiry:   make(map[string]time.Time),
+	}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *respServer) Addr() string { return s.listener.Addr().String() }
+
+func (s *respServer) Close() { s.listener.Close() }
+
+func (s *respServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *respServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		if cmd == "AUTH" {
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+			}
+			continue
+		}
+		switch {
+		case cmd == "MULTI":
+			inMulti, queued = true, nil
+			io.WriteString(conn, "+OK\r\n")
+		case cmd == "EXEC":
+			inMulti = false
+			io.WriteString(conn, s.execAll(queued))
+		case inMulti:
+			queued = append(queued, args)
+			io.WriteString(conn, "+QUEUED\r\n")
+		default:
+			io.WriteString(conn, s.exec(cmd, args[1:]))
+		}
+	}
+}
+
+// execAll runs queued commands atomically and returns the EXEC reply
+func (s *respServer) execAll(cmds [][]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply := fmt.Sprintf("*%d\r\n", len(cmds))
+	for _, args := range cmds {
+		reply += s.execLocked(strings.ToUpper(args[0]), args[1:])
+	}
+	return reply
+}
+
+func (s *respServer) exec(cmd string, args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execLocked(cmd, args)
+}
+
+func (s *respServer) execLocked(cmd string, args []string) string {
+	s.commands++
+
+	for key, exp := range s.expiry {
+		if time.Now().After(exp) {
+			delete(s.values, key)
+			delete(s.expiry, key)
+		}
+	}
+
+	switch cmd {
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := s.values[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "SET":
+		var ttl time.Duration
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				if _, ok := s.values[args[0]]; ok {
+					return "$-1\r\n"
+				}
+			case "PX":
+				if i+1 < len(args) {
+					ms, _ := strconv.Atoi(args[i+1])
+					ttl = time.Duration(ms) * time.Millisecond
+					i++
+				}
+			}
+		}
+		s.values[args[0]] = args[1]
+		delete(s.expiry, args[0])
+		if ttl > 0 {
+			s.expiry[args[0]] = time.Now().Add(ttl)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := s.values[args[0]]
+		delete(s.values, args[0])
+		delete(s.expiry, args[0])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "INCRBY":
+		n, _ := strconv.ParseInt(s.values[args[0]], 10, 64)
+		delta, _ := strconv.ParseInt(args[1], 10, 64)
+		n += delta
+		s.values[args[0]] = strconv.FormatInt(n, 10)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "PEXPIRE":
+		ms, _ := strconv.Atoi(args[1])
+		s.expiry[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	case "SCAN":
+		// Return all matches in one batch
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for key := range s.values {
+			if ok, _ := path.Match(pattern, key); ok {
+				keys = append(keys, key)
+			}
+		}
+		reply := fmt.Sprintf("*2\r\n%s*%d\r\n", bulk("0"), len(keys))
+		for _, key := range keys {
+			reply += bulk(key)
+		}
+		return reply
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// readCommand reads a RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("invalid command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
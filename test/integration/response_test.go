@@ -0,0 +1,254 @@
+package integration
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/response"
+)
+
+const (
+	javaStackTrace = "java.lang.NullPointerException: null\n" +
+		"\tat com.example.app.OrderController.show(OrderController.java:42)\n" +
+		"\tat sun.reflect.NativeMethodAccessorImpl.invoke0(Native Method)\n"
+	leakyOrder = `{"card":"4111 1111 1111 1111","ssn":"123-45-6789","order":"1234567890123"}`
+)
+
+// createLeakyUpstreamServer serves responses that leak internal details and PII
+func createLeakyUpstreamServer(t *testing.T) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/trace":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, javaStackTrace)
+		case "/sql":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, "<p>You have an error in your SQL syntax; check the manual near ''' at line 1</p>")
+		case "/listing":
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<html><head><title>Index of /backup</title></head></html>")
+		case "/order":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, leakyOrder)
+		case "/order-gzip", "/clean-gzip":
+			body := leakyOrder
+			if r.URL.Path == "/clean-gzip" {
+				body = `{"status":"ok"}`
+			}
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			io.WriteString(zw, body)
+			zw.Close()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+			w.Write(buf.Bytes())
+		case "/large":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, strings.Repeat("a", 4096)+javaStackTrace)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, javaStackTrace)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"status":"ok"}`)
+		}
+	})
+	return httptest.NewServer(handler)
+}
+
+func TestResponseInspection(t *testing.T) {
+	upstream := createLeakyUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.Response = config.ResponseConfig{Enabled: true, MaxBodyBytes: 1024}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/trace", http.StatusForbidden, ""},
+		{"/sql", http.StatusForbidden, ""},
+		{"/listing", http.StatusForbidden, ""},
+		{"/order", http.StatusOK, `{"card":"**** **** **** ****","ssn":"***-**-****","order":"1234567890123"}`},
+		{"/order-gzip", http.StatusOK, `{"card":"**** **** **** ****","ssn":"***-**-****","order":"1234567890123"}`},
+		{"/clean-gzip", http.StatusOK, `{"status":"ok"}`},
+		{"/clean", http.StatusOK, `{"status":"ok"}`},
+		// Only the first MaxBodyBytes are inspected
+		{"/large", http.StatusOK, strings.Repeat("a", 4096) + javaStackTrace},
+		{"/image", http.StatusOK, javaStackTrace},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+		if tt.status == http.StatusForbidden {
+			if bytes.Contains(body, []byte("OrderController")) || bytes.Contains(body, []byte("SQL syntax")) {
+				t.Errorf("%s: blocked response leaks the upstream body: %s", tt.path, body)
+			}
+			continue
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.body, body)
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength != int64(len(body)) {
+			t.Errorf("%s: Content-Length %d does not match body length %d", tt.path, resp.ContentLength, len(body))
+		}
+	}
+}
+
+func TestResponseInspectionDisabled(t *testing.T) {
+	upstream := createLeakyUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/order")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != leakyOrder {
+		t.Errorf("Expected the response to pass unchanged, got %q", body)
+	}
+}
+
+func TestResponseRules(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "RESP-HEADER"
+  name: "Internal version header"
+  severity: 10
+  phase: "response"
+  enabled: true
+  conditions:
+    - target: "response_header"
+      name: "X-Backend-Version"
+      operator: "contains"
+      value: "internal"
+  actions:
+    - type: "deny"
+      param: 502
+
+- id: "RESP-TOKEN"
+  name: "Session token in body"
+  severity: 5
+  phase: "response"
+  enabled: true
+  conditions:
+    - target: "response_status"
+      operator: "eq"
+      value: "200"
+    - target: "response_body"
+      operator: "regex"
+      value: "tok_[a-z0-9]+"
+  actions:
+    - type: "mask"
+`)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			w.Header().Set("X-Backend-Version", "internal-1.2")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "a=tok_abc123 b=tok_xyz")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.Response.Enabled = true
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/version")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the deny status 502, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/tokens")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "a=***_****** b=***_***" {
+		t.Errorf("Expected masked tokens, got %q", body)
+	}
+}
+
+func TestResponseDetectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		detector string
+	}{
+		{"python traceback", "Traceback (most recent call last):\n  File \"app.py\", line 3", response.DetectorStackTrace},
+		{"php fatal error", "Fatal error: Uncaught Error in /var/www/index.php on line 12", response.DetectorStackTrace},
+		{"go panic", "panic: boom\n\ngoroutine 1 [running]:\nmain.main()", response.DetectorStackTrace},
+		{"oracle error", "ORA-00933: SQL command not properly ended", response.DetectorSQLError},
+		{"postgres error", "ERROR:  syntax error at or near \"'\"", response.DetectorSQLError},
+		{"pdo error", "SQLSTATE[42000]: Syntax error or access violation", response.DetectorSQLError},
+		{"python http.server listing", "<title>Directory listing for /</title>", response.DetectorDirectoryListing},
+		{"visa number", "card 4111-1111-1111-1111 on file", response.DetectorCreditCard},
+		{"amex number", "card 378282246310005", response.DetectorCreditCard},
+		{"ssn", "ssn 078-05-1120", response.DetectorSSN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := response.Detect(tt.body, nil)
+			if len(findings) == 0 || findings[0].Detector != tt.detector {
+				t.Errorf("Expected a %s finding, got %+v", tt.detector, findings)
+			}
+		})
+	}
+
+	clean := []string{
+		"order 4111 1111 1111 1112", // fails the Luhn check
+		"tracking 1234567890123456", // no card prefix
+		"ssn 000-12-3456",           // never issued
+		"see the index of /docs for details",
+		"at the park (see map.java)",
+	}
+	for _, body := range clean {
+		if findings := response.Detect(body, nil); len(findings) > 0 {
+			t.Errorf("Expected no findings for %q, got %+v", body, findings)
+		}
+	}
+
+	if findings := response.Detect(javaStackTrace, []string{response.DetectorSQLError}); len(findings) > 0 {
+		t.Errorf("Expected only the listed detectors to run, got %+v", findings)
+	}
+}
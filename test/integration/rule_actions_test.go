@@ -0,0 +1,236 @@
+package integration
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/decision"
+	"github.com/waf-draft/waf/internal/detection"
+	"github.com/waf-draft/waf/internal/detection/rules"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+// decideWithRules evaluates a request against an inline rule set
+func decideWithRules(t *testing.T, target string, ruleSet []rules.Rule) (*detection.Transaction, decision.Decision) {
+	req := httptest.NewRequest("GET", target, nil)
+	norm, err := normalize.Request(req, false)
+	if err != nil {
+		t.Fatalf("Failed to normalize request: %v", err)
+	}
+	tx, err := detection.EvaluateRequest(req, norm, ruleSet)
+	if err != nil {
+		t.Fatalf("Failed to evaluate request: %v", err)
+	}
+	cfg := &config.Config{Security: config.SecurityConfig{AnomalyThreshold: 10}}
+	return tx, decision.Decide(tx, cfg)
+}
+
+func queryRule(id, pattern string, actions ...rules.Action) rules.Rule {
+	return rules.Rule{
+		ID:         id,
+		Phase:      "request",
+		Enabled:    true,
+		Tags:       []string{"test"},
+		Conditions: []rules.MatchCondition{{Target: "query", Operator: "contains", Value: pattern}},
+		Actions:    actions,
+	}
+}
+
+func TestDenyActionUsesStatus(t *testing.T) {
+	_, dec := decideWithRules(t, "/?q=evil", []rules.Rule{
+		queryRule("R1", "evil", rules.Action{Type: "deny", Param: 406}),
+	})
+	if dec.Action != "block" || dec.Status != 406 {
+		t.Fatalf("Expected block with 406, got %s/%d", dec.Action, dec.Status)
+	}
+}
+
+func TestAllowActionShortCircuits(t *testing.T) {
+	tx, dec := decideWithRules(t, "/?q=evil", []rules.Rule{
+		queryRule("ALLOW", "evil", rules.Action{Type: "allow"}),
+		queryRule("SCORE", "evil", rules.Action{Type: "add_score", Param: 100}),
+	})
+	if dec.Action != "allow" {
+		t.Fatalf("Expected allow, got %s", dec.Action)
+	}
+	if tx.Score.Total != 0 || len(tx.MatchedRules) != 1 {
+		t.Errorf("Expected later rules to be skipped, got score %d and %d matches", tx.Score.Total, len(tx.MatchedRules))
+	}
+}
+
+func TestSkipRulesByTagAndID(t *testing.T) {
+	skipped := queryRule("SKIPPED", "evil", rules.Action{Type: "add_score", Param: 100})
+	skipped.Tags = []string{"noisy"}
+	tx, _ := decideWithRules(t, "/?q=evil", []rules.Rule{
+		queryRule("SKIP", "evil", rules.Action{Type: "skip_rules", Param: map[string]interface{}{
+			"ids":  []interface{}{"BYID"},
+			"tags": []interface{}{"noisy"},
+		}}),
+		skipped,
+		queryRule("BYID", "evil", rules.Action{Type: "add_score", Param: 100}),
+		queryRule("KEPT", "evil", rules.Action{Type: "add_score", Param: 1}),
+	})
+	if tx.Score.Total != 1 {
+		t.Errorf("Expected only KEPT to score, got %d", tx.Score.Total)
+	}
+}
+
+func TestSetVarAndTxTarget(t *testing.T) {
+	setVar := rules.Action{Type: "set_var", Param: map[string]interface{}{"name": "hits", "value": "+3"}}
+	tx, dec := decideWithRules(t, "/?q=evil", []rules.Rule{
+		queryRule("V1", "evil", setVar),
+		queryRule("V2", "evil", setVar),
+		{
+			ID:         "CHECK",
+			Enabled:    true,
+			Conditions: []rules.MatchCondition{{Target: "tx", Name: "hits", Operator: "ge", Value: "6"}},
+			Actions: []rules.Action{
+				{Type: "tag", Param: "repeat-offender"},
+				{Type: "set_header", Param: map[string]interface{}{"target": "response", "name": "X-WAF-Flag", "value": "1"}},
+				{Type: "redirect", Param: map[string]interface{}{"location": "/blocked", "status": 303}},
+			},
+		},
+	})
+	if tx.Vars["hits"] != "6" {
+		t.Errorf("Expected hits=6, got %q", tx.Vars["hits"])
+	}
+	if dec.Action != "redirect" || dec.Location != "/blocked" || dec.Status != 303 {
+		t.Errorf("Unexpected decision: %+v", dec)
+	}
+	if len(dec.Tags) != 1 || dec.Tags[0] != "repeat-offender" {
+		t.Errorf("Expected repeat-offender tag, got %v", dec.Tags)
+	}
+	if tx.ResponseHeaders.Get("X-WAF-Flag") != "1" {
+		t.Errorf("Expected response header to be set")
+	}
+}
+
+func TestLoadRulesValidatesActions(t *testing.T) {
+	invalid := map[string]string{
+		"unknown type":    `{type: "block"}`,
+		"deny status":     `{type: "deny", param: 200}`,
+		"deny param":      `{type: "deny", param: "nope"}`,
+		"redirect":        `{type: "redirect", param: {status: 302}}`,
+		"set_header name": `{type: "set_header", param: {value: "1"}}`,
+		"set_var name":    `{type: "set_var", param: "hits"}`,
+		"add_score param": `{type: "add_score", param: "high"}`,
+	}
+	for name, action := range invalid {
+		path := writeRulesFile(t, `
+- id: "BAD"
+  enabled: true
+  conditions:
+    - {target: "query", operator: "contains", value: "evil"}
+  actions:
+    - `+action+`
+`)
+		if _, err := rules.LoadRules([]string{path}); err == nil {
+			t.Errorf("%s: expected LoadRules to reject the action", name)
+		}
+	}
+
+	path := writeRulesFile(t, `
+- id: "GOOD"
+  enabled: true
+  conditions:
+    - {target: "query", operator: "contains", value: "evil"}
+  actions:
+    - {type: "deny", param: 406}
+    - {type: "redirect", param: "/blocked"}
+    - {type: "set_var", param: {name: "hits", value: "+1"}}
+    - {type: "add_score"}
+`)
+	if _, err := rules.LoadRules([]string{path}); err != nil {
+		t.Errorf("Expected valid actions to load, got %v", err)
+	}
+}
+
+func TestLoadRulesValidatesConditions(t *testing.T) {
+	invalid := map[string]string{
+		"transform": `{target: "query", operator: "contains", value: "evil", transforms: ["rot13"]}`,
+		"combine":   `{target: "args", operator: "contains", value: "evil", combine: "concat"}`,
+	}
+	for name, condition := range invalid {
+		path := writeRulesFile(t, `
+- id: "BAD"
+  enabled: true
+  conditions:
+    - `+condition+`
+  actions:
+    - {type: "deny"}
+`)
+		if _, err := rules.LoadRules([]string{path}); err == nil {
+			t.Errorf("%s: expected LoadRules to reject the condition", name)
+		}
+	}
+}
+
+func TestContainsFindAllIndexOffsets(t *testing.T) {
+	// "İ" lowercases to a longer sequence, which must not shift the matches
+	value := "İİ Secret and SECRET"
+	cond := rules.MatchCondition{Target: "response_body", Operator: "contains", Value: "secret"}
+	for _, compiled := range []bool{false, true} {
+		if compiled {
+			if err := cond.Compile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		locs, err := cond.FindAllIndex(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locs) != 2 {
+			t.Fatalf("Expected two matches, got %v", locs)
+		}
+		for _, loc := range locs {
+			if got := value[loc[0]:loc[1]]; !strings.EqualFold(got, "secret") {
+				t.Errorf("Match %v covers %q, want the needle", loc, got)
+			}
+		}
+	}
+}
+
+func TestInvalidActionDoesNotStopLaterRules(t *testing.T) {
+	tx, dec := decideWithRules(t, "/?q=evil", []rules.Rule{
+		queryRule("BROKEN", "evil", rules.Action{Type: "block"}),
+		queryRule("DENY", "evil", rules.Action{Type: "deny", Param: 200}),
+	})
+	if dec.Action != "block" || dec.Status != 403 {
+		t.Errorf("Expected the later deny to block with 403, got %s/%d", dec.Action, dec.Status)
+	}
+	if len(tx.MatchedRules) != 2 {
+		t.Errorf("Expected both rules to run, got %d matches", len(tx.MatchedRules))
+	}
+}
+
+func TestLoadRulesCompilesRegexes(t *testing.T) {
+	path := writeRulesFile(t, `
+- id: "BAD-REGEX"
+  enabled: true
+  conditions:
+    - {target: "query", operator: "regex", value: "(unclosed"}
+  actions:
+    - {type: "deny"}
+`)
+	if _, err := rules.LoadRules([]string{path}); err == nil {
+		t.Error("Expected LoadRules to reject an invalid regex")
+	}
+
+	path = writeRulesFile(t, `
+- id: "REGEX"
+  enabled: true
+  conditions:
+    - {target: "query", operator: "regex", value: "ev[i1]l"}
+  actions:
+    - {type: "deny", param: 406}
+`)
+	ruleSet, err := rules.LoadRules([]string{path})
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if _, dec := decideWithRules(t, "/?q=ev1l", ruleSet); dec.Status != 406 {
+		t.Errorf("Expected the precompiled regex to match, got %s/%d", dec.Action, dec.Status)
+	}
+}
@@ -0,0 +1,144 @@
+package integration
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+)
+
+func TestSecurityHeaderPolicy(t *testing.T) {
+	rulesFile := writeRulesFile(t, `
+- id: "HDR-RULE"
+  name: "Rule-set frame options"
+  severity: 0
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/partner/"
+  actions:
+    - type: "set_header"
+      param: {target: "response", name: "X-Frame-Options", value: "SAMEORIGIN"}
+
+- id: "HDR-BLOCK"
+  name: "Blocked path"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "path"
+      operator: "starts_with"
+      value: "/blocked"
+  actions:
+    - type: "deny"
+`)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "Apache/2.4.41 (Ubuntu)")
+		w.Header().Set("X-Powered-By", "PHP/7.4.3")
+		w.Header().Set("X-Frame-Options", "ALLOWALL")
+		w.Header().Set("X-Debug-Token", "a1b2c3")
+		if r.URL.Path == "/own-csp" {
+			w.Header().Set("Content-Security-Policy", "default-src https:")
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Rules.Files = []string{rulesFile}
+	cfg.Security.Headers = config.SecurityHeadersConfig{
+		Enabled: true,
+		Set: map[string]string{
+			"strict-transport-security": "max-age=31536000",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+		},
+		Defaults: map[string]string{
+			"Content-Security-Policy": "default-src 'self'",
+			"Referrer-Policy":         "no-referrer",
+		},
+		Routes: []config.SecurityHeadersRouteConfig{
+			{Match: config.RouteMatchConfig{PathPrefix: "/raw/"}, Disabled: true},
+			{
+				Match: config.RouteMatchConfig{PathPrefix: "/embed/"},
+				Set:   map[string]string{"X-Frame-Options": ""},
+				Strip: []string{"x-debug-token"},
+			},
+			{
+				Match: config.RouteMatchConfig{Hosts: []string{"admin.example.com"}},
+				Set:   map[string]string{"Permissions-Policy": "camera=()"},
+			},
+		},
+	}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		path string
+		host string
+		want map[string]string
+	}{
+		{"global policy", "/page", "", map[string]string{
+			"Server":                    "",
+			"X-Powered-By":              "",
+			"Strict-Transport-Security": "max-age=31536000",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Content-Security-Policy":   "default-src 'self'",
+			"Referrer-Policy":           "no-referrer",
+		}},
+		{"upstream default kept", "/own-csp", "", map[string]string{
+			"Content-Security-Policy": "default-src https:",
+		}},
+		{"route disabled", "/raw/file", "", map[string]string{
+			"Server":                    "Apache/2.4.41 (Ubuntu)",
+			"X-Frame-Options":           "ALLOWALL",
+			"Strict-Transport-Security": "",
+		}},
+		{"route removes and strips", "/embed/widget", "", map[string]string{
+			"X-Frame-Options":        "",
+			"X-Debug-Token":          "",
+			"X-Content-Type-Options": "nosniff",
+			"Server":                 "",
+		}},
+		{"host route", "/page", "admin.example.com", map[string]string{
+			"Permissions-Policy": "camera=()",
+			"X-Frame-Options":    "DENY",
+			"X-Debug-Token":      "a1b2c3",
+		}},
+		{"rule header wins", "/partner/page", "", map[string]string{
+			"X-Frame-Options": "SAMEORIGIN",
+		}},
+		{"block response", "/blocked", "", map[string]string{
+			"X-Content-Type-Options":  "nosniff",
+			"Content-Security-Policy": "default-src 'self'",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			for name, want := range tt.want {
+				if got := resp.Header.Get(name); got != want {
+					t.Errorf("%s: expected %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
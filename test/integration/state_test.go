@@ -0,0 +1,245 @@
+package integration
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/ipfilter"
+	"github.com/waf-draft/waf/internal/state"
+)
+
+func TestRedisBackend(t *testing.T) {
+	server := newRESPServer(t, "secret")
+	backend := state.NewRedisBackend(state.RedisOptions{
+		Address:   server.Addr(),
+		Password:  "secret",
+		KeyPrefix: "waf:",
+	})
+	defer backend.Close()
+
+	expiry := func(key string) (time.Time, bool) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		exp, ok := server.expiry[key]
+		return exp, ok
+	}
+	if n, err := backend.IncrBy("count", 2, time.Minute); err != nil || n != 2 {
+		t.Fatalf("IncrBy = %d, %v", n, err)
+	}
+	created, ok := expiry("waf:count")
+	if !ok {
+		t.Fatal("Expected the counter to be created with a TTL")
+	}
+	if n, err := backend.IncrBy("count", 3, time.Minute); err != nil || n != 5 {
+		t.Fatalf("IncrBy = %d, %v", n, err)
+	}
+	if exp, _ := expiry("waf:count"); !exp.Equal(created) {
+		t.Error("Expected later increments to keep the counter's TTL")
+	}
+	if _, ok, err := backend.Get("missing"); ok || err != nil {
+		t.Fatalf("Expected missing key, got ok=%v err=%v", ok, err)
+	}
+	if err := backend.Set("ban:10.0.0.1", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Set("ban:10.0.0.2", "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := backend.Get("ban:10.0.0.1"); v != "a" || !ok || err != nil {
+		t.Fatalf("Get = %q, %v, %v", v, ok, err)
+	}
+
+	keys, err := backend.Keys("ban:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "ban:10.0.0.1" {
+		t.Fatalf("Keys = %v", keys)
+	}
+
+	if err := backend.Delete("ban:10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := backend.Get("ban:10.0.0.1"); ok {
+		t.Error("Expected key to be deleted")
+	}
+
+	// Wrong credentials surface as errors
+	bad := state.NewRedisBackend(state.RedisOptions{Address: server.Addr(), Password: "wrong"})
+	defer bad.Close()
+	if _, _, err := bad.Get("count"); err == nil {
+		t.Error("Expected authentication error")
+	}
+}
+
+// newSharedStateServer starts a WAF instance using the given state backend settings
+func newSharedStateServer(t *testing.T, upstreamURL string, stateCfg config.StateConfig) *httptest.Server {
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstreamURL
+	cfg.Server.TrustedProxies = []string{"127.0.0.1", "::1"}
+	cfg.Admin.Token = "secret"
+	cfg.State = stateCfg
+	cfg.Security.RateLimit = config.RateLimitConfig{Enabled: true, MaxRequests: 4, WindowSeconds: 60}
+	cfg.Security.Bans = config.BanConfig{Enabled: true, WindowSeconds: 60, BanSeconds: 60, EscalationFactor: 2}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSharedStateAcrossReplicas(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+	redis := newRESPServer(t, "")
+
+	stateCfg := config.StateConfig{
+		Backend:  "redis",
+		Redis:    config.RedisConfig{Address: redis.Addr(), KeyPrefix: "waf:"},
+		FailMode: state.FailOpen,
+	}
+	a := newSharedStateServer(t, upstream.URL, stateCfg)
+	b := newSharedStateServer(t, upstream.URL, stateCfg)
+
+	get := func(server *httptest.Server, path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The limit of 4 is shared: two requests on each replica exhaust it
+	for _, server := range []*httptest.Server{a, b, a, b} {
+		if code := get(server, "/api/users"); code != http.StatusOK {
+			t.Fatalf("Expected 200 within the shared limit, got %d", code)
+		}
+	}
+	if code := get(a, "/api/users"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once the shared limit is exhausted, got %d", code)
+	}
+
+	// A ban created through one replica's admin API applies on the other
+	req, _ := http.NewRequest("POST", a.URL+"/admin/api/v1/bans", strings.NewReader(`{"ip":"198.51.100.9","reason":"manual","duration_seconds":60}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected ban to be created, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", b.URL+"/api/users", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected ban to be enforced by the other replica, got %d", resp.StatusCode)
+	}
+}
+
+func TestSharedStateFailModes(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	// Nothing listens on the address once the server is closed
+	redis := newRESPServer(t, "")
+	addr := redis.Addr()
+	redis.Close()
+
+	get := func(server *httptest.Server) int {
+		resp, err := http.Get(server.URL + "/api/users")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	open := newSharedStateServer(t, upstream.URL, config.StateConfig{
+		Backend:  "redis",
+		Redis:    config.RedisConfig{Address: addr, TimeoutMs: 100},
+		FailMode: state.FailOpen,
+	})
+	if code := get(open); code != http.StatusOK {
+		t.Errorf("Expected fail-open instance to allow, got %d", code)
+	}
+
+	closed := newSharedStateServer(t, upstream.URL, config.StateConfig{
+		Backend:  "redis",
+		Redis:    config.RedisConfig{Address: addr, TimeoutMs: 100},
+		FailMode: state.FailClosed,
+	})
+	if code := get(closed); code == http.StatusOK {
+		t.Errorf("Expected fail-closed instance to reject, got %d", code)
+	}
+}
+
+// stallingBackend blocks Keys until released and fails every Get
+type stallingBackend struct {
+	*state.MemoryBackend
+	release chan struct{}
+	gets    atomic.Int32
+}
+
+func (b *stallingBackend) Keys(prefix string) ([]string, error) {
+	<-b.release
+	return b.MemoryBackend.Keys(prefix)
+}
+
+func (b *stallingBackend) Get(key string) (string, bool, error) {
+	b.gets.Add(1)
+	return "", false, errors.New("backend unavailable")
+}
+
+func TestSharedBansOutsideLock(t *testing.T) {
+	bans, err := ipfilter.NewBanManager(ipfilter.BanPolicy{Duration: time.Minute}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bans.Stop()
+	backend := &stallingBackend{MemoryBackend: state.NewMemoryBackend(), release: make(chan struct{})}
+	defer backend.Close()
+	bans.SetBackend(backend, time.Minute, state.FailOpen)
+
+	// A slow listing must not hold up lookups for other clients
+	listed := make(chan struct{})
+	go func() {
+		bans.List()
+		close(listed)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	lookup := make(chan struct{})
+	go func() {
+		bans.IsBanned("203.0.113.1")
+		bans.IsBanned("203.0.113.1")
+		close(lookup)
+	}()
+	select {
+	case <-lookup:
+	case <-time.After(time.Second):
+		t.Fatal("IsBanned blocked behind a backend call")
+	}
+	close(backend.release)
+	<-listed
+
+	// Failed lookups are cached like successful ones
+	if n := backend.gets.Load(); n != 1 {
+		t.Errorf("Expected one backend lookup while the failure is cached, got %d", n)
+	}
+}
@@ -0,0 +1,183 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/waf-draft/waf/internal/config"
+	"github.com/waf-draft/waf/internal/httpserver"
+	"github.com/waf-draft/waf/internal/normalize"
+)
+
+func TestUnicodeTransforms(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform string
+		input     string
+		want      string
+	}{
+		{"full-width brackets", normalize.TransformCompat, "＜script＞", "<script>"},
+		{"ideographic space", normalize.TransformCompat, "ＵＮＩＯＮ　ＳＥＬＥＣＴ", "UNION SELECT"},
+		{"mathematical bold", normalize.TransformCompat, "𝐮𝐧𝐢𝐨𝐧 𝐬𝐞𝐥𝐞𝐜𝐭", "union select"},
+		{"circled letters", normalize.TransformCompat, "ⓐⓛⓔⓡⓣ", "alert"},
+		{"small form variants", normalize.TransformCompat, "﹤svg onload﹦alert(1)﹥", "<svg onload=alert(1)>"},
+		{"long s", normalize.TransformCompat, "<ſcript>", "<script>"},
+		{"leaders", normalize.TransformCompat, "‥/‥/etc/passwd", "../../etc/passwd"},
+		{"cyrillic homoglyphs", normalize.TransformConfusables, "<ѕсrірt>", "<script>"},
+		{"greek homoglyphs", normalize.TransformConfusables, "ΟR 1=1", "OR 1=1"},
+		{"combining marks", normalize.TransformConfusables, "<scṙiṕt>", "<script>"},
+		{"zero-width characters", normalize.TransformConfusables, "uni​on sel­ect", "union select"},
+		{"quote and bracket look-alikes", normalize.TransformConfusables, "‹img src=x onerror=alert(’1’)›", "<img src=x onerror=alert('1')>"},
+		{"case folding", normalize.TransformCaseFold, "SCRİPT straße", "script strasse"},
+		{"all together", normalize.TransformUnicode, "＜ЅＣＲＩ​ＰＴ＞", "<script>"},
+		{"ascii unchanged", normalize.TransformUnicode, "plain ascii", "plain ascii"},
+		{"accented letters kept", normalize.TransformConfusables, "café", "café"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalize.Transform(tt.transform, tt.input)
+			if err != nil {
+				t.Fatalf("Transform failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := normalize.Transform("rot13", "x"); err == nil {
+		t.Error("Expected an error for an unknown transformation")
+	}
+}
+
+// unicodeBypasses are payloads that evade the rules without Unicode folding
+var unicodeBypasses = []string{
+	"/api/search?q=" + url.QueryEscape("＜script＞alert(1)＜/script＞"),
+	"/api/search?q=" + url.QueryEscape("<ѕсrірt>alert(1)</ѕсrірt>"),
+	"/api/search?q=" + url.QueryEscape("<scr​ipt>alert(1)</script>"),
+	"/api/search?q=" + url.QueryEscape("1 ＵＮＩＯＮ　ＳＥＬＥＣＴ password FROM users"),
+	"/api/search?q=" + url.QueryEscape("1 𝐮𝐧𝐢𝐨𝐧 𝐬𝐞𝐥𝐞𝐜𝐭 password"),
+	"/api/search?q=" + url.QueryEscape("‹script›alert(1)‹/script›"),
+	"/static/" + url.PathEscape("．．／．．／etc/passwd"),
+}
+
+func TestUnicodeBypassesBlockedByRuleTransforms(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	for _, target := range unicodeBypasses {
+		resp, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d", target, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/search?q=" + url.QueryEscape("crème brûlée"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected accented text to pass, got %d", resp.StatusCode)
+	}
+}
+
+// writeRulesFile writes a ruleset to a temporary file
+func writeRulesFile(t *testing.T, ruleset string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(ruleset), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	return path
+}
+
+func TestUnicodePrePass(t *testing.T) {
+	// Rules without transforms only see the payload after the pre-pass
+	rulesFile := writeRulesFile(t, `
+- id: "XSS-PLAIN"
+  name: "Script tag without transforms"
+  severity: 10
+  phase: "request"
+  enabled: true
+  conditions:
+    - target: "query"
+      operator: "contains"
+      value: "<script>"
+  actions:
+    - type: "add_score"
+      param: 10
+`)
+
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	for _, enabled := range []bool{false, true} {
+		cfg := &config.Config{}
+		cfg.Server.UpstreamURL = upstream.URL
+		cfg.Rules.Files = []string{rulesFile}
+		cfg.Security.Unicode = config.UnicodeConfig{Enabled: enabled, Targets: []string{"query", "bogus"}}
+		handler := newTestHandler(t, cfg)
+		server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+
+		resp, err := http.Get(server.URL + "/api/search?q=" + url.QueryEscape("＜ＳＣＲＩＰＴ＞"))
+		server.Close()
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		want := http.StatusOK
+		if enabled {
+			want = http.StatusForbidden
+		}
+		if resp.StatusCode != want {
+			t.Errorf("Pre-pass enabled=%v: expected status %d, got %d", enabled, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestUnicodePrePassKeepsRoutingPath(t *testing.T) {
+	upstream := createTestUpstreamServer(t)
+	defer upstream.Close()
+
+	// Case folding must not move /Admin out from under its rate limit policy
+	cfg := &config.Config{}
+	cfg.Server.UpstreamURL = upstream.URL
+	cfg.Security.Unicode = config.UnicodeConfig{Enabled: true, Targets: []string{"path"}}
+	cfg.Security.RateLimit = config.RateLimitConfig{Enabled: true, Policies: []config.RateLimitPolicyConfig{{
+		Name:          "admin",
+		Match:         config.RateLimitMatchConfig{PathPrefix: "/Admin"},
+		MaxRequests:   1,
+		WindowSeconds: 60,
+	}}}
+	handler := newTestHandler(t, cfg)
+	server := httptest.NewServer(httpserver.NewRouter(handler, "stdout"))
+	defer server.Close()
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/Admin/users")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected the policy to match the unfolded path, got %v", codes)
+	}
+}